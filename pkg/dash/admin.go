@@ -0,0 +1,276 @@
+package dash
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultAdminAppName = "dashborg-admin"
+	RoleAdmin           = "admin"
+)
+
+// A runtime adjustable setting exposed by the admin control app (see StartAdminControl).
+// Used for things like cache TTLs and rate limits that operators should be able to tune
+// without a restart.  Built-in settings (app dispatch limits) are added automatically.
+type ControlSetting struct {
+	Name        string // unique name, e.g. "orders.cachettl"
+	Description string
+	GetFn       func() interface{}
+	SetFn       func(value string) error // parses and applies the new value
+}
+
+// Current value of a ControlSetting.  Returned from the admin "settings" handler.
+type ControlSettingInfo struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Value       interface{} `json:"value"`
+}
+
+// Options for StartAdminControl.
+type AdminControlOpts struct {
+	AppName      string   // defaults to DefaultAdminAppName
+	AllowedRoles []string // roles that can view and change settings (defaults to [RoleAdmin])
+}
+
+// Creates a ControlSetting for an int value (e.g. a rate limit).
+func MakeIntSetting(name string, description string, getFn func() int, setFn func(int) error) ControlSetting {
+	return ControlSetting{
+		Name:        name,
+		Description: description,
+		GetFn:       func() interface{} { return getFn() },
+		SetFn: func(value string) error {
+			intVal, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return dasherr.ValidateErr(fmt.Errorf("Setting '%s' requires an integer value", name))
+			}
+			return setFn(intVal)
+		},
+	}
+}
+
+// Creates a ControlSetting for a time.Duration value (e.g. a cache TTL).  Values are
+// parsed with time.ParseDuration ("30s", "5m").
+func MakeDurationSetting(name string, description string, getFn func() time.Duration, setFn func(time.Duration) error) ControlSetting {
+	return ControlSetting{
+		Name:        name,
+		Description: description,
+		GetFn:       func() interface{} { return getFn().String() },
+		SetFn: func(value string) error {
+			dur, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return dasherr.ValidateErr(fmt.Errorf("Setting '%s' requires a duration value: %v", name, err))
+			}
+			return setFn(dur)
+		},
+	}
+}
+
+func (setting ControlSetting) Validate() error {
+	if setting.Name == "" || strings.ContainsAny(setting.Name, " \t\n") {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid ControlSetting name '%s'", setting.Name))
+	}
+	if setting.GetFn == nil || setting.SetFn == nil {
+		return dasherr.ValidateErr(fmt.Errorf("ControlSetting '%s' requires GetFn and SetFn", setting.Name))
+	}
+	if strings.HasPrefix(setting.Name, "app.") {
+		return dasherr.ValidateErr(fmt.Errorf("ControlSetting '%s', the 'app.' prefix is reserved for built-in settings", setting.Name))
+	}
+	return nil
+}
+
+// Registers a setting that can be changed from the admin control app.  Registering a
+// setting with the same name replaces it.
+func (pc *DashCloudClient) RegisterControlSetting(setting ControlSetting) error {
+	err := setting.Validate()
+	if err != nil {
+		return err
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	pc.controlSettings[setting.Name] = setting
+	return nil
+}
+
+func makeLimitsSetting(name string, description string, limiter *dispatchLimiter, getFn func(DispatchLimits) int, setFn func(*DispatchLimits, int)) ControlSetting {
+	return MakeIntSetting(name, description, func() int {
+		return getFn(limiter.getLimits())
+	}, func(val int) error {
+		limits := limiter.getLimits()
+		setFn(&limits, val)
+		err := limits.Validate()
+		if err != nil {
+			return err
+		}
+		limiter.setLimits(limits)
+		return nil
+	})
+}
+
+// built-in dispatch limit settings for each connected app
+func (pc *DashCloudClient) appControlSettings() []ControlSetting {
+	pc.Lock.Lock()
+	var apps []*App
+	for _, app := range pc.connectedApps {
+		apps = append(apps, app)
+	}
+	pc.Lock.Unlock()
+	var rtn []ControlSetting
+	for _, app := range apps {
+		apprt := app.appRuntime
+		if apprt == nil {
+			continue
+		}
+		prefix := "app." + app.appName + "."
+		getMaxInFlight := func(l DispatchLimits) int { return l.MaxInFlight }
+		setMaxInFlight := func(l *DispatchLimits, val int) { l.MaxInFlight = val }
+		getMaxQueue := func(l DispatchLimits) int { return l.MaxQueue }
+		setMaxQueue := func(l *DispatchLimits, val int) { l.MaxQueue = val }
+		rtn = append(rtn,
+			makeLimitsSetting(prefix+"maxinflight", "Maximum concurrently running requests (0 is unlimited)", apprt.limiter, getMaxInFlight, setMaxInFlight),
+			makeLimitsSetting(prefix+"maxqueue", "Maximum queued requests (0 is unlimited)", apprt.limiter, getMaxQueue, setMaxQueue),
+			makeLimitsSetting(prefix+"heavy.maxinflight", "Maximum concurrently running heavy requests (0 is unlimited)", apprt.heavyLimiter, getMaxInFlight, setMaxInFlight),
+			makeLimitsSetting(prefix+"heavy.maxqueue", "Maximum queued heavy requests (0 is unlimited)", apprt.heavyLimiter, getMaxQueue, setMaxQueue),
+		)
+	}
+	return rtn
+}
+
+func (pc *DashCloudClient) allControlSettings() map[string]ControlSetting {
+	rtn := make(map[string]ControlSetting)
+	for _, setting := range pc.appControlSettings() {
+		rtn[setting.Name] = setting
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	for name, setting := range pc.controlSettings {
+		rtn[name] = setting
+	}
+	return rtn
+}
+
+// Returns the current values of all control settings (registered and built-in), sorted by name.
+func (pc *DashCloudClient) ControlSettings() []ControlSettingInfo {
+	var rtn []ControlSettingInfo
+	for _, setting := range pc.allControlSettings() {
+		rtn = append(rtn, ControlSettingInfo{Name: setting.Name, Description: setting.Description, Value: setting.GetFn()})
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn
+}
+
+// Changes a control setting.  Returns the setting's new value.
+func (pc *DashCloudClient) SetControlSetting(name string, value string) (*ControlSettingInfo, error) {
+	setting, ok := pc.allControlSettings()[name]
+	if !ok {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("ControlSetting '%s' not found", name))
+	}
+	err := setting.SetFn(value)
+	if err != nil {
+		return nil, err
+	}
+	pc.log("Dashborg control setting changed %s => %v\n", name, setting.GetFn())
+	return &ControlSettingInfo{Name: setting.Name, Description: setting.Description, Value: setting.GetFn()}, nil
+}
+
+func (opts *AdminControlOpts) Validate() error {
+	if !dashutil.IsAppNameValid(opts.AppName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AdminControlOpts AppName '%s'", opts.AppName))
+	}
+	for _, role := range opts.AllowedRoles {
+		if !dashutil.IsRoleValid(role) {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid AdminControlOpts role '%s'", role))
+		}
+	}
+	return nil
+}
+
+// Creates and connects an (opt-in) admin control app that lets operators view and change
+// control settings (see RegisterControlSetting) and log levels (see SetLogLevel) live.
+// Both the app and its handlers are restricted to opts.AllowedRoles.  opts may be nil.
+func (pc *DashCloudClient) StartAdminControl(opts *AdminControlOpts) (*App, error) {
+	if opts == nil {
+		opts = &AdminControlOpts{}
+	}
+	optsCopy := *opts
+	if optsCopy.AppName == "" {
+		optsCopy.AppName = DefaultAdminAppName
+	}
+	if len(optsCopy.AllowedRoles) == 0 {
+		optsCopy.AllowedRoles = []string{RoleAdmin}
+	}
+	err := optsCopy.Validate()
+	if err != nil {
+		return nil, err
+	}
+	roles := optsCopy.AllowedRoles
+	app := pc.AppClient().NewApp(optsCopy.AppName)
+	app.SetAppTitle("Dashborg Admin")
+	app.SetAllowedRoles(roles...)
+	app.SetHtml(adminHtml)
+	rt := app.Runtime()
+	rt.PureHandler("settings", func(req *AppRequest) ([]ControlSettingInfo, error) {
+		err := checkAllowedRoles(req, roles)
+		if err != nil {
+			return nil, err
+		}
+		return pc.ControlSettings(), nil
+	})
+	rt.Handler("set-setting", func(req *AppRequest, name string, value string) ([]ControlSettingInfo, error) {
+		err := checkAllowedRoles(req, roles)
+		if err != nil {
+			return nil, err
+		}
+		_, err = pc.SetControlSetting(name, value)
+		if err != nil {
+			return nil, err
+		}
+		return pc.ControlSettings(), nil
+	})
+	pc.AddLogLevelHandlers(rt, roles...)
+	err = pc.AppClient().WriteAndConnectApp(app)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+const adminHtml = `<app ui="dashborg">
+  <d-data query="/@app:settings" output.bindpath="$.settings"/>
+  <d-data query="/@app:loglevels" output.bindpath="$.loglevels"/>
+  <h1>Dashborg Admin</h1>
+  <h2>Settings</h2>
+  <d-table bind="$.settings">
+    <d-col label="Name" bind=".name"/>
+    <d-col label="Value" bind=".value"/>
+    <d-col label="Description" bind=".description"/>
+  </d-table>
+  <div class="row">
+    <d-input placeholder="Setting" value.bindpath="$.form.name"/>
+    <d-input placeholder="Value" value.bindpath="$.form.value"/>
+    <d-button onclickhandler="$.settings = /@app:set-setting($.form.name, $.form.value)">Set</d-button>
+  </div>
+  <h2>Log Levels</h2>
+  <d-table bind="$.loglevels">
+    <d-col label="Filter" bind=".filter"/>
+    <d-col label="Level" bind=".level"/>
+  </d-table>
+  <div class="row">
+    <d-input placeholder="app=[appname],path=[glob]" value.bindpath="$.logform.filter"/>
+    <d-select value.bindpath="$.logform.level">
+      <d-option value="debug">debug</d-option>
+      <d-option value="info">info</d-option>
+      <d-option value="">clear</d-option>
+    </d-select>
+    <d-button onclickhandler="$.loglevels = /@app:set-loglevel($.logform.filter, $.logform.level)">Set</d-button>
+  </div>
+</app>
+`
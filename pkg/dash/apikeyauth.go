@@ -0,0 +1,155 @@
+package dash
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	AuthTypeApiKey = "apikey"
+	ApiKeyParam    = "apikey" // request data key (challenge submit) or url param holding the API key
+
+	apiKeyHashKey = "keyhash" // AuthAtom.Data key holding the hex sha256 of the matched key
+
+	ApiKeyMwName     = "apikey"
+	ApiKeyMwPriority = 130 // runs before AuthzMwPriority, so rules see the API key's role
+)
+
+func apiKeyHash(key string) string {
+	keyHash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(keyHash[:])
+}
+
+// parses a validKeys value, "role" or "role:userid" (roles can be a comma separated list).
+// the default userid is "apikey-" followed by a short hash of the key.
+func parseApiKeyValue(key string, value string) ([]string, string, error) {
+	roleStr, userId := value, ""
+	if idx := strings.Index(value, ":"); idx != -1 {
+		roleStr, userId = value[0:idx], value[idx+1:]
+	}
+	if roleStr == "" || !dashutil.IsRoleListValid(roleStr) {
+		return nil, "", dasherr.ValidateErr(fmt.Errorf("Invalid role list '%s' for API key", roleStr))
+	}
+	if userId == "" {
+		userId = "apikey-" + apiKeyHash(key)[0:8]
+	}
+	return strings.Split(roleStr, ","), userId, nil
+}
+
+// returns the API key submitted with the request (request data, then url params), "" if none
+func (req *AppRequest) submittedApiKey() string {
+	if apiKey := req.DataPath(ApiKeyParam).StringOr(""); apiKey != "" {
+		return apiKey
+	}
+	var state dashborgState
+	err := req.BindAppState(&state)
+	if err != nil {
+		return ""
+	}
+	apiKey, _ := state.UrlParams[ApiKeyParam].(string)
+	return apiKey
+}
+
+// compares against every key in constant time
+func matchApiKey(validKeys map[string]string, apiKey string) (string, string, bool) {
+	var matchKey, matchValue string
+	found := false
+	for key, value := range validKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			matchKey, matchValue, found = key, value, true
+		}
+	}
+	return matchKey, matchValue, found
+}
+
+// checks that an existing apikey AuthAtom was issued for a key that is still in validKeys
+func isApiKeyAuthValid(aa *AuthAtom, validKeys map[string]string) bool {
+	atomHash, _ := aa.Data[apiKeyHashKey].(string)
+	if atomHash == "" {
+		return false
+	}
+	found := false
+	for key := range validKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKeyHash(key)), []byte(atomHash)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// Authenticates the request with an API key submitted as the "apikey" request data field
+// (e.g. a login challenge form) or url param.  validKeys maps each key to a role (or a comma
+// separated role list), optionally followed by ":" and a user id, e.g. "user:kiosk-1".
+// On success the request's AuthAtom is set (type "apikey") and sent to the frontend.  An
+// existing apikey AuthAtom is only accepted if its key is still in validKeys.
+// Returns false (and no error) if no key was submitted, and a BADAUTH error for an unknown key.
+// Usage: ok, err := req.ApiKeyAuth(map[string]string{os.Getenv("KIOSK_KEY"): "user:kiosk-1"})
+func (req *AppRequest) ApiKeyAuth(validKeys map[string]string) (bool, error) {
+	if req.authData != nil && req.authData.Type == AuthTypeApiKey && isApiKeyAuthValid(req.authData, validKeys) {
+		return true, nil
+	}
+	apiKey := req.submittedApiKey()
+	if apiKey == "" {
+		return false, nil
+	}
+	key, value, ok := matchApiKey(validKeys, apiKey)
+	if !ok {
+		return false, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid API key"))
+	}
+	roleList, userId, err := parseApiKeyValue(key, value)
+	if err != nil {
+		return false, err
+	}
+	aa := &AuthAtom{Type: AuthTypeApiKey, Id: userId, RoleList: roleList, Data: map[string]interface{}{apiKeyHashKey: apiKeyHash(key)}}
+	req.setAuthData(aa)
+	req.authData = aa
+	return true, nil
+}
+
+// Creates a middleware that calls ApiKeyAuth for requests that submit an API key (requests
+// without one are passed through unchanged).  An apikey AuthAtom whose key is no longer in
+// validKeys is dropped from the request.  Install with
+// AddRawMiddleware(ApiKeyMwName, mw, ApiKeyMwPriority) or app.SetApiKeyAuth.
+func ApiKeyMiddleware(validKeys map[string]string) (MiddlewareFuncType, error) {
+	keysCopy := make(map[string]string)
+	for key, value := range validKeys {
+		if key == "" {
+			return nil, dasherr.ValidateErr(fmt.Errorf("API key cannot be empty"))
+		}
+		_, _, err := parseApiKeyValue(key, value)
+		if err != nil {
+			return nil, err
+		}
+		keysCopy[key] = value
+	}
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		if req.info.IsBackendCall {
+			return nextFn(req)
+		}
+		ok, err := req.ApiKeyAuth(keysCopy)
+		if err != nil {
+			return nil, err
+		}
+		if !ok && req.authData != nil && req.authData.Type == AuthTypeApiKey {
+			// the AuthAtom's key was removed from validKeys
+			req.authData = nil
+		}
+		return nextFn(req)
+	}, nil
+}
+
+// Installs an ApiKeyMiddleware on the app's runtime.
+func (app *App) SetApiKeyAuth(validKeys map[string]string) {
+	mw, err := ApiKeyMiddleware(validKeys)
+	if err != nil {
+		app.errs = append(app.errs, err)
+		return
+	}
+	app.appRuntime.AddRawMiddleware(ApiKeyMwName, mw, ApiKeyMwPriority)
+}
@@ -0,0 +1,75 @@
+package dash
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	AppEventWrite  = "appwrite"  // app config and HTML written (WriteApp, WriteAndConnectApp, ImportBundle)
+	AppEventRemove = "appremove" // app removed (RemoveApp)
+)
+
+// An app lifecycle change made by this process, passed to OnAppEvent listeners.
+type AppEvent struct {
+	Type      string
+	AppName   string
+	Ts        time.Time
+	Connected bool             // appwrite: the app's runtime was connected (WriteAndConnectApp)
+	AppLink   string           // appwrite: link to the app
+	BuildInfo *BuildInfoOption // appwrite: build info stamped on the app (see App.SetBuildInfo)
+}
+
+func (e AppEvent) String() string {
+	return fmt.Sprintf("AppEvent[%s] %s", e.Type, e.AppName)
+}
+
+type appListener struct {
+	id int
+	fn func(AppEvent)
+}
+
+// Registers fn to be called after this client writes or removes an app.  Unlike OnConnEvent,
+// fn is called synchronously (in the goroutine that called WriteApp or RemoveApp, after the
+// change succeeds), so deploy scripts can rely on it having run when WriteApp returns.
+// Returns a function that removes the listener.
+// Usage: remove := client.OnAppEvent(func(e dash.AppEvent) { cmdb.RecordDeploy(e.AppName, e.BuildInfo) })
+func (pc *DashCloudClient) OnAppEvent(fn func(AppEvent)) func() {
+	if fn == nil {
+		return func() {}
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	pc.appListenerSeq++
+	id := pc.appListenerSeq
+	pc.appListeners = append(pc.appListeners, appListener{id: id, fn: fn})
+	return func() {
+		pc.Lock.Lock()
+		defer pc.Lock.Unlock()
+		for idx, l := range pc.appListeners {
+			if l.id == id {
+				pc.appListeners = append(pc.appListeners[:idx:idx], pc.appListeners[idx+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (pc *DashCloudClient) fireAppEvent(event AppEvent) {
+	event.Ts = time.Now()
+	pc.Lock.Lock()
+	listeners := pc.appListeners
+	pc.Lock.Unlock()
+	for _, l := range listeners {
+		pc.callAppListener(l, event)
+	}
+}
+
+func (pc *DashCloudClient) callAppListener(l appListener, event AppEvent) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			pc.log("DashborgCloudClient PANIC in OnAppEvent listener (%v): %v\n", event, panicErr)
+		}
+	}()
+	l.fn(event)
+}
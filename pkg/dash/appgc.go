@@ -0,0 +1,165 @@
+package dash
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const DefaultGCRetention = 7 * 24 * time.Hour
+
+// Options for DashAppClient.GCApp.
+type GCOpts struct {
+	Retention    time.Duration // orphans updated more recently than this are kept (defaults to DefaultGCRetention)
+	KeepPaths    []string      // app relative paths that are always kept
+	KeepPrefixes []string      // app relative directory prefixes that are always kept
+	Manifests    []*Manifest   // paths in these manifests (app relative) are kept
+	Remove       bool          // remove the orphans (the default is a dry run that only reports them)
+	Trash        bool          // with Remove, move orphans to the trash (see TrashPath) instead of removing them
+}
+
+// A file under the app's prefix that is not referenced by the app config or GCOpts.
+type GCOrphan struct {
+	Path      string // full Dashborg FS path
+	Size      int64
+	UpdatedTs int64
+	Removed   bool
+	Err       error
+}
+
+// Result of GCApp.
+type GCResult struct {
+	AppName      string
+	NumScanned   int
+	NumKept      int // unreferenced, but within the retention window
+	Orphans      []GCOrphan
+	BytesRemoved int64
+}
+
+// Returns the errors from removing orphans (nil if all were removed, or for a dry run).
+func (r *GCResult) Err() error {
+	var errs []error
+	for _, orphan := range r.Orphans {
+		if orphan.Err != nil {
+			errs = append(errs, fmt.Errorf("path:%s: %w", orphan.Path, orphan.Err))
+		}
+	}
+	return dashutil.ConvertErrArray(errs)
+}
+
+// full paths referenced by the app's current config
+func appReferencedPaths(appPath string, cfg *AppConfig) map[string]bool {
+	rtn := map[string]bool{appPath: true}
+	for _, path := range []string{cfg.HtmlPath, cfg.RuntimePath} {
+		if path == "" {
+			continue
+		}
+		pathNoFrag, err := dashutil.PathNoFrag(path)
+		if err == nil {
+			rtn[pathNoFrag] = true
+		}
+	}
+	return rtn
+}
+
+// Finds orphaned files under the app's prefix: static files that are not referenced by the
+// app's current config (app, HTML, runtime), GCOpts.KeepPaths, KeepPrefixes, or Manifests,
+// and that have not been updated within the retention window.  Files referenced only from the
+// app's HTML (e.g. /@app/ static paths) are not detected, so by default orphans are only
+// reported, set GCOpts.Remove (after checking a report) to remove them.  Directories and
+// runtime links are never removed.
+// Usage: result, err := client.AppClient().GCApp("myapp", &dash.GCOpts{Retention: 30 * 24 * time.Hour})
+func (dac *DashAppClient) GCApp(appName string, opts *GCOpts) (*GCResult, error) {
+	if !dashutil.IsAppNameValid(appName) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	if opts == nil {
+		opts = &GCOpts{}
+	}
+	retention := opts.Retention
+	if retention <= 0 {
+		retention = DefaultGCRetention
+	}
+	appPath := AppPathFromName(appName)
+	fs := dac.client.GlobalFSClient()
+	appInfo, err := fs.FileInfo(appPath)
+	if err != nil {
+		return nil, err
+	}
+	if appInfo == nil || appInfo.FileType != FileTypeApp {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("App '%s' not found", appName))
+	}
+	var cfg AppConfig
+	if appInfo.AppConfigJson != "" {
+		err = dashutil.UnmarshalJson(appInfo.AppConfigJson, &cfg, dashutil.JsonOpts{})
+		if err != nil {
+			return nil, dasherr.JsonUnmarshalErr("AppConfig", err)
+		}
+	}
+	keep := appReferencedPaths(appPath, &cfg)
+	for _, path := range opts.KeepPaths {
+		keep[appPath+path] = true
+	}
+	for _, manifest := range opts.Manifests {
+		if manifest == nil {
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			keep[appPath+entry.Path] = true
+		}
+	}
+	finfos, err := fs.DirInfo(appPath, &DirOpts{ShowHidden: true, Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+	cutoffTs := dashutil.Ts() - int64(retention/time.Millisecond)
+	rtn := &GCResult{AppName: appName}
+	for _, finfo := range finfos {
+		if finfo.FileType == FileTypeDir || finfo.IsLinkType() || finfo.FileType == FileTypeApp || finfo.Removed {
+			continue
+		}
+		rtn.NumScanned++
+		if keep[finfo.Path] || hasKeepPrefix(appPath, finfo.Path, opts.KeepPrefixes) {
+			continue
+		}
+		if finfo.UpdatedTs > cutoffTs {
+			rtn.NumKept++
+			continue
+		}
+		rtn.Orphans = append(rtn.Orphans, GCOrphan{Path: finfo.Path, Size: finfo.Size, UpdatedTs: finfo.UpdatedTs})
+	}
+	sort.Slice(rtn.Orphans, func(i int, j int) bool {
+		return rtn.Orphans[i].Path < rtn.Orphans[j].Path
+	})
+	if !opts.Remove {
+		return rtn, nil
+	}
+	for idx := range rtn.Orphans {
+		orphan := &rtn.Orphans[idx]
+		if opts.Trash {
+			_, orphan.Err = fs.TrashPath(orphan.Path, nil)
+		} else {
+			orphan.Err = fs.RemovePath(orphan.Path)
+		}
+		if orphan.Err == nil {
+			orphan.Removed = true
+			rtn.BytesRemoved += orphan.Size
+		}
+	}
+	dac.client.log("Dashborg GC app:%s scanned:%d orphans:%d bytes-removed:%d\n", appName, rtn.NumScanned, len(rtn.Orphans), rtn.BytesRemoved)
+	return rtn, nil
+}
+
+func hasKeepPrefix(appPath string, fullPath string, keepPrefixes []string) bool {
+	for _, prefix := range keepPrefixes {
+		fullPrefix := appPath + strings.TrimRight(prefix, "/")
+		if fullPath == fullPrefix || strings.HasPrefix(fullPath, fullPrefix+"/") {
+			return true
+		}
+	}
+	return false
+}
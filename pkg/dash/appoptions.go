@@ -0,0 +1,271 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	AppOptionAuth       = "auth"
+	AppOptionHtml       = "html"
+	AppOptionVisibility = "visibility"
+)
+
+// A typed view of a group of AppConfig options.  The built-in options (AuthOption,
+// HtmlOption, VisibilityOption) map onto AppConfig fields, other registered options (see
+// RegisterAppOption) are stored in AppConfig.ExtraOpts under their OptionName.
+type AppOption interface {
+	OptionName() string
+	Validate() error
+}
+
+// built-in options read and write AppConfig fields directly
+type builtinAppOption interface {
+	AppOption
+	fromConfig(cfg *AppConfig)
+	toConfig(cfg *AppConfig)
+}
+
+// Who can access the app.
+type AuthOption struct {
+	AllowedRoles []string `json:"allowedroles"`
+}
+
+// Where the app's HTML is served from.
+type HtmlOption struct {
+	HtmlPath        string `json:"htmlpath"`
+	InitialHtmlPage string `json:"initialhtmlpage"`
+	PagesEnabled    bool   `json:"pagesenabled,omitempty"`
+}
+
+// How the app shows up in the app-switcher.
+type VisibilityOption struct {
+	AppTitle    string  `json:"apptitle,omitempty"`
+	AppVisType  string  `json:"appvistype,omitempty"`
+	AppVisOrder float64 `json:"appvisorder,omitempty"`
+}
+
+func (AuthOption) OptionName() string { return AppOptionAuth }
+
+func (opt AuthOption) Validate() error {
+	if len(opt.AllowedRoles) == 0 {
+		return dasherr.ValidateErr(fmt.Errorf("AllowedRoles cannot be empty"))
+	}
+	if !dashutil.IsRoleListValid(strings.Join(opt.AllowedRoles, ",")) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AllowedRoles"))
+	}
+	return nil
+}
+
+func (opt *AuthOption) fromConfig(cfg *AppConfig) { opt.AllowedRoles = cfg.AllowedRoles }
+func (opt *AuthOption) toConfig(cfg *AppConfig)   { cfg.AllowedRoles = opt.AllowedRoles }
+
+func (HtmlOption) OptionName() string { return AppOptionHtml }
+
+func (opt HtmlOption) Validate() error {
+	if opt.HtmlPath != "" {
+		_, _, _, err := dashutil.ParseFullPath(opt.HtmlPath, true)
+		if err != nil {
+			return dasherr.ValidateErr(err)
+		}
+	}
+	if opt.InitialHtmlPage != "" {
+		_, _, err := dashutil.ParseHtmlPage(opt.InitialHtmlPage)
+		if err != nil {
+			return dasherr.ValidateErr(err)
+		}
+	}
+	return nil
+}
+
+func (opt *HtmlOption) fromConfig(cfg *AppConfig) {
+	opt.HtmlPath = cfg.HtmlPath
+	opt.InitialHtmlPage = cfg.InitialHtmlPage
+	opt.PagesEnabled = cfg.PagesEnabled
+}
+
+func (opt *HtmlOption) toConfig(cfg *AppConfig) {
+	cfg.HtmlPath = opt.HtmlPath
+	cfg.InitialHtmlPage = opt.InitialHtmlPage
+	cfg.PagesEnabled = opt.PagesEnabled
+}
+
+func (VisibilityOption) OptionName() string { return AppOptionVisibility }
+
+func (opt VisibilityOption) Validate() error {
+	if len(opt.AppTitle) > 80 {
+		return dasherr.ValidateErr(fmt.Errorf("AppTitle too long"))
+	}
+	if opt.AppVisType != "" && opt.AppVisType != VisTypeHidden && opt.AppVisType != VisTypeDefault && opt.AppVisType != VisTypeAlwaysVisible {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppVisType '%s'", opt.AppVisType))
+	}
+	return nil
+}
+
+func (opt *VisibilityOption) fromConfig(cfg *AppConfig) {
+	opt.AppTitle = cfg.AppTitle
+	opt.AppVisType = cfg.AppVisType
+	opt.AppVisOrder = cfg.AppVisOrder
+}
+
+func (opt *VisibilityOption) toConfig(cfg *AppConfig) {
+	cfg.AppTitle = opt.AppTitle
+	cfg.AppVisType = opt.AppVisType
+	cfg.AppVisOrder = opt.AppVisOrder
+}
+
+var appOptionLock = &sync.Mutex{}
+var appOptionReg = map[string]reflect.Type{
+	AppOptionAuth:       reflect.TypeOf(AuthOption{}),
+	AppOptionHtml:       reflect.TypeOf(HtmlOption{}),
+	AppOptionVisibility: reflect.TypeOf(VisibilityOption{}),
+	AppOptionBuildInfo:  reflect.TypeOf(BuildInfoOption{}),
+}
+
+// Registers a custom app option type so it can be read and written with AppConfig.GetOption
+// and SetOption (and is not treated as an unknown option, see Config.AppVersionPolicy).
+// proto must be a struct value (not a pointer) whose OptionName is a valid, unused name.
+// Usage: dash.RegisterAppOption(MyCacheOption{})
+func RegisterAppOption(proto AppOption) error {
+	if proto == nil {
+		return dasherr.ValidateErr(fmt.Errorf("RegisterAppOption proto cannot be nil"))
+	}
+	optType := reflect.TypeOf(proto)
+	if optType.Kind() != reflect.Struct {
+		return dasherr.ValidateErr(fmt.Errorf("RegisterAppOption proto must be a struct, got %v", optType))
+	}
+	name := proto.OptionName()
+	if !dashutil.IsSimpleIdValid(name) || appConfigKnownKeys[name] {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid app option name '%s'", name))
+	}
+	appOptionLock.Lock()
+	defer appOptionLock.Unlock()
+	if existingType, ok := appOptionReg[name]; ok && existingType != optType {
+		return dasherr.ValidateErr(fmt.Errorf("App option '%s' is already registered with type %v", name, existingType))
+	}
+	appOptionReg[name] = optType
+	return nil
+}
+
+// Returns the names of all registered app options (sorted), including the built-in options.
+func RegisteredAppOptions() []string {
+	appOptionLock.Lock()
+	defer appOptionLock.Unlock()
+	var rtn []string
+	for name := range appOptionReg {
+		rtn = append(rtn, name)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func isAppOptionRegistered(name string) bool {
+	appOptionLock.Lock()
+	defer appOptionLock.Unlock()
+	_, ok := appOptionReg[name]
+	return ok
+}
+
+func lookupAppOption(name string) (reflect.Type, error) {
+	appOptionLock.Lock()
+	defer appOptionLock.Unlock()
+	optType, ok := appOptionReg[name]
+	if !ok {
+		return nil, dasherr.ValidateErr(fmt.Errorf("App option '%s' is not registered", name))
+	}
+	return optType, nil
+}
+
+// Returns the typed option registered under name (a value of the registered struct type).
+// Custom options that are not set return the zero value of their type.
+// Usage: opt, err := cfg.GetOption(dash.AppOptionAuth); roles := opt.(dash.AuthOption).AllowedRoles
+func (config *AppConfig) GetOption(name string) (AppOption, error) {
+	optType, err := lookupAppOption(name)
+	if err != nil {
+		return nil, err
+	}
+	optPtr := reflect.New(optType)
+	if builtin, ok := optPtr.Interface().(builtinAppOption); ok {
+		builtin.fromConfig(config)
+		return optPtr.Elem().Interface().(AppOption), nil
+	}
+	if rawVal, ok := config.ExtraOpts[name]; ok {
+		err = json.Unmarshal(rawVal, optPtr.Interface())
+		if err != nil {
+			return nil, dasherr.JsonUnmarshalErr(fmt.Sprintf("AppOption[%s]", name), err)
+		}
+	}
+	return optPtr.Elem().Interface().(AppOption), nil
+}
+
+// Validates and sets a typed option.  The option's type must be registered.
+func (config *AppConfig) SetOption(opt AppOption) error {
+	if opt == nil {
+		return dasherr.ValidateErr(fmt.Errorf("SetOption opt cannot be nil"))
+	}
+	name := opt.OptionName()
+	optType, err := lookupAppOption(name)
+	if err != nil {
+		return err
+	}
+	optVal := reflect.ValueOf(opt)
+	if optVal.Kind() == reflect.Ptr && !optVal.IsNil() {
+		optVal = optVal.Elem()
+	}
+	if optVal.Type() != optType {
+		return dasherr.ValidateErr(fmt.Errorf("App option '%s' requires type %v, got %v", name, optType, optVal.Type()))
+	}
+	err = opt.Validate()
+	if err != nil {
+		return err
+	}
+	optPtr := reflect.New(optType)
+	optPtr.Elem().Set(optVal)
+	if builtin, ok := optPtr.Interface().(builtinAppOption); ok {
+		builtin.toConfig(config)
+		return nil
+	}
+	barr, err := json.Marshal(optPtr.Interface())
+	if err != nil {
+		return dasherr.JsonMarshalErr(fmt.Sprintf("AppOption[%s]", name), err)
+	}
+	if config.ExtraOpts == nil {
+		config.ExtraOpts = make(map[string]json.RawMessage)
+	}
+	config.ExtraOpts[name] = json.RawMessage(barr)
+	return nil
+}
+
+// Removes a custom option.  Built-in options cannot be removed.
+func (config *AppConfig) RemoveOption(name string) error {
+	optType, err := lookupAppOption(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := reflect.New(optType).Interface().(builtinAppOption); ok {
+		return dasherr.ValidateErr(fmt.Errorf("Cannot remove built-in app option '%s'", name))
+	}
+	delete(config.ExtraOpts, name)
+	return nil
+}
+
+// Sets a typed option on the app (see AppConfig.SetOption).
+// Usage: app.SetOption(dash.VisibilityOption{AppTitle: "Orders", AppVisType: dash.VisTypeAlwaysVisible})
+func (app *App) SetOption(opt AppOption) {
+	err := app.appConfig.SetOption(opt)
+	if err != nil {
+		app.errs = append(app.errs, err)
+	}
+}
+
+// Returns a typed option from the app's config (see AppConfig.GetOption).
+func (app *App) GetOption(name string) (AppOption, error) {
+	return app.appConfig.GetOption(name)
+}
@@ -0,0 +1,140 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const onConnectTimeout = time.Minute
+
+type OnConnectFuncType func(ctx context.Context) error
+
+// Connection status of an app.  Returned from App.Status().
+type AppStatus struct {
+	AppName       string
+	Connected     bool  // true if the app's runtime is currently connected to the Dashborg service
+	ConnectTs     int64 // last time the runtime was connected (or reconnected)
+	NumConnects   int
+	OnConnectTs   int64 // last time the OnConnect functions finished
+	OnConnectErr  error // error returned by the last run of the OnConnect functions (nil on success)
+	OnConnectBusy bool  // true while the OnConnect functions are running
+
+	Dispatch      DispatchStats // request queue depth and inflight counts (see App.SetDispatchLimits)
+	HeavyDispatch DispatchStats // same, for the heavy handler pool
+}
+
+type appStatusType struct {
+	lock          *sync.Mutex
+	connected     bool
+	connectTs     int64
+	numConnects   int
+	onConnectTs   int64
+	onConnectErr  error
+	onConnectBusy bool
+	onConnectFns  []OnConnectFuncType
+}
+
+func makeAppStatus() *appStatusType {
+	return &appStatusType{lock: &sync.Mutex{}}
+}
+
+// Adds a function that is called (in a new goroutine) after the app's runtime is successfully
+// connected to the Dashborg service, and again after every reconnect.  Used to pre-compute and
+// push initial data or warm caches.  Errors are available in App.Status().OnConnectErr.
+func (app *App) OnConnect(fn OnConnectFuncType) {
+	if fn == nil {
+		return
+	}
+	app.status.lock.Lock()
+	defer app.status.lock.Unlock()
+	app.status.onConnectFns = append(app.status.onConnectFns, fn)
+}
+
+// Returns the connection status of the app (and the result of the last OnConnect run).
+func (app *App) Status() AppStatus {
+	app.status.lock.Lock()
+	defer app.status.lock.Unlock()
+	rtn := AppStatus{
+		AppName:       app.appName,
+		Connected:     app.status.connected,
+		ConnectTs:     app.status.connectTs,
+		NumConnects:   app.status.numConnects,
+		OnConnectTs:   app.status.onConnectTs,
+		OnConnectErr:  app.status.onConnectErr,
+		OnConnectBusy: app.status.onConnectBusy,
+	}
+	if app.appRuntime != nil {
+		rtn.Dispatch = app.appRuntime.DispatchStats()
+		rtn.HeavyDispatch = app.appRuntime.HeavyDispatchStats()
+	}
+	if rtn.Connected && !app.client.IsConnected() {
+		rtn.Connected = false
+	}
+	return rtn
+}
+
+// called after the app's runtime is connected or reconnected
+func (app *App) setConnected() {
+	app.status.lock.Lock()
+	app.status.connected = true
+	app.status.connectTs = dashutil.Ts()
+	app.status.numConnects++
+	if len(app.status.onConnectFns) == 0 || app.status.onConnectBusy {
+		app.status.lock.Unlock()
+		return
+	}
+	app.status.onConnectBusy = true
+	fns := make([]OnConnectFuncType, len(app.status.onConnectFns))
+	copy(fns, app.status.onConnectFns)
+	app.status.lock.Unlock()
+	go app.runOnConnect(fns)
+}
+
+func (app *App) setDisconnected() {
+	app.status.lock.Lock()
+	defer app.status.lock.Unlock()
+	app.status.connected = false
+}
+
+func (app *App) runOnConnect(fns []OnConnectFuncType) {
+	var rtnErr error
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			rtnErr = fmt.Errorf("PANIC in OnConnect %v", panicErr)
+		}
+		if rtnErr != nil {
+			app.client.log("Dashborg app:%s OnConnect error: %v\n", app.appName, rtnErr)
+		}
+		app.status.lock.Lock()
+		defer app.status.lock.Unlock()
+		app.status.onConnectBusy = false
+		app.status.onConnectTs = dashutil.Ts()
+		app.status.onConnectErr = rtnErr
+	}()
+	ctx, cancelFn := context.WithTimeout(context.Background(), onConnectTimeout)
+	defer cancelFn()
+	for _, fn := range fns {
+		err := fn(ctx)
+		if err != nil {
+			rtnErr = err
+			return
+		}
+	}
+}
+
+func (pc *DashCloudClient) registerConnectedApp(runtimePath string, app *App) {
+	pc.Lock.Lock()
+	pc.connectedApps[runtimePath] = app
+	pc.Lock.Unlock()
+	app.setConnected()
+}
+
+func (pc *DashCloudClient) getConnectedApp(runtimePath string) *App {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	return pc.connectedApps[runtimePath]
+}
@@ -0,0 +1,149 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Policies for loading an AppConfig written by a newer (or different) client version that
+// contains options this client does not know about (see Config.AppVersionPolicy).
+const (
+	AppVersionRefuse   = "refuse"   // return an ErrCodeVersion error (default)
+	AppVersionPreserve = "preserve" // keep unknown options (AppConfig.ExtraOpts) and write them back unchanged
+	AppVersionDrop     = "drop"     // drop unknown options (logs a warning)
+)
+
+type appConfigAlias AppConfig
+
+var appConfigKnownKeys map[string]bool
+
+func init() {
+	appConfigKnownKeys = make(map[string]bool)
+	configType := reflect.TypeOf(AppConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		tag := configType.Field(i).Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		appConfigKnownKeys[name] = true
+	}
+}
+
+// Decodes the AppConfig, options this client does not know about are saved in ExtraOpts.
+func (config *AppConfig) UnmarshalJSON(data []byte) error {
+	var alias appConfigAlias
+	err := json.Unmarshal(data, &alias)
+	if err != nil {
+		return err
+	}
+	var rawMap map[string]json.RawMessage
+	err = json.Unmarshal(data, &rawMap)
+	if err != nil {
+		return err
+	}
+	alias.ExtraOpts = nil
+	for key, val := range rawMap {
+		if appConfigKnownKeys[key] {
+			continue
+		}
+		if alias.ExtraOpts == nil {
+			alias.ExtraOpts = make(map[string]json.RawMessage)
+		}
+		alias.ExtraOpts[key] = val
+	}
+	*config = AppConfig(alias)
+	return nil
+}
+
+// Encodes the AppConfig, including ExtraOpts (known options take precedence).
+func (config AppConfig) MarshalJSON() ([]byte, error) {
+	alias := appConfigAlias(config)
+	if len(alias.ExtraOpts) == 0 {
+		return json.Marshal(alias)
+	}
+	barr, err := json.Marshal(alias)
+	if err != nil {
+		return nil, err
+	}
+	var rawMap map[string]json.RawMessage
+	err = json.Unmarshal(barr, &rawMap)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range alias.ExtraOpts {
+		if _, found := rawMap[key]; found {
+			continue
+		}
+		rawMap[key] = val
+	}
+	return json.Marshal(rawMap)
+}
+
+// Returns the names of options not known by this client (sorted).  Options registered with
+// RegisterAppOption are known.
+func (config *AppConfig) UnknownOpts() []string {
+	var rtn []string
+	for key := range config.ExtraOpts {
+		if isAppOptionRegistered(key) {
+			continue
+		}
+		rtn = append(rtn, key)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func isAppVersionPolicyValid(policy string) bool {
+	return policy == "" || policy == AppVersionRefuse || policy == AppVersionPreserve || policy == AppVersionDrop
+}
+
+// Checks an AppConfig read from the Dashborg service against this client's version.  A
+// config written by a newer client version, or one with unknown options, is refused unless
+// the policy allows migrating it (so an older SDK cannot silently drop newer options).
+func checkAppConfigVersion(client *DashCloudClient, cfg *AppConfig) error {
+	policy := AppVersionRefuse
+	if client != nil && client.Config != nil && client.Config.AppVersionPolicy != "" {
+		policy = client.Config.AppVersionPolicy
+	}
+	if !isAppVersionPolicyValid(policy) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppVersionPolicy '%s'", policy))
+	}
+	isNewer := false
+	if cfg.ClientVersion != "" {
+		cmp, err := dashutil.CompareClientVersions(cfg.ClientVersion, ClientVersion)
+		isNewer = (err == nil && cmp > 0)
+	}
+	unknownOpts := cfg.UnknownOpts()
+	if len(unknownOpts) == 0 {
+		if isNewer && client != nil {
+			client.logV("Dashborg app '%s' was written by a newer client version %s (this client %s)\n", cfg.AppName, cfg.ClientVersion, ClientVersion)
+		}
+		return nil
+	}
+	switch policy {
+	case AppVersionPreserve:
+		if client != nil {
+			client.log("Dashborg app '%s' (client version %s) has unknown options [%s], preserving\n", cfg.AppName, cfg.ClientVersion, strings.Join(unknownOpts, ", "))
+		}
+		return nil
+
+	case AppVersionDrop:
+		if client != nil {
+			client.log("Dashborg WARNING app '%s' (client version %s) has unknown options [%s], dropping\n", cfg.AppName, cfg.ClientVersion, strings.Join(unknownOpts, ", "))
+		}
+		for _, optName := range unknownOpts {
+			delete(cfg.ExtraOpts, optName)
+		}
+		return nil
+
+	default:
+		return dasherr.NoRetryErrWithCode(dasherr.ErrCodeVersion, fmt.Errorf("App '%s' was written by client version %s (this client %s) and has options this client does not support [%s].  Upgrade the SDK, or set Config.AppVersionPolicy to '%s' or '%s' to migrate", cfg.AppName, cfg.ClientVersion, ClientVersion, strings.Join(unknownOpts, ", "), AppVersionPreserve, AppVersionDrop))
+	}
+}
@@ -0,0 +1,106 @@
+package dash
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// A likely misconfiguration in an app's options.  Warnings do not stop an app from being
+// written unless strict mode is on (see WriteAppOpts.Strict and Config.StrictAppOptions).
+type OptionWarning struct {
+	Option  string `json:"option"` // the AppConfig option (json key) the warning is about
+	Message string `json:"message"`
+}
+
+func (w OptionWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Option, w.Message)
+}
+
+// Options for DashAppClient.WriteAppWithOpts.
+type WriteAppOpts struct {
+	Connect bool // connect the app's runtime (same as WriteAndConnectApp)
+	Strict  bool // treat OptionWarnings as errors (the app is not written)
+}
+
+// Result of DashAppClient.WriteAppWithOpts.
+type WriteAppResult struct {
+	AppName  string
+	AppLink  string
+	Warnings []OptionWarning
+}
+
+// Returns the option warnings for the app's current configuration.
+func (app *App) OptionWarnings() []OptionWarning {
+	var rtn []OptionWarning
+	cfg := app.appConfig
+	if cfg.InitRequired && cfg.OfflineAccess {
+		rtn = append(rtn, OptionWarning{Option: "offlineaccess", Message: "app has InitRequired set, it cannot be viewed offline"})
+	}
+	if cfg.AppVisType != "" && cfg.AppVisType != VisTypeHidden && cfg.AppVisType != VisTypeDefault && cfg.AppVisType != VisTypeAlwaysVisible {
+		rtn = append(rtn, OptionWarning{Option: "appvistype", Message: fmt.Sprintf("unknown visibility type '%s', will be treated as '%s'", cfg.AppVisType, VisTypeDefault)})
+	}
+	if cfg.AppVisType == VisTypeHidden && cfg.AppVisOrder != 0 {
+		rtn = append(rtn, OptionWarning{Option: "appvisorder", Message: "AppVisOrder is ignored for hidden apps"})
+	}
+	if app.htmlFromRuntime && !app.HasExternalRuntime() && app.appRuntime != nil && !app.appRuntime.hasHandler(pathFragHtml) {
+		rtn = append(rtn, OptionWarning{Option: "htmlpath", Message: "HTML is set from the runtime, but the runtime has no HTML handler (see SetHtmlHandler)"})
+	}
+	if !app.hasHtml() && !app.HasExternalRuntime() {
+		rtn = append(rtn, OptionWarning{Option: "htmlpath", Message: "app has no HTML set"})
+	}
+	for _, optName := range cfg.UnknownOpts() {
+		rtn = append(rtn, OptionWarning{Option: optName, Message: fmt.Sprintf("option is not known by this client version (%s), preserved as-is", ClientVersion)})
+	}
+	return rtn
+}
+
+func (app *App) hasHtml() bool {
+	return app.htmlStr != "" || app.htmlFileName != "" || app.htmlExtPath != "" || app.htmlFromRuntime || app.appConfig.HtmlPath != ""
+}
+
+func (apprt *AppRuntimeImpl) hasHandler(name string) bool {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	_, ok := apprt.handlers[name]
+	return ok
+}
+
+func optionWarningsErr(appName string, warnings []OptionWarning) error {
+	var warnStrs []string
+	for _, w := range warnings {
+		warnStrs = append(warnStrs, w.String())
+	}
+	return dasherr.ValidateErr(fmt.Errorf("App '%s' has option warnings (strict mode): %s", appName, strings.Join(warnStrs, "; ")))
+}
+
+// Writes the app (and optionally connects its runtime), returning the option warnings
+// instead of only logging them.  With opts.Strict (or Config.StrictAppOptions), any
+// warning is returned as an error and the app is not written (for CI deploys).
+// Usage: result, err := client.AppClient().WriteAppWithOpts(app, &dash.WriteAppOpts{Strict: true})
+func (dac *DashAppClient) WriteAppWithOpts(app *App, opts *WriteAppOpts) (*WriteAppResult, error) {
+	if opts == nil {
+		opts = &WriteAppOpts{}
+	}
+	warnings := app.OptionWarnings()
+	if len(warnings) > 0 && (opts.Strict || dac.client.Config.StrictAppOptions) {
+		return &WriteAppResult{AppName: app.AppName(), Warnings: warnings}, optionWarningsErr(app.AppName(), warnings)
+	}
+	appLink, err := dac.baseWriteApp(app, opts.Connect)
+	if err != nil {
+		return nil, err
+	}
+	return &WriteAppResult{AppName: app.AppName(), AppLink: appLink, Warnings: warnings}, nil
+}
+
+func (dac *DashAppClient) writeAppLogWarnings(app *App, shouldConnect bool) error {
+	result, err := dac.WriteAppWithOpts(app, &WriteAppOpts{Connect: shouldConnect})
+	if err != nil {
+		return err
+	}
+	for _, w := range result.Warnings {
+		dac.client.log("Dashborg App [%s] option warning %s\n", result.AppName, w.String())
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package dash
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	AuthzMwName     = "authz"
+	AuthzMwPriority = 120 // runs before NonceMwPriority (denied calls do not consume a nonce)
+)
+
+// A declarative authorization rule for AuthzMiddleware.  Path and Handler are glob patterns
+// (path.Match), Path is matched against the request path without the handler fragment
+// (e.g. "/_/apps/myapp/_/runtime" or a linked runtime path), Handler against the handler name.
+// Empty patterns match everything.  A matching request is denied if the caller has any of
+// DenyRoles or its AuthAtom id is in DenyIds, otherwise it must have one of AllowRoles
+// (RolePublic allows everyone, RoleSuper callers are always allowed).  An empty AllowRoles
+// allows every caller that is not denied.
+type AuthzRule struct {
+	Path       string
+	Handler    string
+	AllowRoles []string
+	DenyRoles  []string
+	DenyIds    []string
+}
+
+func (rule AuthzRule) Validate() error {
+	for _, pattern := range []string{rule.Path, rule.Handler} {
+		if pattern == "" {
+			continue
+		}
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid AuthzRule pattern '%s': %w", pattern, err))
+		}
+	}
+	return nil
+}
+
+func (rule AuthzRule) matches(reqPath string, handlerName string) bool {
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, reqPath); !ok {
+			return false
+		}
+	}
+	if rule.Handler != "" {
+		if ok, _ := path.Match(rule.Handler, handlerName); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// callers always have RolePublic
+func authzHasRole(authData *AuthAtom, role string) bool {
+	return role == RolePublic || authData.HasRole(role)
+}
+
+// returns nil if authData is allowed by the rule
+func (rule AuthzRule) check(authData *AuthAtom, handlerName string) error {
+	for _, role := range rule.DenyRoles {
+		if authzHasRole(authData, role) {
+			return dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Forbidden, role '%s' cannot call handler '%s'", role, handlerName))
+		}
+	}
+	if authData != nil && authData.Id != "" {
+		for _, id := range rule.DenyIds {
+			if id == authData.Id {
+				return dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Forbidden, user '%s' cannot call handler '%s'", id, handlerName))
+			}
+		}
+	}
+	if len(rule.AllowRoles) == 0 || authData.IsSuper() {
+		return nil
+	}
+	for _, role := range rule.AllowRoles {
+		if authzHasRole(authData, role) {
+			return nil
+		}
+	}
+	if authData == nil {
+		return dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Not authenticated, handler '%s' requires one of roles %v", handlerName, rule.AllowRoles))
+	}
+	return dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Forbidden, handler '%s' requires one of roles %v (has %v)", handlerName, rule.AllowRoles, authData.GetRoleList()))
+}
+
+// Creates a middleware that checks the request's AuthAtom against rules before the handler
+// runs.  The first rule that matches the request path and handler decides (requests that
+// match no rule are allowed).  Denied requests return a BADROLE (or BADAUTH if the caller is
+// not authenticated) error, requests whose path cannot be parsed return a BADPATH error.  Backend calls are not checked (see SetBackendACL).
+// Install with AddRawMiddleware(AuthzMwName, mw, AuthzMwPriority).
+// Usage: mw, err := dash.AuthzMiddleware([]dash.AuthzRule{{Handler: "admin-*", AllowRoles: []string{dash.RoleAdmin}}})
+func AuthzMiddleware(rules []AuthzRule) (MiddlewareFuncType, error) {
+	for _, rule := range rules {
+		err := rule.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rulesCopy := make([]AuthzRule, len(rules))
+	copy(rulesCopy, rules)
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		if req.info.IsBackendCall {
+			return nextFn(req)
+		}
+		_, reqPath, handlerName, err := dashutil.ParseFullPath(req.info.Path, true)
+		if err != nil {
+			// fail closed, the rules cannot be matched
+			return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadPath, fmt.Errorf("Forbidden, cannot check authorization for path '%s': %w", req.info.Path, err))
+		}
+		if handlerName == "" {
+			handlerName = pathFragDefault
+		}
+		for _, rule := range rulesCopy {
+			if !rule.matches(reqPath, handlerName) {
+				continue
+			}
+			err = rule.check(req.authData, handlerName)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		return nextFn(req)
+	}, nil
+}
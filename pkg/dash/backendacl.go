@@ -0,0 +1,135 @@
+package dash
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Handler name used to set the default BackendACL for all handlers in a runtime.
+const BackendACLDefault = "*"
+
+// Controls which processes can call a runtime's handlers from the backend (requests with
+// RequestInfo().IsBackendCall set, e.g. data handler calls made by other connected processes).
+// Callers are identified by the ProcName and ProcTags the Dashborg service attaches to the
+// caller's AuthAtom ("procname" and "proctags" in AuthAtom.Data).  Frontend requests are not affected.
+type BackendACL struct {
+	DenyAll          bool              // reject all backend calls
+	AllowedProcNames []string          // if set, the caller's ProcName must be in this list
+	AllowedProcTags  map[string]string // if set, the caller must have all of these ProcTags (key and value must match)
+}
+
+type backendACLSet struct {
+	lock *sync.Mutex
+	acls map[string]*BackendACL
+}
+
+func makeBackendACLSet() *backendACLSet {
+	return &backendACLSet{lock: &sync.Mutex{}, acls: make(map[string]*BackendACL)}
+}
+
+func (s *backendACLSet) set(handlerName string, acl *BackendACL) error {
+	if handlerName != BackendACLDefault && !dashutil.IsPathFragValid(handlerName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid handler name '%s' for BackendACL", handlerName))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if acl == nil {
+		delete(s.acls, handlerName)
+		return nil
+	}
+	aclCopy := *acl
+	s.acls[handlerName] = &aclCopy
+	return nil
+}
+
+func (s *backendACLSet) get(handlerName string) *BackendACL {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if acl := s.acls[handlerName]; acl != nil {
+		return acl
+	}
+	return s.acls[BackendACLDefault]
+}
+
+// returns the caller's procname and proctags (from the AuthAtom) for backend calls
+func backendCallerInfo(req *AppRequest) (string, map[string]string) {
+	if req.authData == nil || req.authData.Data == nil {
+		return "", nil
+	}
+	procName, _ := req.authData.Data["procname"].(string)
+	procTags := make(map[string]string)
+	if tagsMap, ok := req.authData.Data["proctags"].(map[string]interface{}); ok {
+		for key, val := range tagsMap {
+			if strVal, ok := val.(string); ok {
+				procTags[key] = strVal
+			}
+		}
+	}
+	return procName, procTags
+}
+
+func (acl *BackendACL) allows(procName string, procTags map[string]string) bool {
+	if acl.DenyAll {
+		return false
+	}
+	if len(acl.AllowedProcNames) > 0 {
+		found := false
+		for _, allowedName := range acl.AllowedProcNames {
+			if allowedName == procName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, val := range acl.AllowedProcTags {
+		if callerVal, ok := procTags[key]; !ok || callerVal != val {
+			return false
+		}
+	}
+	return true
+}
+
+// returns an error if req is a backend call that is not allowed for the given handler
+func (s *backendACLSet) check(req *AppRequest, handlerName string) error {
+	if !req.info.IsBackendCall {
+		return nil
+	}
+	acl := s.get(handlerName)
+	if acl == nil {
+		return nil
+	}
+	procName, procTags := backendCallerInfo(req)
+	if !acl.allows(procName, procTags) {
+		return dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Backend caller proc:%s not allowed to call handler '%s'", procName, handlerName))
+	}
+	return nil
+}
+
+// Sets the BackendACL for a handler (or BackendACLDefault for all handlers without their
+// own ACL).  Pass a nil acl to remove it.
+func (apprt *AppRuntimeImpl) SetBackendACL(handlerName string, acl *BackendACL) {
+	err := apprt.backendACLs.set(handlerName, acl)
+	if err != nil {
+		apprt.addError(err)
+	}
+}
+
+// Sets the BackendACL for a handler (or BackendACLDefault for all handlers without their
+// own ACL).  Pass a nil acl to remove it.
+func (linkrt *LinkRuntimeImpl) SetBackendACL(handlerName string, acl *BackendACL) {
+	err := linkrt.backendACLs.set(handlerName, acl)
+	if err != nil {
+		linkrt.addError(err)
+	}
+}
+
+// Sets the app's default BackendACL (see AppRuntimeImpl.SetBackendACL).
+func (app *App) SetBackendACL(acl *BackendACL) {
+	app.appRuntime.SetBackendACL(BackendACLDefault, acl)
+}
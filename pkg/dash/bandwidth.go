@@ -0,0 +1,134 @@
+package dash
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+)
+
+const (
+	BandwidthUpload   = "upload"
+	BandwidthDownload = "download"
+
+	BandwidthCategoryBlob     = "blob"     // blob uploads (UploadFile)
+	BandwidthCategoryStream   = "stream"   // responses pushed to streams
+	BandwidthCategoryResponse = "response" // responses to non-stream requests
+	BandwidthCategoryFile     = "file"     // file contents read back from the service (blobs, configs)
+
+	// largest single read/wait for a rate limited transfer (keeps waits smooth for large bodies)
+	maxRateLimitChunk = 32 * 1024
+)
+
+// token bucket limiting a transfer to rate bytes/sec with a burst of one second.  A transfer
+// larger than the available tokens is allowed, the bucket goes into debt and the next
+// transfer waits for it to refill.  A nil *rateLimiter does not limit.
+type rateLimiter struct {
+	lock       *sync.Mutex
+	rate       float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func makeRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{lock: &sync.Mutex{}, rate: float64(bytesPerSec), tokens: float64(bytesPerSec), lastRefill: time.Now()}
+}
+
+// must hold lock
+func (rl *rateLimiter) refillNoLock() {
+	now := time.Now()
+	rl.tokens += now.Sub(rl.lastRefill).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.lastRefill = now
+}
+
+// takes n bytes from the bucket, waiting until the bucket is out of debt.  Returns ctx.Err()
+// if ctx is done while waiting.
+func (rl *rateLimiter) wait(ctx context.Context, n int) error {
+	if rl == nil || n <= 0 {
+		return nil
+	}
+	rl.lock.Lock()
+	rl.refillNoLock()
+	var delay time.Duration
+	if rl.tokens < 0 {
+		delay = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+	rl.tokens -= float64(n)
+	rl.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > maxRateLimitChunk {
+		p = p[0:maxRateLimitChunk]
+	}
+	n, err := lr.r.Read(p)
+	waitErr := lr.limiter.wait(lr.ctx, n)
+	if err == nil && waitErr != nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+// wraps r with the upload rate limit (r is returned if there is no limit)
+func (pc *DashCloudClient) limitUploadReader(ctx context.Context, r io.Reader) io.Reader {
+	if pc.uploadLimiter == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: pc.uploadLimiter}
+}
+
+// approximate payload size of a response (action data, html, and blobs)
+func responseSize(m *dashproto.SendResponseMessage) int {
+	if m == nil {
+		return 0
+	}
+	size := 0
+	for _, rra := range m.Actions {
+		size += len(rra.JsonData) + len(rra.Html) + len(rra.BlobBytes)
+	}
+	return size
+}
+
+// waits for the upload rate limit and counts the bytes sent
+func (pc *DashCloudClient) meterUpload(ctx context.Context, category string, n int) error {
+	pc.addBandwidthBytes(BandwidthUpload, category, n)
+	return pc.uploadLimiter.wait(ctx, n)
+}
+
+// waits for the download rate limit (bytes already received) and counts them
+func (pc *DashCloudClient) meterDownload(ctx context.Context, category string, n int) error {
+	pc.addBandwidthBytes(BandwidthDownload, category, n)
+	return pc.downloadLimiter.wait(ctx, n)
+}
+
+func (pc *DashCloudClient) addBandwidthBytes(direction string, category string, n int) {
+	if n <= 0 {
+		return
+	}
+	pc.metrics.add(MetricNetworkBytes, float64(n), direction, category)
+}
@@ -0,0 +1,128 @@
+package dash
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const DefaultBatchParallelism = 4
+const MaxBatchParallelism = 32
+
+// Options for DashAppClient.WriteApps and RemoveApps.
+type BatchOpts struct {
+	Parallelism int  // maximum concurrent operations (defaults to DefaultBatchParallelism, max MaxBatchParallelism)
+	Connect     bool // WriteApps only, connect each app's runtime (WriteAndConnectApp)
+	Strict      bool // WriteApps only, treat OptionWarnings as errors (see WriteAppOpts)
+}
+
+// Result for a single app in a batch operation.  WriteResult is only set for WriteApps.
+type BatchAppResult struct {
+	AppName     string
+	WriteResult *WriteAppResult
+	Err         error
+}
+
+// Results of a batch operation, in the same order as the apps passed in.
+type BatchResult struct {
+	Results []BatchAppResult
+}
+
+// Returns nil if every operation succeeded, otherwise the errors (wrapped with the app name).
+func (r *BatchResult) Err() error {
+	var errs []error
+	for _, result := range r.Results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("App '%s': %w", result.AppName, result.Err))
+		}
+	}
+	return dashutil.ConvertErrArray(errs)
+}
+
+// Returns the number of operations that failed.
+func (r *BatchResult) NumErrors() int {
+	rtn := 0
+	for _, result := range r.Results {
+		if result.Err != nil {
+			rtn++
+		}
+	}
+	return rtn
+}
+
+func (opts *BatchOpts) parallelism() int {
+	if opts == nil || opts.Parallelism <= 0 {
+		return DefaultBatchParallelism
+	}
+	if opts.Parallelism > MaxBatchParallelism {
+		return MaxBatchParallelism
+	}
+	return opts.Parallelism
+}
+
+// runs fn for each index with bounded parallelism
+func runBatch(num int, parallelism int, fn func(idx int)) {
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for idx := 0; idx < num; idx++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(idx)
+		}(idx)
+	}
+	wg.Wait()
+}
+
+// Writes multiple apps concurrently (config, HTML, and optionally connecting runtimes, see
+// WriteAppWithOpts) with bounded parallelism.  Every app is attempted, check the per-app
+// results or BatchResult.Err().  Apps with duplicate names are not written.
+// Usage: result := client.AppClient().WriteApps(apps, &dash.BatchOpts{Parallelism: 8}); err := result.Err()
+func (dac *DashAppClient) WriteApps(apps []*App, opts *BatchOpts) *BatchResult {
+	rtn := &BatchResult{Results: make([]BatchAppResult, len(apps))}
+	seen := make(map[string]bool)
+	var toWrite []int
+	for idx, app := range apps {
+		if app == nil {
+			rtn.Results[idx] = BatchAppResult{Err: dasherr.ValidateErr(fmt.Errorf("App is nil"))}
+			continue
+		}
+		rtn.Results[idx].AppName = app.AppName()
+		if seen[app.AppName()] {
+			rtn.Results[idx].Err = dasherr.ValidateErr(fmt.Errorf("Duplicate app in batch"))
+			continue
+		}
+		seen[app.AppName()] = true
+		toWrite = append(toWrite, idx)
+	}
+	writeOpts := &WriteAppOpts{}
+	if opts != nil {
+		writeOpts.Connect = opts.Connect
+		writeOpts.Strict = opts.Strict
+	}
+	runBatch(len(toWrite), opts.parallelism(), func(batchIdx int) {
+		idx := toWrite[batchIdx]
+		result, err := dac.WriteAppWithOpts(apps[idx], writeOpts)
+		rtn.Results[idx].WriteResult = result
+		rtn.Results[idx].Err = err
+	})
+	return rtn
+}
+
+// Removes multiple apps concurrently (see RemoveApp) with bounded parallelism.
+func (dac *DashAppClient) RemoveApps(appNames []string, opts *BatchOpts) *BatchResult {
+	rtn := &BatchResult{Results: make([]BatchAppResult, len(appNames))}
+	for idx, appName := range appNames {
+		rtn.Results[idx].AppName = appName
+	}
+	runBatch(len(appNames), opts.parallelism(), func(idx int) {
+		rtn.Results[idx].Err = dac.RemoveApp(appNames[idx])
+	})
+	return rtn
+}
@@ -0,0 +1,80 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Metadata for a blob returned by GetBlobData.  Sha256 is base64 encoded (same as FileInfo.Sha256).
+type BlobData struct {
+	Path      string // full Dashborg FS path
+	MimeType  string
+	Sha256    string
+	Size      int64
+	UpdatedTs int64
+}
+
+// reads a static path's contents (via FileInfo), returns nil FileInfo if the path does not exist.
+// the content is checked against the stored size and Sha256.
+func (pc *DashCloudClient) readStaticPath(fullPath string) (*FileInfo, []byte, error) {
+	finfos, content, err := pc.fileInfo(fullPath, nil, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(finfos) == 0 || finfos[0].Removed {
+		return nil, nil, nil
+	}
+	finfo := finfos[0]
+	if finfo.FileType != FileTypeStatic {
+		return nil, nil, dasherr.ValidateErr(fmt.Errorf("Path '%s' is not a static file (file-type:%s)", fullPath, finfo.FileType))
+	}
+	if int64(len(content)) != finfo.Size || (finfo.Sha256 != "" && dashutil.Sha256Base64(content) != finfo.Sha256) {
+		return nil, nil, dasherr.ErrWithCode(dasherr.ErrCodeProtocol, fmt.Errorf("Path '%s' content does not match size/sha256 (received %d bytes, expected %d)", fullPath, len(content), finfo.Size))
+	}
+	return finfo, content, nil
+}
+
+// Reads back a blob (a static file) stored under the app's path.  blobKey is the path relative
+// to the app (e.g. "/images/logo.png").  Returns ErrCodePathNotFound if the blob does not exist.
+// The content is verified against the stored size and sha256.  The caller must close the reader.
+// Note that the service returns the content in a single response, so large blobs are
+// held in memory.
+// Usage: blobData, r, err := client.GetBlobData("myapp", "/images/logo.png")
+func (pc *DashCloudClient) GetBlobData(appName string, blobKey string) (*BlobData, io.ReadCloser, error) {
+	if !dashutil.IsAppNameValid(appName) {
+		return nil, nil, dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	err := dashutil.Path(blobKey).Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	fullPath := AppPathFromName(appName) + blobKey
+	finfo, content, err := pc.readStaticPath(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if finfo == nil {
+		return nil, nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Blob '%s' not found for app '%s'", blobKey, appName))
+	}
+	blobData := &BlobData{
+		Path:      finfo.Path,
+		MimeType:  finfo.MimeType,
+		Sha256:    finfo.Sha256,
+		Size:      finfo.Size,
+		UpdatedTs: finfo.UpdatedTs,
+	}
+	return blobData, ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Reads back a blob stored under this app's path (see DashCloudClient.GetBlobData).
+func (app *App) GetBlobData(blobKey string) (*BlobData, io.ReadCloser, error) {
+	if app.client == nil {
+		return nil, nil, dasherr.ValidateErr(fmt.Errorf("App '%s' has no client (use DashCloudClient.OpenApp)", app.appName))
+	}
+	return app.client.GetBlobData(app.appName, blobKey)
+}
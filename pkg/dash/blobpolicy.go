@@ -0,0 +1,254 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Passed to BlobScanner.ScanBlob.
+type BlobScanInfo struct {
+	AppName  string
+	FullPath string
+	MimeType string
+	Size     int64
+}
+
+// Hook to scan blob content (e.g. a virus scanner) before it is written to Dashborg FS.
+// Return an error to reject the write.
+type BlobScanner interface {
+	ScanBlob(info BlobScanInfo, r io.Reader) error
+}
+
+// Policy for blob and static file writes (SetBlob, SetRawPath, SetStaticPath, etc.), checked
+// before any bytes are sent.  Set per app with SetBlobPolicy.  Extension checks and the
+// Scanner only apply to Dashborg FS writes (frontend blobs have no file name).
+type BlobPolicy struct {
+	AllowedMimeTypes  []string // e.g. "image/png" or "image/*", empty allows all
+	MaxSize           int64    // 0 for no limit (beyond the service limits)
+	AllowedExtensions []string // e.g. ".png", empty allows all
+	DeniedExtensions  []string // e.g. ".exe"
+	CheckExtMimeType  bool     // reject files whose extension maps to a different mime type
+	Scanner           BlobScanner
+
+	// Run (in order) on the content before the size check and Scanner, e.g. ExifScrubber.
+	// Content is buffered in memory when transformers are set.
+	Transformers []BlobTransformer
+
+	// Name of a BlobStore (see SetBlobStore).  Files of at least ExternalMinSize are uploaded
+	// to the store and only a BlobRef is written to Dashborg FS (see GetBlobRef).
+	ExternalStore   string
+	ExternalMinSize int64
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+func (p *BlobPolicy) Validate() error {
+	for _, mimeType := range p.AllowedMimeTypes {
+		if strings.HasSuffix(mimeType, "/*") {
+			continue
+		}
+		if !dashutil.IsMimeTypeValid(mimeType) {
+			return dasherr.ValidateErr(fmt.Errorf("BlobPolicy invalid AllowedMimeType '%s'", mimeType))
+		}
+	}
+	if p.MaxSize < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("BlobPolicy invalid MaxSize %d", p.MaxSize))
+	}
+	return nil
+}
+
+func (p *BlobPolicy) mimeTypeAllowed(mimeType string) bool {
+	if len(p.AllowedMimeTypes) == 0 {
+		return true
+	}
+	baseType := strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	for _, allowed := range p.AllowedMimeTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == baseType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(baseType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks mime type and size (pass 0 if the size is not known yet)
+func (p *BlobPolicy) checkBlob(mimeType string, size int64) error {
+	if !p.mimeTypeAllowed(mimeType) {
+		return dasherr.ValidateErr(fmt.Errorf("BlobPolicy mime-type '%s' not allowed", mimeType))
+	}
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return dasherr.ValidateErr(fmt.Errorf("BlobPolicy size %d exceeds max-size %d", size, p.MaxSize))
+	}
+	return nil
+}
+
+func (p *BlobPolicy) checkFileName(fullPath string, mimeType string) error {
+	ext := normalizeExt(path.Ext(fullPath))
+	for _, denied := range p.DeniedExtensions {
+		if ext == normalizeExt(denied) {
+			return dasherr.ValidateErr(fmt.Errorf("BlobPolicy extension '%s' not allowed", ext))
+		}
+	}
+	if len(p.AllowedExtensions) > 0 {
+		found := false
+		for _, allowed := range p.AllowedExtensions {
+			if ext == normalizeExt(allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return dasherr.ValidateErr(fmt.Errorf("BlobPolicy extension '%s' not allowed", ext))
+		}
+	}
+	if p.CheckExtMimeType && ext != "" {
+		extMimeType := mime.TypeByExtension(ext)
+		if extMimeType != "" && strings.Split(extMimeType, ";")[0] != strings.Split(mimeType, ";")[0] {
+			return dasherr.ValidateErr(fmt.Errorf("BlobPolicy extension '%s' does not match mime-type '%s'", ext, mimeType))
+		}
+	}
+	return nil
+}
+
+// Sets the blob policy for an app's writes (appName "" sets the default for all paths).
+// Pass nil to remove the policy.
+// Usage: client.SetBlobPolicy("myapp", &dash.BlobPolicy{AllowedMimeTypes: []string{"image/*"}, MaxSize: 5 << 20})
+func (pc *DashCloudClient) SetBlobPolicy(appName string, policy *BlobPolicy) error {
+	if appName != "" && !dashutil.IsAppNameValid(appName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	if policy != nil {
+		err := policy.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if policy == nil {
+		delete(pc.blobPolicies, appName)
+		return nil
+	}
+	pc.blobPolicies[appName] = policy
+	return nil
+}
+
+// Sets the blob policy for this app's writes (see DashCloudClient.SetBlobPolicy).
+func (app *App) SetBlobPolicy(policy *BlobPolicy) error {
+	if app.client == nil {
+		return dasherr.ValidateErr(fmt.Errorf("App '%s' has no client (use DashCloudClient.OpenApp)", app.appName))
+	}
+	return app.client.SetBlobPolicy(app.appName, policy)
+}
+
+func (pc *DashCloudClient) getBlobPolicy(appName string) *BlobPolicy {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if policy, ok := pc.blobPolicies[appName]; ok {
+		return policy
+	}
+	return pc.blobPolicies[""]
+}
+
+// enforces the app's blob policy for a static path write.  returns the reader to upload
+// (content is buffered if it had to be scanned and r cannot seek).  if the content is
+// stored externally (storeName or BlobPolicy.ExternalStore), fileOpts is updated to the
+// BlobRef file and the BlobRef reader is returned.
+func (pc *DashCloudClient) enforceFilePolicy(fullPath string, r io.Reader, fileOpts *FileOpts, storeName string) (io.Reader, error) {
+	if r == nil || fileOpts.FileType != FileTypeStatic || fileOpts.MimeType == MimeTypeBlobRef {
+		return r, nil
+	}
+	appName := dashutil.AppNameFromPath(fullPath)
+	var policy *BlobPolicy
+	if appName != "" || !strings.HasPrefix(fullPath, "/_/") {
+		// internal paths (trash, snapshots) are not subject to the default policy
+		policy = pc.getBlobPolicy(appName)
+	}
+	if policy != nil {
+		var err error
+		r, err = policy.apply(appName, fullPath, r, fileOpts)
+		if err != nil {
+			return nil, err
+		}
+		if storeName == "" && policy.ExternalStore != "" && fileOpts.Size >= policy.ExternalMinSize {
+			storeName = policy.ExternalStore
+		}
+	}
+	if storeName == "" {
+		return r, nil
+	}
+	refReader, refOpts, err := pc.putExternalBlob(storeName, fullPath, r, fileOpts)
+	if err != nil {
+		return nil, err
+	}
+	*fileOpts = *refOpts
+	return refReader, nil
+}
+
+func (policy *BlobPolicy) apply(appName string, fullPath string, r io.Reader, fileOpts *FileOpts) (io.Reader, error) {
+	err := policy.checkBlob(fileOpts.MimeType, fileOpts.Size)
+	if err == nil {
+		err = policy.checkFileName(fullPath, fileOpts.MimeType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("path:%s %w", fullPath, err)
+	}
+	if len(policy.Transformers) > 0 {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		content, err = applyBlobTransformers(policy.Transformers, fileOpts.MimeType, content)
+		if err != nil {
+			return nil, fmt.Errorf("path:%s %w", fullPath, err)
+		}
+		br := bytes.NewReader(content)
+		err = UpdateFileOptsFromReadSeeker(br, fileOpts)
+		if err != nil {
+			return nil, err
+		}
+		err = policy.checkBlob(fileOpts.MimeType, fileOpts.Size)
+		if err != nil {
+			return nil, fmt.Errorf("path:%s %w", fullPath, err)
+		}
+		r = br
+	}
+	if policy.Scanner == nil {
+		return r, nil
+	}
+	scanInfo := BlobScanInfo{AppName: appName, FullPath: fullPath, MimeType: fileOpts.MimeType, Size: fileOpts.Size}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		err = policy.Scanner.ScanBlob(scanInfo, rs)
+		if err != nil {
+			return nil, dasherr.ValidateErr(fmt.Errorf("path:%s rejected by BlobScanner: %w", fullPath, err))
+		}
+		_, err = rs.Seek(0, 0)
+		if err != nil {
+			return nil, err
+		}
+		return rs, nil
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.Scanner.ScanBlob(scanInfo, bytes.NewReader(content))
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("path:%s rejected by BlobScanner: %w", fullPath, err))
+	}
+	return bytes.NewReader(content), nil
+}
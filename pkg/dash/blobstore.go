@@ -0,0 +1,172 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const MimeTypeBlobRef = "application/x-dashborg-blobref+json"
+const DefaultBlobRefUrlTTL = 15 * time.Minute
+
+// External storage for blob content (e.g. an S3 or GCS bucket).  Implement with your cloud
+// SDK and register with DashCloudClient.SetBlobStore.  Only a BlobRef is written to Dashborg FS.
+type BlobStore interface {
+	PutBlob(ctx context.Context, key string, mimeType string, size int64, r io.Reader) error
+	// Returns a (signed) URL the frontend can load the blob from, valid for at least ttl.
+	SignedUrl(key string, ttl time.Duration) (string, error)
+}
+
+// Reference to a blob stored in a BlobStore, written as the contents of a Dashborg FS path.
+// Url and UrlExpTs are only set by GetBlobRef (they are not stored).
+type BlobRef struct {
+	Store    string `json:"store"`
+	Key      string `json:"key"`
+	MimeType string `json:"mimetype"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Url      string `json:"url,omitempty"`
+	UrlExpTs int64  `json:"urlexpts,omitempty"`
+}
+
+// Registers a BlobStore under name (for SetExternalPath and BlobPolicy.ExternalStore).
+// Pass nil to unregister.
+func (pc *DashCloudClient) SetBlobStore(name string, store BlobStore) error {
+	if name == "" {
+		return dasherr.ValidateErr(fmt.Errorf("BlobStore name cannot be empty"))
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if store == nil {
+		delete(pc.blobStores, name)
+		return nil
+	}
+	pc.blobStores[name] = store
+	return nil
+}
+
+func (pc *DashCloudClient) getBlobStore(name string) (BlobStore, error) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	store := pc.blobStores[name]
+	if store == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("BlobStore '%s' not registered (see SetBlobStore)", name))
+	}
+	return store, nil
+}
+
+// content addressed key, hex sha256 plus the path's extension
+func blobStoreKey(fullPath string, sha256B64 string) (string, error) {
+	hashBytes, err := base64.StdEncoding.DecodeString(sha256B64)
+	if err != nil {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid Sha256 for path '%s'", fullPath))
+	}
+	return hex.EncodeToString(hashBytes) + path.Ext(fullPath), nil
+}
+
+// uploads r to the store and returns the reference file (contents and FileOpts) to write in its place.
+// fileOpts must have Size, Sha256, and MimeType set.
+func (pc *DashCloudClient) putExternalBlob(storeName string, fullPath string, r io.Reader, fileOpts *FileOpts) (io.ReadSeeker, *FileOpts, error) {
+	store, err := pc.getBlobStore(storeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := blobStoreKey(fullPath, fileOpts.Sha256)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	err = store.PutBlob(ctx, key, fileOpts.MimeType, fileOpts.Size, r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("BlobStore '%s' PutBlob path:%s: %w", storeName, fullPath, err)
+	}
+	ref := BlobRef{Store: storeName, Key: key, MimeType: fileOpts.MimeType, Size: fileOpts.Size, Sha256: fileOpts.Sha256}
+	refJson, err := dashutil.MarshalJson(ref)
+	if err != nil {
+		return nil, nil, dasherr.JsonMarshalErr("BlobRef", err)
+	}
+	refOpts := *fileOpts
+	refOpts.MimeType = MimeTypeBlobRef
+	refReader := bytes.NewReader([]byte(refJson))
+	err = UpdateFileOptsFromReadSeeker(refReader, &refOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc.logPathV(fullPath, "Dashborg stored external blob %s => store:%s key:%s size:%d\n", dashutil.SimplifyPath(fullPath, nil), storeName, key, ref.Size)
+	return refReader, &refOpts, nil
+}
+
+// Uploads the content to the named BlobStore and writes a BlobRef to path (only the
+// reference is sent to Dashborg).  fileOpts must set at least MimeType.  The app's
+// BlobPolicy (see SetBlobPolicy) is applied to the content before it is uploaded.
+// Usage: err := fs.SetExternalPath("/videos/intro.mp4", "s3", fd, &dash.FileOpts{MimeType: "video/mp4"})
+func (fs *DashFSClient) SetExternalPath(path string, storeName string, r io.ReadSeeker, fileOpts *FileOpts) error {
+	if fileOpts == nil || fileOpts.MimeType == "" {
+		return dasherr.ValidateErr(fmt.Errorf("SetExternalPath requires FileOpts with a MimeType"))
+	}
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.client.getBlobStore(storeName); err != nil {
+		return err
+	}
+	err = UpdateFileOptsFromReadSeeker(r, fileOpts)
+	if err != nil {
+		return err
+	}
+	// applies the app's BlobPolicy to the content, then uploads it and swaps in the BlobRef
+	refReader, err := fs.client.enforceFilePolicy(fullPath, r, fileOpts, storeName)
+	if err != nil {
+		return err
+	}
+	return fs.client.setRawPath(fullPath, refReader, fileOpts, nil)
+}
+
+// Reads the BlobRef written to path (by SetExternalPath or a BlobPolicy.ExternalStore) and
+// sets a signed Url valid for ttl (defaults to DefaultBlobRefUrlTTL).  Set the BlobRef (or its
+// Url) as panel data to render the blob.
+// Usage: ref, err := fs.GetBlobRef("/videos/intro.mp4", 0); req.SetData("$.video", ref)
+func (fs *DashFSClient) GetBlobRef(path string, ttl time.Duration) (*BlobRef, error) {
+	if ttl <= 0 {
+		ttl = DefaultBlobRefUrlTTL
+	}
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return nil, err
+	}
+	finfo, content, err := fs.client.readStaticPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if finfo == nil {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path '%s' not found", path))
+	}
+	if finfo.MimeType != MimeTypeBlobRef {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Path '%s' is not a BlobRef (mime-type:%s)", path, finfo.MimeType))
+	}
+	var ref BlobRef
+	err = dashutil.UnmarshalJson(string(content), &ref, dashutil.JsonOpts{})
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("BlobRef", err)
+	}
+	store, err := fs.client.getBlobStore(ref.Store)
+	if err != nil {
+		return nil, err
+	}
+	ref.UrlExpTs = dashutil.DashTime(time.Now().Add(ttl))
+	ref.Url, err = store.SignedUrl(ref.Key, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("BlobStore '%s' SignedUrl key:%s: %w", ref.Store, ref.Key, err)
+	}
+	return &ref, nil
+}
@@ -0,0 +1,111 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+)
+
+const DefaultMaxStreamBlobSize = 512 * 1024 * 1024
+
+// Options for AppRequest.SetBlobWithOpts.
+type BlobOpts struct {
+	MaxSize    int64 // maximum blob size (defaults to DefaultMaxStreamBlobSize)
+	ProgressFn func(progress BlobProgress)
+}
+
+// Passed to BlobOpts.ProgressFn after each frame is read.
+type BlobProgress struct {
+	Path      string
+	BytesRead int64
+	NumFrames int
+	Streaming bool // true once frames are being sent before the handler returns
+}
+
+// Same as SetBlob, with a progress callback and size limit.  The reader is read in ~1MB
+// frames.  Small blobs are sent with the response (as with SetBlob).  Once a blob is larger
+// than a single response allows, buffered frames are flushed and every following frame is
+// sent as it is read (see Flush), so large blobs are never held in memory.  Note that
+// streamed frames are sent even if the handler later returns an error.
+// Usage: err := req.SetBlobWithOpts("$.video", "video/mp4", fd, &dash.BlobOpts{ProgressFn: showProgress})
+func (req *AppRequest) SetBlobWithOpts(path string, mimeType string, reader io.Reader, opts *BlobOpts) error {
+	if req.isDone {
+		return fmt.Errorf("Cannot call SetBlob(), path=%s, Request is already done", path)
+	}
+	if opts == nil {
+		opts = &BlobOpts{}
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxStreamBlobSize
+	}
+	if req.client != nil {
+		if policy := req.client.getBlobPolicy(req.info.AppName); policy != nil {
+			err := policy.checkBlob(mimeType, 0)
+			if err != nil {
+				return err
+			}
+			if policy.MaxSize > 0 && policy.MaxSize < maxSize {
+				maxSize = policy.MaxSize
+			}
+			if len(policy.Transformers) > 0 {
+				// transformers need the full content
+				content, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+				if err != nil {
+					return err
+				}
+				content, err = applyBlobTransformers(policy.Transformers, mimeType, content)
+				if err != nil {
+					return err
+				}
+				reader = bytes.NewReader(content)
+			}
+		}
+	}
+	canStream := (req.client != nil)
+	if !canStream && maxSize > maxRRABlobSize {
+		maxSize = maxRRABlobSize
+	}
+	var pending []*dashproto.RRAction
+	var progress BlobProgress
+	progress.Path = path
+	_, err := readBlobChunks(mimeType, reader, func(rrAction *dashproto.RRAction) error {
+		rrAction.Selector = path
+		progress.BytesRead += int64(len(rrAction.BlobBytes))
+		progress.NumFrames++
+		if progress.BytesRead > maxSize {
+			return dasherr.ValidateErr(fmt.Errorf("BLOB too large, max-size:%d, path:%s", maxSize, path))
+		}
+		if !progress.Streaming && canStream && progress.BytesRead > maxRRABlobSize {
+			progress.Streaming = true
+			for _, pendingAction := range pending {
+				req.appendRR(pendingAction)
+			}
+			pending = nil
+		}
+		if progress.Streaming {
+			req.appendRR(rrAction)
+			flushErr := req.Flush()
+			if flushErr != nil {
+				return flushErr
+			}
+		} else {
+			pending = append(pending, rrAction)
+		}
+		if opts.ProgressFn != nil {
+			opts.ProgressFn(progress)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rrAction := range pending {
+		req.appendRR(rrAction)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package dash
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const AppOptionBuildInfo = "buildinfo"
+
+const buildInfoFieldMax = 200
+
+// environment variables checked (in order) when BuildInfoOption fields are not set
+var (
+	buildInfoGitShaEnv    = []string{"DASHBORG_GITSHA", "GIT_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA"}
+	buildInfoBuildTimeEnv = []string{"DASHBORG_BUILDTIME", "BUILD_TIME"}
+	buildInfoBuilderEnv   = []string{"DASHBORG_BUILDER", "GITHUB_ACTOR", "GITLAB_USER_LOGIN", "USER"}
+)
+
+// Deploy metadata stamped by WriteApp (see App.SetBuildInfo), stored as the "buildinfo" app
+// option and in the app file's metadata.  Answers "which code is this dashboard running".
+type BuildInfoOption struct {
+	GitSha        string `json:"gitsha,omitempty"`
+	BuildTime     string `json:"buildtime,omitempty"`
+	Builder       string `json:"builder,omitempty"`
+	MainVersion   string `json:"mainversion,omitempty"` // main module version (from debug.ReadBuildInfo)
+	GoVersion     string `json:"goversion,omitempty"`
+	ClientVersion string `json:"clientversion,omitempty"`
+	DeployTs      int64  `json:"deployts,omitempty"`
+}
+
+func (BuildInfoOption) OptionName() string { return AppOptionBuildInfo }
+
+func (opt BuildInfoOption) Validate() error {
+	if len(opt.GitSha) > buildInfoFieldMax || len(opt.BuildTime) > buildInfoFieldMax || len(opt.Builder) > buildInfoFieldMax || len(opt.MainVersion) > buildInfoFieldMax {
+		return dasherr.ValidateErr(fmt.Errorf("BuildInfo fields must be at most %d characters", buildInfoFieldMax))
+	}
+	return nil
+}
+
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// Sets the build info stamped on the next WriteApp.  Empty fields are filled in from the
+// environment (DASHBORG_GITSHA, GIT_COMMIT, GITHUB_SHA, DASHBORG_BUILDTIME, DASHBORG_BUILDER, ...).
+// Usage: app.SetBuildInfo(dash.BuildInfoOption{GitSha: gitSha, BuildTime: buildTime})
+func (app *App) SetBuildInfo(info BuildInfoOption) {
+	app.buildInfo = &info
+}
+
+// returns nil if there is no git sha or build time (from SetBuildInfo or the environment) and
+// no builder was set with SetBuildInfo (a bare $USER does not stamp the app)
+func (app *App) resolveBuildInfo() *BuildInfoOption {
+	var rtn BuildInfoOption
+	if app.buildInfo != nil {
+		rtn = *app.buildInfo
+	}
+	rtn.GitSha = dashutil.DefaultString(rtn.GitSha, firstEnv(buildInfoGitShaEnv))
+	rtn.BuildTime = dashutil.DefaultString(rtn.BuildTime, firstEnv(buildInfoBuildTimeEnv))
+	rtn.Builder = dashutil.DefaultString(rtn.Builder, firstEnv(buildInfoBuilderEnv))
+	if rtn.GitSha == "" && rtn.BuildTime == "" && (app.buildInfo == nil || rtn.Builder == "") {
+		return nil
+	}
+	if rtn.MainVersion == "" {
+		if binfo, ok := debug.ReadBuildInfo(); ok {
+			rtn.MainVersion = binfo.Main.Version
+		}
+	}
+	rtn.GoVersion = runtime.Version()
+	rtn.ClientVersion = ClientVersion
+	rtn.DeployTs = dashutil.Ts()
+	return &rtn
+}
+
+// Returns the build info stamped on the app by its last WriteApp, nil if none was recorded.
+// Usage: binfo, err := client.AppClient().GetBuildInfo("myapp"); fmt.Printf("running %s\n", binfo.GitSha)
+func (dac *DashAppClient) GetBuildInfo(appName string) (*BuildInfoOption, error) {
+	if !dashutil.IsAppNameValid(appName) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	finfo, err := dac.client.GlobalFSClient().FileInfo(AppPathFromName(appName))
+	if err != nil {
+		return nil, err
+	}
+	if finfo == nil || finfo.FileType != FileTypeApp {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodeNoApp, fmt.Errorf("App '%s' not found", appName))
+	}
+	if finfo.MetadataJson == "" {
+		return nil, nil
+	}
+	var rtn BuildInfoOption
+	err = finfo.BindMetadata(&rtn)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("BuildInfo", err)
+	}
+	if rtn == (BuildInfoOption{}) {
+		return nil, nil
+	}
+	return &rtn, nil
+}
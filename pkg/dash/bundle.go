@@ -0,0 +1,277 @@
+package dash
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	BundleFormatVersion  = 1
+	bundleManifestName   = "bundle.json"
+	bundleAppConfigName  = "appconfig.json"
+	bundleFilesDir       = "files"
+	maxBundleImportBytes = 512 * 1024 * 1024
+)
+
+// A static file in an app bundle.  Path is relative to the app path (e.g. "/_/html").
+type BundleFile struct {
+	Path         string   `json:"path"`
+	MimeType     string   `json:"mimetype"`
+	Size         int64    `json:"size"`
+	Sha256       string   `json:"sha256"`
+	AllowedRoles []string `json:"allowedroles,omitempty"`
+	Hidden       bool     `json:"hidden,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	MetadataJson string   `json:"metadata,omitempty"`
+	CacheControl string   `json:"cachecontrol,omitempty"`
+}
+
+// bundle.json, the index of an app bundle (see App.ExportBundle).
+type BundleManifest struct {
+	FormatVersion int          `json:"formatversion"`
+	AppName       string       `json:"appname"`
+	ClientVersion string       `json:"clientversion"`
+	CreatedTs     int64        `json:"createdts"`
+	Files         []BundleFile `json:"files"`
+}
+
+// Options for DashAppClient.ImportBundle.
+type ImportBundleOpts struct {
+	AppName string // import under a different app name (paths under the old app path are moved)
+}
+
+// Writes the app (its AppConfig and every static file under its app path, including the HTML)
+// to w as a zip file, so it can be versioned in git or imported into another account or zone
+// with ImportBundle.  The app must already be written.  Runtime links are not exported, and
+// BlobRefs are exported as references (the blob content stays in its BlobStore).
+// Usage: fd, _ := os.Create("myapp.zip"); err := app.ExportBundle(fd)
+func (app *App) ExportBundle(w io.Writer) error {
+	if app.client == nil {
+		return dasherr.ValidateErr(fmt.Errorf("App '%s' has no client (use DashCloudClient.OpenApp)", app.appName))
+	}
+	pc := app.client
+	appPath := app.AppPath()
+	finfos, _, err := pc.fileInfo(appPath, nil, false)
+	if err != nil {
+		return err
+	}
+	if len(finfos) == 0 || finfos[0].FileType != FileTypeApp {
+		return dasherr.ErrWithCode(dasherr.ErrCodeNoApp, fmt.Errorf("App '%s' not found (must be written before it is exported)", app.appName))
+	}
+	var appConfig AppConfig
+	err = json.Unmarshal([]byte(finfos[0].AppConfigJson), &appConfig)
+	if err != nil {
+		return dasherr.JsonUnmarshalErr("AppConfig", err)
+	}
+	fileInfos, err := app.AppFSClient().DirInfo("/", &DirOpts{ShowHidden: true, Recursive: true})
+	if err != nil {
+		return err
+	}
+	sort.Slice(fileInfos, func(i int, j int) bool { return fileInfos[i].Path < fileInfos[j].Path })
+	zw := zip.NewWriter(w)
+	manifest := BundleManifest{FormatVersion: BundleFormatVersion, AppName: app.appName, ClientVersion: ClientVersion, CreatedTs: dashutil.Ts()}
+	for _, finfo := range fileInfos {
+		if finfo.FileType != FileTypeStatic || !strings.HasPrefix(finfo.Path, appPath+"/") {
+			continue
+		}
+		_, content, err := pc.readStaticPath(finfo.Path)
+		if err != nil {
+			return fmt.Errorf("ExportBundle path:%s: %w", finfo.Path, err)
+		}
+		bfile := BundleFile{
+			Path:         strings.TrimPrefix(finfo.Path, appPath),
+			MimeType:     finfo.MimeType,
+			Size:         finfo.Size,
+			Sha256:       finfo.Sha256,
+			AllowedRoles: finfo.AllowedRoles,
+			Hidden:       finfo.Hidden,
+			Description:  finfo.Description,
+			MetadataJson: finfo.MetadataJson,
+			CacheControl: finfo.CacheControl,
+		}
+		err = writeZipEntry(zw, bundleFilesDir+bfile.Path, content)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, bfile)
+	}
+	appConfigJson, err := dashutil.MarshalJsonIndent(appConfig)
+	if err != nil {
+		return dasherr.JsonMarshalErr("AppConfig", err)
+	}
+	err = writeZipEntry(zw, bundleAppConfigName, []byte(appConfigJson))
+	if err != nil {
+		return err
+	}
+	manifestJson, err := dashutil.MarshalJsonIndent(manifest)
+	if err != nil {
+		return dasherr.JsonMarshalErr("BundleManifest", err)
+	}
+	err = writeZipEntry(zw, bundleManifestName, []byte(manifestJson))
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZipEntry(zw *zip.Writer, name string, content []byte) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(content)
+	return err
+}
+
+// reads at most maxSize decompressed bytes (UncompressedSize64 is checked first, but is not
+// trusted since it comes from the zip header)
+func readZipEntry(files map[string]*zip.File, name string, maxSize int64) ([]byte, error) {
+	zf := files[name]
+	if zf == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle, missing '%s'", name))
+	}
+	if zf.UncompressedSize64 > uint64(maxSize) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle, uncompressed contents too large (max %dMB)", maxBundleImportBytes/(1024*1024)))
+	}
+	fr, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(fr, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle, uncompressed contents too large (max %dMB)", maxBundleImportBytes/(1024*1024)))
+	}
+	return content, nil
+}
+
+// moves an absolute path under oldAppPath to newAppPath
+func rebaseAppPath(fullPath string, oldAppPath string, newAppPath string) string {
+	if fullPath == oldAppPath || strings.HasPrefix(fullPath, oldAppPath+"/") || strings.HasPrefix(fullPath, oldAppPath+":") {
+		return newAppPath + fullPath[len(oldAppPath):]
+	}
+	return fullPath
+}
+
+// Reads an app bundle (see App.ExportBundle) and writes the app and its files to this
+// account/zone.  Each file is checked against the bundle's sha256.  The app is returned
+// unconnected, set its runtime (or external runtime) and call WriteAndConnectApp to serve it.
+// The whole bundle is validated before anything is written.
+// opts may be nil.
+// Usage: fd, _ := os.Open("myapp.zip"); app, err := client.AppClient().ImportBundle(fd, nil)
+func (dac *DashAppClient) ImportBundle(r io.Reader, opts *ImportBundleOpts) (*App, error) {
+	if opts == nil {
+		opts = &ImportBundleOpts{}
+	}
+	bundleBytes, err := ioutil.ReadAll(io.LimitReader(r, maxBundleImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bundleBytes) > maxBundleImportBytes {
+		return nil, dasherr.ValidateErr(fmt.Errorf("App bundle too large (max %dMB)", maxBundleImportBytes/(1024*1024)))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(bundleBytes), int64(len(bundleBytes)))
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle: %w", err))
+	}
+	zipFiles := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		zipFiles[zf.Name] = zf
+	}
+	// total decompressed bytes read from the bundle are capped at maxBundleImportBytes
+	remainingBytes := int64(maxBundleImportBytes)
+	manifestBytes, err := readZipEntry(zipFiles, bundleManifestName, remainingBytes)
+	if err != nil {
+		return nil, err
+	}
+	remainingBytes -= int64(len(manifestBytes))
+	var manifest BundleManifest
+	err = json.Unmarshal(manifestBytes, &manifest)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("BundleManifest", err)
+	}
+	if manifest.FormatVersion != BundleFormatVersion {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodeVersion, fmt.Errorf("Unsupported app bundle format version %d", manifest.FormatVersion))
+	}
+	appConfigBytes, err := readZipEntry(zipFiles, bundleAppConfigName, remainingBytes)
+	if err != nil {
+		return nil, err
+	}
+	remainingBytes -= int64(len(appConfigBytes))
+	var appConfig AppConfig
+	err = json.Unmarshal(appConfigBytes, &appConfig)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("AppConfig", err)
+	}
+	oldAppPath := AppPathFromName(appConfig.AppName)
+	if opts.AppName != "" && opts.AppName != appConfig.AppName {
+		if !dashutil.IsAppNameValid(opts.AppName) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", opts.AppName))
+		}
+		newAppPath := AppPathFromName(opts.AppName)
+		appConfig.AppName = opts.AppName
+		appConfig.HtmlPath = rebaseAppPath(appConfig.HtmlPath, oldAppPath, newAppPath)
+		appConfig.RuntimePath = rebaseAppPath(appConfig.RuntimePath, oldAppPath, newAppPath)
+	}
+	app, err := makeAppFromConfig(dac.client, appConfig)
+	if err != nil {
+		return nil, err
+	}
+	appConfigJson, err := dashutil.MarshalJson(app.appConfig)
+	if err != nil {
+		return nil, dasherr.JsonMarshalErr("AppConfig", err)
+	}
+	// validate every file before writing anything, so a bad bundle does not leave a half-imported app
+	fileContents := make([][]byte, len(manifest.Files))
+	for idx, bfile := range manifest.Files {
+		if path.Clean(bfile.Path) != bfile.Path || !strings.HasPrefix(bfile.Path, "/") {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle, bad path '%s'", bfile.Path))
+		}
+		content, err := readZipEntry(zipFiles, bundleFilesDir+bfile.Path, remainingBytes)
+		if err != nil {
+			return nil, err
+		}
+		remainingBytes -= int64(len(content))
+		if dashutil.Sha256Base64(content) != bfile.Sha256 {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid app bundle, sha256 mismatch for '%s'", bfile.Path))
+		}
+		fileContents[idx] = content
+	}
+	fs := dac.client.GlobalFSClient()
+	err = fs.SetRawPath(app.AppPath(), nil, &FileOpts{FileType: FileTypeApp, MimeType: MimeTypeDashborgApp, AllowedRoles: app.appConfig.AllowedRoles, AppConfigJson: appConfigJson}, nil)
+	if err != nil {
+		return nil, err
+	}
+	appFs := app.AppFSClient()
+	for idx, bfile := range manifest.Files {
+		fileOpts := &FileOpts{
+			MimeType:     bfile.MimeType,
+			AllowedRoles: bfile.AllowedRoles,
+			Hidden:       bfile.Hidden,
+			Description:  bfile.Description,
+			MetadataJson: bfile.MetadataJson,
+			CacheControl: bfile.CacheControl,
+		}
+		err = appFs.SetStaticPath(bfile.Path, bytes.NewReader(fileContents[idx]), fileOpts)
+		if err != nil {
+			return nil, fmt.Errorf("ImportBundle path:%s: %w", bfile.Path, err)
+		}
+	}
+	dac.client.log("Dashborg imported app bundle '%s' (%d files)\n", app.appName, len(manifest.Files))
+	dac.client.fireAppEvent(AppEvent{Type: AppEventWrite, AppName: app.appName})
+	return app, nil
+}
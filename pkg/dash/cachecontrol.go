@@ -0,0 +1,50 @@
+package dash
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	CacheControlNoCache   = "no-cache"                            // always revalidate (with the ETag)
+	CacheControlNoStore   = "no-store"                            // never cache
+	CacheControlImmutable = "public, max-age=31536000, immutable" // fingerprinted assets that never change
+)
+
+// Returns a Cache-Control value that lets browsers cache a file for maxAge.
+// Usage: fileOpts := &dash.FileOpts{MimeType: "text/css", CacheControl: dash.CacheControlMaxAge(time.Hour)}
+func CacheControlMaxAge(maxAge time.Duration) string {
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+}
+
+// Returns a strong ETag (quoted, base64url) for a base64 encoded SHA-256 hash (FileOpts.Sha256).
+// Static files get this ETag by default, so unchanged content keeps the same ETag.
+func ETagFromSha256(sha256B64 string) (string, error) {
+	hashBytes, err := base64.StdEncoding.DecodeString(sha256B64)
+	if err != nil || len(hashBytes) != 32 {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid SHA-256 hash value for ETag"))
+	}
+	return "\"" + base64.RawURLEncoding.EncodeToString(hashBytes) + "\"", nil
+}
+
+// Returns true if an If-None-Match header value matches the file's ETag (weak comparison),
+// meaning the browser's cached copy is current.
+func (finfo *FileInfo) ETagMatches(ifNoneMatch string) bool {
+	if finfo.ETag == "" || ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	fileTag := strings.TrimPrefix(finfo.ETag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == fileTag {
+			return true
+		}
+	}
+	return false
+}
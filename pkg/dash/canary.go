@@ -0,0 +1,353 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultCanaryConfigPath   = "/_/canary"
+	DefaultCanaryPollInterval = 30 * time.Second
+	DefaultCanaryMinRequests  = 20
+	minCanaryPollInterval     = 5 * time.Second
+)
+
+// Options for routing a percentage of an app's frontend clients to a canary runtime
+// (see App.SetCanaryRuntime).
+type CanaryOpts struct {
+	// Percentage (0-100) of FeClientIds routed to the canary runtime.  Routing is sticky, a
+	// given FeClientId always goes to the same runtime for a given Percent.  Requests without
+	// a FeClientId (e.g. backend calls) always go to the primary runtime.
+	Percent float64
+
+	// If set, an app relative JSON path (e.g. DefaultCanaryConfigPath) that is polled for a
+	// CanaryConfig.  Writing a new percent to this path changes the canary routing for all
+	// processes serving the app.
+	ConfigPath   string
+	PollInterval time.Duration // defaults to DefaultCanaryPollInterval
+
+	// If set (0-1), the canary is rolled back (Percent set to 0) once it has served at least
+	// MinRequests and its error rate exceeds MaxErrorRate.
+	MaxErrorRate float64
+	MinRequests  int // defaults to DefaultCanaryMinRequests
+	OnRollback   func(stats CanaryStats)
+
+	ShutdownCh chan struct{} // close to stop polling ConfigPath
+}
+
+// Format of the JSON file at CanaryOpts.ConfigPath.
+type CanaryConfig struct {
+	Percent    float64 `json:"percent"`
+	RolledBack bool    `json:"rolledback,omitempty"`
+}
+
+// Canary routing stats (counts are reset whenever the percent changes).
+type CanaryStats struct {
+	Percent          float64 `json:"percent"`
+	RolledBack       bool    `json:"rolledback"`
+	NumCanary        int64   `json:"numcanary"`
+	NumCanaryErrors  int64   `json:"numcanaryerrors"`
+	NumPrimary       int64   `json:"numprimary"`
+	NumPrimaryErrors int64   `json:"numprimaryerrors"`
+}
+
+type canaryType struct {
+	lock   *sync.Mutex
+	rt     *AppRuntimeImpl
+	opts   CanaryOpts
+	stats  CanaryStats
+	stopCh chan struct{} // closed when the canary is replaced or removed (stops the config poller)
+}
+
+func (opts *CanaryOpts) Validate() error {
+	if opts.Percent < 0 || opts.Percent > 100 {
+		return dasherr.ValidateErr(fmt.Errorf("CanaryOpts Percent must be between 0 and 100"))
+	}
+	if opts.MaxErrorRate < 0 || opts.MaxErrorRate > 1 {
+		return dasherr.ValidateErr(fmt.Errorf("CanaryOpts MaxErrorRate must be between 0 and 1"))
+	}
+	if opts.PollInterval != 0 && opts.PollInterval < minCanaryPollInterval {
+		return dasherr.ValidateErr(fmt.Errorf("CanaryOpts PollInterval must be at least %v", minCanaryPollInterval))
+	}
+	if opts.ConfigPath != "" {
+		err := dashutil.Path(opts.ConfigPath).Validate()
+		if err != nil {
+			return dasherr.ValidateErr(fmt.Errorf("CanaryOpts invalid ConfigPath: %w", err))
+		}
+	}
+	if opts.MinRequests < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("CanaryOpts MinRequests cannot be negative"))
+	}
+	return nil
+}
+
+// returns a stable bucket in [0, 10000) for the FeClientId
+func canaryBucket(feClientId string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(feClientId))
+	return h.Sum32() % 10000
+}
+
+func (c *canaryType) isCanaryRequest(req *AppRequest) bool {
+	if req.info.FeClientId == "" {
+		return false
+	}
+	c.lock.Lock()
+	percent := c.stats.Percent
+	c.lock.Unlock()
+	return float64(canaryBucket(req.info.FeClientId)) < percent*100
+}
+
+// rolledBack is set when applying a rolled back config, the counters are kept so the
+// stats still show why the canary was rolled back.
+func (c *canaryType) setPercent(percent float64, rolledBack bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if rolledBack {
+		c.stats.Percent = percent
+		c.stats.RolledBack = true
+		return
+	}
+	if percent == c.stats.Percent {
+		return
+	}
+	c.stats = CanaryStats{Percent: percent}
+}
+
+// records the result of a request, rolls back the canary if it exceeds MaxErrorRate
+func (c *canaryType) recordResult(isCanary bool, err error) {
+	c.lock.Lock()
+	if !isCanary {
+		c.stats.NumPrimary++
+		if err != nil {
+			c.stats.NumPrimaryErrors++
+		}
+		c.lock.Unlock()
+		return
+	}
+	c.stats.NumCanary++
+	if err != nil {
+		c.stats.NumCanaryErrors++
+	}
+	minRequests := int64(c.opts.MinRequests)
+	if minRequests == 0 {
+		minRequests = DefaultCanaryMinRequests
+	}
+	shouldRollback := c.opts.MaxErrorRate > 0 && c.stats.Percent > 0 && c.stats.NumCanary >= minRequests &&
+		float64(c.stats.NumCanaryErrors)/float64(c.stats.NumCanary) > c.opts.MaxErrorRate
+	if !shouldRollback {
+		c.lock.Unlock()
+		return
+	}
+	c.stats.Percent = 0
+	c.stats.RolledBack = true
+	stats := c.stats
+	onRollback := c.opts.OnRollback
+	c.lock.Unlock()
+	if onRollback != nil {
+		go onRollback(stats)
+	}
+}
+
+// Routes CanaryOpts.Percent of frontend clients to canaryRt instead of this runtime.
+// Pass a nil canaryRt to remove the canary.  Use App.SetCanaryRuntime to also poll a
+// DashFS config path.
+func (apprt *AppRuntimeImpl) SetCanaryRuntime(canaryRt *AppRuntimeImpl, opts *CanaryOpts) {
+	if canaryRt == nil {
+		apprt.setCanary(nil)
+		return
+	}
+	if canaryRt == apprt {
+		apprt.addError(dasherr.ValidateErr(fmt.Errorf("Cannot set a runtime as its own canary")))
+		return
+	}
+	if opts == nil {
+		opts = &CanaryOpts{}
+	}
+	err := opts.Validate()
+	if err != nil {
+		apprt.addError(err)
+		return
+	}
+	canary := &canaryType{lock: &sync.Mutex{}, rt: canaryRt, opts: *opts, stopCh: make(chan struct{})}
+	canary.stats.Percent = opts.Percent
+	apprt.setCanary(canary)
+}
+
+// replaces the canary, stops the previous canary's config poller
+func (apprt *AppRuntimeImpl) setCanary(canary *canaryType) {
+	apprt.lock.Lock()
+	oldCanary := apprt.canary
+	apprt.canary = canary
+	apprt.lock.Unlock()
+	if oldCanary != nil && oldCanary != canary {
+		close(oldCanary.stopCh)
+	}
+}
+
+// Changes the percentage of frontend clients routed to the canary runtime (and clears
+// the canary stats).
+func (apprt *AppRuntimeImpl) SetCanaryPercent(percent float64) {
+	if percent < 0 || percent > 100 {
+		apprt.addError(dasherr.ValidateErr(fmt.Errorf("Canary percent must be between 0 and 100")))
+		return
+	}
+	canary := apprt.getCanary()
+	if canary == nil {
+		return
+	}
+	canary.setPercent(percent, false)
+}
+
+// Returns the canary routing stats (zero value if no canary runtime is set).
+func (apprt *AppRuntimeImpl) CanaryStats() CanaryStats {
+	canary := apprt.getCanary()
+	if canary == nil {
+		return CanaryStats{}
+	}
+	canary.lock.Lock()
+	defer canary.lock.Unlock()
+	return canary.stats
+}
+
+func (apprt *AppRuntimeImpl) getCanary() *canaryType {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	return apprt.canary
+}
+
+// Routes a percentage of the app's frontend clients to canaryRt (see CanaryOpts).  If
+// CanaryOpts.ConfigPath is set, the app must be connected.  When the canary is rolled back
+// the config file is rewritten with percent 0 so other processes also roll back.
+func (app *App) SetCanaryRuntime(canaryRt *AppRuntimeImpl, opts *CanaryOpts) error {
+	if opts == nil {
+		opts = &CanaryOpts{}
+	}
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+	if canaryRt == app.appRuntime {
+		return dasherr.ValidateErr(fmt.Errorf("Cannot set a runtime as its own canary"))
+	}
+	if canaryRt == nil || opts.ConfigPath == "" {
+		app.appRuntime.SetCanaryRuntime(canaryRt, opts)
+		return nil
+	}
+	if !app.client.IsConnected() {
+		return NotConnectedErr
+	}
+	optsCopy := *opts
+	userOnRollback := opts.OnRollback
+	optsCopy.OnRollback = func(stats CanaryStats) {
+		writeErr := app.writeCanaryConfig(optsCopy.ConfigPath, CanaryConfig{Percent: 0, RolledBack: true})
+		if writeErr != nil {
+			app.client.log("Dashborg error writing canary config app:%s err:%v\n", app.appName, writeErr)
+		}
+		if userOnRollback != nil {
+			userOnRollback(stats)
+		}
+	}
+	cfg, err := app.readCanaryConfig(optsCopy.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		optsCopy.Percent = cfg.Percent
+	}
+	app.appRuntime.SetCanaryRuntime(canaryRt, &optsCopy)
+	canary := app.appRuntime.getCanary()
+	if canary == nil || canary.rt != canaryRt {
+		return nil
+	}
+	go app.pollCanaryConfig(canary, cfg)
+	return nil
+}
+
+// Changes the percentage of frontend clients routed to the canary runtime.  If the canary
+// has a ConfigPath, the new percent is written there (so all processes pick it up).
+func (app *App) SetCanaryPercent(percent float64) error {
+	canary := app.appRuntime.getCanary()
+	if canary == nil {
+		return dasherr.ValidateErr(fmt.Errorf("App '%s' does not have a canary runtime", app.appName))
+	}
+	if percent < 0 || percent > 100 {
+		return dasherr.ValidateErr(fmt.Errorf("Canary percent must be between 0 and 100"))
+	}
+	if canary.opts.ConfigPath != "" {
+		err := app.writeCanaryConfig(canary.opts.ConfigPath, CanaryConfig{Percent: percent})
+		if err != nil {
+			return err
+		}
+	}
+	canary.setPercent(percent, false)
+	return nil
+}
+
+func (app *App) readCanaryConfig(configPath string) (*CanaryConfig, error) {
+	_, content, err := app.client.fileInfo(app.AppPath()+configPath, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	var rtn CanaryConfig
+	err = json.Unmarshal(content, &rtn)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("CanaryConfig", err)
+	}
+	if rtn.Percent < 0 || rtn.Percent > 100 {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid canary config percent %v", rtn.Percent))
+	}
+	return &rtn, nil
+}
+
+func (app *App) writeCanaryConfig(configPath string, cfg CanaryConfig) error {
+	return app.AppFSClient().SetJsonPath(configPath, cfg, &FileOpts{Hidden: true, AllowedRoles: []string{RoleSuper}})
+}
+
+// only applies the config when it changes, so a rolled back canary is not re-enabled
+// until the config file is updated.  stops when the canary is replaced or removed.
+func (app *App) pollCanaryConfig(canary *canaryType, lastCfg *CanaryConfig) {
+	opts := &canary.opts
+	interval := opts.PollInterval
+	if interval == 0 {
+		interval = DefaultCanaryPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !app.client.IsConnected() {
+				continue
+			}
+			cfg, err := app.readCanaryConfig(opts.ConfigPath)
+			if err != nil {
+				app.client.log("Dashborg error reading canary config app:%s err:%v\n", app.appName, err)
+				continue
+			}
+			if cfg == nil || (lastCfg != nil && *cfg == *lastCfg) {
+				continue
+			}
+			lastCfg = cfg
+			canary.setPercent(cfg.Percent, cfg.RolledBack)
+
+		case <-canary.stopCh:
+			return
+
+		case <-opts.ShutdownCh:
+			return
+
+		case <-app.client.DoneCh:
+			return
+		}
+	}
+}
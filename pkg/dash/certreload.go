@@ -0,0 +1,86 @@
+package dash
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+)
+
+// checks that KeyFileName/CertFileName hold a valid keypair for the client's AccId
+func (c *Config) checkKeyPair() (*certInfo, error) {
+	_, err := tls.LoadX509KeyPair(c.CertFileName, c.KeyFileName)
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Cannot load keypair key:%s cert:%s err:%w", c.KeyFileName, c.CertFileName, err))
+	}
+	info, err := readCertInfo(c.CertFileName)
+	if err != nil {
+		return nil, dasherr.ValidateErr(err)
+	}
+	if info.AccId != c.AccId {
+		return nil, dasherr.ValidateErr(fmt.Errorf("AccId read from certificate:%s does not match client AccId:%s", info.AccId, c.AccId))
+	}
+	return info, nil
+}
+
+// Re-reads the keypair from Config.KeyFileName / CertFileName and reconnects with it (the
+// gRPC connection is re-dialed, the https transport signs new JWTs), re-linking the client's
+// apps and runtimes.  Use after renewing the certificate, the new certificate must have the
+// same AccId.  On error the client keeps using the current connection.
+// Sends a "certreload" ConnEvent.  See also Config.WatchCertFiles.
+// Usage: err := client.ReloadCertificate()
+func (pc *DashCloudClient) ReloadCertificate() error {
+	if !pc.hasConn() {
+		return NotConnectedErr
+	}
+	info, err := pc.Config.checkKeyPair()
+	if err != nil {
+		return err
+	}
+	if pc.httpsConn != nil {
+		pc.httpsConn.resetJwt()
+		pc.ConnId.Store("")
+	} else {
+		conn, err := pc.dialGrpc()
+		if err != nil {
+			return err
+		}
+		pc.Lock.Lock()
+		if pc.pendingConn != nil {
+			pc.pendingConn.Close()
+		}
+		pc.pendingConn = conn
+		pc.Lock.Unlock()
+	}
+	pc.Lock.Lock()
+	streamCancelFn := pc.streamCancelFn
+	if pc.httpsConn != nil {
+		pc.queueConnEventNoLock(ConnEventCertReload, nil)
+	}
+	pc.Lock.Unlock()
+	if streamCancelFn != nil {
+		streamCancelFn()
+	}
+	pc.log("DashborgCloudClient reloaded certificate KeyFile:%s CertFile:%s SHA-256:%s\n", pc.Config.KeyFileName, pc.Config.CertFileName, info.Pk256)
+	return nil
+}
+
+// swaps in the connection dialed by ReloadCertificate.  the ConnId is cleared so the request
+// stream loop reconnects (re-linking runtimes).  only called from runRequestStreamLoop.
+func (pc *DashCloudClient) maybeSwapConn() bool {
+	pc.Lock.Lock()
+	conn := pc.pendingConn
+	pc.pendingConn = nil
+	pc.Lock.Unlock()
+	if conn == nil {
+		return false
+	}
+	oldConn := pc.setServiceConn(conn, dashproto.NewDashborgServiceClient(conn))
+	pc.ConnId.Store("")
+	pc.closeReplacedConn(oldConn)
+	pc.Lock.Lock()
+	pc.queueConnEventNoLock(ConnEventCertReload, nil)
+	pc.Lock.Unlock()
+	return true
+}
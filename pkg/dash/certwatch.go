@@ -0,0 +1,76 @@
+//go:build !dashlite
+// +build !dashlite
+
+package dash
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// wait after the last change to the key/cert files before reloading (renewals write both files)
+const certWatchThrottle = time.Second
+
+// watches the directories of KeyFileName and CertFileName (renewals often replace the files
+// with a rename) and calls ReloadCertificate after they change.  stops when the client exits.
+func (pc *DashCloudClient) watchCertFiles() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	keyFile := filepath.Clean(pc.Config.KeyFileName)
+	certFile := filepath.Clean(pc.Config.CertFileName)
+	for _, dir := range []string{filepath.Dir(keyFile), filepath.Dir(certFile)} {
+		err = watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			var timerCh <-chan time.Time
+			if timer != nil {
+				timerCh = timer.C
+			}
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				fileName := filepath.Clean(event.Name)
+				if fileName != keyFile && fileName != certFile {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.NewTimer(certWatchThrottle)
+
+			case <-timerCh:
+				timer = nil
+				err := pc.ReloadCertificate()
+				if err != nil {
+					pc.log("DashborgCloudClient ERROR reloading certificate (keeping current connection): %v\n", err)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				pc.log("DashborgCloudClient certificate watcher error: %v\n", err)
+
+			case <-pc.DoneCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
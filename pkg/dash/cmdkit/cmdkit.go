@@ -0,0 +1,370 @@
+// Exposes an allowlist of commands/scripts as Dashborg handlers with validated, templated arguments.
+package cmdkit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultTimeout    = time.Minute
+	DefaultOutputPath = "$.cmdoutput"
+	DefaultMaxLines   = 5000
+	DefaultParamMax   = 200
+
+	flushInterval     = 250 * time.Millisecond
+	maxOutputLineSize = 1024 * 1024
+)
+
+var paramTemplateRe = regexp.MustCompile("\\{\\{([a-zA-Z][a-zA-Z0-9_]*)\\}\\}")
+
+// A named parameter that can be substituted into a command's Args using {{name}}.
+type Param struct {
+	Name     string
+	Pattern  string // regular expression the value must fully match (required)
+	Required bool
+	Default  string
+	MaxLen   int // defaults to DefaultParamMax
+
+	// By default values starting with "-" are rejected (to prevent option injection).
+	AllowDashPrefix bool
+
+	re *regexp.Regexp
+}
+
+// A command that can be run from the frontend.  Commands are run directly (exec), never through a
+// shell.  Each element of Args may contain {{param}} templates which are replaced by validated values.
+type Command struct {
+	Name         string // handler name
+	Description  string
+	Path         string   // executable path
+	Args         []string // argument templates
+	Params       []*Param
+	Dir          string
+	Env          []string
+	Timeout      time.Duration // defaults to DefaultTimeout
+	AllowedRoles []string      // if set, the caller must have one of these roles
+	OutputPath   string        // frontend path that output lines are appended to (defaults to DefaultOutputPath)
+	MaxLines     int           // maximum output lines sent to the frontend (defaults to DefaultMaxLines)
+}
+
+// A single line of command output.
+type OutputLine struct {
+	Ts     int64  `json:"ts"`
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Text   string `json:"text"`
+}
+
+// Returned from a command handler.
+type Result struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exitcode"`
+	DurationMs int64  `json:"durationms"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	TimedOut   bool   `json:"timedout,omitempty"`
+}
+
+type commandInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Params      []string `json:"params"`
+}
+
+type CmdKit struct {
+	lock     *sync.Mutex
+	cmds     map[string]*Command
+	runtimes []*dash.AppRuntimeImpl // passed to Register, commands added later are registered with them too
+}
+
+func MakeCmdKit() *CmdKit {
+	return &CmdKit{lock: &sync.Mutex{}, cmds: make(map[string]*Command)}
+}
+
+func (p *Param) validateDef() error {
+	if !dashutil.IsSimpleIdValid(p.Name) {
+		return fmt.Errorf("Invalid param name '%s'", p.Name)
+	}
+	if p.Pattern == "" {
+		return fmt.Errorf("Param '%s' must have a Pattern", p.Name)
+	}
+	re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+	if err != nil {
+		return fmt.Errorf("Param '%s' invalid Pattern: %w", p.Name, err)
+	}
+	p.re = re
+	if p.MaxLen <= 0 {
+		p.MaxLen = DefaultParamMax
+	}
+	return nil
+}
+
+func (p *Param) validateValue(val string) error {
+	if len(val) > p.MaxLen {
+		return dasherr.ValidateErr(fmt.Errorf("Param '%s' too long (max %d)", p.Name, p.MaxLen))
+	}
+	if !p.AllowDashPrefix && strings.HasPrefix(val, "-") {
+		return dasherr.ValidateErr(fmt.Errorf("Param '%s' cannot start with '-'", p.Name))
+	}
+	if !p.re.MatchString(val) {
+		return dasherr.ValidateErr(fmt.Errorf("Param '%s' does not match pattern", p.Name))
+	}
+	return nil
+}
+
+// Adds a command to the allowlist.  Returns an error if the definition is invalid
+// (bad handler name, params without patterns, or templates that reference unknown params).
+// Commands can be added before or after Register.
+func (kit *CmdKit) AddCommand(cmd *Command) error {
+	if cmd == nil {
+		return dasherr.ValidateErr(fmt.Errorf("AddCommand nil Command"))
+	}
+	if !dashutil.IsPathFragValid(cmd.Name) || strings.HasPrefix(cmd.Name, "@") {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid command name '%s'", cmd.Name))
+	}
+	if cmd.Path == "" {
+		return dasherr.ValidateErr(fmt.Errorf("Command '%s' has no Path", cmd.Name))
+	}
+	paramMap := make(map[string]*Param)
+	for _, p := range cmd.Params {
+		err := p.validateDef()
+		if err != nil {
+			return dasherr.ValidateErr(fmt.Errorf("Command '%s': %w", cmd.Name, err))
+		}
+		paramMap[p.Name] = p
+	}
+	for _, arg := range cmd.Args {
+		for _, match := range paramTemplateRe.FindAllStringSubmatch(arg, -1) {
+			if paramMap[match[1]] == nil {
+				return dasherr.ValidateErr(fmt.Errorf("Command '%s' arg references unknown param '%s'", cmd.Name, match[1]))
+			}
+		}
+	}
+	if cmd.Timeout <= 0 {
+		cmd.Timeout = DefaultTimeout
+	}
+	if cmd.OutputPath == "" {
+		cmd.OutputPath = DefaultOutputPath
+	}
+	if cmd.MaxLines <= 0 {
+		cmd.MaxLines = DefaultMaxLines
+	}
+	kit.lock.Lock()
+	defer kit.lock.Unlock()
+	kit.cmds[cmd.Name] = cmd
+	for _, apprt := range kit.runtimes {
+		kit.registerCommand(apprt, cmd.Name)
+	}
+	return nil
+}
+
+// Registers a handler for each command, and a pure "commands" handler that lists the available
+// commands.  Commands added after Register are registered when they are added.
+func (kit *CmdKit) Register(apprt *dash.AppRuntimeImpl) {
+	kit.lock.Lock()
+	defer kit.lock.Unlock()
+	kit.runtimes = append(kit.runtimes, apprt)
+	for _, cmd := range kit.cmds {
+		kit.registerCommand(apprt, cmd.Name)
+	}
+	apprt.PureHandler("commands", kit.listCommands)
+}
+
+func (kit *CmdKit) registerCommand(apprt *dash.AppRuntimeImpl, cmdName string) {
+	apprt.Handler(cmdName, func(req dash.ActionRequest, params map[string]string) (*Result, error) {
+		return kit.RunCommand(req, cmdName, params)
+	})
+}
+
+func (kit *CmdKit) listCommands(req dash.Request) ([]commandInfo, error) {
+	kit.lock.Lock()
+	defer kit.lock.Unlock()
+	rtn := make([]commandInfo, 0)
+	for _, cmd := range kit.cmds {
+		if !hasAllowedRole(req.AuthData(), cmd.AllowedRoles) {
+			continue
+		}
+		info := commandInfo{Name: cmd.Name, Description: cmd.Description, Params: []string{}}
+		for _, p := range cmd.Params {
+			info.Params = append(info.Params, p.Name)
+		}
+		rtn = append(rtn, info)
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn, nil
+}
+
+func hasAllowedRole(aa *dash.AuthAtom, roles []string) bool {
+	if len(roles) == 0 || aa.IsSuper() {
+		return true
+	}
+	for _, role := range roles {
+		if aa.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *Command) makeArgs(params map[string]string) ([]string, error) {
+	vals := make(map[string]string)
+	for _, p := range cmd.Params {
+		val, ok := params[p.Name]
+		if !ok || val == "" {
+			if p.Required {
+				return nil, dasherr.ValidateErr(fmt.Errorf("Missing required param '%s'", p.Name))
+			}
+			val = p.Default
+		}
+		if val != "" {
+			err := p.validateValue(val)
+			if err != nil {
+				return nil, err
+			}
+		}
+		vals[p.Name] = val
+	}
+	for name := range params {
+		if _, ok := vals[name]; !ok {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Unknown param '%s'", name))
+		}
+	}
+	var rtn []string
+	for _, argTemplate := range cmd.Args {
+		arg := paramTemplateRe.ReplaceAllStringFunc(argTemplate, func(m string) string {
+			return vals[m[2:len(m)-2]]
+		})
+		if arg == "" && argTemplate != "" {
+			// an optional param that was not given, drop the argument
+			continue
+		}
+		rtn = append(rtn, arg)
+	}
+	return rtn, nil
+}
+
+// Runs the named command (normally called by the registered handler).  Output lines are
+// appended to the command's OutputPath and flushed to the frontend while the command runs.
+func (kit *CmdKit) RunCommand(req dash.ActionRequest, cmdName string, params map[string]string) (*Result, error) {
+	kit.lock.Lock()
+	cmd := kit.cmds[cmdName]
+	kit.lock.Unlock()
+	if cmd == nil {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodeNoHandler, fmt.Errorf("No command '%s'", cmdName))
+	}
+	if !hasAllowedRole(req.AuthData(), cmd.AllowedRoles) {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Not authorized to run command '%s'", cmdName))
+	}
+	args, err := cmd.makeArgs(params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancelFn := context.WithTimeout(req.Context(), cmd.Timeout)
+	defer cancelFn()
+	execCmd := exec.CommandContext(ctx, cmd.Path, args...)
+	execCmd.Dir = cmd.Dir
+	if len(cmd.Env) > 0 {
+		execCmd.Env = cmd.Env
+	}
+	stdout, err := execCmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := execCmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	req.SetData(cmd.OutputPath, []OutputLine{})
+	startTime := time.Now()
+	err = execCmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	out := &outputWriter{req: req, cmd: cmd, lock: &sync.Mutex{}, lastFlush: time.Now()}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go out.readLines(&wg, "stdout", stdout)
+	go out.readLines(&wg, "stderr", stderr)
+	wg.Wait()
+	waitErr := execCmd.Wait()
+	rtn := &Result{
+		Command:    cmd.Name,
+		DurationMs: int64(time.Since(startTime) / time.Millisecond),
+		Truncated:  out.truncated,
+		TimedOut:   ctx.Err() == context.DeadlineExceeded,
+	}
+	if execCmd.ProcessState != nil {
+		rtn.ExitCode = execCmd.ProcessState.ExitCode()
+	}
+	if waitErr != nil {
+		if _, ok := waitErr.(*exec.ExitError); !ok {
+			return nil, waitErr
+		}
+	}
+	if out.err != nil {
+		return nil, fmt.Errorf("Command '%s' output could not be sent: %w", cmd.Name, out.err)
+	}
+	return rtn, nil
+}
+
+type outputWriter struct {
+	req       dash.ActionRequest
+	cmd       *Command
+	lock      *sync.Mutex
+	numLines  int
+	truncated bool
+	lastFlush time.Time
+	err       error // first AddDataOp/Flush error, no more lines are sent after an error
+}
+
+func (w *outputWriter) readLines(wg *sync.WaitGroup, streamName string, r io.Reader) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxOutputLineSize)
+	for scanner.Scan() {
+		w.addLine(streamName, scanner.Text())
+	}
+	if scanner.Err() != nil {
+		// line longer than maxOutputLineSize, discard the rest of the output so the command
+		// does not block writing to the pipe
+		w.lock.Lock()
+		w.truncated = true
+		w.lock.Unlock()
+		io.Copy(ioutil.Discard, r)
+	}
+}
+
+func (w *outputWriter) addLine(streamName string, text string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err != nil {
+		return
+	}
+	if w.numLines >= w.cmd.MaxLines {
+		w.truncated = true
+		return
+	}
+	w.numLines++
+	err := w.req.AddDataOp("append", w.cmd.OutputPath, OutputLine{Ts: dashutil.Ts(), Stream: streamName, Text: text})
+	if err == nil && time.Since(w.lastFlush) >= flushInterval {
+		err = w.req.Flush()
+		w.lastFlush = time.Now()
+	}
+	if err != nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,83 @@
+package dash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+)
+
+const (
+	EncodingGzip = "gzip"
+
+	// Default for Config.CompressMinSize
+	DefaultCompressMinSize = 64 * 1024
+
+	// BlobMimeType set on compressed "setdata" actions (the JSON is gzipped into BlobBytes).
+	MimeTypeGzipJson = "application/json+gzip"
+
+	acceptEncodingKey = "acceptencoding"
+	mdEncodingKey     = "dashborg-encoding"
+)
+
+// Returns true if the frontend that sent this request can decode gzipped responses.  The
+// frontend advertises this in $state.dashborg.acceptencoding.
+func (req *AppRequest) acceptsGzip() bool {
+	stateMap, ok := req.appState.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	dbState, ok := stateMap["dashborg"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	acceptEncoding, _ := dbState[acceptEncodingKey].(string)
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		if strings.TrimSpace(encoding) == EncodingGzip {
+			return true
+		}
+	}
+	return false
+}
+
+// Gzips the JsonData of "setdata" actions that are at least minSize bytes (moved to BlobBytes
+// with BlobMimeType set to MimeTypeGzipJson).  Returns true if any action was compressed.
+// Actions are only replaced if compression makes them smaller.
+func compressActions(actions []*dashproto.RRAction, minSize int) bool {
+	if minSize <= 0 {
+		return false
+	}
+	rtn := false
+	for _, rra := range actions {
+		if rra.ActionType != "setdata" || len(rra.JsonData) < minSize {
+			continue
+		}
+		var buf bytes.Buffer
+		gzWriter := gzip.NewWriter(&buf)
+		_, err := gzWriter.Write([]byte(rra.JsonData))
+		if err != nil {
+			continue
+		}
+		err = gzWriter.Close()
+		if err != nil || buf.Len() >= len(rra.JsonData) {
+			continue
+		}
+		rra.BlobBytes = buf.Bytes()
+		rra.BlobMimeType = MimeTypeGzipJson
+		rra.JsonData = ""
+		rtn = true
+	}
+	return rtn
+}
+
+// returns the response encoding to use for req ("" for none), compresses m's actions
+func (pc *DashCloudClient) compressResponse(m *dashproto.SendResponseMessage, req *AppRequest) string {
+	if req == nil || pc.Config.CompressMinSize <= 0 || !req.acceptsGzip() {
+		return ""
+	}
+	if !compressActions(m.Actions, pc.Config.CompressMinSize) {
+		return ""
+	}
+	return EncodingGzip
+}
@@ -2,6 +2,7 @@ package dash
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -30,6 +32,8 @@ const (
 	DefaultJWTValidFor     = 24 * time.Hour
 	DefaultJWTUserId       = "jwt-user"
 	DefaultJWTRole         = RoleUser
+	DefaultJWTAudience     = "dashborg-auth"
+	jwtIssuer              = "dashborg"
 )
 
 const consoleHostDev = "console.dashborg-dev.com:8080"
@@ -58,6 +62,10 @@ type Config struct {
 	ProcIKey string            // DASHBORG_PROCIKEY (optional, user-specified key to identify procs in a cluster)
 	ProcTags map[string]string // optional, user-specified key/values to identify this proc
 
+	// DASHBORG_INSTANCEID, identifies this process when multiple processes link the same runtime
+	// (see LinkOpts).  Defaults to ProcIKey if set, otherwise to the client's ProcRunId.
+	InstanceId string
+
 	KeyFileName  string // DASHBORG_KEYFILE private key file (defaults to dashborg-client.key)
 	CertFileName string // DASHBORG_CERTFILE certificate file, CN must be set to your Dashborg Account Id.  (defaults to dashborg-client.crt)
 
@@ -65,9 +73,150 @@ type Config struct {
 	// If AccId is set, will create a key with that AccId, if AccId is not set, it will create a new random AccId.
 	AutoKeygen bool
 
+	// key type and certificate validity for AutoKeygen (defaults to P-384, see keygen.KeyPairOpts).
+	KeygenOpts *keygen.KeyPairOpts
+
 	// DASHBORG_VERBOSE, set to true for extra debugging information
 	Verbose bool
 
+	// DASHBORG_WARNOVERDUE, set to true to log a warning when a handler is still running after
+	// its request context has expired (usually a handler that ignores req.Context()).
+	WarnOverdueHandlers bool
+
+	// DASHBORG_COMPRESSMINSIZE, JSON data at least this size (in bytes) is gzipped when sent
+	// to frontends that support it.  Defaults to DefaultCompressMinSize, set to -1 to disable.
+	CompressMinSize int
+
+	// DASHBORG_JSONUSENUMBER, set to true to decode numbers in request data and app state as
+	// json.Number (instead of float64) when binding to interface{} values.  Preserves large
+	// int64 ids and decimals.  See also dash.Int64Str and dash.Decimal.
+	JsonUseNumber bool
+
+	// DASHBORG_JSONEMPTYNILS, set to true to send nil slices and maps in panel data (SetData,
+	// handler return values, SetJsonPath) as [] and {} instead of null.
+	JsonEmptyNils bool
+
+	// DASHBORG_JSONOMITEMPTY, set to true to omit zero-valued struct fields from panel data
+	// (as if every field was tagged ",omitempty").
+	JsonOmitEmpty bool
+
+	// DASHBORG_JSONTIMEFORMAT, how time.Time values are encoded in panel data: "rfc3339",
+	// "rfc3339ms", "epochms", or "epochsec".  Defaults to encoding/json (RFC3339 with nanoseconds).
+	// Can be overridden per app (see App.SetTimeFormats).
+	JsonTimeFormat string
+
+	// DASHBORG_JSONDURATIONFORMAT, how time.Duration values are encoded in panel data: "seconds",
+	// "ms", or "string" (e.g. "1m30s").  Defaults to encoding/json (integer nanoseconds).
+	JsonDurationFormat string
+
+	// DASHBORG_APPVERSIONPOLICY, what to do when an app config (OpenApp, LoadApp) was written
+	// by a client version with options this client does not know about.  "refuse" (default)
+	// returns an error, "preserve" keeps the unknown options and writes them back unchanged,
+	// "drop" removes them (logs a warning).
+	AppVersionPolicy string
+
+	// DASHBORG_STRICTAPPOPTIONS, set to true to treat app OptionWarnings as errors in
+	// WriteApp and WriteAndConnectApp (for CI deploys).
+	StrictAppOptions bool
+
+	// DASHBORG_ERRORSNAPSHOTS, set to "dashfs" or "local" to capture a diagnostic snapshot
+	// (request data, app state, and emitted actions) when a handler returns an error.  The
+	// snapshot id is included in the error shown to the user.  Off by default.
+	ErrorSnapshots string
+
+	// DASHBORG_ERRORSNAPSHOTDIR, where snapshots are written.  A DashFS directory for "dashfs"
+	// (defaults to "/_/snapshots") or a local directory for "local" (defaults to $TMPDIR/dashborg-snapshots).
+	// DashFS snapshots are only readable by the super role.
+	ErrorSnapshotDir string
+
+	// DASHBORG_ENABLEMETRICS, set to true to record the SDK's internal metrics (gRPC calls,
+	// dispatches, handler latencies, reconnects, uploads).  See DashCloudClient.Metrics().
+	EnableMetrics bool
+
+	// DASHBORG_INTROSPECTADDR, if set (e.g. "localhost:8083") serves a JSON catalog of the
+	// client's apps, runtimes, handlers, middleware, and types (see DashCloudClient.Introspect).
+	// Not authenticated, so bind it to a local/internal address.
+	IntrospectAddr string
+
+	// set to trace handler dispatches and gRPC calls (e.g. an OpenTelemetry adapter, see Tracer).
+	// Request spans carry the ReqId and FeClientId as attributes.
+	Tracer Tracer
+
+	// DASHBORG_TRANSPORT, "grpc" (default) or "https".  "https" sends the SDK's calls as JSON over
+	// HTTPS to the ConsoleHost (authenticated with a JWT signed by the client's key, the request
+	// stream uses server-sent events) for networks that block gRPC or client-certificate TLS.
+	// Standard proxy environment variables (HTTPS_PROXY) are respected.
+	// Experimental: "https" requires a Dashborg server that supports the HTTPS rpc protocol
+	// (POSTs to /api2/rpc1/[Method] and an SSE request stream), which is not yet stable.
+	Transport string
+
+	// DASHBORG_PROXYURL, connect through a proxy: "http://[user:pass@]host:port" or "https://..."
+	// (HTTP CONNECT), or "socks5://[user:pass@]host:port".  If not set, the standard proxy
+	// environment variables (HTTPS_PROXY, NO_PROXY) are honored.
+	ProxyURL string
+
+	// custom dialer for the gRPC connection and calls to the ConsoleHost (overrides ProxyURL).
+	DialContext DialContextFn
+
+	// overrides the TLS settings for the gRPC connection (e.g. RootCAs and ServerName to pin the
+	// server's certificate chain, or MinVersion and CipherSuites for compliance).  The client
+	// certificate is added unless Certificates or GetClientCertificate is set.  Defaults to
+	// TLS 1.3 with curve P-384.
+	TLSConfig *tls.Config
+
+	// DASHBORG_ROOTCAFILE, PEM file of the CA certificates trusted for the Dashborg servers
+	// (gRPC and https), replacing the system roots.  Overrides TLSConfig.RootCAs.
+	RootCAFile string
+
+	// DASHBORG_ADDRFAMILY, "ipv4" or "ipv6" to only connect over one address family, or
+	// "prefer-ipv4" / "prefer-ipv6" to try it first.  Defaults to the system's dual-stack dialing.
+	AddressFamily string
+
+	// DASHBORG_GRPCHOSTIP, IP address(es, comma separated) to dial for the gRPC host, bypassing
+	// DNS (TLS still verifies the GrpcHost name).
+	GrpcHostIP string
+
+	// custom DNS lookup for the Dashborg hosts (e.g. for split-horizon DNS), returns IP addresses.
+	Resolver ResolverFn
+
+	// metadata key/values sent with every outgoing RPC (e.g. for routing through gRPC-aware proxies
+	// or request attribution).  Keys must be lowercase, values printable ASCII.  Sent as HTTP
+	// headers with the https transport.  See also DashCloudClient.SetExtraMetadata.
+	ExtraMetadata map[string]string
+
+	// DASHBORG_SECONDARYGRPCADDR, warm standby gRPC endpoint ("host" or "host:port", port defaults
+	// to GrpcPort).  When the client has been disconnected for FailoverAfter it switches to the
+	// other endpoint, reconnects, and re-links its runtimes (sends a "failover" ConnEvent).
+	// Not used with the https transport.
+	SecondaryGrpcAddr string
+
+	// how long the client must be disconnected before switching endpoints, defaults to DefaultFailoverAfter (60s).
+	FailoverAfter time.Duration
+
+	// DASHBORG_UPLOADRATELIMIT, limits blob uploads and responses (including stream pushes) to this
+	// many bytes/sec (token bucket with a one second burst).  0 for no limit.
+	UploadRateLimit int64
+
+	// DASHBORG_DOWNLOADRATELIMIT, limits file contents read back from the service (e.g. GetBlobData)
+	// to this many bytes/sec.  The service sends contents in a single response, so the limit
+	// is applied by delaying subsequent reads.  0 for no limit.
+	DownloadRateLimit int64
+
+	// DASHBORG_WATCHCERTFILES, watch KeyFileName and CertFileName and call ReloadCertificate
+	// when they change (e.g. renewed by an external PKI).
+	WatchCertFiles bool
+
+	// DASHBORG_LITEMODE, for small devices on metered links.  Sends gRPC keepalives every
+	// LiteKeepaliveTime (instead of 10s), and defaults StreamFlushInterval and CompressMinSize to
+	// DefaultLiteStreamFlushInterval and DefaultLiteCompressMinSize.  Always set in builds with the
+	// "dashlite" tag, which also leaves out file watching (WatchFile, WatchDir, WatchCertFiles)
+	// and the fsnotify dependency.
+	LiteMode bool
+
+	// DASHBORG_STREAMFLUSHINTERVAL (e.g. "5s"), minimum time between stream Flushes, Flushes in
+	// between are batched.  0 to send every Flush.
+	StreamFlushInterval time.Duration
+
 	// close this channel to force a shutdown of the Dashborg Cloud Client
 	ShutdownCh chan struct{}
 
@@ -125,9 +274,72 @@ func (c *Config) setDefaults() {
 	}
 	c.ProcName = dashutil.DefaultString(c.ProcName, os.Getenv("DASHBORG_PROCNAME"), cmdName, DefaultProcName)
 	c.ProcIKey = dashutil.DefaultString(c.ProcIKey, os.Getenv("DASHBORG_PROCIKEY"), "")
+	c.InstanceId = dashutil.DefaultString(c.InstanceId, os.Getenv("DASHBORG_INSTANCEID"), c.ProcIKey)
 	c.KeyFileName = dashutil.DefaultString(c.KeyFileName, os.Getenv("DASHBORG_KEYFILE"), TlsKeyFileName)
 	c.CertFileName = dashutil.DefaultString(c.CertFileName, os.Getenv("DASHBORG_CERTFILE"), TlsCertFileName)
 	c.Verbose = dashutil.EnvOverride(c.Verbose, "DASHBORG_VERBOSE")
+	c.WarnOverdueHandlers = dashutil.EnvOverride(c.WarnOverdueHandlers, "DASHBORG_WARNOVERDUE")
+	c.JsonUseNumber = dashutil.EnvOverride(c.JsonUseNumber, "DASHBORG_JSONUSENUMBER")
+	c.JsonEmptyNils = dashutil.EnvOverride(c.JsonEmptyNils, "DASHBORG_JSONEMPTYNILS")
+	c.JsonOmitEmpty = dashutil.EnvOverride(c.JsonOmitEmpty, "DASHBORG_JSONOMITEMPTY")
+	c.StrictAppOptions = dashutil.EnvOverride(c.StrictAppOptions, "DASHBORG_STRICTAPPOPTIONS")
+	c.EnableMetrics = dashutil.EnvOverride(c.EnableMetrics, "DASHBORG_ENABLEMETRICS")
+	c.WatchCertFiles = dashutil.EnvOverride(c.WatchCertFiles, "DASHBORG_WATCHCERTFILES")
+	c.LiteMode = dashutil.EnvOverride(c.LiteMode, "DASHBORG_LITEMODE") || liteBuild
+	c.IntrospectAddr = dashutil.DefaultString(c.IntrospectAddr, os.Getenv("DASHBORG_INTROSPECTADDR"))
+	c.ErrorSnapshots = dashutil.DefaultString(c.ErrorSnapshots, os.Getenv("DASHBORG_ERRORSNAPSHOTS"))
+	c.ErrorSnapshotDir = dashutil.DefaultString(c.ErrorSnapshotDir, os.Getenv("DASHBORG_ERRORSNAPSHOTDIR"))
+	c.JsonTimeFormat = dashutil.DefaultString(c.JsonTimeFormat, os.Getenv("DASHBORG_JSONTIMEFORMAT"))
+	c.JsonDurationFormat = dashutil.DefaultString(c.JsonDurationFormat, os.Getenv("DASHBORG_JSONDURATIONFORMAT"))
+	c.AppVersionPolicy = dashutil.DefaultString(c.AppVersionPolicy, os.Getenv("DASHBORG_APPVERSIONPOLICY"), AppVersionRefuse)
+	c.Transport = dashutil.DefaultString(c.Transport, os.Getenv("DASHBORG_TRANSPORT"), TransportGrpc)
+	c.ProxyURL = dashutil.DefaultString(c.ProxyURL, os.Getenv("DASHBORG_PROXYURL"))
+	c.RootCAFile = dashutil.DefaultString(c.RootCAFile, os.Getenv("DASHBORG_ROOTCAFILE"))
+	c.AddressFamily = dashutil.DefaultString(c.AddressFamily, os.Getenv("DASHBORG_ADDRFAMILY"))
+	c.GrpcHostIP = dashutil.DefaultString(c.GrpcHostIP, os.Getenv("DASHBORG_GRPCHOSTIP"))
+	c.SecondaryGrpcAddr = dashutil.DefaultString(c.SecondaryGrpcAddr, os.Getenv("DASHBORG_SECONDARYGRPCADDR"))
+	if c.UploadRateLimit == 0 && os.Getenv("DASHBORG_UPLOADRATELIMIT") != "" {
+		var err error
+		c.UploadRateLimit, err = strconv.ParseInt(os.Getenv("DASHBORG_UPLOADRATELIMIT"), 10, 64)
+		if err != nil {
+			c.log("Invalid DASHBORG_UPLOADRATELIMIT environment variable: %v\n", err)
+		}
+	}
+	if c.DownloadRateLimit == 0 && os.Getenv("DASHBORG_DOWNLOADRATELIMIT") != "" {
+		var err error
+		c.DownloadRateLimit, err = strconv.ParseInt(os.Getenv("DASHBORG_DOWNLOADRATELIMIT"), 10, 64)
+		if err != nil {
+			c.log("Invalid DASHBORG_DOWNLOADRATELIMIT environment variable: %v\n", err)
+		}
+	}
+	if c.CompressMinSize == 0 {
+		if os.Getenv("DASHBORG_COMPRESSMINSIZE") != "" {
+			var err error
+			c.CompressMinSize, err = strconv.Atoi(os.Getenv("DASHBORG_COMPRESSMINSIZE"))
+			if err != nil {
+				c.log("Invalid DASHBORG_COMPRESSMINSIZE environment variable: %v\n", err)
+			}
+		}
+		if c.CompressMinSize == 0 && c.LiteMode {
+			c.CompressMinSize = DefaultLiteCompressMinSize
+		}
+		if c.CompressMinSize == 0 {
+			c.CompressMinSize = DefaultCompressMinSize
+		}
+	}
+
+	if c.StreamFlushInterval == 0 {
+		if os.Getenv("DASHBORG_STREAMFLUSHINTERVAL") != "" {
+			var err error
+			c.StreamFlushInterval, err = time.ParseDuration(os.Getenv("DASHBORG_STREAMFLUSHINTERVAL"))
+			if err != nil {
+				c.log("Invalid DASHBORG_STREAMFLUSHINTERVAL environment variable: %v\n", err)
+			}
+		}
+		if c.StreamFlushInterval == 0 && c.LiteMode {
+			c.StreamFlushInterval = DefaultLiteStreamFlushInterval
+		}
+	}
 
 	if c.JWTOpts == nil {
 		c.JWTOpts = DefaultJWTOpts
@@ -185,7 +397,12 @@ func (c *Config) maybeMakeKeys(accId string) error {
 	if accId == "" {
 		accId = uuid.New().String()
 	}
-	err := keygen.CreateKeyPair(c.KeyFileName, c.CertFileName, accId)
+	var err error
+	if c.KeygenOpts != nil {
+		err = keygen.CreateKeyPairWithOpts(c.KeyFileName, c.CertFileName, accId, c.KeygenOpts)
+	} else {
+		err = keygen.CreateKeyPair(c.KeyFileName, c.CertFileName, accId)
+	}
 	if err != nil {
 		return fmt.Errorf("Cannot create keypair err:%v", err)
 	}
@@ -193,6 +410,27 @@ func (c *Config) maybeMakeKeys(accId string) error {
 	return nil
 }
 
+// Creates a self-signed keypair at the config's KeyFileName and CertFileName (after applying
+// defaults) with CN set to AccId (a new random AccId if not set).  Call before StartProcClient,
+// set opts.Overwrite to replace an existing keypair.  opts may be nil.
+// Usage: err := cfg.CreateKeyPair(&keygen.KeyPairOpts{KeyType: keygen.KeyTypeP256, ValidFor: 365 * 24 * time.Hour})
+func (c *Config) CreateKeyPair(opts *keygen.KeyPairOpts) error {
+	if !c.setupDone {
+		c.setDefaults()
+	}
+	accId := c.AccId
+	if accId == "" {
+		accId = uuid.New().String()
+	}
+	err := keygen.CreateKeyPairWithOpts(c.KeyFileName, c.CertFileName, accId, opts)
+	if err != nil {
+		return err
+	}
+	c.AccId = accId
+	c.log("Dashborg created new self-signed keypair %s / %s for AccId:%s\n", c.KeyFileName, c.CertFileName, accId)
+	return nil
+}
+
 type certInfo struct {
 	AccId     string
 	Pk256     string
@@ -229,11 +467,21 @@ func (c *Config) loadPrivateKey() (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error loading x509 key pair cert[%s] key[%s]: %w", c.CertFileName, c.KeyFileName, err)
 	}
-	ecKey, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("Invalid private key %s, must be ECDSA", c.KeyFileName)
+	switch cert.PrivateKey.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return cert.PrivateKey, nil
 	}
-	return ecKey, nil
+	return nil, fmt.Errorf("Invalid private key %s, must be ECDSA or Ed25519", c.KeyFileName)
+}
+
+func jwtSigningMethod(privateKey interface{}) jwt.SigningMethod {
+	if ecKey, ok := privateKey.(*ecdsa.PrivateKey); ok && ecKey.Curve.Params().BitSize == 256 {
+		return jwt.SigningMethodES256
+	}
+	if _, ok := privateKey.(ed25519.PrivateKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodES384
 }
 
 // Creates a JWT token from the public/private keypair.
@@ -246,19 +494,22 @@ func (c *Config) MakeAccountJWT(jwtOpts *JWTOpts) (string, error) {
 	if jwtOpts.NoJWT {
 		return "", fmt.Errorf("NoJWT set in JWTOpts")
 	}
-	ecKey, err := c.loadPrivateKey()
+	err := jwtOpts.Validate()
 	if err != nil {
 		return "", err
 	}
-	err = jwtOpts.Validate()
-	if err != nil {
-		return "", err
+	privateKey := jwtOpts.SigningKey
+	if privateKey == nil {
+		privateKey, err = c.loadPrivateKey()
+		if err != nil {
+			return "", err
+		}
 	}
 	jwtValidFor := jwtOpts.ValidFor
 	if jwtValidFor == 0 {
 		jwtValidFor = DefaultJWTValidFor
 	}
-	jwtRole := jwtOpts.Role
+	jwtRole := jwtOpts.roleList()
 	if jwtRole == "" {
 		jwtRole = DefaultJWTRole
 	}
@@ -266,17 +517,27 @@ func (c *Config) MakeAccountJWT(jwtOpts *JWTOpts) (string, error) {
 	if jwtUserId == "" {
 		jwtUserId = DefaultJWTUserId
 	}
+	jwtAudience := jwtOpts.Audience
+	if jwtAudience == "" {
+		jwtAudience = DefaultJWTAudience
+	}
 	claims := jwt.MapClaims{}
-	claims["iss"] = "dashborg"
+	for claimName, val := range jwtOpts.ExtraClaims {
+		claims[claimName] = val
+	}
+	claims["iss"] = jwtIssuer
 	claims["exp"] = time.Now().Add(jwtValidFor).Unix()
 	claims["iat"] = time.Now().Add(-5 * time.Second).Unix() // skeww
 	claims["jti"] = uuid.New().String()
 	claims["dash-acc"] = c.AccId
-	claims["aud"] = "dashborg-auth"
+	claims["aud"] = jwtAudience
 	claims["sub"] = jwtUserId
 	claims["role"] = jwtRole
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("ES384"), claims)
-	jwtStr, err := token.SignedString(ecKey)
+	token := jwt.NewWithClaims(jwtSigningMethod(privateKey), claims)
+	if jwtOpts.KeyId != "" {
+		token.Header["kid"] = jwtOpts.KeyId
+	}
+	jwtStr, err := token.SignedString(privateKey)
 	if err != nil {
 		return "", fmt.Errorf("Error signing JWT: %w", err)
 	}
@@ -292,6 +553,98 @@ func (c *Config) MustMakeAccountJWT(jwtOpts *JWTOpts) string {
 	return rtn
 }
 
+// Options for ParseAccountJWT
+type ParseJWTOpts struct {
+	Audience  string      // expected "aud" claim, defaults to DefaultJWTAudience
+	VerifyKey interface{} // *ecdsa.PublicKey or ed25519.PublicKey (defaults to the public key in Config.CertFileName)
+}
+
+// Claims from a JWT created by MakeAccountJWT
+type AccountJWTClaims struct {
+	Id        string // "jti"
+	AccId     string
+	UserId    string
+	Roles     []string
+	Audience  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Extra     map[string]interface{} // JWTOpts.ExtraClaims (JSON decoded)
+}
+
+// Verifies a JWT created by MakeAccountJWT (signature, expiration, issuer, audience, and
+// account id) and returns its claims.  opts may be nil.
+// Usage: claims, err := cfg.ParseAccountJWT(jwtStr, nil)
+func (c *Config) ParseAccountJWT(jwtStr string, opts *ParseJWTOpts) (*AccountJWTClaims, error) {
+	c.setDefaultsAndLoadKeys()
+	if opts == nil {
+		opts = &ParseJWTOpts{}
+	}
+	verifyKey := opts.VerifyKey
+	if verifyKey == nil {
+		info, err := readCertInfo(c.CertFileName)
+		if err != nil {
+			return nil, err
+		}
+		verifyKey = info.PublicKey
+	}
+	audience := opts.Audience
+	if audience == "" {
+		audience = DefaultJWTAudience
+	}
+	var validMethods []string
+	switch key := verifyKey.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize == 256 {
+			validMethods = []string{jwt.SigningMethodES256.Alg()}
+		} else {
+			validMethods = []string{jwt.SigningMethodES384.Alg()}
+		}
+	case ed25519.PublicKey:
+		validMethods = []string{jwt.SigningMethodEdDSA.Alg()}
+	default:
+		return nil, fmt.Errorf("Invalid VerifyKey, must be *ecdsa.PublicKey or ed25519.PublicKey")
+	}
+	parser := &jwt.Parser{ValidMethods: validMethods}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(jwtStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return verifyKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWT: %w", err)
+	}
+	if !claims.VerifyIssuer(jwtIssuer, true) {
+		return nil, fmt.Errorf("Invalid JWT: bad issuer")
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, fmt.Errorf("Invalid JWT: bad audience (expected '%s')", audience)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("Invalid JWT: missing exp")
+	}
+	accId, _ := claims["dash-acc"].(string)
+	if accId != c.AccId {
+		return nil, fmt.Errorf("Invalid JWT: AccId does not match config")
+	}
+	rtn := &AccountJWTClaims{AccId: accId, Audience: audience, Extra: make(map[string]interface{})}
+	rtn.Id, _ = claims["jti"].(string)
+	rtn.UserId, _ = claims["sub"].(string)
+	if roleStr, _ := claims["role"].(string); roleStr != "" {
+		rtn.Roles = strings.Split(roleStr, ",")
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		rtn.IssuedAt = time.Unix(int64(iat), 0)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		rtn.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	for claimName, val := range claims {
+		if !reservedJWTClaims[claimName] {
+			rtn.Extra[claimName] = val
+		}
+	}
+	return rtn, nil
+}
+
 func (c *Config) log(fmtStr string, args ...interface{}) {
 	if c.Logger != nil {
 		c.Logger.Printf(fmtStr, args...)
@@ -300,6 +653,17 @@ func (c *Config) log(fmtStr string, args ...interface{}) {
 	}
 }
 
+func (c *Config) jsonOpts() dashutil.JsonOpts {
+	return dashutil.JsonOpts{
+		UseNumber:        c.JsonUseNumber,
+		NilSlicesAsEmpty: c.JsonEmptyNils,
+		NilMapsAsEmpty:   c.JsonEmptyNils,
+		OmitEmpty:        c.JsonOmitEmpty,
+		TimeFormat:       c.JsonTimeFormat,
+		DurationFormat:   c.JsonDurationFormat,
+	}
+}
+
 func (c *Config) copyJWTOpts() JWTOpts {
 	return *c.JWTOpts
 }
@@ -0,0 +1,138 @@
+package dash
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	ConnEventConnect        = "connect"        // first connection to the Dashborg service
+	ConnEventDisconnect     = "disconnect"     // connection (or request stream) lost, the client will retry
+	ConnEventReconnect      = "reconnect"      // connected again after a disconnect
+	ConnEventPermanentError = "permanenterror" // the client has stopped (see GetExitError), no more events are sent
+	ConnEventFailover       = "failover"       // switched to the other gRPC endpoint (see Config.SecondaryGrpcAddr, ActiveEndpoint)
+	ConnEventCertReload     = "certreload"     // reconnected with a reloaded keypair (see ReloadCertificate)
+)
+
+const connEventQueueSize = 64
+
+// A change in the client's connectivity, passed to OnConnEvent listeners.  Err is set for
+// disconnect (when known) and permanenterror events.
+type ConnEvent struct {
+	Type   string
+	Ts     time.Time
+	ConnId string
+	Err    error
+}
+
+func (e ConnEvent) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("ConnEvent[%s] %v", e.Type, e.Err)
+	}
+	return fmt.Sprintf("ConnEvent[%s]", e.Type)
+}
+
+type connListener struct {
+	id int
+	fn func(ConnEvent)
+}
+
+// Registers fn to be called on connectivity changes (connect, disconnect, reconnect, and
+// permanenterror).  Events are delivered in order on a separate goroutine, so fn should not
+// block for long (events are dropped, and logged, if the queue fills up).  Past events are
+// not replayed, check IsConnected() for the current state.  Returns a function that removes
+// the listener.
+// Usage: remove := client.OnConnEvent(func(e dash.ConnEvent) { pusher.SetPaused(e.Type == dash.ConnEventDisconnect) })
+func (pc *DashCloudClient) OnConnEvent(fn func(ConnEvent)) func() {
+	if fn == nil {
+		return func() {}
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if pc.connEventCh == nil {
+		pc.connEventCh = make(chan ConnEvent, connEventQueueSize)
+		go pc.runConnEvents(pc.connEventCh)
+	}
+	pc.connListenerSeq++
+	id := pc.connListenerSeq
+	pc.connListeners = append(pc.connListeners, connListener{id: id, fn: fn})
+	return func() {
+		pc.Lock.Lock()
+		defer pc.Lock.Unlock()
+		for idx, l := range pc.connListeners {
+			if l.id == id {
+				pc.connListeners = append(pc.connListeners[:idx:idx], pc.connListeners[idx+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (pc *DashCloudClient) runConnEvents(ch chan ConnEvent) {
+	for event := range ch {
+		pc.Lock.Lock()
+		listeners := pc.connListeners
+		pc.Lock.Unlock()
+		for _, l := range listeners {
+			pc.callConnListener(l, event)
+		}
+		if event.Type == ConnEventPermanentError {
+			return
+		}
+	}
+}
+
+func (pc *DashCloudClient) callConnListener(l connListener, event ConnEvent) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			pc.log("DashborgCloudClient PANIC in OnConnEvent listener (%s): %v\n", event.Type, panicErr)
+		}
+	}()
+	l.fn(event)
+}
+
+// must hold pc.Lock
+func (pc *DashCloudClient) queueConnEventNoLock(eventType string, err error) {
+	if pc.connEventCh == nil || pc.connEventsDone {
+		return
+	}
+	event := ConnEvent{Type: eventType, Ts: time.Now(), ConnId: pc.ConnId.Load().(string), Err: err}
+	if eventType == ConnEventPermanentError {
+		pc.connEventsDone = true
+	}
+	select {
+	case pc.connEventCh <- event:
+	default:
+		pc.log("DashborgCloudClient OnConnEvent queue full, dropping %v\n", event)
+	}
+}
+
+// tracks connected state transitions, sends connect/reconnect/disconnect events
+func (pc *DashCloudClient) setConnState(connected bool, err error) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if pc.ExitErr != nil || connected == pc.connected {
+		return
+	}
+	pc.connected = connected
+	if !connected {
+		pc.disconnectTime = time.Now()
+		pc.queueConnEventNoLock(ConnEventDisconnect, err)
+		return
+	}
+	if pc.everConnected {
+		pc.queueConnEventNoLock(ConnEventReconnect, nil)
+		return
+	}
+	pc.everConnected = true
+	pc.queueConnEventNoLock(ConnEventConnect, nil)
+}
+
+func connStateErr(errCode dasherr.ErrCode) error {
+	if errCode == dasherr.ErrCodeNone {
+		return nil
+	}
+	return dasherr.ErrWithCode(errCode, fmt.Errorf("RequestStream ended"))
+}
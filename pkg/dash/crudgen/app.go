@@ -0,0 +1,331 @@
+package crudgen
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Generates the handlers and HTML for a CRUD app from an introspected schema.
+type Generator struct {
+	db     *sql.DB
+	opts   Options
+	schema *Schema
+}
+
+// Returned from the "rows" handler.  Sort and Filter are the request's, so the frontend can
+// page through the same result set.
+type RowsResult struct {
+	Table    string                   `json:"table"`
+	Page     int                      `json:"page"`
+	PageSize int                      `json:"pagesize"`
+	Total    int64                    `json:"total"`
+	Sort     *dashutil.SortSpec       `json:"sort,omitempty"`
+	Filter   map[string]interface{}   `json:"filter,omitempty"`
+	Columns  []*ColumnView            `json:"columns"`
+	Rows     []map[string]interface{} `json:"rows"`
+	CanEdit  bool                     `json:"canedit"`  // table has a primary key and the user can edit at least one column
+	Editable map[string]bool          `json:"editable"` // columns the user can edit
+}
+
+// Per-user view of a column (only returned for readable columns).
+type ColumnView struct {
+	*ColumnInfo
+	Editable bool `json:"editable"`
+}
+
+type TableView struct {
+	Name    string        `json:"name"`
+	Columns []*ColumnView `json:"columns"`
+}
+
+// Introspects the database and returns a Generator.  opts may be nil.
+func MakeGenerator(ctx context.Context, db *sql.DB, opts *Options) (*Generator, error) {
+	var genOpts Options
+	if opts != nil {
+		genOpts = *opts
+	}
+	genOpts.setDefaults()
+	schema, err := Introspect(ctx, db, &genOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{db: db, opts: genOpts, schema: schema}, nil
+}
+
+// Returns the introspected schema.
+func (g *Generator) Schema() *Schema {
+	return g.schema
+}
+
+// Creates a new app with the generated HTML and handlers.  The app still must be
+// written and connected using WriteAndConnectApp.
+func (g *Generator) MakeApp(appClient *dash.DashAppClient, appName string) *dash.App {
+	app := appClient.NewApp(appName)
+	app.SetAppTitle(fmt.Sprintf("%s (%s)", appName, g.schema.Name))
+	app.SetHtml(g.Html())
+	g.RegisterHandlers(app.Runtime())
+	return app
+}
+
+// Registers the "tables", "rows", and "update" handlers on the given runtime.
+func (g *Generator) RegisterHandlers(apprt *dash.AppRuntimeImpl) {
+	apprt.PureHandler("tables", g.tablesHandler)
+	apprt.PureHandler("rows", g.rowsHandler)
+	apprt.Handler("update", g.updateHandler)
+}
+
+func (g *Generator) columnViews(aa *dash.AuthAtom, table *TableInfo) []*ColumnView {
+	var rtn []*ColumnView
+	for _, col := range table.Columns {
+		if !col.CanRead(aa) {
+			continue
+		}
+		rtn = append(rtn, &ColumnView{ColumnInfo: col, Editable: col.CanEdit(aa)})
+	}
+	return rtn
+}
+
+func (g *Generator) tablesHandler(req dash.Request) (interface{}, error) {
+	rtn := make([]*TableView, 0)
+	for _, table := range g.schema.Tables {
+		rtn = append(rtn, &TableView{Name: table.Name, Columns: g.columnViews(req.AuthData(), table)})
+	}
+	return rtn, nil
+}
+
+func (g *Generator) getTable(tableName string) (*TableInfo, error) {
+	table := g.schema.Table(tableName)
+	if table == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid table '%s'", tableName))
+	}
+	return table, nil
+}
+
+// filter is a map of column-name => value (equality only), used for foreign-key navigation.
+func (g *Generator) rowsHandler(req dash.Request, tableName string, page int, sortSpec *dashutil.SortSpec, filter map[string]interface{}) (*RowsResult, error) {
+	table, err := g.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	cols := g.columnViews(req.AuthData(), table)
+	if len(cols) == 0 {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("No readable columns in table '%s'", tableName))
+	}
+	if page < 0 {
+		page = 0
+	}
+	s := g.schema
+	var whereParts []string
+	var args []interface{}
+	for colName, val := range filter {
+		col := table.Column(colName)
+		if col == nil || !col.CanRead(req.AuthData()) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid filter column '%s'", colName))
+		}
+		args = append(args, val)
+		whereParts = append(whereParts, fmt.Sprintf("%s = %s", s.quoteIdent(colName), s.placeholder(len(args))))
+	}
+	whereClause := ""
+	if len(whereParts) > 0 {
+		whereClause = " WHERE " + strings.Join(whereParts, " AND ")
+	}
+	var total int64
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", s.quoteIdent(table.Name), whereClause)
+	err = g.db.QueryRowContext(req.Context(), countQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	colNames := make([]string, len(cols))
+	for idx, col := range cols {
+		colNames[idx] = s.quoteIdent(col.Name)
+	}
+	var orderParts []string
+	if sortSpec != nil && sortSpec.Column != "" {
+		sortCol := table.Column(sortSpec.Column)
+		if sortCol == nil || !sortCol.CanRead(req.AuthData()) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid sort column '%s'", sortSpec.Column))
+		}
+		dir := "DESC"
+		if sortSpec.Asc {
+			dir = "ASC"
+		}
+		orderParts = append(orderParts, fmt.Sprintf("%s %s", s.quoteIdent(sortCol.Name), dir))
+	}
+	// order by the primary key (after the sort column) so pages are stable
+	for _, pkCol := range table.PrimaryKey() {
+		orderParts = append(orderParts, s.quoteIdent(pkCol.Name))
+	}
+	orderClause := ""
+	if len(orderParts) > 0 {
+		orderClause = " ORDER BY " + strings.Join(orderParts, ", ")
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s%s%s LIMIT %d OFFSET %d", strings.Join(colNames, ", "), s.quoteIdent(table.Name), whereClause, orderClause, g.opts.PageSize, page*g.opts.PageSize)
+	rows, err := g.db.QueryContext(req.Context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rtn := &RowsResult{Table: table.Name, Page: page, PageSize: g.opts.PageSize, Total: total, Sort: sortSpec, Filter: filter, Columns: cols, Rows: make([]map[string]interface{}, 0), Editable: make(map[string]bool)}
+	for _, col := range cols {
+		if col.Editable {
+			rtn.Editable[col.Name] = true
+		}
+	}
+	rtn.CanEdit = len(rtn.Editable) > 0 && len(table.PrimaryKey()) > 0
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		valPtrs := make([]interface{}, len(cols))
+		for idx := range vals {
+			valPtrs[idx] = &vals[idx]
+		}
+		err = rows.Scan(valPtrs...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{})
+		for idx, col := range cols {
+			if barr, ok := vals[idx].([]byte); ok {
+				row[col.Name] = string(barr)
+			} else {
+				row[col.Name] = vals[idx]
+			}
+		}
+		rtn.Rows = append(rtn.Rows, row)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return rtn, nil
+}
+
+// key must contain values for every primary key column.  values is a map of column-name => new value.
+func (g *Generator) updateHandler(req dash.Request, tableName string, key map[string]interface{}, values map[string]interface{}) error {
+	table, err := g.getTable(tableName)
+	if err != nil {
+		return err
+	}
+	pkCols := table.PrimaryKey()
+	if len(pkCols) == 0 {
+		return dasherr.ValidateErr(fmt.Errorf("Table '%s' has no primary key, cannot update", tableName))
+	}
+	if len(values) == 0 {
+		return dasherr.ValidateErr(fmt.Errorf("No values to update"))
+	}
+	s := g.schema
+	var setParts []string
+	var args []interface{}
+	for colName, val := range values {
+		col := table.Column(colName)
+		if col == nil {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid column '%s'", colName))
+		}
+		if !col.CanEdit(req.AuthData()) {
+			return dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Not authorized to edit column '%s'", colName))
+		}
+		args = append(args, val)
+		setParts = append(setParts, fmt.Sprintf("%s = %s", s.quoteIdent(colName), s.placeholder(len(args))))
+	}
+	var whereParts []string
+	for _, pkCol := range pkCols {
+		keyVal, ok := key[pkCol.Name]
+		if !ok {
+			return dasherr.ValidateErr(fmt.Errorf("Missing primary key column '%s'", pkCol.Name))
+		}
+		args = append(args, keyVal)
+		whereParts = append(whereParts, fmt.Sprintf("%s = %s", s.quoteIdent(pkCol.Name), s.placeholder(len(args))))
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", s.quoteIdent(table.Name), strings.Join(setParts, ", "), strings.Join(whereParts, " AND "))
+	result, err := g.db.ExecContext(req.Context(), query, args...)
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err == nil && numRows == 0 {
+		return dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Row not found"))
+	}
+	return nil
+}
+
+var simpleBindKeyRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// column names that are not simple identifiers use the bracket-quoted form, e.g. $.edit.row["first name"].
+// an empty parentPath returns a path relative to the current row (".name" or .["first name"]).
+func childBindPath(parentPath string, name string) string {
+	if simpleBindKeyRe.MatchString(name) {
+		return parentPath + "." + name
+	}
+	if parentPath == "" {
+		parentPath = "."
+	}
+	return parentPath + "[" + dashutil.QuoteString(name) + "]"
+}
+
+// Returns the generated Dashborg HTML for the app.
+func (g *Generator) Html() string {
+	var buf bytes.Buffer
+	buf.WriteString("<app ui=\"dashborg\">\n")
+	buf.WriteString("  <d-data query=\"/@app:tables\" output.bindpath=\"$.tables\"/>\n")
+	buf.WriteString("  <h1>" + html.EscapeString(g.schema.Name) + "</h1>\n")
+	buf.WriteString("  <div class=\"row\">\n")
+	buf.WriteString("    <d-foreach bind=\"$.tables\">\n")
+	buf.WriteString("      <d-button onclickhandler=\"$.edit = null; $.rows = /@app:rows(.name, 0, null, null)\"><d-text bind=\".name\"/></d-button>\n")
+	buf.WriteString("    </d-foreach>\n")
+	buf.WriteString("  </div>\n")
+	buf.WriteString("  <hr/>\n")
+	reloadRows := "$.rows = /@app:rows($.rows.table, $.rows.page, $.rows.sort, $.rows.filter)"
+	for _, table := range g.schema.Tables {
+		fmt.Fprintf(&buf, "  <div if=\"$.rows.table == %s\">\n", html.EscapeString(dashutil.QuoteString(table.Name)))
+		fmt.Fprintf(&buf, "    <h2>%s <d-text bind=\"'(' + $.rows.total + ' rows)'\"/></h2>\n", html.EscapeString(table.Name))
+		buf.WriteString("    <d-table bind=\"$.rows.rows\">\n")
+		for _, col := range table.Columns {
+			colBind := childBindPath("", col.Name)
+			if col.ForeignKey != nil {
+				fkFilter := fmt.Sprintf("{%s: %s}", dashutil.QuoteString(col.ForeignKey.RefColumn), colBind)
+				fmt.Fprintf(&buf, "      <d-col label=\"%s\"><d-button class=\"link\" onclickhandler=\"$.edit = null; $.rows = /@app:rows(%s, 0, null, %s)\"><d-text bind=\"%s\"/></d-button></d-col>\n",
+					html.EscapeString(col.Name), html.EscapeString(dashutil.QuoteString(col.ForeignKey.RefTable)), html.EscapeString(fkFilter), html.EscapeString(colBind))
+				continue
+			}
+			fmt.Fprintf(&buf, "      <d-col label=\"%s\" bind=\"%s\"/>\n", html.EscapeString(col.Name), html.EscapeString(colBind))
+		}
+		if pkCols := table.PrimaryKey(); len(pkCols) > 0 {
+			// the key is set from the row, only changed values are sent to the "update" handler
+			keyParts := make([]string, len(pkCols))
+			for idx, pkCol := range pkCols {
+				keyParts[idx] = fmt.Sprintf("%s: %s", dashutil.QuoteString(pkCol.Name), childBindPath("", pkCol.Name))
+			}
+			editExpr := fmt.Sprintf("$.edit = {key: {%s}, row: ., values: {}}", strings.Join(keyParts, ", "))
+			fmt.Fprintf(&buf, "      <d-col label=\"\" if=\"$.rows.canedit\"><d-button class=\"link\" onclickhandler=\"%s\">Edit</d-button></d-col>\n", html.EscapeString(editExpr))
+		}
+		buf.WriteString("    </d-table>\n")
+		if len(table.PrimaryKey()) > 0 {
+			buf.WriteString("    <div if=\"$.edit\">\n")
+			buf.WriteString("      <h3>Edit Row</h3>\n")
+			for _, col := range table.Columns {
+				fmt.Fprintf(&buf, "      <div class=\"row\" if=\"%s\">%s <d-input value.bindpath=\"%s\" placeholder=\"* %s\"/></div>\n",
+					html.EscapeString(childBindPath("$.rows.editable", col.Name)), html.EscapeString(col.Name),
+					html.EscapeString(childBindPath("$.edit.values", col.Name)), html.EscapeString(childBindPath("$.edit.row", col.Name)))
+			}
+			fmt.Fprintf(&buf, "      <d-button onclickhandler=\"/@app:update($.rows.table, $.edit.key, $.edit.values); $.edit = null; %s\">Save</d-button>\n", reloadRows)
+			buf.WriteString("      <d-button onclickhandler=\"$.edit = null\">Cancel</d-button>\n")
+			buf.WriteString("    </div>\n")
+		}
+		buf.WriteString("  </div>\n")
+	}
+	buf.WriteString("  <div class=\"row\" if=\"$.rows\">\n")
+	buf.WriteString("    <d-button onclickhandler=\"$.rows = /@app:rows($.rows.table, $.rows.page-1, $.rows.sort, $.rows.filter)\" disabled=\"* $.rows.page == 0\">Prev</d-button>\n")
+	buf.WriteString("    <d-text bind=\"'Page ' + ($.rows.page+1)\"/>\n")
+	buf.WriteString("    <d-button onclickhandler=\"$.rows = /@app:rows($.rows.table, $.rows.page+1, $.rows.sort, $.rows.filter)\" disabled=\"* ($.rows.page+1)*$.rows.pagesize >= $.rows.total\">Next</d-button>\n")
+	buf.WriteString("  </div>\n")
+	buf.WriteString("</app>\n")
+	return buf.String()
+}
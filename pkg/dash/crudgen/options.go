@@ -0,0 +1,107 @@
+package crudgen
+
+import (
+	"fmt"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 500
+)
+
+// Column level permissions.  Empty ReadRoles means the column is readable by every
+// user that can access the app.  Empty EditRoles falls back to Options.EditRoles.
+type ColumnPerm struct {
+	ReadRoles []string
+	EditRoles []string
+}
+
+type Options struct {
+	Dialect       string   // DialectPostgres (default) or DialectMySQL
+	SchemaName    string   // defaults to "public" for postgres, and the current database for mysql
+	Tables        []string // if set, only these tables are exposed
+	ExcludeTables []string
+	PageSize      int // defaults to DefaultPageSize
+
+	// Roles allowed to edit columns (when not overridden in ColumnPerms).
+	// If empty, the generated app is read-only (except for columns with explicit EditRoles).
+	EditRoles []string
+
+	// keyed by "[table].[column]"
+	ColumnPerms map[string]ColumnPerm
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Dialect == "" {
+		opts.Dialect = DialectPostgres
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.PageSize > MaxPageSize {
+		opts.PageSize = MaxPageSize
+	}
+}
+
+func (opts *Options) Validate() error {
+	if opts.Dialect != DialectPostgres && opts.Dialect != DialectMySQL {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid Dialect '%s', must be '%s' or '%s'", opts.Dialect, DialectPostgres, DialectMySQL))
+	}
+	return nil
+}
+
+func (opts *Options) includeTable(name string) bool {
+	for _, exclude := range opts.ExcludeTables {
+		if exclude == name {
+			return false
+		}
+	}
+	if len(opts.Tables) == 0 {
+		return true
+	}
+	for _, include := range opts.Tables {
+		if include == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *Options) columnPerm(tableName string, colName string) ColumnPerm {
+	perm := opts.ColumnPerms[tableName+"."+colName]
+	if len(perm.EditRoles) == 0 {
+		perm.EditRoles = opts.EditRoles
+	}
+	return perm
+}
+
+func hasAnyRole(aa *dash.AuthAtom, roles []string) bool {
+	if aa.IsSuper() {
+		return true
+	}
+	for _, role := range roles {
+		if aa.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the user with the given AuthAtom can read this column.
+func (col *ColumnInfo) CanRead(aa *dash.AuthAtom) bool {
+	if len(col.ReadRoles) == 0 {
+		return true
+	}
+	return hasAnyRole(aa, col.ReadRoles)
+}
+
+// Returns true if the user with the given AuthAtom can edit this column.
+func (col *ColumnInfo) CanEdit(aa *dash.AuthAtom) bool {
+	if !col.CanRead(aa) {
+		return false
+	}
+	return hasAnyRole(aa, col.EditRoles)
+}
@@ -0,0 +1,259 @@
+// Introspects a SQL database schema and generates a Dashborg app to browse and edit its tables.
+package crudgen
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+)
+
+const defaultPostgresSchema = "public"
+
+// Column metadata read from information_schema.columns.
+type ColumnInfo struct {
+	Name       string   `json:"name"`
+	DataType   string   `json:"datatype"`
+	Nullable   bool     `json:"nullable"`
+	PrimaryKey bool     `json:"primarykey,omitempty"`
+	ForeignKey *FKInfo  `json:"foreignkey,omitempty"`
+	ReadRoles  []string `json:"-"`
+	EditRoles  []string `json:"-"`
+}
+
+// Describes the table/column that a foreign key column references.
+type FKInfo struct {
+	RefTable  string `json:"reftable"`
+	RefColumn string `json:"refcolumn"`
+}
+
+type TableInfo struct {
+	Name    string        `json:"name"`
+	Columns []*ColumnInfo `json:"columns"`
+
+	pkNames []string // primary key column names in key order
+}
+
+// The introspected database schema.  Only tables and columns that are present
+// in the schema can be queried or updated by the generated app.
+type Schema struct {
+	Dialect string       `json:"dialect"`
+	Name    string       `json:"name"`
+	Tables  []*TableInfo `json:"tables"`
+}
+
+// Returns the table with the given name, or nil if it does not exist.
+func (s *Schema) Table(name string) *TableInfo {
+	for _, t := range s.Tables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Returns the column with the given name, or nil if it does not exist.
+func (t *TableInfo) Column(name string) *ColumnInfo {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns the primary key columns (in key order).
+func (t *TableInfo) PrimaryKey() []*ColumnInfo {
+	var rtn []*ColumnInfo
+	for _, name := range t.pkNames {
+		if c := t.Column(name); c != nil {
+			rtn = append(rtn, c)
+		}
+	}
+	return rtn
+}
+
+func (s *Schema) placeholder(n int) string {
+	if s.Dialect == DialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (s *Schema) quoteIdent(name string) string {
+	if s.Dialect == DialectMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+// Reads tables, columns, primary keys, and foreign keys from information_schema.  opts may be
+// nil (it is not modified).
+func Introspect(ctx context.Context, db *sql.DB, opts *Options) (*Schema, error) {
+	var optsCopy Options
+	if opts != nil {
+		optsCopy = *opts
+	}
+	opts = &optsCopy
+	opts.setDefaults()
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{Dialect: opts.Dialect, Name: opts.SchemaName}
+	if schema.Name == "" {
+		schema.Name, err = defaultSchemaName(ctx, db, opts.Dialect)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tableNames, err := schema.readTables(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	tableMap := make(map[string]*TableInfo)
+	for _, name := range tableNames {
+		if !opts.includeTable(name) {
+			continue
+		}
+		table := &TableInfo{Name: name}
+		tableMap[name] = table
+		schema.Tables = append(schema.Tables, table)
+	}
+	err = schema.readColumns(ctx, db, tableMap)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.readPrimaryKeys(ctx, db, tableMap)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.readForeignKeys(ctx, db, tableMap)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range schema.Tables {
+		for _, col := range table.Columns {
+			perm := opts.columnPerm(table.Name, col.Name)
+			col.ReadRoles = perm.ReadRoles
+			col.EditRoles = perm.EditRoles
+		}
+	}
+	return schema, nil
+}
+
+func defaultSchemaName(ctx context.Context, db *sql.DB, dialect string) (string, error) {
+	if dialect == DialectPostgres {
+		return defaultPostgresSchema, nil
+	}
+	var name sql.NullString
+	err := db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&name)
+	if err != nil {
+		return "", err
+	}
+	if !name.Valid || name.String == "" {
+		return "", dasherr.ValidateErr(fmt.Errorf("No database selected, set SchemaName in crudgen.Options"))
+	}
+	return name.String, nil
+}
+
+func (s *Schema) readTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	query := fmt.Sprintf("SELECT table_name FROM information_schema.tables WHERE table_schema = %s AND table_type = 'BASE TABLE' ORDER BY table_name", s.placeholder(1))
+	rows, err := db.QueryContext(ctx, query, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rtn []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, name)
+	}
+	return rtn, rows.Err()
+}
+
+func (s *Schema) readColumns(ctx context.Context, db *sql.DB, tableMap map[string]*TableInfo) error {
+	query := fmt.Sprintf("SELECT table_name, column_name, data_type, is_nullable FROM information_schema.columns WHERE table_schema = %s ORDER BY table_name, ordinal_position", s.placeholder(1))
+	rows, err := db.QueryContext(ctx, query, s.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName, colName, dataType, nullable string
+		err = rows.Scan(&tableName, &colName, &dataType, &nullable)
+		if err != nil {
+			return err
+		}
+		table := tableMap[tableName]
+		if table == nil {
+			continue
+		}
+		table.Columns = append(table.Columns, &ColumnInfo{Name: colName, DataType: dataType, Nullable: nullable == "YES"})
+	}
+	return rows.Err()
+}
+
+func (s *Schema) readPrimaryKeys(ctx context.Context, db *sql.DB, tableMap map[string]*TableInfo) error {
+	query := fmt.Sprintf("SELECT kcu.table_name, kcu.column_name FROM information_schema.table_constraints tc JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema AND tc.table_name = kcu.table_name WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = %s ORDER BY kcu.table_name, kcu.ordinal_position", s.placeholder(1))
+	rows, err := db.QueryContext(ctx, query, s.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName, colName string
+		err = rows.Scan(&tableName, &colName)
+		if err != nil {
+			return err
+		}
+		if table := tableMap[tableName]; table != nil {
+			if col := table.Column(colName); col != nil {
+				col.PrimaryKey = true
+				table.pkNames = append(table.pkNames, colName)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Schema) readForeignKeys(ctx context.Context, db *sql.DB, tableMap map[string]*TableInfo) error {
+	var query string
+	if s.Dialect == DialectPostgres {
+		query = "SELECT kcu.table_name, kcu.column_name, ccu.table_name, ccu.column_name FROM information_schema.table_constraints tc JOIN information_schema.key_column_usage kcu ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema JOIN information_schema.constraint_column_usage ccu ON ccu.constraint_name = tc.constraint_name AND ccu.table_schema = tc.table_schema WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = $1"
+	} else {
+		query = "SELECT table_name, column_name, referenced_table_name, referenced_column_name FROM information_schema.key_column_usage WHERE table_schema = ? AND referenced_table_name IS NOT NULL"
+	}
+	rows, err := db.QueryContext(ctx, query, s.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName, colName, refTable, refCol string
+		err = rows.Scan(&tableName, &colName, &refTable, &refCol)
+		if err != nil {
+			return err
+		}
+		table := tableMap[tableName]
+		if table == nil || tableMap[refTable] == nil {
+			// only link to tables that are visible in the generated app
+			continue
+		}
+		if col := table.Column(colName); col != nil {
+			col.ForeignKey = &FKInfo{RefTable: refTable, RefColumn: refCol}
+		}
+	}
+	return rows.Err()
+}
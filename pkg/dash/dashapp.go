@@ -1,6 +1,7 @@
 package dash
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -52,6 +53,10 @@ type AppConfig struct {
 	InitialHtmlPage string   `json:"initialhtmlpage"`
 	RuntimePath     string   `json:"runtimepath,omitempty"` // empty for ./runtime
 	PagesEnabled    bool     `json:"pagesenabled,omitempty"`
+	StateVersion    int      `json:"stateversion,omitempty"`
+
+	// options written by another client version that this client does not know about (see Config.AppVersionPolicy)
+	ExtraOpts map[string]json.RawMessage `json:"-"`
 }
 
 type middlewareType struct {
@@ -63,6 +68,16 @@ type middlewareType struct {
 type MiddlewareNextFuncType func(req *AppRequest) (interface{}, error)
 type MiddlewareFuncType func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error)
 
+// Middleware that only depends on the ActionRequest interface (see AddMiddleware).  nextFn
+// runs the rest of the middleware chain and the handler.
+type RequestMiddlewareFuncType func(req ActionRequest, nextFn func() (interface{}, error)) (interface{}, error)
+
+func adaptRequestMiddleware(mwFunc RequestMiddlewareFuncType) MiddlewareFuncType {
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		return mwFunc(req, func() (interface{}, error) { return nextFn(req) })
+	}
+}
+
 type ProcInfo struct {
 	StartTs   int64
 	ProcRunId string
@@ -81,6 +96,9 @@ type App struct {
 	htmlFileWatchOpts *WatchOpts
 	htmlFromRuntime   bool
 	htmlExtPath       string
+	linkOpts          *LinkOpts
+	buildInfo         *BuildInfoOption
+	status            *appStatusType
 	errs              []error
 }
 
@@ -113,6 +131,7 @@ func makeApp(client *DashCloudClient, appName string) *App {
 			AllowedRoles:  []string{RoleUser},
 		},
 		appRuntime: MakeAppRuntime(),
+		status:     makeAppStatus(),
 	}
 	if appNameErr != nil {
 		rtn.errs = append(rtn.errs, appNameErr)
@@ -121,8 +140,12 @@ func makeApp(client *DashCloudClient, appName string) *App {
 }
 
 func makeAppFromConfig(client *DashCloudClient, cfg AppConfig) (*App, error) {
+	err := checkAppConfigVersion(client, &cfg)
+	if err != nil {
+		return nil, err
+	}
 	cfg.ClientVersion = ClientVersion
-	err := cfg.Validate()
+	err = cfg.Validate()
 	if err != nil {
 		return nil, err
 	}
@@ -131,6 +154,7 @@ func makeAppFromConfig(client *DashCloudClient, cfg AppConfig) (*App, error) {
 		appName:    cfg.AppName,
 		appConfig:  cfg,
 		appRuntime: MakeAppRuntime(),
+		status:     makeAppStatus(),
 	}
 	return rtn, nil
 }
@@ -167,6 +191,9 @@ func (app *App) AppConfig() (AppConfig, error) {
 		app.appConfig.InitialHtmlPage = initialHtmlPageDefault
 	}
 	app.appConfig.RuntimePath = app.getRuntimePath()
+	if app.appRuntime != nil && !app.HasExternalRuntime() {
+		app.appConfig.StateVersion = app.appRuntime.StateVersion()
+	}
 	app.appConfig.ClientVersion = ClientVersion
 	return app.appConfig, nil
 }
@@ -286,12 +313,36 @@ func (app *App) SetInitRequired(initRequired bool) {
 	app.appConfig.InitRequired = initRequired
 }
 
+// Sets the app state version (see AppRuntimeImpl.SetStateVersion).
+func (app *App) SetStateVersion(version int) {
+	app.appRuntime.SetStateVersion(version)
+}
+
+// Registers an app state migration from fromVersion to fromVersion+1 (see AppRuntimeImpl.MigrateState).
+// Usage: app.MigrateState(1, func(old map[string]interface{}) interface{} { ... })
+func (app *App) MigrateState(fromVersion int, migrateFn StateMigrationFn) {
+	app.appRuntime.MigrateState(fromVersion, migrateFn)
+}
+
 // Set PagesEnabled to true to allow your app to serve different frontend/UI pages.  When PagesEnabled
 // is false, the app will only have one logical page (single page app).
 func (app *App) SetPagesEnabled(pagesEnabled bool) {
 	app.appConfig.PagesEnabled = pagesEnabled
 }
 
+// Sets the LinkOpts used when the app's runtime is connected.  Set Mode to LinkModeShared
+// to load balance requests across multiple processes that connect the same app.
+func (app *App) SetLinkOpts(opts *LinkOpts) {
+	if opts != nil {
+		err := opts.Validate()
+		if err != nil {
+			app.errs = append(app.errs, err)
+			return
+		}
+	}
+	app.linkOpts = opts
+}
+
 // Returns the app's name.
 func (app *App) AppName() string {
 	return app.appConfig.AppName
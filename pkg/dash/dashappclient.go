@@ -61,15 +61,16 @@ func (dac *DashAppClient) LoadApp(appName string, createIfNotFound bool) (*App,
 // Writes the app to the Dashborg service.  Note that the app runtime will
 // *not* be connected.  This is used to create or update an app's settings,
 // offline apps, or apps with external runtimes.
+// Option warnings are logged (see WriteAppWithOpts).
 func (dac *DashAppClient) WriteApp(app *App) error {
-	return dac.baseWriteApp(app, false)
+	return dac.writeAppLogWarnings(app, false)
 }
 
 // Writes the app to the Dashborg service and connects the app's runtime to
 // receive requests.  If an app uses an external runtime, you should call
 // WriteApp(), not WriteAndConnectApp().
 func (dac *DashAppClient) WriteAndConnectApp(app *App) error {
-	return dac.baseWriteApp(app, true)
+	return dac.writeAppLogWarnings(app, true)
 }
 
 // Given an app name, returns the canonical path (e.g. /_/apps/[appName])
@@ -88,6 +89,7 @@ func (dac *DashAppClient) RemoveApp(appName string) error {
 	if err != nil {
 		return err
 	}
+	dac.client.fireAppEvent(AppEvent{Type: AppEventRemove, AppName: appName})
 	return nil
 }
 
@@ -106,11 +108,16 @@ func (dac *DashAppClient) ConnectAppRuntime(app *App) error {
 		return dasherr.ValidateErr(fmt.Errorf("App has specified an external runtime path '%s', use DashFS().LinkAppRuntime() to connect", app.getRuntimePath()))
 	}
 	runtimePath := appConfig.RuntimePath
+	err = dac.client.setLinkOpts(runtimePath, app.linkOpts)
+	if err != nil {
+		return err
+	}
 	err = dac.client.connectLinkRpc(appConfig.RuntimePath)
 	if err != nil {
 		return err
 	}
 	dac.client.connectLinkRuntime(runtimePath, app.Runtime())
+	dac.client.registerConnectedApp(runtimePath, app)
 	return nil
 }
 
@@ -118,7 +125,7 @@ func (dac *DashAppClient) ConnectAppRuntime(app *App) error {
 // config's default jwt options.
 func (dac *DashAppClient) MakeAppUrl(appNameOrPath string, jwtOpts *JWTOpts) (string, error) {
 	if appNameOrPath == "" {
-		return "", fmt.Errorf("Invalid App Path")
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid App Path"))
 	}
 	if appNameOrPath[0] == '/' {
 		return dac.client.GlobalFSClient().MakePathUrl(appNameOrPath, jwtOpts)
@@ -139,23 +146,36 @@ func (dac *DashAppClient) MakeAppUrl(appNameOrPath string, jwtOpts *JWTOpts) (st
 	return fmt.Sprintf("%s?jwt=%s", baseUrl, jwtToken), nil
 }
 
-func (dac *DashAppClient) baseWriteApp(app *App, shouldConnect bool) error {
+// returns the app link
+func (dac *DashAppClient) baseWriteApp(app *App, shouldConnect bool) (string, error) {
 	appConfig, err := app.AppConfig()
 	if err != nil {
-		return err
+		return "", err
 	}
 	if shouldConnect && app.HasExternalRuntime() {
-		return dasherr.ValidateErr(fmt.Errorf("App has specified an external runtime path '%s', use DashFS().LinkAppRuntime() to connect", app.getRuntimePath()))
+		return "", dasherr.ValidateErr(fmt.Errorf("App has specified an external runtime path '%s', use DashFS().LinkAppRuntime() to connect", app.getRuntimePath()))
 	}
 	roles := appConfig.AllowedRoles
+	var metadataJson string
+	binfo := app.resolveBuildInfo()
+	if binfo != nil {
+		err = appConfig.SetOption(*binfo)
+		if err != nil {
+			return "", err
+		}
+		metadataJson, err = dashutil.MarshalJson(binfo)
+		if err != nil {
+			return "", dasherr.JsonMarshalErr("BuildInfo", err)
+		}
+	}
 	appConfigJson, err := dashutil.MarshalJson(appConfig)
 	if err != nil {
-		return dasherr.JsonMarshalErr("AppConfig", err)
+		return "", dasherr.JsonMarshalErr("AppConfig", err)
 	}
 	fs := dac.client.GlobalFSClient()
-	err = fs.SetRawPath(app.AppPath(), nil, &FileOpts{FileType: FileTypeApp, MimeType: MimeTypeDashborgApp, AllowedRoles: roles, AppConfigJson: appConfigJson}, nil)
+	err = fs.SetRawPath(app.AppPath(), nil, &FileOpts{FileType: FileTypeApp, MimeType: MimeTypeDashborgApp, AllowedRoles: roles, AppConfigJson: appConfigJson, MetadataJson: metadataJson}, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 	// test html for error earlier
 	htmlPath := appConfig.HtmlPath
@@ -170,18 +190,26 @@ func (dac *DashAppClient) baseWriteApp(app *App, shouldConnect bool) error {
 		}
 	}
 	if err != nil {
-		return err
+		return "", err
 	}
 	if shouldConnect {
 		runtimePath := appConfig.RuntimePath
+		err = dac.client.setLinkOpts(runtimePath, app.linkOpts)
+		if err != nil {
+			return "", err
+		}
 		err = fs.LinkAppRuntime(runtimePath, app.Runtime(), &FileOpts{AllowedRoles: roles})
 		if err != nil {
-			return err
+			return "", err
 		}
+		dac.client.registerConnectedApp(runtimePath, app)
 	}
 	appLink, err := dac.MakeAppUrl(appConfig.AppName, nil)
-	if err == nil {
+	if err != nil {
+		appLink = ""
+	} else {
 		dac.client.log("Dashborg App Link [%s]: %s\n", appConfig.AppName, appLink)
 	}
-	return nil
+	dac.client.fireAppEvent(AppEvent{Type: AppEventWrite, AppName: appConfig.AppName, Connected: shouldConnect, AppLink: appLink, BuildInfo: binfo})
+	return appLink, nil
 }
@@ -1,14 +1,26 @@
 package dash
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
 	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
 )
 
+// Connects to the Dashborg service.  Runs Preflight on the config first, so all config and
+// keypair problems are returned together (before any network calls).
 func ConnectClient(config *Config) (*DashCloudClient, error) {
+	if !config.setupDone {
+		report := Preflight(config)
+		err := report.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
 	config.setDefaultsAndLoadKeys()
 	container := makeCloudClient(config)
 	err := container.startClient()
@@ -30,7 +42,26 @@ type JWTOpts struct {
 	NoJWT    bool
 	ValidFor time.Duration
 	UserId   string
-	Role     string
+	Role     string   // role (or comma separated role list)
+	Roles    []string // additional roles, combined with Role into the "role" claim
+
+	Audience    string                 // "aud" claim, defaults to DefaultJWTAudience
+	ExtraClaims map[string]interface{} // additional claims (cannot override the standard/Dashborg claims)
+	SigningKey  interface{}            // *ecdsa.PrivateKey or ed25519.PrivateKey to sign with instead of Config.KeyFileName
+	KeyId       string                 // optional "kid" header (e.g. to select the verification key)
+}
+
+// claims set by MakeAccountJWT, cannot be set with JWTOpts.ExtraClaims
+var reservedJWTClaims = map[string]bool{"iss": true, "exp": true, "iat": true, "nbf": true, "jti": true, "dash-acc": true, "aud": true, "sub": true, "role": true}
+
+// returns the combined role list (Role and Roles), "" if neither is set
+func (jwtOpts *JWTOpts) roleList() string {
+	var roles []string
+	if jwtOpts.Role != "" {
+		roles = append(roles, jwtOpts.Role)
+	}
+	roles = append(roles, jwtOpts.Roles...)
+	return strings.Join(roles, ",")
 }
 
 func (jwtOpts *JWTOpts) Validate() error {
@@ -43,9 +74,19 @@ func (jwtOpts *JWTOpts) Validate() error {
 	if jwtOpts.ValidFor > 24*time.Hour {
 		return dasherr.ValidateErr(fmt.Errorf("Maximum validFor for JWT tokens is 24-hours"))
 	}
-	if jwtOpts.Role != "" && !dashutil.IsRoleListValid(jwtOpts.Role) {
+	if roleList := jwtOpts.roleList(); roleList != "" && !dashutil.IsRoleListValid(roleList) {
 		return dasherr.ValidateErr(fmt.Errorf("Invalid Role"))
 	}
+	for claimName := range jwtOpts.ExtraClaims {
+		if claimName == "" || reservedJWTClaims[claimName] {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid ExtraClaims key '%s' (reserved)", claimName))
+		}
+	}
+	switch jwtOpts.SigningKey.(type) {
+	case nil, *ecdsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return dasherr.ValidateErr(fmt.Errorf("Invalid SigningKey, must be *ecdsa.PrivateKey or ed25519.PrivateKey"))
+	}
 	if jwtOpts.UserId != "" && !dashutil.IsUserIdValid(jwtOpts.UserId) {
 		return dasherr.ValidateErr(fmt.Errorf("Invalid UserId"))
 	}
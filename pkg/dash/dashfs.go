@@ -7,11 +7,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
 	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
 )
@@ -53,6 +55,8 @@ type FileInfo struct {
 	ProcLinks     []string `json:"proclinks,omitempty"`
 	TxId          string   `json:"txid,omitempty"`
 	AppConfigJson string   `json:"appconfig"` // json-string
+	CacheControl  string   `json:"cachecontrol,omitempty"`
+	ETag          string   `json:"etag,omitempty"`
 }
 
 // Unmarshals the FileInfo's metadata into an object (like json.Unmarshal).
@@ -86,6 +90,12 @@ type FileOpts struct {
 	NoMkDirs      bool     `json:"nomkdirs,omitempty"`
 	Hidden        bool     `json:"hidden,omitempty"`
 	AppConfigJson string   `json:"appconfig"` // json-string
+
+	// Cache-Control header for static files served to browsers (see CacheControlMaxAge).
+	CacheControl string `json:"cachecontrol,omitempty"`
+
+	// ETag for static files, defaults to a strong ETag computed from Sha256 (see ETagFromSha256).
+	ETag string `json:"etag,omitempty"`
 }
 
 // Marshals (json.Marshal) an object to the FileInfo.Metadata field.
@@ -119,6 +129,16 @@ type WatchOpts struct {
 	ShutdownCh   chan struct{}
 }
 
+// Options to pass to DashFSClient.WatchDir().
+type WatchDirOpts struct {
+	Include      []string      // glob patterns (filepath.Match) matched against the relative path and base name, empty includes all
+	Exclude      []string      // glob patterns, excluded files are never uploaded (or removed remotely)
+	ThrottleTime time.Duration // changes are batched for this long before syncing (defaults to 1s)
+	ShutdownCh   chan struct{}
+	NoDelete     bool      // do not remove remote paths when local files are deleted (or missing at startup)
+	FileOpts     *FileOpts // template for uploaded files (roles, hidden, etc.), MimeType is set from the extension if empty
+}
+
 type DashFSClient struct {
 	rootPath string
 	client   *DashCloudClient
@@ -127,23 +147,25 @@ type DashFSClient struct {
 // Low-level function to set a Dashborg FS path.  Not normally called by end users.  This function
 // is called by SetJsonPath, LinkRuntime, LinkAppRuntime, SetPathFromFile, SetStaticPath, and WatchFile.
 func (fs *DashFSClient) SetRawPath(path string, r io.Reader, fileOpts *FileOpts, runtime LinkRuntime) error {
-	if path == "" || path[0] != '/' {
-		return dasherr.ValidateErr(fmt.Errorf("Path must begin with '/'"))
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
 	}
-	return fs.client.setRawPath(fs.rootPath+path, r, fileOpts, runtime)
+	return fs.client.setRawPath(fullPath, r, fileOpts, runtime)
 }
 
 // Sets static JSON data to the given path.  FileOpts is optional (type will be set to "static",
 // and mimeType to "application/json").
 func (fs *DashFSClient) SetJsonPath(path string, data interface{}, fileOpts *FileOpts) error {
-	var jsonBuf bytes.Buffer
-	enc := json.NewEncoder(&jsonBuf)
-	enc.SetEscapeHTML(false)
-	err := enc.Encode(data)
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
+	}
+	jsonStr, err := dashutil.MarshalJsonOpts(data, fs.client.appJsonOpts(dashutil.AppNameFromPath(fullPath)))
 	if err != nil {
 		return dasherr.JsonMarshalErr("JsonData", err)
 	}
-	reader := bytes.NewReader(jsonBuf.Bytes())
+	reader := bytes.NewReader([]byte(jsonStr))
 	if fileOpts == nil {
 		fileOpts = &FileOpts{}
 	}
@@ -209,96 +231,22 @@ func (fs *DashFSClient) runWatchedSetPath(path string, fileName string, fileOpts
 	}
 }
 
-// First calls SetPathFromFile.  If that that fails, an error is returned and the file will *not* be watched
-// (watching only starts if this function returns nil).  The given file will be watched using fsnotify.
-// Every time fsnotify detects a file modification, the file will be be re-uploaded using SetPathFromFile.
-// watchOpts may be nil, which will use default settings (Throttle time of 1 second, no shutdown channel).
-// This is function is recommended for use in development environments.
-func (fs *DashFSClient) WatchFile(path string, fileName string, fileOpts *FileOpts, watchOpts *WatchOpts) error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
-	if fileOpts == nil {
-		fileOpts = &FileOpts{}
-	}
-	if watchOpts == nil {
-		watchOpts = &WatchOpts{ThrottleTime: time.Second}
-	}
-	err = fs.SetPathFromFile(path, fileName, fileOpts)
-	if err != nil {
-		return err
-	}
-	err = watcher.Add(fileName)
+// Removes (deletes) the specified path from Dashborg FS.  Use TrashPath for a removal that can be undone.
+func (fs *DashFSClient) RemovePath(path string) error {
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
 	if err != nil {
 		return err
 	}
-	go func() {
-		var needsRun bool
-		lastRun := time.Now()
-		defer watcher.Close()
-		var timer *time.Timer
-		for {
-			var timerCh <-chan time.Time
-			if timer != nil {
-				timerCh = timer.C
-			}
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op == fsnotify.Write || event.Op == fsnotify.Create {
-					dur := time.Since(lastRun)
-					if dur < watchOpts.ThrottleTime {
-						needsRun = true
-						if timer == nil {
-							timer = time.NewTimer(watchOpts.ThrottleTime - dur)
-						}
-					} else {
-						needsRun = false
-						fs.runWatchedSetPath(path, fileName, fileOpts)
-						lastRun = time.Now()
-					}
-				}
-
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Printf("DashFS Watch Error path=%s file=%s err=%v\n", dashutil.SimplifyPath(path, nil), fileName, err)
-				return
-
-			case <-timerCh:
-				if needsRun {
-					timer = nil
-					needsRun = false
-					fs.runWatchedSetPath(path, fileName, fileOpts)
-					lastRun = time.Now()
-				}
-
-			case <-watchOpts.ShutdownCh:
-				return
-			}
-		}
-	}()
-	return nil
-}
-
-// Removes (deletes) the specified path from Dashborg FS.
-func (fs *DashFSClient) RemovePath(path string) error {
-	if path == "" || path[0] != '/' {
-		return fmt.Errorf("Path must begin with '/'")
-	}
-	return fs.client.removePath(fs.rootPath + path)
+	return fs.client.removePath(fullPath)
 }
 
 // Gets the FileInfo associated with path.  If the file is not found, will return nil, nil.
 func (fs *DashFSClient) FileInfo(path string) (*FileInfo, error) {
-	if path == "" || path[0] != '/' {
-		return nil, fmt.Errorf("Path must begin with '/'")
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return nil, err
 	}
-	rtn, _, err := fs.client.fileInfo(fs.rootPath+path, nil, false)
+	rtn, _, err := fs.client.fileInfo(fullPath, nil, false)
 	if err != nil {
 		return nil, err
 	}
@@ -314,13 +262,53 @@ func (fs *DashFSClient) DirInfo(path string, dirOpts *DirOpts) ([]*FileInfo, err
 	if dirOpts == nil {
 		dirOpts = &DirOpts{}
 	}
-	if path == "" || path[0] != '/' {
-		return nil, fmt.Errorf("Path must begin with '/'")
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return nil, err
 	}
-	rtn, _, err := fs.client.fileInfo(fs.rootPath+path, dirOpts, false)
+	rtn, _, err := fs.client.fileInfo(fullPath, dirOpts, false)
 	return rtn, err
 }
 
+// Reads the contents of a static path (written with SetRawPath, SetStaticPath, SetJsonPath, etc.).
+// Returns an ErrCodePathNotFound error if the path does not exist.  The contents are checked
+// against the stored size and SHA-256.  The caller must close the returned reader.
+func (fs *DashFSClient) GetRawPath(path string) (io.ReadCloser, *FileInfo, error) {
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	finfo, content, err := fs.client.readStaticPath(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if finfo == nil {
+		return nil, nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path '%s' not found", path))
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), finfo, nil
+}
+
+// Reads JSON data written with SetJsonPath into v (like json.Unmarshal).  Returns an
+// ErrCodePathNotFound error if the path does not exist.
+func (fs *DashFSClient) GetJsonPath(path string, v interface{}) error {
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
+	}
+	finfo, content, err := fs.client.readStaticPath(fullPath)
+	if err != nil {
+		return err
+	}
+	if finfo == nil {
+		return dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path '%s' not found", path))
+	}
+	err = dashutil.UnmarshalJson(string(content), v, fs.client.Config.jsonOpts())
+	if err != nil {
+		return dasherr.JsonUnmarshalErr("JsonData", err)
+	}
+	return nil
+}
+
 // Connects a LinkRuntime to the given path.
 func (fs *DashFSClient) LinkRuntime(path string, rt LinkRuntime, fileOpts *FileOpts) error {
 	if hasErr, ok := rt.(HasErr); ok {
@@ -333,14 +321,16 @@ func (fs *DashFSClient) LinkRuntime(path string, rt LinkRuntime, fileOpts *FileO
 		fileOpts = &FileOpts{}
 	}
 	fileOpts.FileType = FileTypeRuntimeLink
-	if path == "" || path[0] != '/' {
-		return fmt.Errorf("Path must begin with '/'")
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
 	}
-	return fs.client.setRawPath(fs.rootPath+path, nil, fileOpts, rt)
+	return fs.client.setRawPath(fullPath, nil, fileOpts, rt)
 }
 
 // Connects an AppRuntime to the given path.  Normally this function is not called directly.
 // When an app is connected to the Dashborg backend, its runtime is also linked.
+// To share the path across multiple processes (with session affinity) call SetLinkOpts() first.
 func (fs *DashFSClient) LinkAppRuntime(path string, apprt LinkRuntime, fileOpts *FileOpts) error {
 	if hasErr, ok := apprt.(HasErr); ok {
 		err := hasErr.Err()
@@ -352,10 +342,11 @@ func (fs *DashFSClient) LinkAppRuntime(path string, apprt LinkRuntime, fileOpts
 		fileOpts = &FileOpts{}
 	}
 	fileOpts.FileType = FileTypeAppRuntimeLink
-	if path == "" || path[0] != '/' {
-		return fmt.Errorf("Path must begin with '/'")
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
 	}
-	return fs.client.setRawPath(fs.rootPath+path, nil, fileOpts, apprt)
+	return fs.client.setRawPath(fullPath, nil, fileOpts, apprt)
 }
 
 // Sets static data to the given Dashborg FS path, with data from an io.ReadSeeker.
@@ -376,17 +367,18 @@ func (fs *DashFSClient) SetStaticPath(path string, r io.ReadSeeker, fileOpts *Fi
 // Creates a /@fs/ URL link to the given path.  If jwtOpts are specified, it will override
 // the defaults in the config.
 func (fs *DashFSClient) MakePathUrl(path string, jwtOpts *JWTOpts) (string, error) {
-	if path == "" || !dashutil.IsFullPathValid(path) {
-		return "", fmt.Errorf("Invalid Path")
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return "", err
 	}
 	if jwtOpts == nil {
 		jwtOpts = fs.client.Config.GetJWTOpts()
 	}
-	pathLink := fs.client.getAccHost() + "/@fs" + fs.rootPath + path
+	pathLink := fs.client.getAccHost() + "/@fs" + fullPath
 	if jwtOpts.NoJWT {
 		return pathLink, nil
 	}
-	err := jwtOpts.Validate()
+	err = jwtOpts.Validate()
 	if err != nil {
 		return "", err
 	}
@@ -410,16 +402,91 @@ func (fs *DashFSClient) MustMakePathUrl(path string, jwtOpts *JWTOpts) string {
 // Note the difference between this function and LinkRuntime().  LinkRuntime() takes
 // FileOpts and will create/update the path.
 func (fs *DashFSClient) ConnectLinkRuntime(path string, runtime LinkRuntime) error {
-	if !dashutil.IsFullPathValid(path) {
-		return fmt.Errorf("Invalid Path")
+	err := dashutil.Path(path).Validate()
+	if err != nil {
+		return err
 	}
 	if runtime == nil {
 		return fmt.Errorf("LinkRuntime() error, runtime must not be nil")
 	}
-	err := fs.client.connectLinkRpc(path)
+	err = fs.client.acquireLinkLease(path)
+	if err != nil {
+		return err
+	}
+	err = fs.client.connectLinkRpc(path)
 	if err != nil {
 		return err
 	}
 	fs.client.connectLinkRuntime(path, runtime)
 	return nil
 }
+
+// Options for DashFSClient.RemovePrefix.
+type RemovePrefixOpts struct {
+	DryRun bool // if set, returns the paths that would be removed without removing them
+
+	// If set, RemovePrefix fails (without removing anything) unless it would remove exactly
+	// this many paths.  Use the count from a DryRun to confirm the removal.
+	ConfirmCount int
+}
+
+// Result of DashFSClient.RemovePrefix.
+type RemovePrefixResult struct {
+	Paths      []string // paths removed (or that would be removed for a DryRun), files before directories
+	NumRemoved int
+}
+
+// Removes the directory prefix and every path under it (including hidden files).  Files are
+// removed before their directories.  On error, NumRemoved holds the number of paths removed
+// before the failure.
+func (fs *DashFSClient) RemovePrefix(prefix string, opts *RemovePrefixOpts) (*RemovePrefixResult, error) {
+	_, err := dashutil.FullPathFromRoot(fs.rootPath, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &RemovePrefixOpts{}
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" && fs.rootPath == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("RemovePrefix cannot remove the root directory"))
+	}
+	dirPath := prefix
+	if dirPath == "" {
+		dirPath = "/"
+	}
+	finfos, err := fs.DirInfo(dirPath, &DirOpts{ShowHidden: true, Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+	var filePaths, dirPaths []string
+	for _, finfo := range finfos {
+		if finfo.FileType == FileTypeDir {
+			dirPaths = append(dirPaths, finfo.Path)
+		} else {
+			filePaths = append(filePaths, finfo.Path)
+		}
+	}
+	// deepest directories first
+	sort.Slice(dirPaths, func(i int, j int) bool {
+		return len(dirPaths[i]) > len(dirPaths[j])
+	})
+	if prefix != "" {
+		dirPaths = append(dirPaths, fs.rootPath+prefix)
+	}
+	rtn := &RemovePrefixResult{Paths: append(filePaths, dirPaths...)}
+	if opts.ConfirmCount > 0 && opts.ConfirmCount != len(rtn.Paths) {
+		return rtn, dasherr.ValidateErr(fmt.Errorf("RemovePrefix(%s) would remove %d paths, ConfirmCount is %d", prefix, len(rtn.Paths), opts.ConfirmCount))
+	}
+	if opts.DryRun {
+		return rtn, nil
+	}
+	for _, path := range rtn.Paths {
+		err = fs.client.removePath(path)
+		if err != nil && dasherr.GetErrCode(err) != dasherr.ErrCodePathNotFound {
+			return rtn, err
+		}
+		rtn.NumRemoved++
+	}
+	return rtn, nil
+}
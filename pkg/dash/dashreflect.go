@@ -7,12 +7,14 @@ import (
 	"reflect"
 
 	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+	"google.golang.org/protobuf/proto"
 )
 
 var errType = reflect.TypeOf((*error)(nil)).Elem()
 var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 var appReqType = reflect.TypeOf(&AppRequest{})
 var reqType = reflect.TypeOf((*Request)(nil)).Elem()
+var actionReqType = reflect.TypeOf((*ActionRequest)(nil)).Elem()
 var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 func checkOutput(mType reflect.Type, outputTypes ...reflect.Type) bool {
@@ -34,11 +36,11 @@ func ca_unmarshalNil(hType reflect.Type, args []reflect.Value, argNum int) {
 	}
 }
 
-func ca_unmarshalSingle(hType reflect.Type, args []reflect.Value, argNum int, jsonStr string) error {
+func ca_unmarshalSingle(hType reflect.Type, args []reflect.Value, argNum int, jsonStr string, jsonOpts dashutil.JsonOpts) error {
 	if argNum >= hType.NumIn() {
 		return nil
 	}
-	argV, err := unmarshalToType(jsonStr, hType.In(argNum))
+	argV, err := unmarshalToType(jsonStr, hType.In(argNum), jsonOpts)
 	if err != nil {
 		return err
 	}
@@ -48,16 +50,21 @@ func ca_unmarshalSingle(hType reflect.Type, args []reflect.Value, argNum int, js
 	return nil
 }
 
-func ca_unmarshalMulti(hType reflect.Type, args []reflect.Value, argNum int, jsonStr string) error {
+func ca_unmarshalMulti(hType reflect.Type, args []reflect.Value, argNum int, jsonStr string, jsonOpts dashutil.JsonOpts) error {
 	if argNum >= hType.NumIn() {
 		return nil
 	}
 	outVals := make([]interface{}, hType.NumIn()-argNum)
 	dataArgsNum := hType.NumIn() - argNum
 	for i := argNum; i < hType.NumIn(); i++ {
+		if isProtoMessageType(hType.In(i)) {
+			// decoded with protojson below
+			outVals[i-argNum] = &json.RawMessage{}
+			continue
+		}
 		outVals[i-argNum] = reflect.New(hType.In(i)).Interface()
 	}
-	err := json.Unmarshal([]byte(jsonStr), &outVals)
+	err := dashutil.UnmarshalJson(jsonStr, &outVals, jsonOpts)
 	if err != nil {
 		return err
 	}
@@ -65,6 +72,12 @@ func ca_unmarshalMulti(hType reflect.Type, args []reflect.Value, argNum int, jso
 	for i := 0; i < len(outVals) && i < dataArgsNum; i++ {
 		if outVals[i] == nil {
 			args[i+argNum] = reflect.Zero(hType.In(i + argNum))
+		} else if rawMsg, ok := outVals[i].(*json.RawMessage); ok && isProtoMessageType(hType.In(i+argNum)) {
+			argV, err := unmarshalToType(string(*rawMsg), hType.In(i+argNum), jsonOpts)
+			if err != nil {
+				return err
+			}
+			args[i+argNum] = argV
 		} else {
 			args[i+argNum] = reflect.ValueOf(outVals[i]).Elem()
 		}
@@ -92,7 +105,7 @@ func makeCallArgs(hType reflect.Type, req Request, appReq bool, pureHandler bool
 	if argNum == hType.NumIn() {
 		return rtn, nil
 	}
-	if hType.In(argNum) == appReqType {
+	if isAppReqArgType(hType.In(argNum)) {
 		if !appReq {
 			return nil, fmt.Errorf("LinkRuntime functions must use dash.Request, not *dash.AppRequest")
 		}
@@ -109,8 +122,9 @@ func makeCallArgs(hType reflect.Type, req Request, appReq bool, pureHandler bool
 		return rtn, nil
 	}
 	rawData := req.RawData()
+	jsonOpts := requestJsonOpts(req)
 	if stateType != nil && stateType == hType.In(argNum) {
-		stateV, err := unmarshalToType(rawData.AppStateJson, stateType)
+		stateV, err := unmarshalToType(rawData.AppStateJson, stateType, jsonOpts)
 		if err != nil {
 			return nil, fmt.Errorf("Cannot unmarshal appStateJson to type:%v err:%v", hType.In(1), err)
 		}
@@ -125,12 +139,18 @@ func makeCallArgs(hType reflect.Type, req Request, appReq bool, pureHandler bool
 	if dataInterface == nil {
 		ca_unmarshalNil(hType, rtn, argNum)
 	} else if reflect.ValueOf(dataInterface).Kind() == reflect.Slice {
-		err := ca_unmarshalMulti(hType, rtn, argNum, rawData.DataJson)
+		err := ca_unmarshalMulti(hType, rtn, argNum, rawData.DataJson, jsonOpts)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		err := ca_unmarshalSingle(hType, rtn, argNum, rawData.DataJson)
+		err := ca_unmarshalSingle(hType, rtn, argNum, rawData.DataJson, jsonOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := argNum; i < len(rtn); i++ {
+		err := validateHandlerData(rtn[i], i-argNum)
 		if err != nil {
 			return nil, err
 		}
@@ -158,7 +178,7 @@ func validateHandler(hType reflect.Type, appReq bool, pureHandler bool, stateTyp
 		return nil
 	}
 	// check optional first argument: *dash.AppRequest / dash.Request
-	if hType.In(argNum) == appReqType {
+	if isAppReqArgType(hType.In(argNum)) {
 		if !appReq {
 			return fmt.Errorf("LinkRuntime functions must use dash.Request, not *dash.AppRequest")
 		}
@@ -189,7 +209,7 @@ func validateHandler(hType reflect.Type, appReq bool, pureHandler bool, stateTyp
 	// some basic static checking of the rest of the arguments
 	for ; argNum < hType.NumIn(); argNum++ {
 		inType := hType.In(argNum)
-		if inType == appReqType {
+		if isAppReqArgType(inType) {
 			return fmt.Errorf("Invalid arg #%d, *dash.AppRequest must be first argument", argNum+1)
 		}
 		if inType == reqType {
@@ -211,20 +231,31 @@ func validateHandler(hType reflect.Type, appReq bool, pureHandler bool, stateTyp
 	return nil
 }
 
-func unmarshalToType(jsonData string, rtnType reflect.Type) (reflect.Value, error) {
+func unmarshalToType(jsonData string, rtnType reflect.Type, jsonOpts dashutil.JsonOpts) (reflect.Value, error) {
 	if jsonData == "" {
 		return reflect.Zero(rtnType), nil
 	}
+	if isProtoMessageType(rtnType) {
+		if jsonData == "null" {
+			return reflect.Zero(rtnType), nil
+		}
+		rtnV := reflect.New(rtnType.Elem())
+		err := unmarshalProtoJson(jsonData, rtnV.Interface().(proto.Message))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return rtnV, nil
+	}
 	if rtnType.Kind() == reflect.Ptr {
 		rtnV := reflect.New(rtnType.Elem())
-		err := json.Unmarshal([]byte(jsonData), rtnV.Interface())
+		err := dashutil.UnmarshalJson(jsonData, rtnV.Interface(), jsonOpts)
 		if err != nil {
 			return reflect.Value{}, err
 		}
 		return rtnV, nil
 	} else {
 		rtnV := reflect.New(rtnType)
-		err := json.Unmarshal([]byte(jsonData), rtnV.Interface())
+		err := dashutil.UnmarshalJson(jsonData, rtnV.Interface(), jsonOpts)
 		if err != nil {
 			return reflect.Value{}, err
 		}
@@ -241,7 +272,8 @@ func unmarshalToType(jsonData string, rtnType reflect.Type) (reflect.Value, erro
 // the arguments are ignored.  If request Data is shorter, the missing arguments are set to their zero value.
 // If request Data is not an array, it will be converted to a single element array, if request Data is null
 // it will be converted to a zero-element array.  The handler will throw an error if the Data or AppState
-// values cannot be converted to their respective go types (using json.Unmarshal).
+// values cannot be converted to their respective go types (using json.Unmarshal).  Data arguments that
+// implement DataValidator are validated before the handler is called.
 func (apprt *AppRuntimeImpl) Handler(name string, handlerFn interface{}, opts ...*HandlerOpts) {
 	singleOpt := getSingleOpt(opts)
 	err := handlerInternal(apprt, name, handlerFn, true, singleOpt)
@@ -421,12 +453,17 @@ func checkContextArg(hType reflect.Type, argNum *int) bool {
 	return false
 }
 
+// *dash.AppRequest or dash.ActionRequest (handlers that can cause UI side effects)
+func isAppReqArgType(argType reflect.Type) bool {
+	return argType == appReqType || argType == actionReqType
+}
+
 func checkReqArg(hType reflect.Type, argNum *int) bool {
 	if *argNum >= hType.NumIn() {
 		return false
 	}
 	argType := hType.In(*argNum)
-	if argType == reqType || argType == appReqType {
+	if argType == reqType || isAppReqArgType(argType) {
 		(*argNum)++
 		return true
 	}
@@ -446,6 +483,11 @@ func checkAppStateArg(hType reflect.Type, argNum *int, appStateType reflect.Type
 }
 
 func makeHandlerInfo(rti runtimeImplIf, name string, handlerFn interface{}, opts HandlerOpts) (*runtimeHandlerInfo, error) {
+	for _, atom := range opts.StateAtoms {
+		if !dashutil.IsSimpleIdValid(atom) {
+			return nil, fmt.Errorf("Invalid StateAtom '%s'", atom)
+		}
+	}
 	rtn := &runtimeHandlerInfo{
 		Name:           name,
 		Pure:           opts.PureHandler,
@@ -453,6 +495,7 @@ func makeHandlerInfo(rti runtimeImplIf, name string, handlerFn interface{}, opts
 		Display:        opts.Display,
 		FormDisplay:    opts.FormDisplay,
 		ResultsDisplay: opts.ResultsDisplay,
+		StateAtoms:     opts.StateAtoms,
 	}
 	var err error
 	hType := reflect.TypeOf(handlerFn)
@@ -0,0 +1,313 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// A value looked up from request data with DataPath.  Lookups never panic: a missing path
+// or a value that cannot be converted returns an error from the getter (or the default for
+// the *Or variants).
+type DataValue struct {
+	path   string
+	val    interface{}
+	exists bool
+	err    error
+}
+
+// parses "user.address.zip", "items[0].name", or "$.items.0" into parts (string keys or int indexes)
+func parseDataPath(path string) ([]interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+	var rtn []interface{}
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var indexes []int
+		if bracketIdx := strings.Index(part, "["); bracketIdx != -1 {
+			key = part[0:bracketIdx]
+			rest := part[bracketIdx:]
+			for rest != "" {
+				endIdx := strings.Index(rest, "]")
+				if rest[0] != '[' || endIdx == -1 {
+					return nil, dasherr.ValidateErr(fmt.Errorf("Invalid DataPath '%s'", path))
+				}
+				idx, err := strconv.Atoi(rest[1:endIdx])
+				if err != nil || idx < 0 {
+					return nil, dasherr.ValidateErr(fmt.Errorf("Invalid DataPath '%s', bad index '%s'", path, rest[1:endIdx]))
+				}
+				indexes = append(indexes, idx)
+				rest = rest[endIdx+1:]
+			}
+		}
+		if key == "" && len(indexes) == 0 {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid DataPath '%s', empty part", path))
+		}
+		if key != "" {
+			rtn = append(rtn, key)
+		}
+		for _, idx := range indexes {
+			rtn = append(rtn, idx)
+		}
+	}
+	return rtn, nil
+}
+
+func lookupDataPath(root interface{}, path string) DataValue {
+	parts, err := parseDataPath(path)
+	if err != nil {
+		return DataValue{path: path, err: err}
+	}
+	cur := root
+	for _, part := range parts {
+		switch pv := part.(type) {
+		case string:
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				if arr, isArr := cur.([]interface{}); isArr {
+					idx, convErr := strconv.Atoi(pv)
+					if convErr == nil && idx >= 0 && idx < len(arr) {
+						cur = arr[idx]
+						continue
+					}
+				}
+				return DataValue{path: path}
+			}
+			cur, ok = m[pv]
+			if !ok {
+				return DataValue{path: path}
+			}
+
+		case int:
+			arr, ok := cur.([]interface{})
+			if !ok || pv >= len(arr) {
+				return DataValue{path: path}
+			}
+			cur = arr[pv]
+		}
+	}
+	return DataValue{path: path, val: cur, exists: true}
+}
+
+// Looks up a value in the request data by path, e.g. req.DataPath("user.address.zip") or
+// req.DataPath("items[0].name").  The data is decoded once (numbers as json.Number).
+// Usage: zip := req.DataPath("user.address.zip").StringOr("")
+func (req *AppRequest) DataPath(path string) DataValue {
+	req.lock.Lock()
+	if !req.dataDecoded {
+		req.dataDecoded = true
+		if req.rawData.DataJson != "" {
+			req.dataErr = dashutil.UnmarshalJson(req.rawData.DataJson, &req.dataVal, dashutil.JsonOpts{UseNumber: true})
+		}
+	}
+	dataVal, dataErr := req.dataVal, req.dataErr
+	req.lock.Unlock()
+	if dataErr != nil {
+		return DataValue{path: path, err: dasherr.JsonUnmarshalErr("RequestData", dataErr)}
+	}
+	return lookupDataPath(dataVal, path)
+}
+
+// Looks up a sub-path of this value.
+func (v DataValue) Path(subPath string) DataValue {
+	if v.err != nil || !v.exists {
+		return DataValue{path: v.path + "." + subPath, err: v.err}
+	}
+	rtn := lookupDataPath(v.val, subPath)
+	rtn.path = v.path + "." + subPath
+	return rtn
+}
+
+// Returns true if the path exists (it may be set to null, see IsNull).
+func (v DataValue) Exists() bool {
+	return v.exists
+}
+
+// Returns true if the path is missing or set to null.
+func (v DataValue) IsNull() bool {
+	return !v.exists || v.val == nil
+}
+
+// Returns the raw decoded value (map[string]interface{}, []interface{}, string,
+// json.Number, bool, or nil).
+func (v DataValue) Raw() interface{} {
+	return v.val
+}
+
+func (v DataValue) checkExists() error {
+	if v.err != nil {
+		return v.err
+	}
+	if !v.exists {
+		return dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("DataPath '%s' not found", v.path))
+	}
+	return nil
+}
+
+func (v DataValue) convErr(typeName string) error {
+	return dasherr.ValidateErr(fmt.Errorf("DataPath '%s' cannot convert %T to %s", v.path, v.val, typeName))
+}
+
+// Returns the value as a string.  Numbers and bools are formatted.
+func (v DataValue) String() (string, error) {
+	if err := v.checkExists(); err != nil {
+		return "", err
+	}
+	switch tv := v.val.(type) {
+	case string:
+		return tv, nil
+	case json.Number:
+		return tv.String(), nil
+	case bool:
+		return strconv.FormatBool(tv), nil
+	}
+	return "", v.convErr("string")
+}
+
+// Returns the value as an int64.  Numeric strings are parsed, numbers with a fractional
+// part return an error.
+func (v DataValue) Int64() (int64, error) {
+	if err := v.checkExists(); err != nil {
+		return 0, err
+	}
+	var numStr string
+	switch tv := v.val.(type) {
+	case json.Number:
+		numStr = tv.String()
+	case string:
+		numStr = strings.TrimSpace(tv)
+	default:
+		return 0, v.convErr("int64")
+	}
+	ival, err := strconv.ParseInt(numStr, 10, 64)
+	if err == nil {
+		return ival, nil
+	}
+	fval, ferr := strconv.ParseFloat(numStr, 64)
+	if ferr != nil || fval != math.Trunc(fval) || fval > math.MaxInt64 || fval < math.MinInt64 {
+		return 0, v.convErr("int64")
+	}
+	return int64(fval), nil
+}
+
+// Returns the value as a float64.  Numeric strings are parsed.
+func (v DataValue) Float64() (float64, error) {
+	if err := v.checkExists(); err != nil {
+		return 0, err
+	}
+	var numStr string
+	switch tv := v.val.(type) {
+	case json.Number:
+		numStr = tv.String()
+	case string:
+		numStr = strings.TrimSpace(tv)
+	default:
+		return 0, v.convErr("float64")
+	}
+	fval, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0, v.convErr("float64")
+	}
+	return fval, nil
+}
+
+// Returns the value as a bool.  Accepts strings "true" and "false" (see strconv.ParseBool).
+func (v DataValue) Bool() (bool, error) {
+	if err := v.checkExists(); err != nil {
+		return false, err
+	}
+	switch tv := v.val.(type) {
+	case bool:
+		return tv, nil
+	case string:
+		bval, err := strconv.ParseBool(strings.TrimSpace(tv))
+		if err == nil {
+			return bval, nil
+		}
+	}
+	return false, v.convErr("bool")
+}
+
+// Returns the value as a time.Time.  Numbers are Dashborg timestamps (epoch milliseconds),
+// strings are parsed as RFC3339 (or a "2006-01-02" date).
+func (v DataValue) Time() (time.Time, error) {
+	if err := v.checkExists(); err != nil {
+		return time.Time{}, err
+	}
+	switch tv := v.val.(type) {
+	case json.Number:
+		ts, err := tv.Int64()
+		if err != nil {
+			return time.Time{}, v.convErr("time.Time")
+		}
+		return dashutil.GoTime(ts), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			t, err := time.Parse(layout, strings.TrimSpace(tv))
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, v.convErr("time.Time")
+}
+
+// Unmarshals the value into obj (like BindData for a sub-path).
+func (v DataValue) Bind(obj interface{}) error {
+	if err := v.checkExists(); err != nil {
+		return err
+	}
+	jsonStr, err := dashutil.MarshalJson(v.val)
+	if err != nil {
+		return dasherr.JsonMarshalErr("DataPath", err)
+	}
+	return dashutil.UnmarshalJson(jsonStr, obj, dashutil.JsonOpts{UseNumber: true})
+}
+
+func (v DataValue) StringOr(def string) string {
+	rtn, err := v.String()
+	if err != nil || v.val == nil {
+		return def
+	}
+	return rtn
+}
+
+func (v DataValue) Int64Or(def int64) int64 {
+	rtn, err := v.Int64()
+	if err != nil {
+		return def
+	}
+	return rtn
+}
+
+func (v DataValue) Float64Or(def float64) float64 {
+	rtn, err := v.Float64()
+	if err != nil {
+		return def
+	}
+	return rtn
+}
+
+func (v DataValue) BoolOr(def bool) bool {
+	rtn, err := v.Bool()
+	if err != nil {
+		return def
+	}
+	return rtn
+}
+
+func (v DataValue) TimeOr(def time.Time) time.Time {
+	rtn, err := v.Time()
+	if err != nil {
+		return def
+	}
+	return rtn
+}
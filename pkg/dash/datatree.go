@@ -0,0 +1,261 @@
+package dash
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// maximum number of frontend clients tracked per app (least recently updated are dropped)
+const DataTreeMaxClients = 100
+
+// The data tree the backend has pushed to one frontend client, reconstructed from the
+// "setdata" actions sent in responses (see TrackDataTree).  FeClientId "" holds the data
+// sent without a frontend client (stream and broadcast responses), that data is also applied
+// to every client's tree.  Ops other than set, append, and setunless are counted in SkippedOps.
+type DataTreeSnapshot struct {
+	AppName    string         `json:"appname"`
+	FeClientId string         `json:"feclientid"`
+	UpdatedTs  int64          `json:"updatedts"`
+	NumActions int            `json:"numactions"`
+	SkippedOps map[string]int `json:"skippedops,omitempty"`
+	Data       interface{}    `json:"data"`
+}
+
+type dataTreeType struct {
+	updatedTs  int64
+	numActions int
+	skippedOps map[string]int
+	root       interface{}
+}
+
+type appDataTrees struct {
+	lock    *sync.Mutex
+	clients map[string]*dataTreeType // feclientid => tree
+}
+
+// Starts (or stops) tracking the data tree pushed to each frontend client of appName.  Off
+// by default, tracking keeps a copy of all data sent to the frontend in memory (up to
+// DataTreeMaxClients clients), so only enable it while debugging.  Stopping discards the trees.
+// Usage: client.TrackDataTree("myapp", true); snap, err := client.DataTreeSnapshot("myapp", feClientId)
+func (pc *DashCloudClient) TrackDataTree(appName string, enable bool) error {
+	if !dashutil.IsAppNameValid(appName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if !enable {
+		delete(pc.dataTrees, appName)
+		return nil
+	}
+	if pc.dataTrees[appName] == nil {
+		pc.dataTrees[appName] = &appDataTrees{lock: &sync.Mutex{}, clients: make(map[string]*dataTreeType)}
+	}
+	return nil
+}
+
+// Returns the reconstructed data tree for a frontend client (FeClientId from the request's
+// RequestInfo), exportable as JSON.  Returns ErrCodePathNotFound if nothing was sent to the client.
+func (pc *DashCloudClient) DataTreeSnapshot(appName string, feClientId string) (*DataTreeSnapshot, error) {
+	trees := pc.getAppDataTrees(appName)
+	if trees == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Data tree tracking is not enabled for app '%s' (see TrackDataTree)", appName))
+	}
+	trees.lock.Lock()
+	defer trees.lock.Unlock()
+	tree := trees.clients[feClientId]
+	if tree == nil {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("No data tree for app '%s' feclientid '%s'", appName, feClientId))
+	}
+	snap := &DataTreeSnapshot{
+		AppName:    appName,
+		FeClientId: feClientId,
+		UpdatedTs:  tree.updatedTs,
+		NumActions: tree.numActions,
+		Data:       copyDataTree(tree.root),
+	}
+	if len(tree.skippedOps) > 0 {
+		snap.SkippedOps = make(map[string]int)
+		for op, num := range tree.skippedOps {
+			snap.SkippedOps[op] = num
+		}
+	}
+	return snap, nil
+}
+
+// Returns the frontend client ids with a tracked data tree for appName.
+func (pc *DashCloudClient) DataTreeClients(appName string) []string {
+	trees := pc.getAppDataTrees(appName)
+	if trees == nil {
+		return nil
+	}
+	trees.lock.Lock()
+	defer trees.lock.Unlock()
+	var rtn []string
+	for feClientId := range trees.clients {
+		rtn = append(rtn, feClientId)
+	}
+	return rtn
+}
+
+// Returns the reconstructed data tree for a frontend client of this app (see DashCloudClient.DataTreeSnapshot).
+func (app *App) DataTreeSnapshot(feClientId string) (*DataTreeSnapshot, error) {
+	if app.client == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("App '%s' has no client (use DashCloudClient.OpenApp)", app.appName))
+	}
+	return app.client.DataTreeSnapshot(app.appName, feClientId)
+}
+
+// Snapshot as indented JSON.
+func (snap *DataTreeSnapshot) Json() (string, error) {
+	jsonStr, err := dashutil.MarshalJsonIndent(snap)
+	if err != nil {
+		return "", dasherr.JsonMarshalErr("DataTreeSnapshot", err)
+	}
+	return jsonStr, nil
+}
+
+func (pc *DashCloudClient) getAppDataTrees(appName string) *appDataTrees {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	return pc.dataTrees[appName]
+}
+
+// applies the setdata actions of an outgoing response to the tracked trees.  called before
+// compression (JsonData is still set).
+func (pc *DashCloudClient) recordDataTree(m *dashproto.SendResponseMessage) {
+	if m == nil || len(m.Actions) == 0 {
+		return
+	}
+	appName := dashutil.AppNameFromPath(m.Path)
+	trees := pc.getAppDataTrees(appName)
+	if trees == nil {
+		return
+	}
+	trees.lock.Lock()
+	defer trees.lock.Unlock()
+	tree := trees.clients[m.FeClientId]
+	if tree == nil {
+		trees.evictOldest()
+		tree = &dataTreeType{}
+		if m.FeClientId != "" && trees.clients[""] != nil {
+			tree.root = copyDataTree(trees.clients[""].root)
+		}
+		trees.clients[m.FeClientId] = tree
+	}
+	for _, rra := range m.Actions {
+		if rra.ActionType != "setdata" {
+			continue
+		}
+		var val interface{}
+		err := dashutil.UnmarshalJson(rra.JsonData, &val, dashutil.JsonOpts{})
+		if err != nil {
+			continue
+		}
+		if m.FeClientId == "" {
+			for _, clientTree := range trees.clients {
+				clientTree.applySetData(rra.Selector, val)
+			}
+			continue
+		}
+		tree.applySetData(rra.Selector, val)
+	}
+}
+
+func (trees *appDataTrees) evictOldest() {
+	if len(trees.clients) < DataTreeMaxClients {
+		return
+	}
+	var oldestId string
+	var oldestTs int64
+	for feClientId, tree := range trees.clients {
+		if feClientId == "" {
+			continue
+		}
+		if oldestTs == 0 || tree.updatedTs < oldestTs {
+			oldestId, oldestTs = feClientId, tree.updatedTs
+		}
+	}
+	delete(trees.clients, oldestId)
+}
+
+// selector is "path" or "op:path" (see AddDataOp)
+func (tree *dataTreeType) applySetData(selector string, val interface{}) {
+	tree.numActions++
+	tree.updatedTs = dashutil.Ts()
+	op, path := "set", selector
+	if colonIdx := strings.Index(selector, ":"); colonIdx != -1 {
+		op, path = selector[0:colonIdx], selector[colonIdx+1:]
+	}
+	parts, err := parseDataPath(path)
+	if err != nil || (op != "set" && op != "append" && op != "setunless") {
+		if tree.skippedOps == nil {
+			tree.skippedOps = make(map[string]int)
+		}
+		tree.skippedOps[op]++
+		return
+	}
+	tree.root = setDataTreePath(tree.root, parts, func(cur interface{}) interface{} {
+		switch op {
+		case "append":
+			if arr, ok := cur.([]interface{}); ok {
+				return append(arr, val)
+			}
+			return []interface{}{val}
+
+		case "setunless":
+			if cur != nil {
+				return cur
+			}
+		}
+		return val
+	})
+}
+
+// returns the new node with updateFn applied at parts (creating maps/arrays as needed)
+func setDataTreePath(node interface{}, parts []interface{}, updateFn func(cur interface{}) interface{}) interface{} {
+	if len(parts) == 0 {
+		return updateFn(node)
+	}
+	switch key := parts[0].(type) {
+	case string:
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{})
+		}
+		m[key] = setDataTreePath(m[key], parts[1:], updateFn)
+		return m
+
+	case int:
+		arr, _ := node.([]interface{})
+		for len(arr) <= key {
+			arr = append(arr, nil)
+		}
+		arr[key] = setDataTreePath(arr[key], parts[1:], updateFn)
+		return arr
+	}
+	return node
+}
+
+func copyDataTree(node interface{}) interface{} {
+	switch nv := node.(type) {
+	case map[string]interface{}:
+		rtn := make(map[string]interface{}, len(nv))
+		for k, v := range nv {
+			rtn[k] = copyDataTree(v)
+		}
+		return rtn
+
+	case []interface{}:
+		rtn := make([]interface{}, len(nv))
+		for i, v := range nv {
+			rtn[i] = copyDataTree(v)
+		}
+		return rtn
+	}
+	return node
+}
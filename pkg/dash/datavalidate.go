@@ -0,0 +1,43 @@
+package dash
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// Handler data arguments that implement DataValidator are validated after they are
+// unmarshaled (for reflection and typed handlers).  A non-nil error fails the request
+// with a validation error before the handler is called.
+type DataValidator interface {
+	Validate() error
+}
+
+// calls Validate() if v (or a pointer to v) implements DataValidator, nil pointers are not validated
+func validateHandlerData(v reflect.Value, argNum int) error {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	validator, ok := v.Interface().(DataValidator)
+	if !ok && v.Kind() != reflect.Ptr {
+		// allow pointer receivers on value arguments
+		ptrV := reflect.New(v.Type())
+		ptrV.Elem().Set(v)
+		validator, ok = ptrV.Interface().(DataValidator)
+	}
+	if !ok {
+		return nil
+	}
+	err := validator.Validate()
+	if err != nil {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid handler data (arg %d, %v): %w", argNum, v.Type(), err))
+	}
+	return nil
+}
@@ -27,7 +27,6 @@ import (
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -42,6 +41,7 @@ const uploadTimeout = 5 * time.Minute
 
 const stdGrpcTimeout = 10 * time.Second
 const streamGrpcTimeout = 0
+const replacedConnGracePeriod = 30 * time.Second // > stdGrpcTimeout, lets in-flight calls finish
 
 const maxBlobBytes = 5000000
 
@@ -67,14 +67,52 @@ type DashCloudClient struct {
 	StartTime time.Time
 	ProcRunId string
 	Config    *Config
-	Conn      *grpc.ClientConn
-	DBService dashproto.DashborgServiceClient
+	Conn      *grpc.ClientConn                // swapped on endpoint failover / certificate reload, read under Lock
+	DBService dashproto.DashborgServiceClient // swapped on endpoint failover / certificate reload, read under Lock
 	ConnId    *atomic.Value
 	LinkRtMap map[string]LinkRuntime
 	DoneCh    chan bool
 	PermErr   bool
 	ExitErr   error
 	AccInfo   accInfoType
+
+	httpsConn       *httpsServiceClient // set instead of Conn when Config.Transport is "https"
+	serviceConn     *atomic.Value       // *serviceConnType, the current Conn/DBService (read without locking by handler goroutines)
+	extraMd         map[string]string
+	extraMdKv       *atomic.Value // []string, key/value pairs added to outgoing RPC metadata
+	linkOptsMap     map[string]*LinkOpts
+	linkStatsMap    map[string]*LinkStats
+	connectedApps   map[string]*App // runtime path => app
+	numRequests     int64
+	selfMetricsPubs map[string]chan struct{} // data path => stop channel
+	logLock         *sync.Mutex              // protects logFilters (separate from Lock, logging can happen while Lock is held)
+	logFilters      []*logFilter
+	logFilterSeq    int
+	controlSettings map[string]ControlSetting
+	appCounters     map[string]map[string]int64 // app name => request counter totals
+	blobPolicies    map[string]*BlobPolicy      // app name => policy ("" for default)
+	blobStores      map[string]BlobStore
+	linkLeases      map[string]*linkLeaseType
+	metrics         *MetricsRegistry // nil if Config.EnableMetrics is not set
+	appTimeFormats  map[string]JsonTimeFormats
+	inflightWg      *sync.WaitGroup          // request goroutines, waited on by Shutdown
+	shuttingDown    bool                     // set by Shutdown, new requests are rejected
+	streamCancelFn  context.CancelFunc       // cancels the current RequestStream
+	shutdownDoneCh  chan struct{}            // closed when Shutdown completes
+	dataTrees       map[string]*appDataTrees // app name => tracked data trees (see TrackDataTree)
+	connected       bool                     // connection state for OnConnEvent
+	everConnected   bool
+	disconnectTime  time.Time      // start of the current disconnect (for endpoint failover)
+	useSecondary    bool           // using Config.SecondaryGrpcAddr
+	connEventCh     chan ConnEvent // nil until the first OnConnEvent listener is added
+	connEventsDone  bool           // permanenterror event was queued
+	connListeners   []connListener
+	connListenerSeq int
+	appListeners    []appListener
+	appListenerSeq  int
+	uploadLimiter   *rateLimiter     // nil if Config.UploadRateLimit is not set
+	downloadLimiter *rateLimiter     // nil if Config.DownloadRateLimit is not set
+	pendingConn     *grpc.ClientConn // re-dialed by ReloadCertificate, swapped in by runRequestStreamLoop
 }
 
 func makeCloudClient(config *Config) *DashCloudClient {
@@ -86,8 +124,36 @@ func makeCloudClient(config *Config) *DashCloudClient {
 		ConnId:    &atomic.Value{},
 		LinkRtMap: make(map[string]LinkRuntime),
 		DoneCh:    make(chan bool),
+
+		linkOptsMap:     make(map[string]*LinkOpts),
+		linkStatsMap:    make(map[string]*LinkStats),
+		connectedApps:   make(map[string]*App),
+		selfMetricsPubs: make(map[string]chan struct{}),
+		logLock:         &sync.Mutex{},
+		controlSettings: make(map[string]ControlSetting),
+		appCounters:     make(map[string]map[string]int64),
+		blobPolicies:    make(map[string]*BlobPolicy),
+		blobStores:      make(map[string]BlobStore),
+		linkLeases:      make(map[string]*linkLeaseType),
+		appTimeFormats:  make(map[string]JsonTimeFormats),
+		inflightWg:      &sync.WaitGroup{},
+		shutdownDoneCh:  make(chan struct{}),
+		dataTrees:       make(map[string]*appDataTrees),
+		extraMdKv:       &atomic.Value{},
+		serviceConn:     &atomic.Value{},
+		disconnectTime:  time.Now(),
+		uploadLimiter:   makeRateLimiter(config.UploadRateLimit),
+		downloadLimiter: makeRateLimiter(config.DownloadRateLimit),
 	}
 	rtn.ConnId.Store("")
+	rtn.serviceConn.Store(&serviceConnType{})
+	rtn.storeExtraMetadataNoLock(config.ExtraMetadata)
+	if config.EnableMetrics {
+		rtn.metrics = makeMetricsRegistry()
+	}
+	if config.InstanceId == "" {
+		config.InstanceId = rtn.ProcRunId
+	}
 	return rtn
 }
 
@@ -104,7 +170,11 @@ type grpcServerRtn struct {
 
 func (pc *DashCloudClient) getGrpcServer() (*grpcConfig, error) {
 	urlVal := fmt.Sprintf("https://%s%s?accid=%s", pc.Config.ConsoleHost, grpcServerPath, pc.Config.AccId)
-	resp, err := http.Get(urlVal)
+	transport, err := pc.Config.httpTransport()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(urlVal)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get gRPC Server Host: %w", err)
 	}
@@ -125,7 +195,24 @@ func (pc *DashCloudClient) getGrpcServer() (*grpcConfig, error) {
 }
 
 func (pc *DashCloudClient) startClient() error {
-	if pc.Config.GrpcHost == "" {
+	if pc.Config.Transport != TransportGrpc && pc.Config.Transport != TransportHttps {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid Transport '%s' (must be \"grpc\" or \"https\")", pc.Config.Transport))
+	}
+	err := validateExtraMetadataMap(pc.Config.ExtraMetadata)
+	if err != nil {
+		return err
+	}
+	err = pc.Config.validateNetOpts()
+	if err != nil {
+		return err
+	}
+	if pc.Config.ProxyURL != "" {
+		_, err = parseProxyUrl(pc.Config.ProxyURL)
+		if err != nil {
+			return err
+		}
+	}
+	if pc.Config.GrpcHost == "" && pc.Config.Transport == TransportGrpc {
 		grpcConfig, err := pc.getGrpcServer()
 		if err != nil {
 			pc.logV("DashborgCloudClient error starting: %v\n", err)
@@ -137,9 +224,16 @@ func (pc *DashCloudClient) startClient() error {
 			pc.log("Dashborg Using gRPC host %s:%d\n", pc.Config.GrpcHost, pc.Config.GrpcPort)
 		}
 	}
-	err := pc.connectGrpc()
-	if err != nil {
-		pc.logV("DashborgCloudClient ERROR connecting gRPC client: %v\n", err)
+	if pc.Config.Transport == TransportHttps {
+		err = pc.connectHttps()
+		if err != nil {
+			pc.logV("DashborgCloudClient ERROR setting up https transport: %v\n", err)
+		}
+	} else {
+		err = pc.connectGrpc()
+		if err != nil {
+			pc.logV("DashborgCloudClient ERROR connecting gRPC client: %v\n", err)
+		}
 	}
 	if pc.Config.Verbose {
 		pc.log("Dashborg Initialized CloudClient AccId:%s Zone:%s ProcName:%s ProcRunId:%s\n", pc.Config.AccId, pc.Config.ZoneName, pc.Config.ProcName, pc.ProcRunId)
@@ -156,6 +250,18 @@ func (pc *DashCloudClient) startClient() error {
 		return err
 	}
 	go pc.runRequestStreamLoop()
+	if pc.Config.WatchCertFiles {
+		err = pc.watchCertFiles()
+		if err != nil {
+			pc.log("DashborgCloudClient ERROR watching certificate files: %v\n", err)
+		}
+	}
+	if pc.Config.IntrospectAddr != "" {
+		err = pc.serveIntrospect()
+		if err != nil {
+			pc.log("DashborgCloudClient ERROR serving introspection on %s: %v\n", pc.Config.IntrospectAddr, err)
+		}
+	}
 	return nil
 }
 
@@ -170,16 +276,77 @@ func (pc *DashCloudClient) ctxWithMd(timeout time.Duration) (context.Context, co
 	}
 	connId := pc.ConnId.Load().(string)
 	ctx = metadata.AppendToOutgoingContext(ctx, mdConnIdKey, connId, mdClientVersionKey, ClientVersion)
+	if extraKv := pc.extraMdKv.Load().([]string); len(extraKv) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, extraKv...)
+	}
 	return ctx, cancelFn
 }
 
+type serviceConnType struct {
+	conn    *grpc.ClientConn // nil for the https transport
+	service dashproto.DashborgServiceClient
+}
+
+func (pc *DashCloudClient) dbService() dashproto.DashborgServiceClient {
+	return pc.serviceConn.Load().(*serviceConnType).service
+}
+
+func (pc *DashCloudClient) grpcConn() *grpc.ClientConn {
+	return pc.serviceConn.Load().(*serviceConnType).conn
+}
+
+// publishes a new connection (and service client), returns the previous connection.
+// must not hold pc.Lock.
+func (pc *DashCloudClient) setServiceConn(conn *grpc.ClientConn, service dashproto.DashborgServiceClient) *grpc.ClientConn {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	oldConn := pc.grpcConn()
+	pc.serviceConn.Store(&serviceConnType{conn: conn, service: service})
+	pc.Conn = conn
+	pc.DBService = service
+	return oldConn
+}
+
+func (pc *DashCloudClient) hasConn() bool {
+	return pc.grpcConn() != nil || pc.httpsConn != nil
+}
+
+// the https transport is Ready until it is closed
+func (pc *DashCloudClient) connState() connectivity.State {
+	if pc.httpsConn != nil {
+		if pc.httpsConn.isClosed() {
+			return connectivity.Shutdown
+		}
+		return connectivity.Ready
+	}
+	return pc.grpcConn().GetState()
+}
+
+func (pc *DashCloudClient) closeConn() error {
+	if pc.httpsConn != nil {
+		return pc.httpsConn.Close()
+	}
+	return pc.grpcConn().Close()
+}
+
+// closes a replaced connection after replacedConnGracePeriod (calls already started on it,
+// e.g. SendResponse from a handler goroutine, are not cut off)
+func (pc *DashCloudClient) closeReplacedConn(conn *grpc.ClientConn) {
+	if conn == nil {
+		return
+	}
+	time.AfterFunc(replacedConnGracePeriod, func() {
+		conn.Close()
+	})
+}
+
 func (pc *DashCloudClient) externalShutdown() {
-	if pc.Conn == nil {
+	if !pc.hasConn() {
 		pc.logV("DashborgCloudClient ERROR shutting down, gRPC connection is not initialized\n")
 		return
 	}
 	pc.setExitError(fmt.Errorf("ShutdownCh channel closed"))
-	err := pc.Conn.Close()
+	err := pc.closeConn()
 	if err != nil {
 		pc.logV("DashborgCloudClient ERROR closing gRPC connection: %v\n", err)
 	}
@@ -200,6 +367,7 @@ func makeHostData() map[string]string {
 func (pc *DashCloudClient) sendConnectClientMessage(isReconnect bool) error {
 	// only allow one proc message at a time (synchronize)
 	hostData := makeHostData()
+	hostData["InstanceId"] = pc.InstanceId()
 	m := &dashproto.ConnectClientMessage{
 		Ts:        dashutil.Ts(),
 		ProcRunId: pc.ProcRunId,
@@ -214,7 +382,7 @@ func (pc *DashCloudClient) sendConnectClientMessage(isReconnect bool) error {
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.ConnectClient(ctx, m)
+	resp, respErr := pc.dbService().ConnectClient(ctx, m)
 	dashErr := pc.handleStatusErrors("ConnectClient", resp, respErr, true)
 	var accInfo accInfoType
 	if dashErr == nil {
@@ -228,6 +396,7 @@ func (pc *DashCloudClient) sendConnectClientMessage(isReconnect bool) error {
 	}
 	if dashErr != nil {
 		pc.ConnId.Store("")
+		pc.setConnState(false, dashErr)
 		if !dasherr.CanRetry(dashErr) {
 			pc.Lock.Lock()
 			pc.PermErr = true
@@ -239,6 +408,7 @@ func (pc *DashCloudClient) sendConnectClientMessage(isReconnect bool) error {
 	pc.Lock.Lock()
 	pc.AccInfo = accInfo
 	pc.Lock.Unlock()
+	pc.setConnState(true, nil)
 	if !isReconnect {
 		if accInfo.NewAccount {
 			pc.printNewAccMessage()
@@ -283,7 +453,8 @@ func (pc *DashCloudClient) connectLinkRpc(path string) error {
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.ConnectLink(ctx, m)
+	ctx = pc.addLinkMd(ctx, path)
+	resp, respErr := pc.dbService().ConnectLink(ctx, m)
 	dashErr := pc.handleStatusErrors(fmt.Sprintf("ConnectLink(%s)", path), resp, respErr, false)
 	if dashErr != nil {
 		return dashErr
@@ -299,6 +470,9 @@ func (pc *DashCloudClient) reconnectLinks() {
 			pc.log("DashborgCloudClient %v\n", err)
 		} else {
 			pc.logV("DashborgCloudClient ReConnected link:%s\n", dashutil.SimplifyPath(linkPath, nil))
+			if app := pc.getConnectedApp(linkPath); app != nil {
+				app.setConnected()
+			}
 		}
 	}
 }
@@ -323,6 +497,7 @@ func (pc *DashCloudClient) handleStatusErrors(fnName string, resp interface{}, r
 		rtnStatus := respV.FieldByName("Status").Interface().(*dashproto.RtnStatus)
 		rtnErr = dasherr.FromRtnStatus(fnName, rtnStatus)
 	}
+	pc.metrics.add(MetricGrpcCalls, 1, grpcMethodName(fnName), metricsErrCode(rtnErr))
 	if rtnErr == nil {
 		return nil
 	}
@@ -334,7 +509,17 @@ func (pc *DashCloudClient) handleStatusErrors(fnName string, resp interface{}, r
 }
 
 func (pc *DashCloudClient) connectGrpc() error {
-	addr := pc.Config.GrpcHost + ":" + strconv.Itoa(pc.Config.GrpcPort)
+	conn, err := pc.dialGrpc()
+	if err != nil {
+		return err
+	}
+	pc.setServiceConn(conn, dashproto.NewDashborgServiceClient(conn))
+	return nil
+}
+
+// dials the active gRPC endpoint with the current keypair (re-read from KeyFileName/CertFileName)
+func (pc *DashCloudClient) dialGrpc() (*grpc.ClientConn, error) {
+	addr := pc.grpcAddr()
 	backoffConfig := backoff.Config{
 		BaseDelay:  1.0 * time.Second,
 		Multiplier: 1.6,
@@ -342,31 +527,30 @@ func (pc *DashCloudClient) connectGrpc() error {
 		MaxDelay:   60 * time.Second,
 	}
 	connectParams := grpc.ConnectParams{MinConnectTimeout: time.Second, Backoff: backoffConfig}
-	keepaliveParams := keepalive.ClientParameters{Time: 10 * time.Second, Timeout: 5 * time.Second, PermitWithoutStream: true}
+	keepaliveParams := pc.Config.keepaliveParams()
 	clientCert, err := tls.LoadX509KeyPair(pc.Config.CertFileName, pc.Config.KeyFileName)
 	if err != nil {
-		return fmt.Errorf("Cannot load keypair key:%s cert:%s err:%w", pc.Config.KeyFileName, pc.Config.CertFileName, err)
-	}
-	tlsConfig := &tls.Config{
-		MinVersion:               tls.VersionTLS13,
-		CurvePreferences:         []tls.CurveID{tls.CurveP384},
-		PreferServerCipherSuites: true,
-		Certificates:             []tls.Certificate{clientCert},
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
+		return nil, fmt.Errorf("Cannot load keypair key:%s cert:%s err:%w", pc.Config.KeyFileName, pc.Config.CertFileName, err)
+	}
+	tlsConfig, err := pc.Config.grpcTlsConfig(clientCert)
+	if err != nil {
+		return nil, err
 	}
 	tlsCreds := credentials.NewTLS(tlsConfig)
-	conn, err := grpc.Dial(
-		addr,
+	dialOpts := []grpc.DialOption{
 		grpc.WithConnectParams(connectParams),
 		grpc.WithKeepaliveParams(keepaliveParams),
 		grpc.WithTransportCredentials(tlsCreds),
-	)
-	pc.Conn = conn
-	pc.DBService = dashproto.NewDashborgServiceClient(conn)
-	return err
+	}
+	dialer, err := pc.Config.grpcDialer()
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(dialer))
+	}
+	dialOpts = append(dialOpts, pc.traceDialOpts()...)
+	return grpc.Dial(addr, dialOpts...)
 }
 
 func (pc *DashCloudClient) unlinkRuntime(path string) {
@@ -396,7 +580,7 @@ func (pc *DashCloudClient) removeAppPath(appName string) error {
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.RemovePath(ctx, m)
+	resp, respErr := pc.dbService().RemovePath(ctx, m)
 	dashErr := pc.handleStatusErrors(fmt.Sprintf("RemoveApp(%s)", appName), resp, respErr, true)
 	if dashErr != nil {
 		return dashErr
@@ -419,7 +603,7 @@ func (pc *DashCloudClient) removePath(path string) error {
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.RemovePath(ctx, m)
+	resp, respErr := pc.dbService().RemovePath(ctx, m)
 	dashErr := pc.handleStatusErrors(fmt.Sprintf("RemovePath(%s)", path), resp, respErr, true)
 	if dashErr != nil {
 		return dashErr
@@ -450,7 +634,7 @@ func (pc *DashCloudClient) fileInfo(path string, dirOpts *DirOpts, rtnContents b
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.FileInfo(ctx, m)
+	resp, respErr := pc.dbService().FileInfo(ctx, m)
 	dashErr := pc.handleStatusErrors(fmt.Sprintf("FileInfo(%s)", path), resp, respErr, false)
 	if dashErr != nil {
 		return nil, nil, dashErr
@@ -463,6 +647,9 @@ func (pc *DashCloudClient) fileInfo(path string, dirOpts *DirOpts, rtnContents b
 	if err != nil {
 		return nil, nil, dasherr.JsonUnmarshalErr("FileInfoJson", err)
 	}
+	if len(resp.FileContent) > 0 {
+		pc.meterDownload(context.Background(), BandwidthCategoryFile, len(resp.FileContent))
+	}
 	return rtn, resp.FileContent, nil
 }
 
@@ -471,12 +658,25 @@ func (pc *DashCloudClient) runRequestStreamLoop() {
 
 	w := &expoWait{CloudClient: pc}
 	for {
-		state := pc.Conn.GetState()
+		state := pc.connState()
+		if pc.isShuttingDown() {
+			pc.logV("DashborgCloudClient RunRequestStreamLoop exiting - Shutdown\n")
+			<-pc.shutdownDoneCh
+			break
+		}
 		if state == connectivity.Shutdown {
 			pc.log("DashborgCloudClient RunRequestStreamLoop exiting - Conn Shutdown\n")
 			pc.setExitError(fmt.Errorf("gRPC Connection Shutdown"))
 			break
 		}
+		if pc.maybeSwapConn() {
+			w.Reset()
+			continue
+		}
+		if pc.maybeSwitchEndpoint() {
+			w.Reset()
+			continue
+		}
 		if state == connectivity.Connecting || state == connectivity.TransientFailure {
 			time.Sleep(1 * time.Second)
 			w.Reset()
@@ -488,6 +688,7 @@ func (pc *DashCloudClient) runRequestStreamLoop() {
 		}
 		if pc.ConnId.Load().(string) == "" {
 			err := pc.sendConnectClientMessage(true)
+			pc.metrics.add(MetricReconnects, 1, metricsResult(err))
 			if err != nil && !dasherr.CanRetry(err) {
 				pc.log("DashborgCloudClient RunRequestStreamLoop exiting - Permanent Error: %v\n", err)
 				pc.setExitError(err)
@@ -498,6 +699,7 @@ func (pc *DashCloudClient) runRequestStreamLoop() {
 			}
 		}
 		ranOk, errCode := pc.runRequestStream()
+		pc.setConnState(false, connStateErr(errCode))
 		if ranOk {
 			w.Reset()
 		}
@@ -521,7 +723,7 @@ func (pc *DashCloudClient) sendErrResponse(reqMsg *dashproto.RequestMessage, err
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.SendResponse(ctx, m)
+	resp, respErr := pc.dbService().SendResponse(ctx, m)
 	dashErr := pc.handleStatusErrors("SendResponse", resp, respErr, false)
 	if dashErr != nil {
 		pc.logV("Error sending Error Response: %v\n", dashErr)
@@ -533,11 +735,19 @@ func (pc *DashCloudClient) runRequestStream() (bool, dasherr.ErrCode) {
 	pc.logV("Dashborg gRPC RequestStream starting\n")
 	ctx, cancelFn := pc.ctxWithMd(streamGrpcTimeout)
 	defer cancelFn()
-	reqStreamClient, err := pc.DBService.RequestStream(ctx, m)
+	pc.Lock.Lock()
+	if pc.shuttingDown {
+		pc.Lock.Unlock()
+		return false, dasherr.ErrCodeOffline
+	}
+	pc.streamCancelFn = cancelFn
+	pc.Lock.Unlock()
+	reqStreamClient, err := pc.dbService().RequestStream(ctx, m)
 	if err != nil {
 		pc.log("Dashborg Error setting up gRPC RequestStream: %v\n", err)
 		return false, dasherr.ErrCodeRpc
 	}
+	pc.setConnState(true, nil)
 	startTime := time.Now()
 	var reqCounter int64
 	var endingErrCode dasherr.ErrCode
@@ -561,9 +771,25 @@ func (pc *DashCloudClient) runRequestStream() (bool, dasherr.ErrCode) {
 				break
 			}
 		}
-		pc.logV("Dashborg gRPC request %s\n", requestMsgStr(reqMsg))
+		pc.logPathV(reqMsg.Path, "Dashborg gRPC request %s\n", requestMsgStr(reqMsg))
+		if !pc.startInflight() {
+			pc.sendErrResponse(reqMsg, "Client shutting down")
+			continue
+		}
 		go func() {
+			defer pc.inflightWg.Done()
+			defer func() {
+				// handler panics are recovered in dispatchRtRequest, this catches panics while sending the response
+				if panicErr := recover(); panicErr != nil {
+					log.Printf("Dashborg PANIC dispatching request %s | %v\n%s", requestMsgStr(reqMsg), panicErr, debug.Stack())
+					pc.sendErrResponse(reqMsg, fmt.Sprintf("PANIC dispatching request %v", panicErr))
+				}
+			}()
 			atomic.AddInt64(&reqCounter, 1)
+			pc.Lock.Lock()
+			pc.numRequests++
+			pc.Lock.Unlock()
+			pc.metrics.add(MetricRequests, 1, reqMsg.RequestType)
 			timeoutMs := reqMsg.TimeoutMs
 			if timeoutMs == 0 || timeoutMs > 60000 {
 				timeoutMs = 60000
@@ -586,7 +812,7 @@ func (pc *DashCloudClient) runRequestStream() (bool, dasherr.ErrCode) {
 					pc.sendErrResponse(reqMsg, "No Linked Runtime")
 					return
 				}
-				pc.dispatchRtRequest(ctx, runtimeVal, reqMsg)
+				pc.dispatchRtRequest(ctx, fullPath, runtimeVal, reqMsg)
 				return
 			} else {
 				pc.sendErrResponse(reqMsg, fmt.Sprintf("Invalid RequestType '%s'", reqMsg.RequestType))
@@ -602,6 +828,8 @@ func (pc *DashCloudClient) sendPathResponse(preq *AppRequest, rtnVal interface{}
 	if preq.IsDone() {
 		return
 	}
+	preq.stopHeldFlush()
+	pc.recordRequestMetrics(preq)
 	m := &dashproto.SendResponseMessage{
 		Ts:           dashutil.Ts(),
 		ReqId:        preq.RequestInfo().ReqId,
@@ -610,16 +838,19 @@ func (pc *DashCloudClient) sendPathResponse(preq *AppRequest, rtnVal interface{}
 		FeClientId:   preq.RequestInfo().FeClientId,
 		ResponseDone: true,
 	}
-	defer pc.sendResponseProtoRpc(m)
+	defer pc.sendResponseProtoRpc(m, preq)
 	rtnErr := preq.GetError()
 	if rtnErr != nil {
 		m.Err = dasherr.AsProtoErr(rtnErr)
+		if snapshotId := pc.captureErrorSnapshot(preq, rtnErr); snapshotId != "" {
+			m.Err.Err = fmt.Sprintf("%s (ref:%s)", m.Err.Err, snapshotId)
+		}
 		return
 	}
 	var rtnValRRA []*dashproto.RRAction
 	if rtnVal != nil {
 		var err error
-		rtnValRRA, err = rtnValToRRA(rtnVal)
+		rtnValRRA, err = rtnValToRRA(rtnVal, preq.jsonOpts())
 		if err != nil {
 			m.Err = dasherr.AsProtoErr(err)
 			return
@@ -632,17 +863,29 @@ func (pc *DashCloudClient) sendPathResponse(preq *AppRequest, rtnVal interface{}
 	return
 }
 
-func (pc *DashCloudClient) dispatchRtRequest(ctx context.Context, linkrt LinkRuntime, reqMsg *dashproto.RequestMessage) {
+func (pc *DashCloudClient) dispatchRtRequest(ctx context.Context, linkPath string, linkrt LinkRuntime, reqMsg *dashproto.RequestMessage) {
 	var rtnVal interface{}
 	preq := makeAppRequest(ctx, reqMsg, pc)
+	startTime := time.Now()
+	pc.startLinkRequest(linkPath)
+	span := pc.startRequestSpan(preq)
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
-			log.Printf("Dashborg PANIC in Handler %s | %v\n", requestMsgStr(reqMsg), panicErr)
-			preq.SetError(fmt.Errorf("PANIC in handler %v", panicErr))
-			debug.PrintStack()
+			handleRequestPanic(preq, panicErr)
+		}
+		if span != nil {
+			endSpan(span, preq.GetError())
 		}
+		pc.endLinkRequest(linkPath, startTime, preq.GetError() != nil)
+		pc.metrics.observeDuration(MetricHandlerDuration, startTime, dashutil.SimplifyPath(linkPath, nil), metricsResult(preq.GetError()))
 		pc.sendPathResponse(preq, rtnVal, reqMsg.AppRequest)
 	}()
+	if linkOpts := pc.getLinkOpts(linkPath); linkOpts.isShared() {
+		preq.info.AffinityKey = linkOpts.affinityKey(preq)
+		if reqMsg.AppRequest {
+			preq.SetData(instanceIdStatePath, pc.InstanceId())
+		}
+	}
 	dataResult, err := linkrt.RunHandler(preq)
 	if err != nil {
 		preq.SetError(err)
@@ -658,11 +901,102 @@ func (pc *DashCloudClient) WaitForShutdown() error {
 	return pc.GetExitError()
 }
 
+const waitPollInterval = 200 * time.Millisecond
+const waitAppPollInterval = time.Second
+
+// Blocks until the client is connected to the Dashborg service.  Returns ctx.Err() if the
+// context expires first, or the client's exit error if the client shuts down.
+func (pc *DashCloudClient) WaitForConnection(ctx context.Context) error {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		if pc.IsConnected() {
+			return nil
+		}
+		exitErr := pc.GetExitError()
+		if exitErr != nil {
+			return exitErr
+		}
+		select {
+		case <-ticker.C:
+			continue
+
+		case <-pc.DoneCh:
+			return pc.GetExitError()
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Blocks until the given app exists and has a connected runtime (in this process or in any other
+// process).  Used to gate startup on apps that are provided by other processes.  Returns ctx.Err()
+// if the context expires first.
+func (pc *DashCloudClient) WaitForApp(ctx context.Context, appName string) error {
+	if !dashutil.IsAppNameValid(appName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid App Name"))
+	}
+	ticker := time.NewTicker(waitAppPollInterval)
+	defer ticker.Stop()
+	for {
+		err := pc.WaitForConnection(ctx)
+		if err != nil {
+			return err
+		}
+		ready, err := pc.isAppReady(appName)
+		if err != nil && !dasherr.CanRetry(err) {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+			continue
+
+		case <-pc.DoneCh:
+			return pc.GetExitError()
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (pc *DashCloudClient) isAppReady(appName string) (bool, error) {
+	finfos, _, err := pc.fileInfo(AppPathFromName(appName), nil, false)
+	if err != nil {
+		return false, err
+	}
+	if len(finfos) == 0 || finfos[0].FileType != FileTypeApp || finfos[0].AppConfigJson == "" {
+		return false, nil
+	}
+	var config AppConfig
+	err = json.Unmarshal([]byte(finfos[0].AppConfigJson), &config)
+	if err != nil {
+		return false, dasherr.JsonUnmarshalErr("AppConfig", err)
+	}
+	runtimePath := config.RuntimePath
+	if runtimePath == "" {
+		runtimePath = AppPathFromName(appName) + AppRuntimeSubPath
+	}
+	if app := pc.getConnectedApp(runtimePath); app != nil {
+		return app.Status().Connected, nil
+	}
+	finfos, _, err = pc.fileInfo(runtimePath, nil, false)
+	if err != nil {
+		return false, err
+	}
+	return len(finfos) > 0 && len(finfos[0].ProcLinks) > 0, nil
+}
+
 func (pc *DashCloudClient) setExitError(err error) {
 	pc.Lock.Lock()
 	defer pc.Lock.Unlock()
 	if pc.ExitErr == nil {
 		pc.ExitErr = err
+		pc.queueConnEventNoLock(ConnEventPermanentError, err)
 	}
 }
 
@@ -683,7 +1017,7 @@ func (pc *DashCloudClient) IsConnected() bool {
 	if pc.ExitErr != nil {
 		return false
 	}
-	if pc.Conn == nil {
+	if !pc.hasConn() {
 		return false
 	}
 	connId := pc.ConnId.Load().(string)
@@ -711,25 +1045,38 @@ func (pc *DashCloudClient) getAccHost() string {
 }
 
 // SendResponseProtoRpc is for internal use by the Dashborg AppClient, not to be called by the end user.
-func (pc *DashCloudClient) sendResponseProtoRpc(m *dashproto.SendResponseMessage) (int, error) {
+// req is the request being responded to (used to negotiate compression), may be nil.
+func (pc *DashCloudClient) sendResponseProtoRpc(m *dashproto.SendResponseMessage, req *AppRequest) (int, error) {
 	if !pc.IsConnected() {
 		return 0, NotConnectedErr
 	}
+	pc.recordDataTree(m)
+	encoding := pc.compressResponse(m, req)
+	category := BandwidthCategoryResponse
+	if req != nil && req.isStream() {
+		category = BandwidthCategoryStream
+	}
+	// wait for the rate limit before starting the RPC timeout
+	pc.meterUpload(context.Background(), category, responseSize(m))
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.SendResponse(ctx, m)
+	if encoding != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, mdEncodingKey, encoding)
+	}
+	resp, respErr := pc.dbService().SendResponse(ctx, m)
 	dashErr := pc.handleStatusErrors("SendResponse", resp, respErr, false)
 	if dashErr != nil {
 		pc.logV("Error sending response: %v\n", dashErr)
 		return 0, dashErr
 	}
+	if req != nil && req.isStream() {
+		pc.metrics.set(MetricStreamClients, float64(resp.NumStreamClients), dashutil.SimplifyPath(req.info.Path, nil))
+	}
 	return int(resp.NumStreamClients), nil
 }
 
 func (pc *DashCloudClient) logV(fmtStr string, args ...interface{}) {
-	if pc.Config.Verbose {
-		pc.log(fmtStr, args...)
-	}
+	pc.logPathV("", fmtStr, args...)
 }
 
 func (pc *DashCloudClient) log(fmtStr string, args ...interface{}) {
@@ -767,6 +1114,17 @@ func (opts *FileOpts) Validate() error {
 			return dasherr.ValidateErr(fmt.Errorf("Invalid Size (cannot be 0)"))
 		}
 	}
+	if opts.CacheControl != "" {
+		if opts.FileType != FileTypeStatic {
+			return dasherr.ValidateErr(fmt.Errorf("CacheControl can only be set for static files"))
+		}
+		if !dashutil.IsCacheControlValid(opts.CacheControl) {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid CacheControl '%s'", opts.CacheControl))
+		}
+	}
+	if opts.ETag != "" && !dashutil.IsETagValid(opts.ETag) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid ETag (must be a quoted string)"))
+	}
 	if opts.FileType == FileTypeApp && opts.AppConfigJson == "" {
 		return dasherr.ValidateErr(fmt.Errorf("FileType 'app' must have AppConfigJson set"))
 	}
@@ -799,10 +1157,10 @@ func (opts *FileOpts) Validate() error {
 func (pc *DashCloudClient) setRawPath(fullPath string, r io.Reader, fileOpts *FileOpts, linkRt LinkRuntime) error {
 	err := pc.setRawPathWrap(fullPath, r, fileOpts, linkRt)
 	if err != nil {
-		pc.logV("Dashborg SetPath ERROR %s => %s | %v\n", dashutil.SimplifyPath(fullPath, nil), shortFileOptsStr(fileOpts), err)
+		pc.logPathV(fullPath, "Dashborg SetPath ERROR %s => %s | %v\n", dashutil.SimplifyPath(fullPath, nil), shortFileOptsStr(fileOpts), err)
 		return err
 	}
-	pc.logV("Dashborg SetPath %s => %s\n", dashutil.SimplifyPath(fullPath, nil), shortFileOptsStr(fileOpts))
+	pc.logPathV(fullPath, "Dashborg SetPath %s => %s\n", dashutil.SimplifyPath(fullPath, nil), shortFileOptsStr(fileOpts))
 	return nil
 }
 
@@ -823,6 +1181,9 @@ func (pc *DashCloudClient) setRawPathWrap(fullPath string, r io.Reader, fileOpts
 	if len(fileOpts.AllowedRoles) == 0 {
 		fileOpts.AllowedRoles = []string{RoleUser}
 	}
+	if fileOpts.FileType == FileTypeStatic && fileOpts.ETag == "" {
+		fileOpts.ETag, _ = ETagFromSha256(fileOpts.Sha256)
+	}
 	err = fileOpts.Validate()
 	if err != nil {
 		return err
@@ -833,6 +1194,16 @@ func (pc *DashCloudClient) setRawPathWrap(fullPath string, r io.Reader, fileOpts
 	if !fileOpts.IsLinkType() && linkRt != nil {
 		return dasherr.ValidateErr(fmt.Errorf("FileType is %s, no dash.LinkRuntime allowed", fileOpts.FileType))
 	}
+	r, err = pc.enforceFilePolicy(fullPath, r, fileOpts, "")
+	if err != nil {
+		return err
+	}
+	if fileOpts.IsLinkType() && linkRt != nil {
+		err = pc.acquireLinkLease(fullPath)
+		if err != nil {
+			return err
+		}
+	}
 	optsJson, err := dashutil.MarshalJson(fileOpts)
 	if err != nil {
 		return dasherr.JsonMarshalErr("FileOpts", err)
@@ -846,7 +1217,10 @@ func (pc *DashCloudClient) setRawPathWrap(fullPath string, r io.Reader, fileOpts
 	}
 	ctx, cancelFn := pc.ctxWithMd(stdGrpcTimeout)
 	defer cancelFn()
-	resp, respErr := pc.DBService.SetPath(ctx, m)
+	if linkRt != nil {
+		ctx = pc.addLinkMd(ctx, fullPath)
+	}
+	resp, respErr := pc.dbService().SetPath(ctx, m)
 	dashErr := pc.handleStatusErrors("SetPath", resp, respErr, false)
 	if dashErr != nil {
 		return dashErr
@@ -894,6 +1268,35 @@ func (pc *DashCloudClient) AppClient() *DashAppClient {
 	return &DashAppClient{pc}
 }
 
+// Returns the URL for an app (without a JWT token).  Same link that is logged when the app
+// is written, for deployment tools that need to print or store it.
+func (pc *DashCloudClient) AppLink(appName string) (string, error) {
+	if !dashutil.IsAppNameValid(appName) {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	return pc.AppClient().MakeAppUrl(appName, &JWTOpts{NoJWT: true})
+}
+
+// Returns the URL for an app with a JWT token.  If jwtOpts is nil, the config's JWTOpts are
+// used.  Returns an error if the JWT cannot be created (or jwtOpts.NoJWT is set).
+// Usage: link, err := client.AppJWTLink("myapp", &dash.JWTOpts{ValidFor: time.Hour, Role: "user"})
+func (pc *DashCloudClient) AppJWTLink(appName string, jwtOpts *JWTOpts) (string, error) {
+	if !dashutil.IsAppNameValid(appName) {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	if jwtOpts == nil {
+		jwtOpts = pc.Config.GetJWTOpts()
+	}
+	if jwtOpts.NoJWT {
+		return "", dasherr.ValidateErr(fmt.Errorf("Cannot create JWT link, NoJWT is set"))
+	}
+	err := jwtOpts.Validate()
+	if err != nil {
+		return "", err
+	}
+	return pc.AppClient().MakeAppUrl(appName, jwtOpts)
+}
+
 func requestMsgStr(reqMsg *dashproto.RequestMessage) string {
 	if reqMsg.Path == "" {
 		return fmt.Sprintf("[no-path]")
@@ -901,14 +1304,14 @@ func requestMsgStr(reqMsg *dashproto.RequestMessage) string {
 	return fmt.Sprintf("%4s %s", reqMsg.RequestMethod, dashutil.SimplifyPath(reqMsg.Path, nil))
 }
 
-func rtnValToRRA(rtnVal interface{}) ([]*dashproto.RRAction, error) {
+func rtnValToRRA(rtnVal interface{}, jsonOpts dashutil.JsonOpts) ([]*dashproto.RRAction, error) {
 	if blobRtn, ok := rtnVal.(BlobReturn); ok {
 		return blobToRRA(blobRtn.MimeType, blobRtn.Reader)
 	}
 	if blobRtn, ok := rtnVal.(*BlobReturn); ok {
 		return blobToRRA(blobRtn.MimeType, blobRtn.Reader)
 	}
-	jsonData, err := dashutil.MarshalJson(rtnVal)
+	jsonData, err := dashutil.MarshalJsonOpts(rtnVal, jsonOpts)
 	if err != nil {
 		return nil, dasherr.JsonMarshalErr("HandlerReturnValue", err)
 	}
@@ -923,12 +1326,28 @@ func rtnValToRRA(rtnVal interface{}) ([]*dashproto.RRAction, error) {
 
 // convert to streaming
 func blobToRRA(mimeType string, reader io.Reader) ([]*dashproto.RRAction, error) {
+	var rra []*dashproto.RRAction
+	totalSize, err := readBlobChunks(mimeType, reader, func(rrAction *dashproto.RRAction) error {
+		rra = append(rra, rrAction)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if totalSize > maxRRABlobSize {
+		return nil, dasherr.ValidateErr(fmt.Errorf("BLOB too large, max-size:%d, blob-size:%d", maxRRABlobSize, totalSize))
+	}
+	return rra, nil
+}
+
+// reads the blob in blobReadSize chunks, calling chunkFn with a "blob" action for the first
+// chunk and "blobext" actions for the rest.  only one chunk is held in memory at a time.
+func readBlobChunks(mimeType string, reader io.Reader, chunkFn func(rrAction *dashproto.RRAction) error) (int, error) {
 	if !dashutil.IsMimeTypeValid(mimeType) {
-		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Mime-Type passed to SetBlobData mime-type=%s", mimeType))
+		return 0, dasherr.ValidateErr(fmt.Errorf("Invalid Mime-Type passed to SetBlobData mime-type=%s", mimeType))
 	}
 	first := true
 	totalSize := 0
-	var rra []*dashproto.RRAction
 	for {
 		buffer := make([]byte, blobReadSize)
 		n, err := io.ReadFull(reader, buffer)
@@ -950,19 +1369,19 @@ func blobToRRA(mimeType string, reader io.Reader) ([]*dashproto.RRAction, error)
 			} else {
 				rrAction.ActionType = "blobext"
 			}
-			rra = append(rra, rrAction)
+			chunkErr := chunkFn(rrAction)
+			if chunkErr != nil {
+				return totalSize, chunkErr
+			}
 		}
 		if err == io.ErrUnexpectedEOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return totalSize, err
 		}
 	}
-	if totalSize > maxRRABlobSize {
-		return nil, dasherr.ValidateErr(fmt.Errorf("BLOB too large, max-size:%d, blob-size:%d", maxRRABlobSize, totalSize))
-	}
-	return rra, nil
+	return totalSize, nil
 }
 
 func shortFileOptsStr(fileOpts *FileOpts) string {
@@ -994,7 +1413,8 @@ func (pc *DashCloudClient) UploadFile(ctx context.Context, r io.Reader, accId st
 	if !dashutil.IsUUIDValid(uploadId) || uploadKey == "" {
 		return dasherr.ValidateErr(fmt.Errorf("Invalid UploadId/UploadKey"))
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pc.Config.getRawUploadUrl(), r)
+	countingR := &countingReader{r: pc.limitUploadReader(ctx, r)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pc.Config.getRawUploadUrl(), countingR)
 	if err != nil {
 		return err
 	}
@@ -1027,5 +1447,7 @@ func (pc *DashCloudClient) UploadFile(ctx context.Context, r io.Reader, accId st
 		}
 		return errors.New(errMsg)
 	}
+	pc.metrics.add(MetricBlobUploadBytes, float64(countingR.count))
+	pc.addBandwidthBytes(BandwidthUpload, BandwidthCategoryBlob, int(countingR.count))
 	return nil
 }
@@ -0,0 +1,208 @@
+// Publishes expvar and Go runtime metrics of the host process to a Dashborg app.
+package debugpanel
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultAppName  = "debug-vars"
+	DefaultDataPath = "/debugvars.json"
+	DefaultInterval = 10 * time.Second
+	MinInterval     = time.Second
+)
+
+type Options struct {
+	AppName      string        // defaults to DefaultAppName
+	DataPath     string        // app relative path to publish the snapshot to (defaults to DefaultDataPath)
+	Interval     time.Duration // defaults to DefaultInterval
+	AllowedRoles []string      // defaults to the app default (["user"])
+
+	// By default the (very large) "memstats" and "cmdline" expvars are not published since the
+	// runtime section already includes the relevant memory stats.
+	IncludeMemStats bool
+
+	ShutdownCh chan struct{} // close to stop publishing
+}
+
+// Runtime metrics for the host process.
+type RuntimeStats struct {
+	GoVersion    string  `json:"goversion"`
+	GOOS         string  `json:"goos"`
+	GOARCH       string  `json:"goarch"`
+	NumCPU       int     `json:"numcpu"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	NumGoroutine int     `json:"numgoroutine"`
+	Pid          int     `json:"pid"`
+	UptimeSec    float64 `json:"uptimesec"`
+	HeapAlloc    uint64  `json:"heapalloc"`
+	HeapSys      uint64  `json:"heapsys"`
+	HeapObjects  uint64  `json:"heapobjects"`
+	TotalAlloc   uint64  `json:"totalalloc"`
+	Sys          uint64  `json:"sys"`
+	NumGC        uint32  `json:"numgc"`
+	PauseTotalMs float64 `json:"pausetotalms"`
+	LastGCTs     int64   `json:"lastgcts"`
+}
+
+type Snapshot struct {
+	Ts      int64                  `json:"ts"`
+	Runtime RuntimeStats           `json:"runtime"`
+	Vars    map[string]interface{} `json:"vars"`
+}
+
+var startTime = time.Now()
+
+// Returns the current runtime stats and expvars.
+func MakeSnapshot(includeMemStats bool) *Snapshot {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	rtn := &Snapshot{
+		Ts: dashutil.Ts(),
+		Runtime: RuntimeStats{
+			GoVersion:    runtime.Version(),
+			GOOS:         runtime.GOOS,
+			GOARCH:       runtime.GOARCH,
+			NumCPU:       runtime.NumCPU(),
+			GOMAXPROCS:   runtime.GOMAXPROCS(0),
+			NumGoroutine: runtime.NumGoroutine(),
+			Pid:          os.Getpid(),
+			UptimeSec:    time.Since(startTime).Seconds(),
+			HeapAlloc:    memStats.HeapAlloc,
+			HeapSys:      memStats.HeapSys,
+			HeapObjects:  memStats.HeapObjects,
+			TotalAlloc:   memStats.TotalAlloc,
+			Sys:          memStats.Sys,
+			NumGC:        memStats.NumGC,
+			PauseTotalMs: float64(memStats.PauseTotalNs) / float64(time.Millisecond),
+			LastGCTs:     int64(memStats.LastGC / uint64(time.Millisecond)),
+		},
+		Vars: make(map[string]interface{}),
+	}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !includeMemStats && (kv.Key == "memstats" || kv.Key == "cmdline") {
+			return
+		}
+		var val interface{}
+		err := json.Unmarshal([]byte(kv.Value.String()), &val)
+		if err != nil {
+			val = kv.Value.String()
+		}
+		rtn.Vars[kv.Key] = val
+	})
+	return rtn
+}
+
+func (opts *Options) setDefaults() {
+	if opts.AppName == "" {
+		opts.AppName = DefaultAppName
+	}
+	if opts.DataPath == "" {
+		opts.DataPath = DefaultDataPath
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+}
+
+func (opts *Options) Validate() error {
+	if !dashutil.IsAppNameValid(opts.AppName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", opts.AppName))
+	}
+	if !dashutil.IsPathValid(opts.DataPath) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid DataPath '%s'", opts.DataPath))
+	}
+	if opts.Interval < MinInterval {
+		return dasherr.ValidateErr(fmt.Errorf("Interval must be at least %v", MinInterval))
+	}
+	return nil
+}
+
+// Creates and connects the debug app, and starts a goroutine to publish a Snapshot to
+// the app's DataPath every Interval.  Publishing stops when the client shuts down or
+// opts.ShutdownCh is closed.  opts may be nil.
+func Start(client *dash.DashCloudClient, opts *Options) (*dash.App, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	// defaults are set on a copy, the caller's opts are not modified
+	optsCopy := *opts
+	opts = &optsCopy
+	opts.setDefaults()
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	app := client.AppClient().NewApp(opts.AppName)
+	app.SetAppTitle("Debug Vars")
+	if len(opts.AllowedRoles) > 0 {
+		app.SetAllowedRoles(opts.AllowedRoles...)
+	}
+	app.SetHtml(makeHtml(opts.DataPath))
+	app.Runtime().PureHandler("snapshot", func() *Snapshot {
+		return MakeSnapshot(opts.IncludeMemStats)
+	})
+	err = client.AppClient().WriteAndConnectApp(app)
+	if err != nil {
+		return nil, err
+	}
+	fs := app.AppFSClient()
+	err = fs.SetJsonPath(opts.DataPath, MakeSnapshot(opts.IncludeMemStats), &dash.FileOpts{AllowedRoles: opts.AllowedRoles})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !client.IsConnected() {
+					continue
+				}
+				err := fs.SetJsonPath(opts.DataPath, MakeSnapshot(opts.IncludeMemStats), &dash.FileOpts{AllowedRoles: opts.AllowedRoles})
+				if err != nil && client.Config.Verbose {
+					client.Config.Logger.Printf("Dashborg debugpanel error publishing snapshot: %v\n", err)
+				}
+
+			case <-opts.ShutdownCh:
+				return
+
+			case <-client.DoneCh:
+				return
+			}
+		}
+	}()
+	return app, nil
+}
+
+func makeHtml(dataPath string) string {
+	return fmt.Sprintf(`<app ui="dashborg">
+  <d-data query="/@app%s" output.bindpath="$.snap"/>
+  <div class="row">
+    <h1>Debug Vars</h1>
+    <d-button onclickhandler="$.snap = /@app:snapshot">Refresh</d-button>
+  </div>
+  <div class="row">
+    <d-stat label="Goroutines" bind="$.snap.runtime.numgoroutine"/>
+    <d-stat label="Heap Alloc (MB)" bind="fn:round($.snap.runtime.heapalloc / 1000000, 1)"/>
+    <d-stat label="Sys (MB)" bind="fn:round($.snap.runtime.sys / 1000000, 1)"/>
+    <d-stat label="GC Runs" bind="$.snap.runtime.numgc"/>
+    <d-stat label="Uptime (s)" bind="fn:round($.snap.runtime.uptimesec)"/>
+  </div>
+  <h2>Runtime</h2>
+  <d-dataview bind="$.snap.runtime"/>
+  <h2>Vars</h2>
+  <d-dataview bind="$.snap.vars"/>
+</app>
+`, dataPath)
+}
@@ -0,0 +1,159 @@
+package dash
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const DefaultDeltaSnapshotInterval = 60 * time.Second
+
+var deltaKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,100}$`)
+
+// Options for MakeDeltaSeries.
+type DeltaSeriesOpts struct {
+	SnapshotInterval time.Duration // full snapshots are sent at least this often (defaults to DefaultDeltaSnapshotInterval)
+	Epsilon          float64       // changes smaller than this (from the last sent value) are not sent
+}
+
+// The frontend data at a DeltaSeries path.  Snapshots set the whole object, deltas set
+// "seq", "ts", and only the changed "values.<key>" entries, so the frontend data model
+// always holds the full set of current values.
+type DeltaSnapshot struct {
+	Seq    int64              `json:"seq"`
+	Ts     int64              `json:"ts"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Pushes a set of slowly changing values (e.g. sensor readings) to a frontend data path,
+// sending only the values that changed since they were last sent, with periodic full
+// snapshots (so frontends that join a stream mid-way catch up).  Safe for concurrent use.
+type DeltaSeries struct {
+	lock         *sync.Mutex
+	path         string
+	opts         DeltaSeriesOpts
+	seq          int64
+	current      map[string]float64 // all values pushed
+	sent         map[string]float64 // values as last sent to the frontend
+	lastSnapshot time.Time
+}
+
+// Creates a DeltaSeries for a frontend data path (e.g. "$.sensors").  opts may be nil.
+// Usage: ds := dash.MakeDeltaSeries("$.sensors", &dash.DeltaSeriesOpts{Epsilon: 0.05})
+func MakeDeltaSeries(path string, opts *DeltaSeriesOpts) *DeltaSeries {
+	rtn := &DeltaSeries{
+		lock:    &sync.Mutex{},
+		path:    path,
+		current: make(map[string]float64),
+		sent:    make(map[string]float64),
+	}
+	if opts != nil {
+		rtn.opts = *opts
+	}
+	if rtn.opts.SnapshotInterval <= 0 {
+		rtn.opts.SnapshotInterval = DefaultDeltaSnapshotInterval
+	}
+	return rtn
+}
+
+// must hold lock
+func (ds *DeltaSeries) snapshotNoLock() DeltaSnapshot {
+	ds.seq++
+	values := make(map[string]float64)
+	for key, val := range ds.current {
+		values[key] = val
+		ds.sent[key] = val
+	}
+	ds.lastSnapshot = time.Now()
+	return DeltaSnapshot{Seq: ds.seq, Ts: dashutil.Ts(), Values: values}
+}
+
+// Updates values (keys are letters, digits, '_', and '-') and pushes the changes to the
+// frontend, or a full snapshot if one is due, then flushes the request.  Returns the number
+// of values sent.
+func (ds *DeltaSeries) Push(req ActionRequest, values map[string]float64) (int, error) {
+	for key, val := range values {
+		if !deltaKeyRe.MatchString(key) {
+			return 0, dasherr.ValidateErr(fmt.Errorf("Invalid DeltaSeries key '%s'", key))
+		}
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return 0, dasherr.ValidateErr(fmt.Errorf("Invalid DeltaSeries value for '%s' (NaN/Inf cannot be sent as JSON)", key))
+		}
+	}
+	ds.lock.Lock()
+	for key, val := range values {
+		ds.current[key] = val
+	}
+	if time.Since(ds.lastSnapshot) >= ds.opts.SnapshotInterval {
+		snapshot := ds.snapshotNoLock()
+		ds.lock.Unlock()
+		err := req.SetData(ds.path, snapshot)
+		if err != nil {
+			return 0, err
+		}
+		return len(snapshot.Values), req.Flush()
+	}
+	changed := make(map[string]float64)
+	for key, val := range values {
+		sentVal, ok := ds.sent[key]
+		if ok && math.Abs(val-sentVal) <= ds.opts.Epsilon {
+			continue
+		}
+		changed[key] = val
+		ds.sent[key] = val
+	}
+	if len(changed) == 0 {
+		ds.lock.Unlock()
+		return 0, nil
+	}
+	ds.seq++
+	seq := ds.seq
+	ds.lock.Unlock()
+	for key, val := range changed {
+		err := req.SetData(ds.path+".values."+key, val)
+		if err != nil {
+			return 0, err
+		}
+	}
+	req.SetData(ds.path+".seq", seq)
+	req.SetData(ds.path+".ts", dashutil.Ts())
+	return len(changed), req.Flush()
+}
+
+// Sends a full snapshot of the current values and flushes the request (e.g. when a new
+// stream request starts).
+func (ds *DeltaSeries) Snapshot(req ActionRequest) error {
+	ds.lock.Lock()
+	snapshot := ds.snapshotNoLock()
+	ds.lock.Unlock()
+	err := req.SetData(ds.path, snapshot)
+	if err != nil {
+		return err
+	}
+	return req.Flush()
+}
+
+// Removes a value, the next push sends a full snapshot.
+func (ds *DeltaSeries) Remove(key string) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	delete(ds.current, key)
+	delete(ds.sent, key)
+	ds.lastSnapshot = time.Time{}
+}
+
+// Returns a copy of the current values.
+func (ds *DeltaSeries) Values() map[string]float64 {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	rtn := make(map[string]float64)
+	for key, val := range ds.current {
+		rtn[key] = val
+	}
+	return rtn
+}
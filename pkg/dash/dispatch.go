@@ -0,0 +1,299 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	OverflowQueue  = "queue"  // wait for a free slot (until the request's deadline or MaxQueue is reached)
+	OverflowReject = "reject" // reject immediately when MaxInFlight requests are running
+)
+
+// Request priorities.  When an app has DispatchLimits set, queued requests are dispatched
+// in priority order (highest first, FIFO within a priority).  Handlers default to
+// PriorityInteractive, pure (data) handlers default to PriorityData.
+const (
+	PriorityStream      = 10 // stream fill
+	PriorityData        = 20 // background data refresh
+	PriorityInteractive = 30 // user interactions (clicks, form submits)
+)
+
+// Concurrency limits for an app runtime.  Prevents one busy app from starving other apps
+// that share the same client.  The zero value is unlimited.
+type DispatchLimits struct {
+	MaxInFlight int    // maximum concurrently running requests (0 is unlimited)
+	MaxQueue    int    // maximum requests waiting for a slot when Overflow is OverflowQueue (0 is unlimited)
+	Overflow    string // OverflowQueue (default) or OverflowReject
+
+	// If set, handlers run in this pool get a new context with this timeout (instead of the
+	// request's deadline).  Used to give heavy handlers longer to complete.  The context keeps
+	// the request context's values and is still canceled if the caller cancels the request.
+	Timeout time.Duration
+}
+
+// Default limits for the heavy handler pool (see HandlerOpts.Heavy).
+var DefaultHeavyDispatchLimits = DispatchLimits{
+	MaxInFlight: 2,
+	MaxQueue:    20,
+	Overflow:    OverflowQueue,
+	Timeout:     5 * time.Minute,
+}
+
+// Current queue depth and inflight counts for an app runtime.
+type DispatchStats struct {
+	InFlight    int   `json:"inflight"`
+	Queued      int   `json:"queued"`
+	NumRejected int64 `json:"numrejected"`
+	NumTimedOut int64 `json:"numtimedout"`
+}
+
+type dispatchWaiter struct {
+	ch       chan bool
+	priority int
+}
+
+type dispatchLimiter struct {
+	lock        *sync.Mutex
+	limits      DispatchLimits
+	inFlight    int
+	waiters     []*dispatchWaiter
+	numRejected int64
+	numTimedOut int64
+}
+
+func (limits DispatchLimits) Validate() error {
+	if limits.MaxInFlight < 0 || limits.MaxQueue < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("DispatchLimits MaxInFlight and MaxQueue cannot be negative"))
+	}
+	if limits.Overflow != "" && limits.Overflow != OverflowQueue && limits.Overflow != OverflowReject {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid DispatchLimits Overflow '%s'", limits.Overflow))
+	}
+	if limits.Timeout < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("DispatchLimits Timeout cannot be negative"))
+	}
+	return nil
+}
+
+func makeDispatchLimiter(limits DispatchLimits) *dispatchLimiter {
+	return &dispatchLimiter{lock: &sync.Mutex{}, limits: limits}
+}
+
+func (l *dispatchLimiter) getTimeout() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limits.Timeout
+}
+
+func (l *dispatchLimiter) getLimits() DispatchLimits {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limits
+}
+
+func (l *dispatchLimiter) setLimits(limits DispatchLimits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits = limits
+	// raising the limit can free up slots for waiting requests
+	for len(l.waiters) > 0 && (l.limits.MaxInFlight == 0 || l.inFlight < l.limits.MaxInFlight) {
+		l.inFlight++
+		l.popWaiter()
+	}
+}
+
+func (l *dispatchLimiter) stats() DispatchStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return DispatchStats{
+		InFlight:    l.inFlight,
+		Queued:      len(l.waiters),
+		NumRejected: l.numRejected,
+		NumTimedOut: l.numTimedOut,
+	}
+}
+
+// pops the highest priority waiter and grants it the slot (inFlight must already account for it)
+func (l *dispatchLimiter) popWaiter() {
+	bestIdx := 0
+	for idx, w := range l.waiters {
+		if w.priority > l.waiters[bestIdx].priority {
+			bestIdx = idx
+		}
+	}
+	w := l.waiters[bestIdx]
+	l.waiters = append(l.waiters[:bestIdx], l.waiters[bestIdx+1:]...)
+	close(w.ch)
+}
+
+// Waits for a free slot.  Returns an error if the request is rejected or the context
+// expires while waiting.  If nil is returned, release() must be called.
+func (l *dispatchLimiter) acquire(ctx context.Context, priority int) error {
+	l.lock.Lock()
+	if l.limits.MaxInFlight == 0 || l.inFlight < l.limits.MaxInFlight {
+		l.inFlight++
+		l.lock.Unlock()
+		return nil
+	}
+	if l.limits.Overflow == OverflowReject || (l.limits.MaxQueue > 0 && len(l.waiters) >= l.limits.MaxQueue) {
+		l.numRejected++
+		l.lock.Unlock()
+		return dasherr.ErrWithCode(dasherr.ErrCodeQueueFull, fmt.Errorf("Too many requests (max in flight %d)", l.limits.MaxInFlight))
+	}
+	w := &dispatchWaiter{ch: make(chan bool), priority: priority}
+	l.waiters = append(l.waiters, w)
+	l.lock.Unlock()
+	select {
+	case <-w.ch:
+		return nil
+
+	case <-ctx.Done():
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		for idx, checkW := range l.waiters {
+			if checkW == w {
+				l.waiters = append(l.waiters[:idx], l.waiters[idx+1:]...)
+				l.numTimedOut++
+				return dasherr.ErrWithCode(dasherr.ErrCodeTimeout, fmt.Errorf("Request timed out waiting in queue"))
+			}
+		}
+		// slot was granted at the same time the context expired
+		return nil
+	}
+}
+
+func (l *dispatchLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.waiters) > 0 && (l.limits.MaxInFlight == 0 || l.inFlight <= l.limits.MaxInFlight) {
+		// transfer the slot
+		l.popWaiter()
+		return
+	}
+	l.inFlight--
+}
+
+// returns the dispatch priority for a request to the given handler
+func requestPriority(req *AppRequest, hval handlerType) int {
+	if hval.Opts.Priority != 0 {
+		return hval.Opts.Priority
+	}
+	if req.isStream() {
+		return PriorityStream
+	}
+	if hval.Opts.PureHandler {
+		return PriorityData
+	}
+	return PriorityInteractive
+}
+
+// Sets the concurrency limits for this runtime (see DispatchLimits).
+func (apprt *AppRuntimeImpl) SetDispatchLimits(limits DispatchLimits) {
+	err := limits.Validate()
+	if err != nil {
+		apprt.addError(err)
+		return
+	}
+	apprt.limiter.setLimits(limits)
+}
+
+// Returns the current queue depth and inflight counts for this runtime.
+func (apprt *AppRuntimeImpl) DispatchStats() DispatchStats {
+	return apprt.limiter.stats()
+}
+
+// Sets the limits for the separate pool that heavy handlers (HandlerOpts.Heavy) run in.
+// Defaults to DefaultHeavyDispatchLimits.
+func (apprt *AppRuntimeImpl) SetHeavyDispatchLimits(limits DispatchLimits) {
+	err := limits.Validate()
+	if err != nil {
+		apprt.addError(err)
+		return
+	}
+	apprt.heavyLimiter.setLimits(limits)
+}
+
+// Returns the current queue depth and inflight counts for the heavy handler pool.
+func (apprt *AppRuntimeImpl) HeavyDispatchStats() DispatchStats {
+	return apprt.heavyLimiter.stats()
+}
+
+// Sets the app's concurrency limits (see AppRuntimeImpl.SetDispatchLimits).
+func (app *App) SetDispatchLimits(limits DispatchLimits) {
+	app.appRuntime.SetDispatchLimits(limits)
+}
+
+// Sets the app's heavy handler pool limits (see AppRuntimeImpl.SetHeavyDispatchLimits).
+func (app *App) SetHeavyDispatchLimits(limits DispatchLimits) {
+	app.appRuntime.SetHeavyDispatchLimits(limits)
+}
+
+// time after the context deadline to report a handler as a possible goroutine leak
+const overdueLeakTime = time.Minute
+
+// If Config.WarnOverdueHandlers is set, logs a warning when the handler is still running after
+// its context is done (and again if it has not returned after overdueLeakTime).  Returns a
+// function that must be called when the handler returns.
+func watchHandlerDeadline(req *AppRequest, handlerName string) func() {
+	if req.client == nil || req.client.Config == nil || !req.client.Config.WarnOverdueHandlers {
+		return func() {}
+	}
+	ctx := req.Context()
+	doneCh := make(chan bool)
+	go func() {
+		select {
+		case <-doneCh:
+			return
+
+		case <-ctx.Done():
+		}
+		overdueTime := time.Now()
+		req.client.log("Dashborg WARNING handler '%s' is still running after its context is done (%v), reqinfo=%s\n", handlerName, ctx.Err(), req.reqInfoStr())
+		select {
+		case <-doneCh:
+			req.client.log("Dashborg WARNING handler '%s' returned %v after its context was done, reqinfo=%s\n", handlerName, time.Since(overdueTime), req.reqInfoStr())
+
+		case <-time.After(overdueLeakTime):
+			req.client.log("Dashborg WARNING handler '%s' has not returned %v after its context was done (possible goroutine leak), reqinfo=%s\n", handlerName, overdueLeakTime, req.reqInfoStr())
+		}
+	}()
+	return func() { close(doneCh) }
+}
+
+// keeps the parent's values (request meta, trace span) but not its deadline or cancellation
+type dispatchValuesCtx struct {
+	context.Context
+}
+
+func (dispatchValuesCtx) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (dispatchValuesCtx) Done() <-chan struct{}       { return nil }
+func (dispatchValuesCtx) Err() error                  { return nil }
+
+// returns a context for running a handler with the pool's timeout.  if the parent's deadline is
+// later (or it has none) the context is derived from parent.  otherwise the timeout extends past
+// the parent's deadline, so the new context keeps the parent's values and is still canceled when
+// the parent is explicitly canceled (but not when the parent's deadline expires).
+func withDispatchTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	parentDeadline, hasDeadline := parent.Deadline()
+	if !hasDeadline || !parentDeadline.Before(time.Now().Add(timeout)) {
+		return context.WithTimeout(parent, timeout)
+	}
+	ctx, cancelFn := context.WithTimeout(dispatchValuesCtx{parent}, timeout)
+	go func() {
+		select {
+		case <-parent.Done():
+			if parent.Err() == context.Canceled {
+				cancelFn()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancelFn
+}
@@ -0,0 +1,89 @@
+package dash
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+)
+
+const DefaultFailoverAfter = 60 * time.Second
+
+const (
+	EndpointPrimary   = "primary"
+	EndpointSecondary = "secondary"
+)
+
+func (pc *DashCloudClient) primaryGrpcAddr() string {
+	return net.JoinHostPort(pc.Config.GrpcHost, strconv.Itoa(pc.Config.GrpcPort))
+}
+
+// SecondaryGrpcAddr with the port defaulting to GrpcPort
+func (pc *DashCloudClient) secondaryGrpcAddr() string {
+	if _, _, err := net.SplitHostPort(pc.Config.SecondaryGrpcAddr); err == nil {
+		return pc.Config.SecondaryGrpcAddr
+	}
+	return net.JoinHostPort(pc.Config.SecondaryGrpcAddr, strconv.Itoa(pc.Config.GrpcPort))
+}
+
+// address of the gRPC endpoint in use
+func (pc *DashCloudClient) grpcAddr() string {
+	pc.Lock.Lock()
+	useSecondary := pc.useSecondary
+	pc.Lock.Unlock()
+	if useSecondary {
+		return pc.secondaryGrpcAddr()
+	}
+	return pc.primaryGrpcAddr()
+}
+
+// Returns which gRPC endpoint the client is using (EndpointPrimary or EndpointSecondary) and its address.
+func (pc *DashCloudClient) ActiveEndpoint() (string, string) {
+	pc.Lock.Lock()
+	useSecondary := pc.useSecondary
+	pc.Lock.Unlock()
+	if useSecondary {
+		return EndpointSecondary, pc.secondaryGrpcAddr()
+	}
+	return EndpointPrimary, pc.primaryGrpcAddr()
+}
+
+// switches between the primary and secondary gRPC endpoints when the client has been
+// disconnected for Config.FailoverAfter.  the ConnId is cleared so the request stream loop
+// reconnects (re-linking runtimes).  only called from runRequestStreamLoop.
+func (pc *DashCloudClient) maybeSwitchEndpoint() bool {
+	if pc.Config.SecondaryGrpcAddr == "" || pc.httpsConn != nil || pc.grpcConn() == nil {
+		return false
+	}
+	failoverAfter := pc.Config.FailoverAfter
+	if failoverAfter <= 0 {
+		failoverAfter = DefaultFailoverAfter
+	}
+	pc.Lock.Lock()
+	if pc.connected || pc.shuttingDown || pc.ExitErr != nil || time.Since(pc.disconnectTime) < failoverAfter {
+		pc.Lock.Unlock()
+		return false
+	}
+	pc.useSecondary = !pc.useSecondary
+	pc.disconnectTime = time.Now()
+	pc.Lock.Unlock()
+	endpointName, addr := pc.ActiveEndpoint()
+	conn, err := pc.dialGrpc()
+	if err != nil {
+		pc.log("DashborgCloudClient ERROR failing over to %s endpoint %s: %v\n", endpointName, addr, err)
+		pc.Lock.Lock()
+		pc.useSecondary = !pc.useSecondary
+		pc.Lock.Unlock()
+		return false
+	}
+	oldConn := pc.setServiceConn(conn, dashproto.NewDashborgServiceClient(conn))
+	pc.ConnId.Store("")
+	pc.closeReplacedConn(oldConn)
+	pc.log("DashborgCloudClient unreachable for %v, failing over to %s endpoint %s\n", failoverAfter, endpointName, addr)
+	pc.metrics.add(MetricEndpointFailovers, 1, endpointName)
+	pc.Lock.Lock()
+	pc.queueConnEventNoLock(ConnEventFailover, nil)
+	pc.Lock.Unlock()
+	return true
+}
@@ -0,0 +1,206 @@
+package dash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	ErrorSnapshotOff    = ""
+	ErrorSnapshotDashFS = "dashfs"
+	ErrorSnapshotLocal  = "local"
+)
+
+const DefaultErrorSnapshotFSDir = "/_/snapshots"
+const errorSnapshotMaxJsonSize = 256 * 1024
+const errorSnapshotRedacted = "[redacted]"
+
+// Diagnostic bundle captured when a handler returns an error (see Config.ErrorSnapshots).
+// Credentials in the request data and app state (ApiKeyParam, OIDCTokenParam, and any key
+// containing "password" or "secret") are redacted.  Large request data and app state are truncated.
+type ErrorSnapshot struct {
+	SnapshotId    string           `json:"snapshotid"`
+	Ts            int64            `json:"ts"`
+	ClientVersion string           `json:"clientversion"`
+	ProcName      string           `json:"procname"`
+	ProcRunId     string           `json:"procrunid"`
+	RequestInfo   RequestInfo      `json:"requestinfo"`
+	UserId        string           `json:"userid,omitempty"`
+	Err           string           `json:"err"`
+	ErrCode       string           `json:"errcode,omitempty"`
+	DataJson      string           `json:"datajson,omitempty"`
+	AppStateJson  string           `json:"appstatejson,omitempty"`
+	Truncated     bool             `json:"truncated,omitempty"`
+	Actions       []SnapshotAction `json:"actions,omitempty"`
+}
+
+// An action emitted by the handler before it errored (blob data is not captured).
+type SnapshotAction struct {
+	ActionType string `json:"actiontype"`
+	Selector   string `json:"selector,omitempty"`
+	OpType     string `json:"optype,omitempty"`
+	JsonData   string `json:"jsondata,omitempty"`
+	BlobSize   int    `json:"blobsize,omitempty"`
+}
+
+func isErrorSnapshotModeValid(mode string) bool {
+	return mode == ErrorSnapshotOff || mode == ErrorSnapshotDashFS || mode == ErrorSnapshotLocal
+}
+
+func truncateSnapshotJson(jsonStr string) (string, bool) {
+	if len(jsonStr) <= errorSnapshotMaxJsonSize {
+		return jsonStr, false
+	}
+	return jsonStr[0:errorSnapshotMaxJsonSize], true
+}
+
+func isSnapshotSecretKey(key string) bool {
+	lkey := strings.ToLower(key)
+	if lkey == ApiKeyParam || lkey == OIDCTokenParam {
+		return true
+	}
+	return strings.Contains(lkey, "password") || strings.Contains(lkey, "passwd") || strings.Contains(lkey, "secret")
+}
+
+func redactSnapshotValue(val interface{}) {
+	switch tval := val.(type) {
+	case map[string]interface{}:
+		for key, subVal := range tval {
+			if isSnapshotSecretKey(key) {
+				tval[key] = errorSnapshotRedacted
+				continue
+			}
+			redactSnapshotValue(subVal)
+		}
+	case []interface{}:
+		for _, subVal := range tval {
+			redactSnapshotValue(subVal)
+		}
+	}
+}
+
+// Returns jsonStr with credential fields redacted.  JSON that cannot be parsed is dropped
+// entirely (there is no way to know what it contains).
+func redactSnapshotJson(jsonStr string) string {
+	if jsonStr == "" {
+		return ""
+	}
+	var val interface{}
+	err := dashutil.UnmarshalJson(jsonStr, &val, dashutil.JsonOpts{UseNumber: true})
+	if err != nil {
+		return ""
+	}
+	redactSnapshotValue(val)
+	rtn, err := dashutil.MarshalJson(val)
+	if err != nil {
+		return ""
+	}
+	return rtn
+}
+
+func makeSnapshotActions(rras []*dashproto.RRAction) []SnapshotAction {
+	var rtn []SnapshotAction
+	for _, rra := range rras {
+		jsonData, _ := truncateSnapshotJson(rra.JsonData)
+		rtn = append(rtn, SnapshotAction{
+			ActionType: rra.ActionType,
+			Selector:   rra.Selector,
+			OpType:     rra.OpType,
+			JsonData:   jsonData,
+			BlobSize:   len(rra.BlobBytes),
+		})
+	}
+	return rtn
+}
+
+func (pc *DashCloudClient) makeErrorSnapshot(preq *AppRequest, rtnErr error) *ErrorSnapshot {
+	rtn := &ErrorSnapshot{
+		SnapshotId:    uuid.New().String(),
+		Ts:            dashutil.Ts(),
+		ClientVersion: ClientVersion,
+		ProcName:      pc.Config.ProcName,
+		ProcRunId:     pc.ProcRunId,
+		RequestInfo:   preq.RequestInfo(),
+		Err:           dasherr.GetMessage(rtnErr),
+		ErrCode:       string(dasherr.GetErrCode(rtnErr)),
+		Actions:       makeSnapshotActions(preq.getRRA()),
+	}
+	if preq.AuthData() != nil {
+		rtn.UserId = preq.AuthData().Id
+	}
+	var dataTrunc, stateTrunc bool
+	rtn.DataJson, dataTrunc = truncateSnapshotJson(redactSnapshotJson(preq.rawData.DataJson))
+	rtn.AppStateJson, stateTrunc = truncateSnapshotJson(redactSnapshotJson(preq.rawData.AppStateJson))
+	rtn.Truncated = dataTrunc || stateTrunc
+	return rtn
+}
+
+func (pc *DashCloudClient) errorSnapshotDir() string {
+	if pc.Config.ErrorSnapshotDir != "" {
+		return pc.Config.ErrorSnapshotDir
+	}
+	if pc.Config.ErrorSnapshots == ErrorSnapshotLocal {
+		return filepath.Join(os.TempDir(), "dashborg-snapshots")
+	}
+	return DefaultErrorSnapshotFSDir
+}
+
+func (pc *DashCloudClient) writeErrorSnapshot(snapshot *ErrorSnapshot) error {
+	fileName := snapshot.SnapshotId + ".json"
+	if pc.Config.ErrorSnapshots == ErrorSnapshotLocal {
+		dir := pc.errorSnapshotDir()
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+		barr, err := dashutil.MarshalJson(snapshot)
+		if err != nil {
+			return dasherr.JsonMarshalErr("ErrorSnapshot", err)
+		}
+		return ioutil.WriteFile(filepath.Join(dir, fileName), []byte(barr), 0600)
+	}
+	return pc.GlobalFSClient().SetJsonPath(pc.errorSnapshotDir()+"/"+fileName, snapshot, &FileOpts{Hidden: true, AllowedRoles: []string{RoleSuper}})
+}
+
+// Captures an error snapshot (if enabled) and returns its id, or "" if snapshots are off.
+// The snapshot is written in the background so the error response is not delayed.
+func (pc *DashCloudClient) captureErrorSnapshot(preq *AppRequest, rtnErr error) string {
+	if pc.Config.ErrorSnapshots == ErrorSnapshotOff || rtnErr == nil {
+		return ""
+	}
+	snapshot := pc.makeErrorSnapshot(preq, rtnErr)
+	go func() {
+		err := pc.writeErrorSnapshot(snapshot)
+		if err != nil {
+			pc.log("Dashborg error writing error snapshot:%s for %s: %v\n", snapshot.SnapshotId, preq.reqInfoStr(), err)
+			return
+		}
+		pc.logV("Dashborg wrote error snapshot:%s for %s\n", snapshot.SnapshotId, preq.reqInfoStr())
+	}()
+	return snapshot.SnapshotId
+}
+
+// Reads an error snapshot written to local disk (Config.ErrorSnapshots = "local").
+func (pc *DashCloudClient) ReadLocalErrorSnapshot(snapshotId string) (*ErrorSnapshot, error) {
+	if _, err := uuid.Parse(snapshotId); err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid SnapshotId '%s'", snapshotId))
+	}
+	barr, err := ioutil.ReadFile(filepath.Join(pc.errorSnapshotDir(), snapshotId+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var rtn ErrorSnapshot
+	err = dashutil.UnmarshalJson(string(barr), &rtn, dashutil.JsonOpts{})
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("ErrorSnapshot", err)
+	}
+	return &rtn, nil
+}
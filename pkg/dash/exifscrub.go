@@ -0,0 +1,246 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// Transforms blob content before it is written (see BlobPolicy.Transformers).  Transformers
+// run in order, and should return data unchanged for mime types they do not handle.
+type BlobTransformer interface {
+	TransformBlob(mimeType string, data []byte) ([]byte, error)
+}
+
+// Adapts a function to the BlobTransformer interface.
+type BlobTransformerFunc func(mimeType string, data []byte) ([]byte, error)
+
+func (fn BlobTransformerFunc) TransformBlob(mimeType string, data []byte) ([]byte, error) {
+	return fn(mimeType, data)
+}
+
+const DefaultScrubJpegQuality = 90
+
+// A BlobTransformer that strips EXIF (including GPS), XMP, IPTC, and comment metadata from
+// JPEG and PNG images.  JPEGs are scrubbed losslessly (APP0 JFIF, APP2 ICC, and APP14 Adobe
+// segments are kept), unless NormalizeOrientation is set and the image has an EXIF
+// orientation, in which case the image is rotated and re-encoded (ICC profile is not kept).
+type ExifScrubber struct {
+	NormalizeOrientation bool
+	JpegQuality          int // used when re-encoding (defaults to DefaultScrubJpegQuality)
+}
+
+func (s ExifScrubber) TransformBlob(mimeType string, data []byte) ([]byte, error) {
+	switch strings.ToLower(mimeType) {
+	case "image/jpeg", "image/jpg":
+		return s.scrubJpeg(data)
+
+	case "image/png":
+		return scrubPng(data)
+	}
+	return data, nil
+}
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// metadata chunks removed from PNGs
+var pngScrubChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func scrubPng(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid PNG (bad signature)"))
+	}
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid PNG (truncated chunk)"))
+		}
+		chunkLen := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		chunkEnd := pos + 12 + chunkLen // length + type + data + crc
+		if chunkLen < 0 || chunkEnd > len(data) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid PNG (bad chunk length)"))
+		}
+		if !pngScrubChunks[chunkType] {
+			buf.Write(data[pos:chunkEnd])
+		}
+		pos = chunkEnd
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// APPn markers kept when scrubbing (JFIF, ICC profile, Adobe color transform)
+func keepJpegMarker(marker byte) bool {
+	if marker >= 0xe0 && marker <= 0xef {
+		return marker == 0xe0 || marker == 0xe2 || marker == 0xee
+	}
+	return marker != 0xfe // COM
+}
+
+// returns the scrubbed jpeg and the EXIF orientation (0 if not set)
+func stripJpegMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid JPEG (no SOI)"))
+	}
+	var buf bytes.Buffer
+	buf.Write(data[0:2])
+	orientation := 0
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xff || pos+1 >= len(data) {
+			return nil, 0, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid JPEG (bad marker at %d)", pos))
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			buf.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			return nil, 0, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid JPEG (truncated segment)"))
+		}
+		segLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		segEnd := pos + 2 + segLen
+		if segLen < 2 || segEnd > len(data) {
+			return nil, 0, dasherr.ValidateErr(fmt.Errorf("ExifScrubber invalid JPEG (bad segment length)"))
+		}
+		if marker == 0xda {
+			// start of scan, the rest is image data
+			buf.Write(data[pos:])
+			break
+		}
+		if marker == 0xe1 && orientation == 0 {
+			orientation = exifOrientation(data[pos+4 : segEnd])
+		}
+		if keepJpegMarker(marker) {
+			buf.Write(data[pos:segEnd])
+		}
+		pos = segEnd
+	}
+	return buf.Bytes(), orientation, nil
+}
+
+// parses the orientation tag (0x0112) from IFD0 of an APP1 EXIF payload, returns 0 if not found
+func exifOrientation(payload []byte) int {
+	if !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := payload[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return 0
+	}
+	numEntries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for i := 0; i < numEntries; i++ {
+		entryPos := ifdOffset + 2 + i*12
+		if entryPos+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entryPos:entryPos+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entryPos+8 : entryPos+10]))
+			if orientation < 1 || orientation > 8 {
+				return 0
+			}
+			return orientation
+		}
+	}
+	return 0
+}
+
+// applies an EXIF orientation (2-8) so the returned image displays upright with orientation 1
+func orientImage(src image.Image, orientation int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-sx, sy
+			case 3:
+				dx, dy = w-1-sx, h-1-sy
+			case 4:
+				dx, dy = sx, h-1-sy
+			case 5:
+				dx, dy = sy, sx
+			case 6:
+				dx, dy = h-1-sy, sx
+			case 7:
+				dx, dy = h-1-sy, w-1-sx
+			case 8:
+				dx, dy = sy, w-1-sx
+			default:
+				dx, dy = sx, sy
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func (s ExifScrubber) scrubJpeg(data []byte) ([]byte, error) {
+	scrubbed, orientation, err := stripJpegMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if !s.NormalizeOrientation || orientation <= 1 {
+		return scrubbed, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(scrubbed))
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("ExifScrubber cannot decode JPEG: %w", err))
+	}
+	quality := s.JpegQuality
+	if quality <= 0 || quality > 100 {
+		quality = DefaultScrubJpegQuality
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, orientImage(img, orientation), &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func applyBlobTransformers(transformers []BlobTransformer, mimeType string, data []byte) ([]byte, error) {
+	for _, t := range transformers {
+		var err error
+		data, err = t.TransformBlob(mimeType, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
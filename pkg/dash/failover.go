@@ -0,0 +1,241 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	FailoverRolePrimary = "primary"
+	FailoverRoleStandby = "standby"
+)
+
+const (
+	DefaultLeasePath = "/_/lease"
+	DefaultLeaseTTL  = 15 * time.Second
+	MinLeaseTTL      = 3 * time.Second
+
+	leaseSettleTime = time.Second
+)
+
+// Options for DashAppClient.ConnectAppRuntimeFailover.
+type FailoverOpts struct {
+	// FailoverRolePrimary or FailoverRoleStandby.  A primary takes the lease whenever it is
+	// free, expired, or held by a standby.  A standby only takes the lease once it has expired.
+	Role string
+
+	LeasePath    string        // app relative lease path (defaults to DefaultLeasePath)
+	LeaseTTL     time.Duration // defaults to DefaultLeaseTTL, lease is renewed every LeaseTTL/3
+	OnRoleChange func(active bool)
+	ShutdownCh   chan struct{} // close to release the lease and disconnect the runtime
+}
+
+type leaseData struct {
+	ProcRunId string `json:"procrunid"`
+	ProcName  string `json:"procname,omitempty"`
+	Role      string `json:"role"`
+	ExpTs     int64  `json:"expts"`
+}
+
+// Coordinates primary/standby processes serving the same app runtime.  Only the process
+// holding the lease has its runtime connected.
+type RuntimeFailover struct {
+	lock   *sync.Mutex
+	app    *App
+	opts   FailoverOpts
+	active bool
+}
+
+func (opts *FailoverOpts) setDefaults() {
+	if opts.LeasePath == "" {
+		opts.LeasePath = DefaultLeasePath
+	}
+	if opts.LeaseTTL == 0 {
+		opts.LeaseTTL = DefaultLeaseTTL
+	}
+}
+
+func (opts *FailoverOpts) Validate() error {
+	if opts.Role != FailoverRolePrimary && opts.Role != FailoverRoleStandby {
+		return dasherr.ValidateErr(fmt.Errorf("FailoverOpts invalid Role '%s' (must be '%s' or '%s')", opts.Role, FailoverRolePrimary, FailoverRoleStandby))
+	}
+	if !dashutil.IsPathValid(opts.LeasePath) {
+		return dasherr.ValidateErr(fmt.Errorf("FailoverOpts invalid LeasePath '%s'", opts.LeasePath))
+	}
+	if opts.LeaseTTL < MinLeaseTTL {
+		return dasherr.ValidateErr(fmt.Errorf("FailoverOpts LeaseTTL must be at least %v", MinLeaseTTL))
+	}
+	return nil
+}
+
+// Connects the app's runtime using a lease (stored in the app's Dashborg FS) so that only one of
+// several processes (one primary, one or more standbys) serves requests at a time.  If the active
+// process's connection drops, it stops renewing the lease and a standby takes over after LeaseTTL.
+// The app must already be written (use WriteApp(), not WriteAndConnectApp()).
+func (dac *DashAppClient) ConnectAppRuntimeFailover(app *App, opts *FailoverOpts) (*RuntimeFailover, error) {
+	if opts == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("ConnectAppRuntimeFailover requires FailoverOpts"))
+	}
+	opts.setDefaults()
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if app.Runtime() == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("No AppRuntime to connect, app.Runtime() is nil"))
+	}
+	if app.HasExternalRuntime() {
+		return nil, dasherr.ValidateErr(fmt.Errorf("App has specified an external runtime path '%s', cannot use failover", app.getRuntimePath()))
+	}
+	err = app.Err()
+	if err != nil {
+		return nil, err
+	}
+	rtn := &RuntimeFailover{lock: &sync.Mutex{}, app: app, opts: *opts}
+	rtn.checkLease()
+	go rtn.run()
+	return rtn, nil
+}
+
+// Returns true if this process currently holds the lease (its runtime is connected).
+func (f *RuntimeFailover) IsActive() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.active
+}
+
+func (f *RuntimeFailover) client() *DashCloudClient {
+	return f.app.client
+}
+
+func (f *RuntimeFailover) run() {
+	ticker := time.NewTicker(f.opts.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.checkLease()
+
+		case <-f.opts.ShutdownCh:
+			f.release()
+			return
+
+		case <-f.client().DoneCh:
+			f.setActive(false)
+			return
+		}
+	}
+}
+
+func (f *RuntimeFailover) readLease() (*leaseData, error) {
+	_, content, err := f.client().fileInfo(f.app.AppPath()+f.opts.LeasePath, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	var rtn leaseData
+	err = json.Unmarshal(content, &rtn)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("LeaseData", err)
+	}
+	return &rtn, nil
+}
+
+func (f *RuntimeFailover) writeLease() error {
+	lease := leaseData{
+		ProcRunId: f.client().ProcRunId,
+		ProcName:  f.client().Config.ProcName,
+		Role:      f.opts.Role,
+		ExpTs:     dashutil.Ts() + int64(f.opts.LeaseTTL/time.Millisecond),
+	}
+	return f.app.AppFSClient().SetJsonPath(f.opts.LeasePath, lease, &FileOpts{Hidden: true, AllowedRoles: []string{RoleSuper}})
+}
+
+func (f *RuntimeFailover) canClaim(lease *leaseData) bool {
+	if lease == nil || lease.ProcRunId == f.client().ProcRunId || lease.ExpTs < dashutil.Ts() {
+		return true
+	}
+	return f.opts.Role == FailoverRolePrimary && lease.Role == FailoverRoleStandby
+}
+
+func (f *RuntimeFailover) checkLease() {
+	if !f.client().IsConnected() {
+		// cannot renew, must assume the lease will be taken by another process
+		f.setActive(false)
+		return
+	}
+	lease, err := f.readLease()
+	if err != nil {
+		f.client().logPathV(f.app.AppPath(), "Dashborg failover error reading lease app:%s err:%v\n", f.app.appName, err)
+		return
+	}
+	if !f.canClaim(lease) {
+		f.setActive(false)
+		return
+	}
+	err = f.writeLease()
+	if err != nil {
+		f.client().logPathV(f.app.AppPath(), "Dashborg failover error writing lease app:%s err:%v\n", f.app.appName, err)
+		return
+	}
+	if f.IsActive() {
+		return
+	}
+	// wait and re-read to make sure another process did not claim the lease at the same time
+	time.Sleep(leaseSettleTime)
+	lease, err = f.readLease()
+	if err != nil || lease == nil || lease.ProcRunId != f.client().ProcRunId {
+		return
+	}
+	f.setActive(true)
+}
+
+func (f *RuntimeFailover) setActive(active bool) {
+	f.lock.Lock()
+	wasActive := f.active
+	f.lock.Unlock()
+	if wasActive == active {
+		return
+	}
+	runtimePath := f.app.getRuntimePath()
+	if active {
+		err := f.client().connectLinkRpc(runtimePath)
+		if err != nil {
+			f.client().log("Dashborg failover error connecting runtime app:%s err:%v\n", f.app.appName, err)
+			return
+		}
+		f.client().connectLinkRuntime(runtimePath, f.app.Runtime())
+		f.client().registerConnectedApp(runtimePath, f.app)
+		f.client().log("Dashborg failover app:%s role:%s is now active\n", f.app.appName, f.opts.Role)
+	} else {
+		f.client().unlinkRuntime(runtimePath)
+		f.app.setDisconnected()
+		f.client().log("Dashborg failover app:%s role:%s is no longer active\n", f.app.appName, f.opts.Role)
+	}
+	f.lock.Lock()
+	f.active = active
+	f.lock.Unlock()
+	if f.opts.OnRoleChange != nil {
+		f.opts.OnRoleChange(active)
+	}
+}
+
+// releases the lease (if held) so a standby can take over immediately.
+func (f *RuntimeFailover) release() {
+	wasActive := f.IsActive()
+	f.setActive(false)
+	if !wasActive || !f.client().IsConnected() {
+		return
+	}
+	err := f.app.AppFSClient().RemovePath(f.opts.LeasePath)
+	if err != nil {
+		f.client().logPathV(f.app.AppPath(), "Dashborg failover error releasing lease app:%s err:%v\n", f.app.appName, err)
+	}
+}
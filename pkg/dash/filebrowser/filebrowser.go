@@ -0,0 +1,327 @@
+// Exposes a local directory to Dashborg apps (list, preview, download, and optional role-gated writes).
+package filebrowser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultMaxPreviewBytes  = 256 * 1024
+	DefaultMaxDownloadBytes = 3 * 1024 * 1024 // limited by the maximum blob response size
+	MaxWriteBytes           = 1024 * 1024
+)
+
+type Options struct {
+	Root string // local directory to expose (required)
+
+	// Roles allowed to write (mkdir, write, remove).  If empty the browser is read-only.
+	WriteRoles []string
+
+	ShowHidden       bool  // show dot-files
+	MaxPreviewBytes  int64 // defaults to DefaultMaxPreviewBytes
+	MaxDownloadBytes int64 // defaults to DefaultMaxDownloadBytes
+}
+
+// Returned from the "list" handler.
+type FileEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"` // relative to Root, always starts with "/"
+	IsDir    bool   `json:"isdir"`
+	Size     int64  `json:"size"`
+	ModTs    int64  `json:"modts"`
+	MimeType string `json:"mimetype,omitempty"`
+}
+
+type FileBrowser struct {
+	root string
+	opts Options
+}
+
+func MakeFileBrowser(opts *Options) (*FileBrowser, error) {
+	if opts == nil || opts.Root == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("FileBrowser Options.Root must be set"))
+	}
+	root, err := filepath.Abs(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	finfo, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !finfo.IsDir() {
+		return nil, dasherr.ValidateErr(fmt.Errorf("FileBrowser Root '%s' is not a directory", opts.Root))
+	}
+	rtn := &FileBrowser{root: root, opts: *opts}
+	if rtn.opts.MaxPreviewBytes <= 0 {
+		rtn.opts.MaxPreviewBytes = DefaultMaxPreviewBytes
+	}
+	if rtn.opts.MaxDownloadBytes <= 0 {
+		rtn.opts.MaxDownloadBytes = DefaultMaxDownloadBytes
+	}
+	return rtn, nil
+}
+
+// Registers the file browser handlers ("list", "preview", "download", and if WriteRoles
+// are set "mkdir", "write", and "remove").
+func (fb *FileBrowser) Register(rt dash.HandlerRegistry) {
+	rt.PureHandler("list", fb.list)
+	rt.PureHandler("preview", fb.preview)
+	rt.PureHandler("download", fb.download)
+	if len(fb.opts.WriteRoles) > 0 {
+		rt.Handler("mkdir", fb.mkdir)
+		rt.Handler("write", fb.write)
+		rt.Handler("remove", fb.remove)
+	}
+}
+
+// Creates a LinkRuntime for the file browser (to link at an arbitrary Dashborg FS path).
+func (fb *FileBrowser) Runtime() *dash.LinkRuntimeImpl {
+	rt := dash.MakeRuntime()
+	fb.Register(rt)
+	return rt
+}
+
+// Creates an app with the file browser handlers and a prebuilt UI.
+func (fb *FileBrowser) MakeApp(appClient *dash.DashAppClient, appName string) *dash.App {
+	app := appClient.NewApp(appName)
+	app.SetAppTitle(fmt.Sprintf("Files: %s", filepath.Base(fb.root)))
+	app.SetHtml(fb.html())
+	fb.Register(app.Runtime())
+	return app
+}
+
+// resolves a browser path (relative to root) to a local file path.  Rejects paths
+// that escape the root directory (including through symlinks).
+func (fb *FileBrowser) resolve(relPath string) (string, error) {
+	if strings.IndexByte(relPath, 0) != -1 {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid path"))
+	}
+	cleanPath := filepath.Clean("/" + relPath)
+	if !fb.opts.ShowHidden {
+		for _, part := range strings.Split(cleanPath, "/") {
+			if strings.HasPrefix(part, ".") {
+				return "", dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path not found"))
+			}
+		}
+	}
+	fullPath := filepath.Join(fb.root, filepath.FromSlash(cleanPath))
+	realPath, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// allow non-existent leaf (for writes), but the parent must resolve inside root
+			realParent, perr := filepath.EvalSymlinks(filepath.Dir(fullPath))
+			if perr != nil || !fb.isInRoot(realParent) {
+				return "", dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path not found"))
+			}
+			return filepath.Join(realParent, filepath.Base(fullPath)), nil
+		}
+		return "", err
+	}
+	if !fb.isInRoot(realPath) {
+		return "", dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path not found"))
+	}
+	return realPath, nil
+}
+
+func (fb *FileBrowser) isInRoot(realPath string) bool {
+	return realPath == fb.root || strings.HasPrefix(realPath, fb.root+string(filepath.Separator))
+}
+
+func (fb *FileBrowser) checkWrite(req dash.Request) error {
+	aa := req.AuthData()
+	if aa.IsSuper() {
+		return nil
+	}
+	for _, role := range fb.opts.WriteRoles {
+		if aa.HasRole(role) {
+			return nil
+		}
+	}
+	return dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Not authorized to modify files"))
+}
+
+func fileMimeType(fileName string, head []byte) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
+	if mimeType == "" && head != nil {
+		mimeType = http.DetectContentType(head)
+	}
+	if idx := strings.Index(mimeType, ";"); idx != -1 {
+		mimeType = strings.TrimSpace(mimeType[0:idx])
+	}
+	if mimeType == "" || !dashutil.IsMimeTypeValid(mimeType) {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
+
+func (fb *FileBrowser) list(relPath string) ([]*FileEntry, error) {
+	dirPath, err := fb.resolve(relPath)
+	if err != nil {
+		return nil, err
+	}
+	finfos, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	baseRel := filepath.Clean("/" + relPath)
+	rtn := make([]*FileEntry, 0)
+	for _, finfo := range finfos {
+		if !fb.opts.ShowHidden && strings.HasPrefix(finfo.Name(), ".") {
+			continue
+		}
+		entry := &FileEntry{
+			Name:  finfo.Name(),
+			Path:  filepath.ToSlash(filepath.Join(baseRel, finfo.Name())),
+			IsDir: finfo.IsDir(),
+			Size:  finfo.Size(),
+			ModTs: dashutil.DashTime(finfo.ModTime()),
+		}
+		if !entry.IsDir {
+			entry.MimeType = fileMimeType(finfo.Name(), nil)
+		}
+		rtn = append(rtn, entry)
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		if rtn[i].IsDir != rtn[j].IsDir {
+			return rtn[i].IsDir
+		}
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn, nil
+}
+
+func (fb *FileBrowser) readFile(relPath string, maxBytes int64) ([]byte, os.FileInfo, error) {
+	filePath, err := fb.resolve(relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+	finfo, err := fd.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if finfo.IsDir() {
+		return nil, nil, dasherr.ValidateErr(fmt.Errorf("Path is a directory"))
+	}
+	barr, err := ioutil.ReadAll(io.LimitReader(fd, maxBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	return barr, finfo, nil
+}
+
+// Returns the first MaxPreviewBytes of a file.  Images are returned as image blobs, all other
+// files as text/plain.
+func (fb *FileBrowser) preview(relPath string) (*dash.BlobReturn, error) {
+	barr, finfo, err := fb.readFile(relPath, fb.opts.MaxPreviewBytes)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := fileMimeType(finfo.Name(), barr)
+	if dashutil.IsImageMimeTypeValid(mimeType) {
+		if finfo.Size() > fb.opts.MaxPreviewBytes {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Image too large to preview"))
+		}
+		return &dash.BlobReturn{Reader: bytes.NewReader(barr), MimeType: mimeType}, nil
+	}
+	return &dash.BlobReturn{Reader: bytes.NewReader(barr), MimeType: "text/plain"}, nil
+}
+
+func (fb *FileBrowser) download(relPath string) (*dash.BlobReturn, error) {
+	barr, finfo, err := fb.readFile(relPath, fb.opts.MaxDownloadBytes+1)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(barr)) > fb.opts.MaxDownloadBytes {
+		return nil, dasherr.ValidateErr(fmt.Errorf("File too large to download (max %d bytes)", fb.opts.MaxDownloadBytes))
+	}
+	return &dash.BlobReturn{Reader: bytes.NewReader(barr), MimeType: fileMimeType(finfo.Name(), barr)}, nil
+}
+
+func (fb *FileBrowser) mkdir(req dash.Request, relPath string) error {
+	err := fb.checkWrite(req)
+	if err != nil {
+		return err
+	}
+	dirPath, err := fb.resolve(relPath)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(dirPath, 0755)
+}
+
+func (fb *FileBrowser) write(req dash.Request, relPath string, content string) error {
+	err := fb.checkWrite(req)
+	if err != nil {
+		return err
+	}
+	if len(content) > MaxWriteBytes {
+		return dasherr.ValidateErr(fmt.Errorf("Content too large (max %d bytes)", MaxWriteBytes))
+	}
+	filePath, err := fb.resolve(relPath)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, []byte(content), 0644)
+}
+
+func (fb *FileBrowser) remove(req dash.Request, relPath string) error {
+	err := fb.checkWrite(req)
+	if err != nil {
+		return err
+	}
+	filePath, err := fb.resolve(relPath)
+	if err != nil {
+		return err
+	}
+	if filePath == fb.root {
+		return dasherr.ValidateErr(fmt.Errorf("Cannot remove root directory"))
+	}
+	return os.Remove(filePath)
+}
+
+func (fb *FileBrowser) html() string {
+	return `<app ui="dashborg">
+  <d-data query="/@app:list('/')" output.bindpath="$.files"/>
+  <h1>Files <d-text bind="$.dir || '/'"/></h1>
+  <div class="row">
+    <d-button onclickhandler="$.dir = '/'; $.files = /@app:list('/')">Root</d-button>
+  </div>
+  <d-table bind="$.files">
+    <d-col label="Name">
+      <d-button class="link" if=".isdir" onclickhandler="$.dir = .path; $.files = /@app:list(.path)"><d-text bind=".name + '/'"/></d-button>
+      <d-button class="link" if="!.isdir" onclickhandler="$.previewpath = .path; $.preview = /@app:preview(.path)"><d-text bind=".name"/></d-button>
+    </d-col>
+    <d-col label="Size" bind=".size"/>
+    <d-col label="Modified" bind="fn:ts(.modts)"/>
+    <d-col label="Type" bind=".mimetype"/>
+  </d-table>
+  <div if="$.preview">
+    <h2><d-text bind="$.previewpath"/></h2>
+    <d-blob bind="$.preview"/>
+  </div>
+</app>
+`
+}
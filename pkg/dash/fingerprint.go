@@ -0,0 +1,157 @@
+package dash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const DefaultFingerprintHashLen = 10
+
+var defaultFingerprintExts = []string{".js", ".css", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico", ".woff", ".woff2", ".ttf", ".otf"}
+var defaultRewriteExts = []string{".html", ".htm", ".css"}
+
+// Options for FingerprintAssets.  All fields are optional.
+type FingerprintOpts struct {
+	AssetExts   []string // extensions of files to fingerprint (defaults to js, css, images, and fonts)
+	RewriteExts []string // extensions of files whose references are rewritten (defaults to .html, .htm, .css)
+	HashLen     int      // hex characters of the sha256 in the new name (defaults to DefaultFingerprintHashLen)
+
+	// Prefix the frontend uses for the upload paths (e.g. "/@app" if items are app relative).
+	// References with or without the prefix are rewritten.
+	UrlPrefix string
+
+	NoCacheControl bool // by default fingerprinted assets get CacheControlImmutable (unless FileOpts.CacheControl is set)
+}
+
+func hasExt(fileName string, exts []string) bool {
+	ext := strings.ToLower(path.Ext(fileName))
+	for _, e := range exts {
+		if ext == strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// "/js/app.js" => "/js/app.3f2a9c1b0d.js"
+func fingerprintPath(itemPath string, content []byte, hashLen int) string {
+	hashVal := sha256.Sum256(content)
+	hashStr := hex.EncodeToString(hashVal[:])[0:hashLen]
+	ext := path.Ext(itemPath)
+	return strings.TrimSuffix(itemPath, ext) + "." + hashStr + ext
+}
+
+func uploadItemContent(item *UploadItem) ([]byte, error) {
+	if item.FileName == "" {
+		return item.Data, nil
+	}
+	return ioutil.ReadFile(item.FileName)
+}
+
+// rewrites references to the renamed paths (absolute, with UrlPrefix, or relative to the
+// referencing file's directory) that are delimited by quotes, parens, whitespace, '?', or '#'.
+// "../" references are not rewritten.
+func rewriteAssetRefs(content []byte, itemPath string, renames map[string]string, urlPrefix string) []byte {
+	if len(renames) == 0 {
+		return content
+	}
+	dir := path.Dir(itemPath)
+	refMap := make(map[string]string)
+	for oldPath, newPath := range renames {
+		refMap[oldPath] = newPath
+		if urlPrefix != "" {
+			refMap[urlPrefix+oldPath] = urlPrefix + newPath
+		}
+		if relPath := strings.TrimPrefix(oldPath, strings.TrimSuffix(dir, "/")+"/"); relPath != oldPath {
+			refMap[relPath] = strings.TrimPrefix(newPath, strings.TrimSuffix(dir, "/")+"/")
+			refMap["./"+relPath] = "./" + refMap[relPath]
+		}
+	}
+	var refs []string
+	for ref := range refMap {
+		refs = append(refs, regexp.QuoteMeta(ref))
+	}
+	// longest first so "/a/app.js" is matched before "app.js"
+	sort.Slice(refs, func(i int, j int) bool { return len(refs[i]) > len(refs[j]) })
+	refRe := regexp.MustCompile(`(["'(\s=])(` + strings.Join(refs, "|") + `)(["')\s?#])`)
+	return refRe.ReplaceAllFunc(content, func(match []byte) []byte {
+		sub := refRe.FindSubmatch(match)
+		return []byte(string(sub[1]) + refMap[string(sub[2])] + string(sub[3]))
+	})
+}
+
+// Renames assets in an upload batch with a hash of their content (app.js => app.3f2a9c1b0d.js)
+// and rewrites references to them in HTML and CSS files, so every deploy busts browser caches
+// without manual renaming.  CSS files are rewritten before they are fingerprinted (so a changed
+// image changes the CSS name too).  Rewritten files become Data items.  Returns the new items
+// (pass to UploadBatch or BuildManifest) and the renames (old path => new path).
+// Usage: items, renames, err := dash.FingerprintAssets(items, &dash.FingerprintOpts{UrlPrefix: "/@app"})
+func FingerprintAssets(items []UploadItem, opts *FingerprintOpts) ([]UploadItem, map[string]string, error) {
+	if opts == nil {
+		opts = &FingerprintOpts{}
+	}
+	assetExts := opts.AssetExts
+	if len(assetExts) == 0 {
+		assetExts = defaultFingerprintExts
+	}
+	rewriteExts := opts.RewriteExts
+	if len(rewriteExts) == 0 {
+		rewriteExts = defaultRewriteExts
+	}
+	hashLen := opts.HashLen
+	if hashLen <= 0 {
+		hashLen = DefaultFingerprintHashLen
+	}
+	if hashLen > 64 {
+		return nil, nil, dasherr.ValidateErr(fmt.Errorf("FingerprintOpts HashLen cannot be greater than 64"))
+	}
+	rtn := make([]UploadItem, len(items))
+	copy(rtn, items)
+	renames := make(map[string]string)
+	// pass 0: assets that are not rewritten, pass 1: rewritten assets (css), pass 2: other rewritten files (html)
+	for pass := 0; pass < 3; pass++ {
+		for idx := range rtn {
+			item := &rtn[idx]
+			isAsset, isRewrite := hasExt(item.Path, assetExts), hasExt(item.Path, rewriteExts)
+			if (pass == 0 && (!isAsset || isRewrite)) || (pass == 1 && (!isAsset || !isRewrite)) || (pass == 2 && (isAsset || !isRewrite)) {
+				continue
+			}
+			err := item.Validate()
+			if err != nil {
+				return nil, nil, err
+			}
+			content, err := uploadItemContent(item)
+			if err != nil {
+				return nil, nil, fmt.Errorf("FingerprintAssets path:%s: %w", item.Path, err)
+			}
+			if isRewrite {
+				content = rewriteAssetRefs(content, item.Path, renames, opts.UrlPrefix)
+				item.FileName = ""
+				item.Data = content
+			}
+			if !isAsset {
+				continue
+			}
+			newPath := fingerprintPath(item.Path, content, hashLen)
+			renames[item.Path] = newPath
+			item.Path = newPath
+			if !opts.NoCacheControl && (item.FileOpts == nil || item.FileOpts.CacheControl == "") {
+				fileOpts := &FileOpts{}
+				if item.FileOpts != nil {
+					*fileOpts = *item.FileOpts
+				}
+				fileOpts.CacheControl = CacheControlImmutable
+				item.FileOpts = fileOpts
+			}
+		}
+	}
+	return rtn, renames, nil
+}
@@ -0,0 +1,325 @@
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	FlagModeDisable     = "disable"     // handler returns a NoHandler error when the flag is off
+	FlagModeUnavailable = "unavailable" // handler returns UnavailableResponse when the flag is off
+	FlagModeSwitch      = "switch"      // AltHandlerFn is run (instead of the handler) when the flag is on
+)
+
+const (
+	DefaultFlagsPath         = "/_/flags.json"
+	DefaultFlagsPollInterval = 30 * time.Second
+	DefaultFlagTenantClaim   = "tenant"
+	FeatureFlagMwName        = "featureflags"
+	FeatureFlagMwPriority    = 50
+	minFlagsPollInterval     = 5 * time.Second
+)
+
+// Information about the request passed to a FlagProvider.
+type FlagContext struct {
+	AppName     string
+	HandlerName string
+	FeClientId  string
+	Roles       []string
+	TenantId    string // from the AuthAtom data (see FeatureFlagOpts.TenantClaim)
+}
+
+// Evaluates feature flags.  Implementations can wrap external flag services (e.g. LaunchDarkly).
+type FlagProvider interface {
+	IsEnabled(flagName string, flagCtx FlagContext) (bool, error)
+}
+
+// A single flag in a FlagConfig.  The flag is on if Enabled is set and the request
+// matches Roles and Tenants (when set).
+type FlagDef struct {
+	Enabled bool     `json:"enabled"`
+	Roles   []string `json:"roles,omitempty"`   // if set, the request must have one of these roles
+	Tenants []string `json:"tenants,omitempty"` // if set, the request's tenant must be in this list
+}
+
+// Format of the DashFS flags file read by FSFlagProvider.
+type FlagConfig struct {
+	Flags map[string]FlagDef `json:"flags"`
+}
+
+// Gates a handler with a feature flag (see FeatureFlagOpts).
+type HandlerFlag struct {
+	HandlerName string
+	FlagName    string
+	Mode        string // FlagModeDisable (default), FlagModeUnavailable, or FlagModeSwitch
+
+	// Returned (instead of running the handler) in FlagModeUnavailable.  Defaults to
+	// {"unavailable": true, "message": "Feature unavailable"}.
+	UnavailableResponse interface{}
+
+	// Run instead of the handler in FlagModeSwitch when the flag is on.
+	AltHandlerFn func(req *AppRequest) (interface{}, error)
+}
+
+// Options for FeatureFlagMiddleware.
+type FeatureFlagOpts struct {
+	Provider    FlagProvider
+	Flags       []HandlerFlag
+	TenantClaim string // key in AuthAtom.Data holding the tenant id (defaults to DefaultFlagTenantClaim)
+}
+
+func (flag *FlagDef) matches(flagCtx FlagContext) bool {
+	if !flag.Enabled {
+		return false
+	}
+	if len(flag.Roles) > 0 {
+		found := false
+		for _, role := range flag.Roles {
+			for _, reqRole := range flagCtx.Roles {
+				if role == reqRole {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(flag.Tenants) > 0 {
+		found := false
+		for _, tenantId := range flag.Tenants {
+			if flagCtx.TenantId != "" && tenantId == flagCtx.TenantId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// A FlagProvider backed by a static FlagConfig (unknown flags are off).  Can be updated with SetConfig.
+type StaticFlagProvider struct {
+	lock   *sync.Mutex
+	config FlagConfig
+}
+
+func MakeStaticFlagProvider(config FlagConfig) *StaticFlagProvider {
+	return &StaticFlagProvider{lock: &sync.Mutex{}, config: config}
+}
+
+func (p *StaticFlagProvider) SetConfig(config FlagConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.config = config
+}
+
+func (p *StaticFlagProvider) IsEnabled(flagName string, flagCtx FlagContext) (bool, error) {
+	p.lock.Lock()
+	flag, ok := p.config.Flags[flagName]
+	p.lock.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return flag.matches(flagCtx), nil
+}
+
+// A FlagProvider that polls a FlagConfig JSON file in the Dashborg FS.  Until the first
+// successful read all flags are off.
+type FSFlagProvider struct {
+	*StaticFlagProvider
+	client   *DashCloudClient
+	path     string
+	lastRead []byte
+}
+
+// Creates a FSFlagProvider reading the FlagConfig at app relative path (defaults to DefaultFlagsPath).
+// The file is read immediately (if connected) and then every pollInterval (defaults to
+// DefaultFlagsPollInterval) until shutdownCh is closed or the client shuts down.
+func (app *App) MakeFSFlagProvider(path string, pollInterval time.Duration, shutdownCh chan struct{}) (*FSFlagProvider, error) {
+	if path == "" {
+		path = DefaultFlagsPath
+	}
+	err := dashutil.Path(path).Validate()
+	if err != nil {
+		return nil, err
+	}
+	if pollInterval == 0 {
+		pollInterval = DefaultFlagsPollInterval
+	}
+	if pollInterval < minFlagsPollInterval {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Flags pollInterval must be at least %v", minFlagsPollInterval))
+	}
+	rtn := &FSFlagProvider{
+		StaticFlagProvider: MakeStaticFlagProvider(FlagConfig{}),
+		client:             app.client,
+		path:               app.AppPath() + path,
+	}
+	if app.client.IsConnected() {
+		err = rtn.Refresh()
+		if err != nil {
+			return nil, err
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !rtn.client.IsConnected() {
+					continue
+				}
+				err := rtn.Refresh()
+				if err != nil {
+					rtn.client.log("Dashborg error reading feature flags path:%s err:%v\n", rtn.path, err)
+				}
+
+			case <-shutdownCh:
+				return
+
+			case <-rtn.client.DoneCh:
+				return
+			}
+		}
+	}()
+	return rtn, nil
+}
+
+// Re-reads the flags file.  A missing file turns all flags off.
+func (p *FSFlagProvider) Refresh() error {
+	_, content, err := p.client.fileInfo(p.path, nil, true)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	unchanged := p.lastRead != nil && string(p.lastRead) == string(content)
+	p.lock.Unlock()
+	if unchanged {
+		return nil
+	}
+	var config FlagConfig
+	if len(content) > 0 {
+		err = json.Unmarshal(content, &config)
+		if err != nil {
+			return dasherr.JsonUnmarshalErr("FlagConfig", err)
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.config = config
+	p.lastRead = content
+	return nil
+}
+
+func (opts *FeatureFlagOpts) Validate() error {
+	if opts.Provider == nil {
+		return dasherr.ValidateErr(fmt.Errorf("FeatureFlagOpts requires a Provider"))
+	}
+	for _, hflag := range opts.Flags {
+		if !dashutil.IsPathFragValid(hflag.HandlerName) {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid HandlerFlag handler name '%s'", hflag.HandlerName))
+		}
+		if hflag.FlagName == "" {
+			return dasherr.ValidateErr(fmt.Errorf("HandlerFlag for '%s' requires a FlagName", hflag.HandlerName))
+		}
+		if hflag.Mode != "" && hflag.Mode != FlagModeDisable && hflag.Mode != FlagModeUnavailable && hflag.Mode != FlagModeSwitch {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid HandlerFlag mode '%s'", hflag.Mode))
+		}
+		if hflag.Mode == FlagModeSwitch && hflag.AltHandlerFn == nil {
+			return dasherr.ValidateErr(fmt.Errorf("HandlerFlag for '%s' in switch mode requires AltHandlerFn", hflag.HandlerName))
+		}
+	}
+	return nil
+}
+
+func makeFlagContext(req *AppRequest, handlerName string, tenantClaim string) FlagContext {
+	rtn := FlagContext{
+		AppName:     req.info.AppName,
+		HandlerName: handlerName,
+		FeClientId:  req.info.FeClientId,
+		Roles:       req.authData.GetRoleList(),
+	}
+	if req.authData != nil && req.authData.Data != nil {
+		rtn.TenantId, _ = req.authData.Data[tenantClaim].(string)
+	}
+	return rtn
+}
+
+// Creates a middleware that gates handlers with feature flags: disabling them, returning a
+// "feature unavailable" response, or switching to an alternate implementation.  Install with
+// AddRawMiddleware(FeatureFlagMwName, mw, FeatureFlagMwPriority).  Flag provider errors are
+// treated as the flag being off.
+func FeatureFlagMiddleware(opts FeatureFlagOpts) (MiddlewareFuncType, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.TenantClaim == "" {
+		opts.TenantClaim = DefaultFlagTenantClaim
+	}
+	flagMap := make(map[string]HandlerFlag)
+	for _, hflag := range opts.Flags {
+		flagMap[hflag.HandlerName] = hflag
+	}
+	mwFn := func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		_, _, handlerName, err := dashutil.ParseFullPath(req.info.Path, true)
+		if err != nil {
+			return nextFn(req)
+		}
+		if handlerName == "" {
+			handlerName = pathFragDefault
+		}
+		hflag, ok := flagMap[handlerName]
+		if !ok {
+			return nextFn(req)
+		}
+		enabled, err := opts.Provider.IsEnabled(hflag.FlagName, makeFlagContext(req, handlerName, opts.TenantClaim))
+		if err != nil {
+			if req.client != nil {
+				req.client.logPathV(req.info.Path, "Dashborg error checking feature flag '%s': %v\n", hflag.FlagName, err)
+			}
+			enabled = false
+		}
+		switch hflag.Mode {
+		case FlagModeSwitch:
+			if enabled {
+				return hflag.AltHandlerFn(req)
+			}
+			return nextFn(req)
+
+		case FlagModeUnavailable:
+			if enabled {
+				return nextFn(req)
+			}
+			if hflag.UnavailableResponse != nil {
+				return hflag.UnavailableResponse, nil
+			}
+			return map[string]interface{}{"unavailable": true, "message": "Feature unavailable"}, nil
+
+		default:
+			if enabled {
+				return nextFn(req)
+			}
+			return nil, dasherr.ErrWithCode(dasherr.ErrCodeNoHandler, fmt.Errorf("Handler '%s' is disabled (feature flag '%s')", handlerName, hflag.FlagName))
+		}
+	}
+	return mwFn, nil
+}
+
+// Installs a FeatureFlagMiddleware on the app's runtime.
+func (app *App) SetFeatureFlags(opts FeatureFlagOpts) error {
+	mwFn, err := FeatureFlagMiddleware(opts)
+	if err != nil {
+		return err
+	}
+	app.appRuntime.AddRawMiddleware(FeatureFlagMwName, mwFn, FeatureFlagMwPriority)
+	return nil
+}
@@ -0,0 +1,145 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const DefaultUploadRetries = 2
+const DefaultUploadRetryWait = time.Second
+
+// A single path to upload with DashFSClient.UploadBatch.  Set exactly one of FileName or Data
+// (both can be re-read, so failed uploads can be retried).
+type UploadItem struct {
+	Path     string
+	FileName string
+	Data     []byte
+	FileOpts *FileOpts
+}
+
+// Options for DashFSClient.UploadBatch.
+type UploadBatchOpts struct {
+	MaxRetries int           // retries per item for retryable errors (defaults to DefaultUploadRetries, -1 for none)
+	RetryWait  time.Duration // wait before the first retry, doubles on each retry (defaults to DefaultUploadRetryWait)
+
+	// Called (serially) after each item finishes.
+	ProgressFn func(progress UploadProgress)
+}
+
+// Passed to UploadBatchOpts.ProgressFn after each item completes.
+type UploadProgress struct {
+	Path      string
+	Err       error
+	NumDone   int // items finished (including failures)
+	NumFailed int
+	Total     int
+}
+
+type UploadItemResult struct {
+	Path     string
+	Attempts int
+	Err      error
+}
+
+// Result of DashFSClient.UploadBatch, items are in the same order as passed in.
+type UploadBatchResult struct {
+	Items     []UploadItemResult
+	NumOk     int
+	NumFailed int
+}
+
+// Returns nil if every item uploaded, otherwise a summary error with each failed path.
+func (r *UploadBatchResult) Err() error {
+	if r.NumFailed == 0 {
+		return nil
+	}
+	var errs []error
+	for _, item := range r.Items {
+		if item.Err != nil {
+			errs = append(errs, fmt.Errorf("path:%s (attempts:%d): %w", item.Path, item.Attempts, item.Err))
+		}
+	}
+	return fmt.Errorf("%d of %d uploads failed: %w", r.NumFailed, len(r.Items), dashutil.ConvertErrArray(errs))
+}
+
+func (item *UploadItem) Validate() error {
+	if (item.FileName == "") == (item.Data == nil) {
+		return dasherr.ValidateErr(fmt.Errorf("UploadItem path:%s must set exactly one of FileName or Data", item.Path))
+	}
+	return dashutil.Path(item.Path).Validate()
+}
+
+func (fs *DashFSClient) uploadItem(item *UploadItem) error {
+	if item.FileName != "" {
+		return fs.SetPathFromFile(item.Path, item.FileName, item.FileOpts)
+	}
+	return fs.SetStaticPath(item.Path, bytes.NewReader(item.Data), item.FileOpts)
+}
+
+// Uploads many paths in parallel (at most concurrency at a time, defaults to
+// DefaultBatchParallelism).  Each item is retried (with backoff) on retryable errors.  Every
+// item is attempted, check UploadBatchResult.Err() for a summary of the failures.  opts may be nil.
+// Usage: result := fs.UploadBatch(items, 8, &dash.UploadBatchOpts{ProgressFn: printProgress})
+func (fs *DashFSClient) UploadBatch(items []UploadItem, concurrency int, opts *UploadBatchOpts) *UploadBatchResult {
+	if opts == nil {
+		opts = &UploadBatchOpts{}
+	}
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultUploadRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+	retryWait := opts.RetryWait
+	if retryWait <= 0 {
+		retryWait = DefaultUploadRetryWait
+	}
+	rtn := &UploadBatchResult{Items: make([]UploadItemResult, len(items))}
+	var progressLock sync.Mutex
+	finishItem := func(idx int, attempts int, err error) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		rtn.Items[idx] = UploadItemResult{Path: items[idx].Path, Attempts: attempts, Err: err}
+		if err != nil {
+			rtn.NumFailed++
+		} else {
+			rtn.NumOk++
+		}
+		if opts.ProgressFn != nil {
+			opts.ProgressFn(UploadProgress{Path: items[idx].Path, Err: err, NumDone: rtn.NumOk + rtn.NumFailed, NumFailed: rtn.NumFailed, Total: len(items)})
+		}
+	}
+	batchOpts := &BatchOpts{Parallelism: concurrency}
+	runBatch(len(items), batchOpts.parallelism(), func(idx int) {
+		item := &items[idx]
+		err := item.Validate()
+		if err != nil {
+			finishItem(idx, 0, err)
+			return
+		}
+		wait := retryWait
+		attempts := 0
+	retryLoop:
+		for {
+			attempts++
+			err = fs.uploadItem(item)
+			if err == nil || !dasherr.CanRetry(err) || attempts > maxRetries {
+				break
+			}
+			fs.client.logPathV(fs.rootPath+item.Path, "Dashborg UploadBatch retrying path:%s attempt:%d err:%v\n", item.Path, attempts, err)
+			select {
+			case <-time.After(wait):
+			case <-fs.client.DoneCh:
+				break retryLoop
+			}
+			wait *= 2
+		}
+		finishItem(idx, attempts, err)
+	})
+	return rtn
+}
@@ -0,0 +1,185 @@
+package dash
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const handlerStructTag = "dash"
+
+// per-method options parsed from `dash:"..."` struct tags
+type methodTagOpts struct {
+	name string
+	skip bool
+	opts HandlerOpts
+}
+
+// Converts a Go method name to a kebab-cased handler name ("GetHTTPStatus" => "get-http-status").
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var buf strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				buf.WriteRune('-')
+			}
+		}
+		buf.WriteRune(unicode.ToLower(r))
+	}
+	return buf.String()
+}
+
+// tag format: "method=[MethodName],pure,hidden,heavy,skip,name=[handler-name],display=[display],priority=[int]"
+func parseMethodTag(tag string) (string, *methodTagOpts, error) {
+	var methodName string
+	rtn := &methodTagOpts{}
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, val := part, ""
+		if eqIdx := strings.Index(part, "="); eqIdx != -1 {
+			key, val = part[:eqIdx], part[eqIdx+1:]
+		}
+		switch key {
+		case "method":
+			methodName = val
+		case "name":
+			rtn.name = val
+		case "display":
+			rtn.opts.Display = val
+		case "pure":
+			rtn.opts.PureHandler = true
+		case "hidden":
+			rtn.opts.Hidden = true
+		case "heavy":
+			rtn.opts.Heavy = true
+		case "skip":
+			rtn.skip = true
+		case "priority":
+			priority, err := strconv.Atoi(val)
+			if err != nil {
+				return "", nil, fmt.Errorf("Invalid priority '%s'", val)
+			}
+			rtn.opts.Priority = priority
+		default:
+			return "", nil, fmt.Errorf("Invalid tag option '%s'", key)
+		}
+	}
+	if methodName == "" {
+		return "", nil, fmt.Errorf("Tag requires method=[MethodName]")
+	}
+	return methodName, rtn, nil
+}
+
+func parseMethodTags(structType reflect.Type) (map[string]*methodTagOpts, error) {
+	rtn := make(map[string]*methodTagOpts)
+	for i := 0; i < structType.NumField(); i++ {
+		tag, ok := structType.Field(i).Tag.Lookup(handlerStructTag)
+		if !ok {
+			continue
+		}
+		methodName, tagOpts, err := parseMethodTag(tag)
+		if err != nil {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid `%s` tag on %v field %d: %w", handlerStructTag, structType, i, err))
+		}
+		rtn[methodName] = tagOpts
+	}
+	return rtn, nil
+}
+
+// registers every exported method with a valid handler signature, returns any setup errors
+func handlerStructInternal(rti runtimeImplIf, prefix string, svc interface{}, isAppRuntime bool) error {
+	if prefix != "" && !dashutil.IsPathFragValid(prefix) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid HandlerStruct prefix '%s'", prefix))
+	}
+	svcVal := reflect.ValueOf(svc)
+	if !svcVal.IsValid() || (svcVal.Kind() == reflect.Ptr && svcVal.IsNil()) {
+		return dasherr.ValidateErr(fmt.Errorf("HandlerStruct svc cannot be nil"))
+	}
+	structType := svcVal.Type()
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return dasherr.ValidateErr(fmt.Errorf("HandlerStruct svc must be a struct or a pointer to a struct, got %v", svcVal.Type()))
+	}
+	methodTags, err := parseMethodTags(structType)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	svcType := svcVal.Type()
+	for i := 0; i < svcType.NumMethod(); i++ {
+		method := svcType.Method(i)
+		tagOpts, tagged := methodTags[method.Name]
+		delete(methodTags, method.Name)
+		if !tagged {
+			tagOpts = &methodTagOpts{}
+		}
+		if tagOpts.skip {
+			continue
+		}
+		handlerFn := svcVal.Method(i).Interface()
+		err = validateHandler(reflect.TypeOf(handlerFn), isAppRuntime, tagOpts.opts.PureHandler, rti.getStateType())
+		if err != nil {
+			if !tagged {
+				// untagged methods that are not handlers are ignored
+				continue
+			}
+			errs = append(errs, fmt.Errorf("HandlerStruct method %s: %w", method.Name, err))
+			continue
+		}
+		name := tagOpts.name
+		if name == "" {
+			name = kebabCase(method.Name)
+			if prefix != "" {
+				name = prefix + "-" + name
+			}
+		}
+		err = handlerInternal(rti, name, handlerFn, isAppRuntime, tagOpts.opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("HandlerStruct method %s (handler '%s'): %w", method.Name, name, err))
+		}
+	}
+	for methodName := range methodTags {
+		errs = append(errs, dasherr.ValidateErr(fmt.Errorf("HandlerStruct `%s` tag references unknown or unexported method '%s'", handlerStructTag, methodName)))
+	}
+	return dashutil.ConvertErrArray(errs)
+}
+
+// Registers every exported method of svc that has a valid handler signature (see Handler)
+// as a handler named "[prefix]-[kebab-cased-method-name]" (just the method name if prefix is
+// empty).  Methods that are not valid handlers are ignored.  Per-method options are set with
+// `dash` tags on (blank) struct fields: method=[MethodName] plus any of pure, hidden, heavy,
+// skip, name=[handler-name], display=[display], priority=[int].  e.g. the field
+// _ struct{} `dash:"method=ListOrders,pure"` registers ListOrders as a pure handler.
+// Usage: app.Runtime().HandlerStruct("orders", &OrderService{DB: db}) // => "orders-list-orders", ...
+func (apprt *AppRuntimeImpl) HandlerStruct(prefix string, svc interface{}) {
+	err := handlerStructInternal(apprt, prefix, svc, true)
+	if err != nil {
+		apprt.addError(err)
+	}
+}
+
+// Registers the exported methods of svc as handlers (see AppRuntimeImpl.HandlerStruct).
+func (linkrt *LinkRuntimeImpl) HandlerStruct(prefix string, svc interface{}) {
+	err := handlerStructInternal(linkrt, prefix, svc, false)
+	if err != nil {
+		linkrt.addError(err)
+	}
+}
+
+// Registers the exported methods of svc as handlers on the app's runtime (see AppRuntimeImpl.HandlerStruct).
+func (app *App) HandlerStruct(prefix string, svc interface{}) {
+	app.appRuntime.HandlerStruct(prefix, svc)
+}
@@ -0,0 +1,245 @@
+package dash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// RRAction type sent for a changed HTML region.  Selector is "#" + the element id, Html is
+// the region's new outer HTML.
+const HtmlPatchActionType = "htmlpatch"
+
+// maximum number of frontend clients whose last HTML is kept (least recently rendered are dropped)
+const htmlDiffMaxClients = 100
+
+// the hash of the rendered HTML is set at this path with each render
+const htmlHashPath = "$state.dashborg.htmlhash"
+
+var htmlTagRe = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)([^>]*?)(/?)>`)
+var htmlIdAttrRe = regexp.MustCompile(`(?:^|\s)id\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+var htmlVoidTags = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// HTML split into its outermost id'd elements (regions) and the markup around them (skeleton)
+type htmlRegions struct {
+	skeleton string
+	ids      []string
+	regions  map[string]string // id => outer html
+}
+
+type htmlDiffEntry struct {
+	regions *htmlRegions
+	hash    string
+	ts      int64
+}
+
+type htmlDiffState struct {
+	lock    *sync.Mutex
+	clients map[string]*htmlDiffEntry // feclientid => last html sent
+}
+
+func makeHtmlDiffState() *htmlDiffState {
+	return &htmlDiffState{lock: &sync.Mutex{}, clients: make(map[string]*htmlDiffEntry)}
+}
+
+func htmlTagId(attrs string) string {
+	match := htmlIdAttrRe.FindStringSubmatch(attrs)
+	if match == nil {
+		return ""
+	}
+	return match[1] + match[2]
+}
+
+// returns nil if the html cannot be split (duplicate ids or unbalanced region tags)
+func splitHtmlRegions(html string) *htmlRegions {
+	rtn := &htmlRegions{regions: make(map[string]string)}
+	var skeleton strings.Builder
+	pos := 0
+	regionStart, regionDepth := -1, 0
+	var regionTag, regionId string
+	for _, loc := range htmlTagRe.FindAllStringSubmatchIndex(html, -1) {
+		isClose := loc[3] > loc[2]
+		tagName := strings.ToLower(html[loc[4]:loc[5]])
+		selfClose := loc[9] > loc[8] || htmlVoidTags[tagName]
+		if regionStart == -1 {
+			if isClose || selfClose {
+				continue
+			}
+			id := htmlTagId(html[loc[6]:loc[7]])
+			if id == "" {
+				continue
+			}
+			if _, dup := rtn.regions[id]; dup {
+				return nil
+			}
+			regionStart, regionDepth, regionTag, regionId = loc[0], 1, tagName, id
+			continue
+		}
+		if tagName != regionTag || selfClose {
+			continue
+		}
+		if !isClose {
+			regionDepth++
+			continue
+		}
+		regionDepth--
+		if regionDepth > 0 {
+			continue
+		}
+		skeleton.WriteString(html[pos:regionStart])
+		skeleton.WriteString("<!--region:" + regionId + "-->")
+		rtn.regions[regionId] = html[regionStart:loc[1]]
+		rtn.ids = append(rtn.ids, regionId)
+		pos = loc[1]
+		regionStart = -1
+	}
+	if regionStart != -1 {
+		return nil
+	}
+	skeleton.WriteString(html[pos:])
+	rtn.skeleton = skeleton.String()
+	return rtn
+}
+
+// returns the htmlpatch actions to turn prev into cur, false if a full render is required
+// (different skeleton or set of regions)
+func diffHtmlRegions(prev *htmlRegions, cur *htmlRegions) ([]*dashproto.RRAction, bool) {
+	if prev == nil || cur == nil || prev.skeleton != cur.skeleton || len(prev.ids) != len(cur.ids) {
+		return nil, false
+	}
+	var rtn []*dashproto.RRAction
+	for _, id := range cur.ids {
+		prevHtml, ok := prev.regions[id]
+		if !ok {
+			return nil, false
+		}
+		if prevHtml == cur.regions[id] {
+			continue
+		}
+		rtn = append(rtn, &dashproto.RRAction{
+			Ts:         dashutil.Ts(),
+			ActionType: HtmlPatchActionType,
+			Selector:   "#" + id,
+			Html:       cur.regions[id],
+		})
+	}
+	return rtn, true
+}
+
+// returns the previous entry for the client
+func (state *htmlDiffState) swap(feClientId string, regions *htmlRegions, hash string) *htmlDiffEntry {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	prev := state.clients[feClientId]
+	if prev == nil && len(state.clients) >= htmlDiffMaxClients {
+		var oldestId string
+		var oldestTs int64
+		for id, entry := range state.clients {
+			if oldestTs == 0 || entry.ts < oldestTs {
+				oldestId, oldestTs = id, entry.ts
+			}
+		}
+		delete(state.clients, oldestId)
+	}
+	if regions == nil {
+		delete(state.clients, feClientId)
+		return prev
+	}
+	state.clients[feClientId] = &htmlDiffEntry{regions: regions, hash: hash, ts: dashutil.Ts()}
+	return prev
+}
+
+func htmlHash(htmlBytes []byte) string {
+	hashVal := sha256.Sum256(htmlBytes)
+	return hex.EncodeToString(hashVal[0:12])
+}
+
+// the hash of the HTML the frontend is currently showing, sent (as "htmlbase" in the request
+// data) only by frontends that apply htmlpatch actions
+func requestHtmlBase(req *AppRequest) string {
+	if req.rawData.DataJson == "" {
+		return ""
+	}
+	var data struct {
+		HtmlBase string `json:"htmlbase"`
+	}
+	if json.Unmarshal([]byte(req.rawData.DataJson), &data) != nil {
+		return ""
+	}
+	return data.HtmlBase
+}
+
+// converts the HTML handler's return value into htmlpatch actions when only id'd regions
+// changed since the last render for the request's frontend client, and the frontend is showing
+// that render (its htmlbase matches).  otherwise returns the full HTML (as a BlobReturn).
+func (state *htmlDiffState) diffResponse(req *AppRequest, rtnVal interface{}) (interface{}, error) {
+	var blob *BlobReturn
+	switch rv := rtnVal.(type) {
+	case BlobReturn:
+		blob = &rv
+	case *BlobReturn:
+		blob = rv
+	}
+	if blob == nil || blob.Reader == nil || !strings.HasPrefix(blob.MimeType, "text/html") || req.info.FeClientId == "" {
+		return rtnVal, nil
+	}
+	htmlBytes, err := ioutil.ReadAll(blob.Reader)
+	if err != nil {
+		return nil, err
+	}
+	fullRtn := &BlobReturn{Reader: bytes.NewReader(htmlBytes), MimeType: blob.MimeType}
+	hash := htmlHash(htmlBytes)
+	cur := splitHtmlRegions(string(htmlBytes))
+	prev := state.swap(req.info.FeClientId, cur, hash)
+	req.SetData(htmlHashPath, hash)
+	htmlBase := requestHtmlBase(req)
+	if prev == nil || htmlBase == "" || htmlBase != prev.hash {
+		return fullRtn, nil
+	}
+	patches, ok := diffHtmlRegions(prev.regions, cur)
+	if !ok || len(patches) == 0 {
+		return fullRtn, nil
+	}
+	for _, rra := range patches {
+		req.appendRR(rra)
+	}
+	return nil, nil
+}
+
+// Enables differential updates for the dynamic HTML handler (see SetHtmlHandler).  The last
+// HTML sent to each frontend client is kept, and when a re-render only changes elements with
+// an id attribute (the outermost id'd elements are compared), only those elements are sent
+// as "htmlpatch" actions instead of the full HTML.  Each render sets its hash at
+// $state.dashborg.htmlhash, and patches are only sent to frontends that echo that hash back as
+// "htmlbase" in the HTML request data, so frontends that do not support htmlpatch actions (or
+// are showing a different render) always get the full HTML.  Any other change (or no change)
+// sends the full HTML.
+// Usage: app.Runtime().SetHtmlDiff(true)
+func (apprt *AppRuntimeImpl) SetHtmlDiff(enabled bool) {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	if !enabled {
+		apprt.htmlDiff = nil
+		return
+	}
+	if apprt.htmlDiff == nil {
+		apprt.htmlDiff = makeHtmlDiffState()
+	}
+}
+
+// Enables differential HTML updates (see AppRuntimeImpl.SetHtmlDiff).
+func (app *App) SetHtmlDiff(enabled bool) {
+	app.appRuntime.SetHtmlDiff(enabled)
+}
@@ -0,0 +1,142 @@
+package dash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+var lintAttrRe = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9:_.-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+var lintHandlerRefRe = regexp.MustCompile(`/@app:([a-zA-Z0-9_.-]+)`)
+var lintDataRefRe = regexp.MustCompile(`\$\.[a-zA-Z_][a-zA-Z0-9_]*(?:\.[a-zA-Z_][a-zA-Z0-9_]*|\[[0-9]+\])*`)
+var lintDataAssignRe = regexp.MustCompile(`(\$\.[a-zA-Z_][a-zA-Z0-9_]*(?:\.[a-zA-Z_][a-zA-Z0-9_]*|\[[0-9]+\])*)\s*=[^=]`)
+
+// A problem found by LintHtml.  Line is 1-based.
+type HtmlLintIssue struct {
+	Line    int    `json:"line"`
+	Attr    string `json:"attr"`
+	Ref     string `json:"ref"`
+	Message string `json:"message"`
+}
+
+func (issue HtmlLintIssue) String() string {
+	return fmt.Sprintf("line %d %s: %s", issue.Line, issue.Attr, issue.Message)
+}
+
+// Options for LintHtml.  All fields are optional.
+type HtmlLintOpts struct {
+	// Example of the app's root data ($), e.g. what the handlers set.  Data paths that are
+	// not assigned in the HTML are checked against it.  If nil, only paths assigned by the
+	// HTML (handler="$.x = ...") are known.
+	SampleData interface{}
+
+	// Extra data paths set by the backend (e.g. with req.SetData), "$.user" also allows "$.user.name".
+	KnownDataPaths []string
+
+	// Handler names that exist outside of this runtime (e.g. an external runtime).
+	KnownHandlers []string
+
+	HandlersOnly bool // only check handler references (used by Preflight)
+}
+
+type htmlLintRef struct {
+	line  int
+	attr  string
+	value string
+}
+
+func lintLineNum(html string, offset int) int {
+	return strings.Count(html[0:offset], "\n") + 1
+}
+
+// a data path is known if it is (or is under, or is a parent of) a known path
+func isLintDataPathKnown(ref string, known []string) bool {
+	for _, k := range known {
+		if ref == k || strings.HasPrefix(ref, k+".") || strings.HasPrefix(ref, k+"[") || strings.HasPrefix(k, ref+".") || strings.HasPrefix(k, ref+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses Dashborg HTML and cross-checks its handler references (/@app:handler) and root data
+// bindings ($.path) against the registered handlers and known data.  hasHandler is called
+// with the handler name (nil skips the handler checks).  Relative bindings (".name") and
+// app state ($state) are not checked.  Meant for development and CI, not every request.
+// Usage: issues := dash.LintHtml(htmlStr, func(name string) bool { return name == "snapshot" }, nil)
+func LintHtml(html string, hasHandler func(name string) bool, opts *HtmlLintOpts) []HtmlLintIssue {
+	if opts == nil {
+		opts = &HtmlLintOpts{}
+	}
+	knownHandlers := make(map[string]bool)
+	for _, name := range opts.KnownHandlers {
+		knownHandlers[name] = true
+	}
+	var refs []htmlLintRef
+	knownData := append([]string{}, opts.KnownDataPaths...)
+	for _, loc := range lintAttrRe.FindAllStringSubmatchIndex(html, -1) {
+		ref := htmlLintRef{line: lintLineNum(html, loc[0]), attr: html[loc[2]:loc[3]]}
+		if loc[4] != -1 {
+			ref.value = html[loc[4]:loc[5]]
+		} else {
+			ref.value = html[loc[6]:loc[7]]
+		}
+		refs = append(refs, ref)
+		for _, match := range lintDataAssignRe.FindAllStringSubmatch(ref.value, -1) {
+			knownData = append(knownData, match[1])
+		}
+	}
+	var rtn []HtmlLintIssue
+	for _, ref := range refs {
+		for _, match := range lintHandlerRefRe.FindAllStringSubmatch(ref.value, -1) {
+			name := match[1]
+			if hasHandler == nil || knownHandlers[name] || hasHandler(name) {
+				continue
+			}
+			rtn = append(rtn, HtmlLintIssue{Line: ref.line, Attr: ref.attr, Ref: match[0], Message: fmt.Sprintf("no handler registered for '%s'", match[0])})
+		}
+		if opts.HandlersOnly {
+			continue
+		}
+		for _, dataRef := range lintDataRefRe.FindAllString(ref.value, -1) {
+			if isLintDataPathKnown(dataRef, knownData) {
+				continue
+			}
+			if opts.SampleData != nil && lookupDataPath(opts.SampleData, dataRef).Exists() {
+				continue
+			}
+			rtn = append(rtn, HtmlLintIssue{Line: ref.line, Attr: ref.attr, Ref: dataRef, Message: fmt.Sprintf("data path '%s' is never set (not assigned in the HTML or found in the known data)", dataRef)})
+		}
+	}
+	sort.SliceStable(rtn, func(i int, j int) bool {
+		return rtn[i].Line < rtn[j].Line
+	})
+	return rtn
+}
+
+// Lints the app's HTML (set with SetHtml or SetHtmlFromFile) against its runtime's handlers
+// (see LintHtml).  Apps with an external runtime only have their data bindings checked
+// (pass the handler names in opts.KnownHandlers).  Preflight reports missing handlers as warnings.
+// Usage: issues, err := app.LintHtml(&dash.HtmlLintOpts{SampleData: map[string]interface{}{"user": user}})
+func (app *App) LintHtml(opts *HtmlLintOpts) ([]HtmlLintIssue, error) {
+	htmlStr := app.htmlStr
+	if htmlStr == "" && app.htmlFileName != "" {
+		htmlBytes, err := ioutil.ReadFile(app.htmlFileName)
+		if err != nil {
+			return nil, err
+		}
+		htmlStr = string(htmlBytes)
+	}
+	if htmlStr == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("App '%s' has no local HTML to lint (see SetHtml, SetHtmlFromFile)", app.appName))
+	}
+	var hasHandler func(string) bool
+	if app.appRuntime != nil && !app.HasExternalRuntime() {
+		hasHandler = app.appRuntime.hasHandler
+	}
+	return LintHtml(htmlStr, hasHandler, opts), nil
+}
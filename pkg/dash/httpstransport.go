@@ -0,0 +1,357 @@
+package dash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	TransportGrpc  = "grpc"
+	TransportHttps = "https"
+)
+
+// rpc methods are POSTed to https://[ConsoleHost]/api2/rpc1/[Method]
+const httpsRpcPath = "/api2/rpc1"
+
+// service-auth JWTs use their own audience so they cannot be used as frontend login tokens
+const httpsJwtAudience = "dashborg-rpc"
+const httpsJwtValidFor = time.Hour
+const httpsJwtRefresh = 5 * time.Minute
+const maxHttpsResponseBytes = 100 * 1024 * 1024
+
+// SSE event names on the RequestStream response (messages without an event name are requests)
+const (
+	httpsEventRequest = "request"
+	httpsEventEnd     = "end"
+)
+
+// Implements dashproto.DashborgServiceClient over HTTPS (for networks that block gRPC or
+// client-certificate TLS).  Messages are sent as protojson, authenticated with a short-lived
+// account JWT (signed with the client's private key), and the RequestStream is read as
+// server-sent events.  gRPC metadata is sent as HTTP headers.  Experimental, the wire
+// protocol is not stable (see Config.Transport).
+type httpsServiceClient struct {
+	pc         *DashCloudClient
+	baseUrl    string
+	httpClient *http.Client
+	lock       *sync.Mutex
+	jwtToken   string
+	jwtExpires time.Time
+	closed     bool
+	closeCh    chan struct{}
+}
+
+func (pc *DashCloudClient) connectHttps() error {
+	transport, err := pc.Config.httpTransport()
+	if err != nil {
+		return err
+	}
+	hc := &httpsServiceClient{
+		pc:         pc,
+		baseUrl:    fmt.Sprintf("https://%s%s", pc.Config.ConsoleHost, httpsRpcPath),
+		httpClient: &http.Client{Transport: transport}, // no Timeout, calls are bounded by their contexts
+		lock:       &sync.Mutex{},
+		closeCh:    make(chan struct{}),
+	}
+	pc.httpsConn = hc
+	pc.setServiceConn(nil, hc)
+	_, err = hc.getJwt()
+	return err
+}
+
+func (hc *httpsServiceClient) getJwt() (string, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if hc.jwtToken != "" && time.Until(hc.jwtExpires) > httpsJwtRefresh {
+		return hc.jwtToken, nil
+	}
+	jwtToken, err := hc.pc.Config.MakeAccountJWT(&JWTOpts{ValidFor: httpsJwtValidFor, Role: RoleSuper, Audience: httpsJwtAudience})
+	if err != nil {
+		return "", fmt.Errorf("Cannot create JWT for https transport: %w", err)
+	}
+	hc.jwtToken = jwtToken
+	hc.jwtExpires = time.Now().Add(httpsJwtValidFor)
+	return jwtToken, nil
+}
+
+// forces a new JWT (signed with the reloaded key) on the next call
+func (hc *httpsServiceClient) resetJwt() {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	hc.jwtToken = ""
+}
+
+func (hc *httpsServiceClient) isClosed() bool {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	return hc.closed
+}
+
+func (hc *httpsServiceClient) Close() error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if hc.closed {
+		return nil
+	}
+	hc.closed = true
+	close(hc.closeCh)
+	hc.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// returns a context that is also canceled when the transport is closed
+func (hc *httpsServiceClient) callCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancelFn := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-hc.closeCh:
+			cancelFn()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancelFn
+}
+
+func httpsStatusErr(method string, statusCode int, body []byte) error {
+	code := codes.Internal
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case statusCode == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case statusCode == http.StatusNotFound:
+		code = codes.Unimplemented
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout:
+		code = codes.Unavailable
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[0:200] + "..."
+	}
+	return status.Errorf(code, "https %s returned %d %s", method, statusCode, msg)
+}
+
+func (hc *httpsServiceClient) makeRequest(ctx context.Context, method string, in proto.Message) (*http.Request, error) {
+	if hc.isClosed() {
+		return nil, status.Error(codes.Canceled, "https transport is closed")
+	}
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error marshaling %s message: %v", method, err)
+	}
+	jwtToken, err := hc.getJwt()
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", hc.baseUrl+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwtToken)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for key, vals := range md {
+		for _, val := range vals {
+			req.Header.Add(key, val)
+		}
+	}
+	return req, nil
+}
+
+func (hc *httpsServiceClient) invoke(ctx context.Context, method string, in proto.Message, out proto.Message) error {
+	ctx, cancelFn := hc.callCtx(ctx)
+	defer cancelFn()
+	req, err := hc.makeRequest(ctx, method, in)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.httpClient.Do(req)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "https %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHttpsResponseBytes))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "https %s reading response: %v", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return httpsStatusErr(method, resp.StatusCode, respBody)
+	}
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(respBody, out)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Error unmarshaling %s response: %v", method, err)
+	}
+	return nil
+}
+
+func (hc *httpsServiceClient) ConnectClient(ctx context.Context, in *dashproto.ConnectClientMessage, opts ...grpc.CallOption) (*dashproto.ConnectClientResponse, error) {
+	out := &dashproto.ConnectClientResponse{}
+	return out, hc.invoke(ctx, "ConnectClient", in, out)
+}
+
+func (hc *httpsServiceClient) SendResponse(ctx context.Context, in *dashproto.SendResponseMessage, opts ...grpc.CallOption) (*dashproto.SendResponseResponse, error) {
+	out := &dashproto.SendResponseResponse{}
+	return out, hc.invoke(ctx, "SendResponse", in, out)
+}
+
+func (hc *httpsServiceClient) SetPath(ctx context.Context, in *dashproto.SetPathMessage, opts ...grpc.CallOption) (*dashproto.SetPathResponse, error) {
+	out := &dashproto.SetPathResponse{}
+	return out, hc.invoke(ctx, "SetPath", in, out)
+}
+
+func (hc *httpsServiceClient) RemovePath(ctx context.Context, in *dashproto.RemovePathMessage, opts ...grpc.CallOption) (*dashproto.RemovePathResponse, error) {
+	out := &dashproto.RemovePathResponse{}
+	return out, hc.invoke(ctx, "RemovePath", in, out)
+}
+
+func (hc *httpsServiceClient) FileInfo(ctx context.Context, in *dashproto.FileInfoMessage, opts ...grpc.CallOption) (*dashproto.FileInfoResponse, error) {
+	out := &dashproto.FileInfoResponse{}
+	return out, hc.invoke(ctx, "FileInfo", in, out)
+}
+
+func (hc *httpsServiceClient) ConnectLink(ctx context.Context, in *dashproto.ConnectLinkMessage, opts ...grpc.CallOption) (*dashproto.ConnectLinkResponse, error) {
+	out := &dashproto.ConnectLinkResponse{}
+	return out, hc.invoke(ctx, "ConnectLink", in, out)
+}
+
+func (hc *httpsServiceClient) RequestStream(ctx context.Context, in *dashproto.RequestStreamMessage, opts ...grpc.CallOption) (dashproto.DashborgService_RequestStreamClient, error) {
+	ctx, cancelFn := hc.callCtx(ctx)
+	req, err := hc.makeRequest(ctx, "RequestStream", in)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := hc.httpClient.Do(req)
+	if err != nil {
+		cancelFn()
+		return nil, status.Errorf(codes.Unavailable, "https RequestStream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		cancelFn()
+		return nil, httpsStatusErr("RequestStream", resp.StatusCode, respBody)
+	}
+	return &httpsRequestStream{ctx: ctx, cancelFn: cancelFn, resp: resp, reader: bufio.NewReader(resp.Body)}, nil
+}
+
+// dashproto.DashborgService_RequestStreamClient reading server-sent events
+type httpsRequestStream struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+	resp     *http.Response
+	reader   *bufio.Reader
+}
+
+func (s *httpsRequestStream) endStream(err error) error {
+	s.cancelFn()
+	s.resp.Body.Close()
+	if err != io.EOF && s.ctx.Err() != nil {
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+	return err
+}
+
+func (s *httpsRequestStream) Recv() (*dashproto.RequestMessage, error) {
+	var eventName string
+	var data bytes.Buffer
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				err = status.Errorf(codes.Unavailable, "https RequestStream: %v", err)
+			}
+			return nil, s.endStream(err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				eventName = ""
+				continue
+			}
+			if eventName == httpsEventEnd {
+				return nil, s.endStream(io.EOF)
+			}
+			if eventName != "" && eventName != httpsEventRequest {
+				eventName = ""
+				data.Reset()
+				continue
+			}
+			rtn := &dashproto.RequestMessage{}
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data.Bytes(), rtn)
+			if err != nil {
+				return nil, s.endStream(status.Errorf(codes.Internal, "Error unmarshaling RequestStream message: %v", err))
+			}
+			return rtn, nil
+
+		case strings.HasPrefix(line, ":"):
+			// comment (keepalive)
+			continue
+
+		case strings.HasPrefix(line, "event:"):
+			eventName = strings.TrimSpace(line[len("event:"):])
+
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(line[len("data:"):], " "))
+		}
+	}
+}
+
+func (s *httpsRequestStream) Header() (metadata.MD, error) {
+	md := metadata.MD{}
+	for key, vals := range s.resp.Header {
+		md.Append(key, vals...)
+	}
+	return md, nil
+}
+
+func (s *httpsRequestStream) Trailer() metadata.MD {
+	return nil
+}
+
+func (s *httpsRequestStream) CloseSend() error {
+	return nil
+}
+
+func (s *httpsRequestStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *httpsRequestStream) SendMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "https RequestStream is receive only")
+}
+
+func (s *httpsRequestStream) RecvMsg(m interface{}) error {
+	reqMsg, ok := m.(*dashproto.RequestMessage)
+	if !ok {
+		return status.Errorf(codes.Internal, "https RequestStream cannot receive into %T", m)
+	}
+	rtn, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Reset(reqMsg)
+	proto.Merge(reqMsg, rtn)
+	return nil
+}
@@ -0,0 +1,178 @@
+package dash
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	RuntimeTypeApp  = "app"
+	RuntimeTypeLink = "link"
+)
+
+// Handler schema (name, options, parameter and return types), as reported by Introspect.
+type HandlerInfo = runtimeHandlerInfo
+
+// Type schema for handler parameters/return values and app state, as reported by Introspect.
+type TypeInfo = runtimeTypeInfo
+
+type MiddlewareInfo struct {
+	Name     string  `json:"name"`
+	Priority float64 `json:"priority"`
+}
+
+// A connected app or linked runtime.
+type RuntimeInfo struct {
+	Path        string           `json:"path"`
+	Type        string           `json:"type"`              // RuntimeTypeApp or RuntimeTypeLink
+	AppName     string           `json:"appname,omitempty"` // set for apps
+	AppState    *TypeInfo        `json:"appstate,omitempty"`
+	Pages       []string         `json:"pages,omitempty"`
+	Middlewares []MiddlewareInfo `json:"middlewares"`
+	Handlers    []*HandlerInfo   `json:"handlers"`
+}
+
+// Machine-readable catalog of the client's runtimes, returned by Introspect (and served by
+// IntrospectHandler).
+type IntrospectInfo struct {
+	AccId     string         `json:"accid"`
+	ZoneName  string         `json:"zonename"`
+	ProcName  string         `json:"procname"`
+	ProcRunId string         `json:"procrunid"`
+	StartTs   int64          `json:"startts"`
+	Connected bool           `json:"connected"`
+	Runtimes  []*RuntimeInfo `json:"runtimes"`
+}
+
+// implemented by *AppRuntimeImpl and *LinkRuntimeImpl
+type introspectable interface {
+	introspect() *RuntimeInfo
+}
+
+func middlewareInfos(mws []middlewareType) []MiddlewareInfo {
+	rtn := make([]MiddlewareInfo, 0, len(mws))
+	for _, mw := range mws {
+		rtn = append(rtn, MiddlewareInfo{Name: mw.Name, Priority: mw.Priority})
+	}
+	return rtn
+}
+
+func handlerInfos(handlers map[string]handlerType) []*HandlerInfo {
+	rtn := make([]*HandlerInfo, 0, len(handlers))
+	for _, hval := range handlers {
+		rtn = append(rtn, hval.HandlerInfo)
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn
+}
+
+func (apprt *AppRuntimeImpl) introspect() *RuntimeInfo {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	rtn := &RuntimeInfo{
+		Type:        RuntimeTypeApp,
+		Middlewares: middlewareInfos(apprt.middlewares),
+		Handlers:    handlerInfos(apprt.handlers),
+	}
+	if apprt.appStateType != nil {
+		rtn.AppState, _ = makeTypeInfo(apprt.appStateType)
+	}
+	for pageName := range apprt.pageHandlers {
+		rtn.Pages = append(rtn.Pages, pageName)
+	}
+	sort.Strings(rtn.Pages)
+	return rtn
+}
+
+func (linkrt *LinkRuntimeImpl) introspect() *RuntimeInfo {
+	linkrt.lock.Lock()
+	defer linkrt.lock.Unlock()
+	return &RuntimeInfo{
+		Type:        RuntimeTypeLink,
+		Middlewares: middlewareInfos(linkrt.middlewares),
+		Handlers:    handlerInfos(linkrt.handlers),
+	}
+}
+
+// Returns every connected app and linked runtime with its middleware, handlers (including
+// hidden ones), and declared parameter/return/app state types.
+func (pc *DashCloudClient) Introspect() *IntrospectInfo {
+	rtn := &IntrospectInfo{
+		AccId:     pc.Config.AccId,
+		ZoneName:  pc.Config.ZoneName,
+		ProcName:  pc.Config.ProcName,
+		ProcRunId: pc.ProcRunId,
+		StartTs:   dashutil.DashTime(pc.StartTime),
+		Connected: pc.IsConnected(),
+		Runtimes:  make([]*RuntimeInfo, 0),
+	}
+	pc.Lock.Lock()
+	runtimes := make(map[string]LinkRuntime)
+	for path, rt := range pc.LinkRtMap {
+		runtimes[path] = rt
+	}
+	apps := make(map[string]*App)
+	for path, app := range pc.connectedApps {
+		apps[path] = app
+	}
+	pc.Lock.Unlock()
+	for path, rt := range runtimes {
+		irt, ok := rt.(introspectable)
+		if !ok {
+			continue
+		}
+		rtInfo := irt.introspect()
+		rtInfo.Path = path
+		if app := apps[path]; app != nil {
+			rtInfo.AppName = app.AppName()
+		}
+		rtn.Runtimes = append(rtn.Runtimes, rtInfo)
+	}
+	sort.Slice(rtn.Runtimes, func(i int, j int) bool {
+		return rtn.Runtimes[i].Path < rtn.Runtimes[j].Path
+	})
+	return rtn
+}
+
+// Returns an http.Handler that serves Introspect() as JSON (mount it on an internal endpoint
+// for developer portals/tooling).
+// Usage: http.Handle("/dashborg/introspect", client.IntrospectHandler())
+func (pc *DashCloudClient) IntrospectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		barr, err := json.MarshalIndent(pc.Introspect(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(barr)
+	})
+}
+
+// serves IntrospectHandler on Config.IntrospectAddr until the client shuts down
+func (pc *DashCloudClient) serveIntrospect() error {
+	ln, err := net.Listen("tcp", pc.Config.IntrospectAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", pc.IntrospectHandler())
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	go func() {
+		<-pc.DoneCh
+		server.Close()
+	}()
+	pc.logV("Dashborg serving introspection on http://%s\n", ln.Addr().String())
+	return nil
+}
@@ -0,0 +1,87 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var decimalRe = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?$`)
+
+// An int64 that is encoded as a JSON string (e.g. "9007199254740993").  JavaScript cannot
+// represent integers larger than 2^53 exactly, so large ids sent to the frontend should use
+// this type (or the ",string" json tag option).  Unmarshals from either a JSON string or number.
+type Int64Str int64
+
+func (v Int64Str) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(strconv.FormatInt(int64(v), 10))), nil
+}
+
+func (v *Int64Str) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	str := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		var err error
+		str, err = strconv.Unquote(str)
+		if err != nil {
+			return fmt.Errorf("Invalid Int64Str %s: %w", string(data), err)
+		}
+	}
+	ival, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid Int64Str %s: %w", string(data), err)
+	}
+	*v = Int64Str(ival)
+	return nil
+}
+
+// A decimal number stored as its exact decimal string (e.g. "1234.10").  Marshals as a JSON
+// number literal without converting through float64, unmarshals from a JSON number or string.
+// Use for money and other values where float64 rounding is not acceptable.
+type Decimal string
+
+func (d Decimal) IsValid() bool {
+	return decimalRe.MatchString(string(d))
+}
+
+func (d Decimal) String() string {
+	return string(d)
+}
+
+// Converts the decimal to a float64 (may lose precision).
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(string(d), 64)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	if !d.IsValid() {
+		return nil, fmt.Errorf("Invalid Decimal '%s'", string(d))
+	}
+	return []byte(d), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*d = ""
+		return nil
+	}
+	str := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		err := json.Unmarshal(data, &str)
+		if err != nil {
+			return err
+		}
+	}
+	if !Decimal(str).IsValid() {
+		return fmt.Errorf("Invalid Decimal %s", string(data))
+	}
+	*d = Decimal(str)
+	return nil
+}
@@ -0,0 +1,70 @@
+package dash
+
+import (
+	"fmt"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Time and duration encoding for an app's panel data (overrides Config.JsonTimeFormat and
+// Config.JsonDurationFormat).  Empty fields use the config setting.
+type JsonTimeFormats struct {
+	TimeFormat     string // dashutil.JsonTimeRFC3339, JsonTimeRFC3339Ms, JsonTimeEpochMs, or JsonTimeEpochSec
+	DurationFormat string // dashutil.JsonDurationSeconds, JsonDurationMs, or JsonDurationString
+}
+
+func (f JsonTimeFormats) Validate() error {
+	if !dashutil.IsJsonTimeFormatValid(f.TimeFormat) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid TimeFormat '%s'", f.TimeFormat))
+	}
+	if !dashutil.IsJsonDurationFormatValid(f.DurationFormat) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid DurationFormat '%s'", f.DurationFormat))
+	}
+	return nil
+}
+
+// Sets how time.Time and time.Duration values are encoded in an app's panel data (SetData,
+// handler return values, and SetJsonPath under the app's path).  Pass nil to use the config defaults.
+// Usage: err := client.SetAppTimeFormats("myapp", &dash.JsonTimeFormats{TimeFormat: dashutil.JsonTimeEpochMs})
+func (pc *DashCloudClient) SetAppTimeFormats(appName string, formats *JsonTimeFormats) error {
+	if !dashutil.IsAppNameValid(appName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if formats == nil {
+		delete(pc.appTimeFormats, appName)
+		return nil
+	}
+	err := formats.Validate()
+	if err != nil {
+		return err
+	}
+	pc.appTimeFormats[appName] = *formats
+	return nil
+}
+
+// Sets how time.Time and time.Duration values are encoded in this app's panel data (see DashCloudClient.SetAppTimeFormats).
+func (app *App) SetTimeFormats(formats *JsonTimeFormats) error {
+	if app.client == nil {
+		return dasherr.ValidateErr(fmt.Errorf("App '%s' has no client (use DashCloudClient.OpenApp)", app.appName))
+	}
+	return app.client.SetAppTimeFormats(app.appName, formats)
+}
+
+// returns the config's JsonOpts with the app's time formats applied
+func (pc *DashCloudClient) appJsonOpts(appName string) dashutil.JsonOpts {
+	opts := pc.Config.jsonOpts()
+	if appName == "" {
+		return opts
+	}
+	pc.Lock.Lock()
+	formats, ok := pc.appTimeFormats[appName]
+	pc.Lock.Unlock()
+	if ok {
+		opts.TimeFormat = dashutil.DefaultString(formats.TimeFormat, opts.TimeFormat)
+		opts.DurationFormat = dashutil.DefaultString(formats.DurationFormat, opts.DurationFormat)
+	}
+	return opts
+}
@@ -0,0 +1,258 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultLinkHealthInterval    = 30 * time.Second
+	DefaultLinkHealthTimeout     = 5 * time.Second
+	DefaultLinkHealthMaxFailures = 3
+	minLinkHealthInterval        = time.Second
+)
+
+// Options for DashCloudClient.StartLinkHealthChecks.
+type LinkHealthOpts struct {
+	Interval    time.Duration // time between checks (defaults to DefaultLinkHealthInterval)
+	Timeout     time.Duration // a ping that does not return within Timeout fails (defaults to DefaultLinkHealthTimeout)
+	MaxFailures int           // consecutive failures before a link is marked unhealthy (defaults to DefaultLinkHealthMaxFailures)
+
+	// If set, unhealthy links are removed (RemovePath) and disconnected, so the service stops
+	// routing requests to this process.  Unlinked runtimes must be re-linked by the caller.
+	AutoUnlink bool
+
+	// Called (in a new goroutine) when a link becomes unhealthy.
+	OnUnhealthy func(alert LinkHealthAlert)
+
+	ShutdownCh chan struct{} // close to stop the checks (they also stop when the client shuts down)
+}
+
+// Passed to LinkHealthOpts.OnUnhealthy.
+type LinkHealthAlert struct {
+	Path        string
+	NumFailures int
+	Err         error // last ping error
+	Unlinked    bool  // true if the link was removed (AutoUnlink)
+	UnlinkErr   error
+}
+
+type linkHealthChecker struct {
+	pc      *DashCloudClient
+	opts    LinkHealthOpts
+	lock    *sync.Mutex
+	pinging map[string]bool // paths with a ping still running (a stuck ping counts as a failure until it returns)
+}
+
+func (opts *LinkHealthOpts) Validate() error {
+	if opts.Interval != 0 && opts.Interval < minLinkHealthInterval {
+		return dasherr.ValidateErr(fmt.Errorf("LinkHealthOpts Interval must be at least %v", minLinkHealthInterval))
+	}
+	if opts.Timeout < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("LinkHealthOpts Timeout cannot be negative"))
+	}
+	if opts.MaxFailures < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("LinkHealthOpts MaxFailures cannot be negative"))
+	}
+	return nil
+}
+
+func pingHandler() (interface{}, error) {
+	return true, nil
+}
+
+// pings the runtime through RunHandler (so stuck locks, middleware, and dispatch limits are detected).
+// runtimes without a ping handler (custom LinkRuntimes, MakeSingleFnRuntime) are healthy if they
+// return ErrCodeNoHandler.
+func (pc *DashCloudClient) pingLinkRuntime(ctx context.Context, linkPath string, rt LinkRuntime) (rtnErr error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			rtnErr = fmt.Errorf("PANIC in ping %v", panicErr)
+		}
+	}()
+	req := &AppRequest{
+		lock:   &sync.Mutex{},
+		ctx:    ctx,
+		client: pc,
+		info: RequestInfo{
+			StartTime:     time.Now(),
+			RequestType:   requestTypePath,
+			RequestMethod: RequestMethodGet,
+			Path:          linkPath + ":" + pathFragPing,
+			AppName:       dashutil.AppNameFromPath(linkPath),
+		},
+	}
+	_, err := rt.RunHandler(req)
+	if err != nil && dasherr.GetErrCode(err) != dasherr.ErrCodeNoHandler {
+		return err
+	}
+	return nil
+}
+
+// returns nil on success, runs the ping in a goroutine so a deadlocked runtime cannot block the checker
+func (hc *linkHealthChecker) check(linkPath string, rt LinkRuntime) error {
+	hc.lock.Lock()
+	if hc.pinging[linkPath] {
+		hc.lock.Unlock()
+		return fmt.Errorf("previous ping has not returned (deadlock?)")
+	}
+	hc.pinging[linkPath] = true
+	hc.lock.Unlock()
+	ctx, cancelFn := context.WithTimeout(context.Background(), hc.opts.Timeout)
+	defer cancelFn()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- hc.pc.pingLinkRuntime(ctx, linkPath, rt)
+		hc.lock.Lock()
+		delete(hc.pinging, linkPath)
+		hc.lock.Unlock()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+
+	case <-time.After(hc.opts.Timeout):
+		return fmt.Errorf("ping timed out after %v", hc.opts.Timeout)
+	}
+}
+
+// records a check result in the link's stats, returns true if the link just became unhealthy
+func (pc *DashCloudClient) recordLinkHealth(linkPath string, err error, maxFailures int) (int, bool) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	stats := pc.linkStatsMap[linkPath]
+	if stats == nil {
+		stats = &LinkStats{Path: linkPath, InstanceId: pc.Config.InstanceId, Mode: LinkModeExclusive}
+		pc.linkStatsMap[linkPath] = stats
+	}
+	stats.HealthCheckTs = dashutil.Ts()
+	if err == nil {
+		if stats.Unhealthy {
+			pc.log("Dashborg link %s is healthy\n", dashutil.SimplifyPath(linkPath, nil))
+		}
+		stats.Unhealthy = false
+		stats.HealthFailures = 0
+		stats.HealthErr = ""
+		return 0, false
+	}
+	stats.HealthFailures++
+	stats.HealthErr = err.Error()
+	if stats.Unhealthy || stats.HealthFailures < maxFailures {
+		return stats.HealthFailures, false
+	}
+	stats.Unhealthy = true
+	return stats.HealthFailures, true
+}
+
+func (hc *linkHealthChecker) setUnhealthy(linkPath string, numFailures int, err error) {
+	pc := hc.pc
+	pc.log("Dashborg link %s is unhealthy, %d consecutive health check failures, err:%v\n", dashutil.SimplifyPath(linkPath, nil), numFailures, err)
+	alert := LinkHealthAlert{Path: linkPath, NumFailures: numFailures, Err: err}
+	if hc.opts.AutoUnlink {
+		alert.UnlinkErr = pc.removePath(linkPath)
+		if alert.UnlinkErr == nil {
+			pc.unlinkRuntime(linkPath)
+			alert.Unlinked = true
+			pc.log("Dashborg unlinked unhealthy link %s\n", dashutil.SimplifyPath(linkPath, nil))
+		} else {
+			pc.log("Dashborg error unlinking unhealthy link %s: %v\n", dashutil.SimplifyPath(linkPath, nil), alert.UnlinkErr)
+		}
+	}
+	if hc.opts.OnUnhealthy != nil {
+		go func() {
+			defer func() {
+				if panicErr := recover(); panicErr != nil {
+					pc.log("Dashborg PANIC in LinkHealthOpts.OnUnhealthy %v\n", panicErr)
+				}
+			}()
+			hc.opts.OnUnhealthy(alert)
+		}()
+	}
+}
+
+// checks all connected link runtimes (app runtimes are not checked)
+func (hc *linkHealthChecker) checkAll() {
+	pc := hc.pc
+	pc.Lock.Lock()
+	links := make(map[string]LinkRuntime)
+	for linkPath, rt := range pc.LinkRtMap {
+		if _, isApp := rt.(*AppRuntimeImpl); isApp {
+			continue
+		}
+		links[linkPath] = rt
+	}
+	pc.Lock.Unlock()
+	var linkPaths []string
+	for linkPath := range links {
+		linkPaths = append(linkPaths, linkPath)
+	}
+	sort.Strings(linkPaths)
+	var wg sync.WaitGroup
+	for _, linkPath := range linkPaths {
+		wg.Add(1)
+		go func(linkPath string) {
+			defer wg.Done()
+			err := hc.check(linkPath, links[linkPath])
+			numFailures, becameUnhealthy := pc.recordLinkHealth(linkPath, err, hc.opts.MaxFailures)
+			if err != nil {
+				pc.logPathV(linkPath, "Dashborg link %s health check failed (%d): %v\n", dashutil.SimplifyPath(linkPath, nil), numFailures, err)
+			}
+			if becameUnhealthy {
+				hc.setUnhealthy(linkPath, numFailures, err)
+			}
+		}(linkPath)
+	}
+	wg.Wait()
+}
+
+func (hc *linkHealthChecker) run() {
+	ticker := time.NewTicker(hc.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if hc.pc.IsConnected() {
+				hc.checkAll()
+			}
+
+		case <-hc.opts.ShutdownCh:
+			return
+
+		case <-hc.pc.DoneCh:
+			return
+		}
+	}
+}
+
+// Starts periodic health checks of this process's link runtimes (LinkRuntime paths, app runtimes are
+// not checked).  Each check calls the runtime's internal ping handler.  Links that fail (or do not
+// respond within Timeout) MaxFailures times in a row are marked Unhealthy in LinkStats(), optionally
+// unlinked (AutoUnlink), and reported to OnUnhealthy.  opts may be nil.
+// Usage: err := client.StartLinkHealthChecks(&dash.LinkHealthOpts{AutoUnlink: true, OnUnhealthy: alertFn})
+func (pc *DashCloudClient) StartLinkHealthChecks(opts *LinkHealthOpts) error {
+	hc := &linkHealthChecker{pc: pc, lock: &sync.Mutex{}, pinging: make(map[string]bool)}
+	if opts != nil {
+		err := opts.Validate()
+		if err != nil {
+			return err
+		}
+		hc.opts = *opts
+	}
+	if hc.opts.Interval == 0 {
+		hc.opts.Interval = DefaultLinkHealthInterval
+	}
+	if hc.opts.Timeout == 0 {
+		hc.opts.Timeout = DefaultLinkHealthTimeout
+	}
+	if hc.opts.MaxFailures == 0 {
+		hc.opts.MaxFailures = DefaultLinkHealthMaxFailures
+	}
+	go hc.run()
+	return nil
+}
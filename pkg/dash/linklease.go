@@ -0,0 +1,191 @@
+package dash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultLinkLeaseTTL = 30 * time.Second
+	minLinkLeaseTTL     = 5 * time.Second
+	linkLeaseDir        = "/_/linkleases"
+)
+
+// Returned (wrapped, check with errors.Is) when linking a path whose lease is held by another
+// process.  Set LinkOpts.LeaseOverride to take over the path.
+var OwnershipConflictErr = errors.New("Link path is owned by another process")
+
+// Ownership record for a leased link path (see LinkOpts.Lease).
+type LinkLease struct {
+	Path       string `json:"path"`
+	InstanceId string `json:"instanceid"`
+	ProcName   string `json:"procname"`
+	ProcRunId  string `json:"procrunid"`
+	AcquiredTs int64  `json:"acquiredts"`
+	ExpTs      int64  `json:"expts"`
+}
+
+type linkLeaseType struct {
+	lease  LinkLease
+	opts   LinkOpts
+	stopCh chan struct{}
+}
+
+func linkLeasePath(fullPath string) string {
+	hashVal := sha256.Sum256([]byte(fullPath))
+	return linkLeaseDir + "/" + hex.EncodeToString(hashVal[0:16]) + ".json"
+}
+
+func (opts *LinkOpts) getLeaseTTL() time.Duration {
+	if opts.LeaseTTL == 0 {
+		return DefaultLinkLeaseTTL
+	}
+	return opts.LeaseTTL
+}
+
+// returns nil, nil if there is no lease for the path
+func (pc *DashCloudClient) readLinkLease(fullPath string) (*LinkLease, error) {
+	finfo, content, err := pc.readStaticPath(linkLeasePath(fullPath))
+	if err != nil || finfo == nil {
+		return nil, err
+	}
+	var lease LinkLease
+	err = dashutil.UnmarshalJson(string(content), &lease, dashutil.JsonOpts{})
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("LinkLease", err)
+	}
+	return &lease, nil
+}
+
+func (pc *DashCloudClient) writeLinkLease(lease LinkLease) error {
+	leaseJson, err := dashutil.MarshalJson(lease)
+	if err != nil {
+		return dasherr.JsonMarshalErr("LinkLease", err)
+	}
+	r := bytes.NewReader([]byte(leaseJson))
+	fileOpts := &FileOpts{FileType: FileTypeStatic, MimeType: MimeTypeJson, Hidden: true, AllowedRoles: []string{RoleAdmin}}
+	err = UpdateFileOptsFromReadSeeker(r, fileOpts)
+	if err != nil {
+		return err
+	}
+	return pc.setRawPath(linkLeasePath(lease.Path), r, fileOpts, nil)
+}
+
+func (pc *DashCloudClient) isLeaseOwner(lease *LinkLease) bool {
+	return lease.InstanceId == pc.Config.InstanceId && lease.ProcRunId == pc.ProcRunId
+}
+
+// acquires (or renews) the lease for fullPath if the path's LinkOpts has Lease set.  returns a
+// wrapped OwnershipConflictErr if an unexpired lease is held by another process (unless
+// LeaseOverride is set).  note that the read and write are not atomic, leases protect against
+// accidental double linking, not concurrent races.
+func (pc *DashCloudClient) acquireLinkLease(fullPath string) error {
+	opts := pc.getLinkOpts(fullPath)
+	if opts == nil || !opts.Lease {
+		return nil
+	}
+	curLease, err := pc.readLinkLease(fullPath)
+	if err != nil {
+		return err
+	}
+	nowTs := dashutil.Ts()
+	if curLease != nil && !pc.isLeaseOwner(curLease) && curLease.ExpTs > nowTs {
+		if !opts.LeaseOverride {
+			return dasherr.NoRetryErrWithCode(dasherr.ErrCodeConflict, fmt.Errorf("%w, path:%s owner:%s proc:%s expires in %v", OwnershipConflictErr, dashutil.SimplifyPath(fullPath, nil), curLease.InstanceId, curLease.ProcName, time.Duration(curLease.ExpTs-nowTs)*time.Millisecond))
+		}
+		pc.log("Dashborg taking over link lease %s from owner:%s proc:%s (LeaseOverride)\n", dashutil.SimplifyPath(fullPath, nil), curLease.InstanceId, curLease.ProcName)
+	}
+	lease := LinkLease{
+		Path:       fullPath,
+		InstanceId: pc.Config.InstanceId,
+		ProcName:   pc.Config.ProcName,
+		ProcRunId:  pc.ProcRunId,
+		AcquiredTs: nowTs,
+		ExpTs:      nowTs + int64(opts.getLeaseTTL()/time.Millisecond),
+	}
+	if curLease != nil && pc.isLeaseOwner(curLease) {
+		lease.AcquiredTs = curLease.AcquiredTs
+	}
+	err = pc.writeLinkLease(lease)
+	if err != nil {
+		return err
+	}
+	pc.startLeaseRenewal(lease, *opts)
+	return nil
+}
+
+func (pc *DashCloudClient) startLeaseRenewal(lease LinkLease, opts LinkOpts) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if ll := pc.linkLeases[lease.Path]; ll != nil {
+		ll.lease = lease
+		return
+	}
+	ll := &linkLeaseType{lease: lease, opts: opts, stopCh: make(chan struct{})}
+	pc.linkLeases[lease.Path] = ll
+	go pc.runLeaseRenewal(ll)
+}
+
+// stops renewing the lease for fullPath (the lease expires after its TTL)
+func (pc *DashCloudClient) releaseLinkLease(fullPath string) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	ll := pc.linkLeases[fullPath]
+	if ll == nil {
+		return
+	}
+	close(ll.stopCh)
+	delete(pc.linkLeases, fullPath)
+}
+
+// renews the lease (TTL/3) while the path is linked.  if another process has taken over the
+// lease, the runtime is unlinked and LinkOpts.OnLeaseLost is called.
+func (pc *DashCloudClient) runLeaseRenewal(ll *linkLeaseType) {
+	fullPath := ll.lease.Path
+	ticker := time.NewTicker(ll.opts.getLeaseTTL() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ll.stopCh:
+			return
+		case <-pc.DoneCh:
+			return
+		}
+		pc.Lock.Lock()
+		_, linked := pc.LinkRtMap[fullPath]
+		pc.Lock.Unlock()
+		if !linked || !pc.IsConnected() {
+			continue
+		}
+		curLease, err := pc.readLinkLease(fullPath)
+		if err != nil {
+			pc.log("Dashborg error reading link lease %s: %v\n", dashutil.SimplifyPath(fullPath, nil), err)
+			continue
+		}
+		if curLease != nil && !pc.isLeaseOwner(curLease) && curLease.ExpTs > dashutil.Ts() {
+			pc.log("Dashborg lost link lease %s to owner:%s proc:%s, unlinking runtime\n", dashutil.SimplifyPath(fullPath, nil), curLease.InstanceId, curLease.ProcName)
+			pc.releaseLinkLease(fullPath)
+			pc.unlinkRuntime(fullPath)
+			if ll.opts.OnLeaseLost != nil {
+				ll.opts.OnLeaseLost(*curLease)
+			}
+			return
+		}
+		pc.Lock.Lock()
+		lease := ll.lease
+		pc.Lock.Unlock()
+		lease.ExpTs = dashutil.Ts() + int64(ll.opts.getLeaseTTL()/time.Millisecond)
+		err = pc.writeLinkLease(lease)
+		if err != nil {
+			pc.log("Dashborg error renewing link lease %s: %v\n", dashutil.SimplifyPath(fullPath, nil), err)
+		}
+	}
+}
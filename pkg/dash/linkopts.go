@@ -0,0 +1,209 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	LinkModeExclusive = "exclusive" // default, the last process to link a path receives all of its requests
+	LinkModeShared    = "shared"    // requests are load balanced across all processes that link the path
+)
+
+const (
+	mdLinkModeKey     = "dashborg-linkmode"
+	mdLinkAffinityKey = "dashborg-linkaffinity"
+	mdInstanceIdKey   = "dashborg-instanceid"
+)
+
+const (
+	LinkAffinityFeClient = "feclientid" // route requests from the same frontend client to the same process
+	LinkAffinityUser     = "user"       // route requests from the same user (AuthAtom Id, e.g. the JWT sub) to the same process
+	LinkAffinityNone     = "none"       // no affinity, every request may go to any process
+)
+
+const instanceIdStatePath = "$state.dashborg.instanceid"
+
+// Options that control how a runtime is linked when multiple processes link the same path.
+// Set with App.SetLinkOpts() or DashFSClient.SetLinkOpts() before the runtime is linked.
+type LinkOpts struct {
+	// LinkModeExclusive (default) or LinkModeShared.
+	Mode string
+
+	// For shared links, controls which requests are routed to the same process (sticky sessions).
+	// One of LinkAffinityFeClient (default), LinkAffinityUser, or LinkAffinityNone.  The affinity key
+	// for a request is available to handlers as RequestInfo().AffinityKey.
+	Affinity string
+
+	// If set (exclusive mode only), linking acquires a lease on the path (renewed every LeaseTTL/3).
+	// Linking a path leased by another process fails with OwnershipConflictErr unless LeaseOverride
+	// is set.  A process that loses its lease (override) unlinks the runtime and calls OnLeaseLost.
+	Lease         bool
+	LeaseTTL      time.Duration // defaults to DefaultLinkLeaseTTL
+	LeaseOverride bool
+	OnLeaseLost   func(newOwner LinkLease)
+}
+
+// Per-process request statistics for a linked runtime.  Returned by DashCloudClient.LinkStats().
+type LinkStats struct {
+	Path        string `json:"path"`
+	InstanceId  string `json:"instanceid"`
+	Mode        string `json:"mode"`
+	NumRequests int64  `json:"numrequests"`
+	NumErrors   int64  `json:"numerrors"`
+	InFlight    int64  `json:"inflight"`
+	TotalMs     int64  `json:"totalms"`
+	MaxMs       int64  `json:"maxms"`
+	LastReqTs   int64  `json:"lastreqts"`
+
+	// set by link health checks (see StartLinkHealthChecks)
+	Unhealthy      bool   `json:"unhealthy,omitempty"`
+	HealthFailures int    `json:"healthfailures,omitempty"` // consecutive failures
+	HealthErr      string `json:"healtherr,omitempty"`
+	HealthCheckTs  int64  `json:"healthcheckts,omitempty"`
+}
+
+func (opts *LinkOpts) Validate() error {
+	if opts.Mode != "" && opts.Mode != LinkModeExclusive && opts.Mode != LinkModeShared {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid LinkOpts Mode '%s'", opts.Mode))
+	}
+	if opts.Affinity != "" && opts.Affinity != LinkAffinityFeClient && opts.Affinity != LinkAffinityUser && opts.Affinity != LinkAffinityNone {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid LinkOpts Affinity '%s'", opts.Affinity))
+	}
+	if opts.Affinity != "" && !opts.isShared() {
+		return dasherr.ValidateErr(fmt.Errorf("LinkOpts Affinity can only be set for Mode '%s'", LinkModeShared))
+	}
+	if opts.Lease && opts.isShared() {
+		return dasherr.ValidateErr(fmt.Errorf("LinkOpts Lease cannot be set for Mode '%s'", LinkModeShared))
+	}
+	if opts.LeaseTTL != 0 && opts.LeaseTTL < minLinkLeaseTTL {
+		return dasherr.ValidateErr(fmt.Errorf("LinkOpts LeaseTTL must be at least %v", minLinkLeaseTTL))
+	}
+	return nil
+}
+
+func (opts *LinkOpts) getAffinity() string {
+	if opts == nil || opts.Affinity == "" {
+		return LinkAffinityFeClient
+	}
+	return opts.Affinity
+}
+
+// returns the affinity key for a request to a shared link
+func (opts *LinkOpts) affinityKey(req *AppRequest) string {
+	switch opts.getAffinity() {
+	case LinkAffinityFeClient:
+		return req.info.FeClientId
+
+	case LinkAffinityUser:
+		if req.authData == nil {
+			return ""
+		}
+		return req.authData.Id
+
+	default:
+		return ""
+	}
+}
+
+func (opts *LinkOpts) isShared() bool {
+	return opts != nil && opts.Mode == LinkModeShared
+}
+
+// Returns the identity of this process for shared links (Config.InstanceId).
+func (pc *DashCloudClient) InstanceId() string {
+	return pc.Config.InstanceId
+}
+
+func (pc *DashCloudClient) setLinkOpts(fullPath string, opts *LinkOpts) error {
+	if opts != nil {
+		err := opts.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if opts == nil {
+		delete(pc.linkOptsMap, fullPath)
+		return nil
+	}
+	optsCopy := *opts
+	pc.linkOptsMap[fullPath] = &optsCopy
+	return nil
+}
+
+func (pc *DashCloudClient) getLinkOpts(fullPath string) *LinkOpts {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	return pc.linkOptsMap[fullPath]
+}
+
+// adds the link mode / instance metadata for linking fullPath to ctx
+func (pc *DashCloudClient) addLinkMd(ctx context.Context, fullPath string) context.Context {
+	opts := pc.getLinkOpts(fullPath)
+	if !opts.isShared() {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, mdLinkModeKey, LinkModeShared, mdLinkAffinityKey, opts.getAffinity(), mdInstanceIdKey, pc.InstanceId())
+}
+
+func (pc *DashCloudClient) startLinkRequest(fullPath string) {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	stats := pc.linkStatsMap[fullPath]
+	if stats == nil {
+		stats = &LinkStats{Path: fullPath, InstanceId: pc.Config.InstanceId, Mode: LinkModeExclusive}
+		pc.linkStatsMap[fullPath] = stats
+	}
+	if pc.linkOptsMap[fullPath].isShared() {
+		stats.Mode = LinkModeShared
+	}
+	stats.NumRequests++
+	stats.InFlight++
+	stats.LastReqTs = dashutil.Ts()
+}
+
+func (pc *DashCloudClient) endLinkRequest(fullPath string, startTime time.Time, hasErr bool) {
+	elapsedMs := int64(time.Since(startTime) / time.Millisecond)
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	stats := pc.linkStatsMap[fullPath]
+	if stats == nil {
+		return
+	}
+	stats.InFlight--
+	stats.TotalMs += elapsedMs
+	if elapsedMs > stats.MaxMs {
+		stats.MaxMs = elapsedMs
+	}
+	if hasErr {
+		stats.NumErrors++
+	}
+}
+
+// Returns request statistics (for this process) for each linked runtime path.
+func (pc *DashCloudClient) LinkStats() []LinkStats {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	var rtn []LinkStats
+	for _, stats := range pc.linkStatsMap {
+		rtn = append(rtn, *stats)
+	}
+	return rtn
+}
+
+// Sets the LinkOpts for a runtime path.  Must be called before LinkRuntime() or
+// LinkAppRuntime() for the options to take effect.
+func (fs *DashFSClient) SetLinkOpts(path string, opts *LinkOpts) error {
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return err
+	}
+	return fs.client.setLinkOpts(fullPath, opts)
+}
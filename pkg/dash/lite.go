@@ -0,0 +1,29 @@
+//go:build dashlite
+// +build dashlite
+
+package dash
+
+import (
+	"fmt"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// built with the "dashlite" tag (see Config.LiteMode)
+const liteBuild = true
+
+var errNotInLiteBuild = dasherr.ErrWithCodeStr(dasherr.ErrCodeValidation, "Not available in dashlite builds (file watching requires fsnotify)")
+
+// Not available in dashlite builds (returns an error).
+func (fs *DashFSClient) WatchFile(path string, fileName string, fileOpts *FileOpts, watchOpts *WatchOpts) error {
+	return errNotInLiteBuild
+}
+
+// Not available in dashlite builds (returns an error).
+func (fs *DashFSClient) WatchDir(path string, localDir string, opts *WatchDirOpts) error {
+	return errNotInLiteBuild
+}
+
+func (pc *DashCloudClient) watchCertFiles() error {
+	return fmt.Errorf("Config.WatchCertFiles: %w", errNotInLiteBuild)
+}
@@ -0,0 +1,73 @@
+package dash
+
+import (
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// gRPC keepalive ping interval (LiteKeepaliveTime with Config.LiteMode)
+	DefaultKeepaliveTime = 10 * time.Second
+	LiteKeepaliveTime    = 60 * time.Second
+
+	// defaults for Config.StreamFlushInterval and Config.CompressMinSize with Config.LiteMode
+	DefaultLiteStreamFlushInterval = 5 * time.Second
+	DefaultLiteCompressMinSize     = 4 * 1024
+)
+
+func (c *Config) keepaliveParams() keepalive.ClientParameters {
+	keepaliveTime := DefaultKeepaliveTime
+	if c.LiteMode {
+		keepaliveTime = LiteKeepaliveTime
+	}
+	return keepalive.ClientParameters{Time: keepaliveTime, Timeout: 5 * time.Second, PermitWithoutStream: true}
+}
+
+// true if a stream Flush should leave its actions queued.  held actions are sent by a
+// trailing Flush when the interval expires (or with the final response, whichever is first).
+func (req *AppRequest) holdStreamFlush() bool {
+	if req.client == nil || !req.isStream() || req.client.Config.StreamFlushInterval <= 0 {
+		return false
+	}
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	sinceFlush := time.Since(req.lastFlush)
+	if sinceFlush < req.client.Config.StreamFlushInterval {
+		if req.flushTimer == nil && !req.flushDone {
+			req.flushTimer = time.AfterFunc(req.client.Config.StreamFlushInterval-sinceFlush, req.sendHeldFlush)
+		}
+		return true
+	}
+	req.lastFlush = time.Now()
+	if req.flushTimer != nil {
+		req.flushTimer.Stop()
+		req.flushTimer = nil
+	}
+	return false
+}
+
+func (req *AppRequest) sendHeldFlush() {
+	req.lock.Lock()
+	req.flushTimer = nil
+	flushDone := req.flushDone
+	req.lock.Unlock()
+	if flushDone {
+		return
+	}
+	err := req.Flush()
+	if err != nil {
+		req.client.logV("Dashborg error sending held stream Flush, reqinfo=%s: %v\n", req.reqInfoStr(), err)
+	}
+}
+
+// called before the final response is sent (it includes any held actions)
+func (req *AppRequest) stopHeldFlush() {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	req.flushDone = true
+	if req.flushTimer != nil {
+		req.flushTimer.Stop()
+		req.flushTimer = nil
+	}
+}
@@ -0,0 +1,275 @@
+package dash
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+type LogLevel int
+
+const (
+	LogLevelInfo  LogLevel = 1 // errors and connection events (default)
+	LogLevelDebug LogLevel = 2 // extra debugging information (same as Config.Verbose)
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelInfo:
+		return "info"
+	case LogLevelDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(level))
+}
+
+func (level LogLevel) MarshalJSON() ([]byte, error) {
+	return []byte(dashutil.QuoteString(level.String())), nil
+}
+
+// Parses "info" or "debug" (case insensitive).
+func ParseLogLevel(str string) (LogLevel, error) {
+	switch strings.ToLower(str) {
+	case "info":
+		return LogLevelInfo, nil
+	case "debug":
+		return LogLevelDebug, nil
+	}
+	return 0, dasherr.ValidateErr(fmt.Errorf("Invalid log level '%s' (must be 'info' or 'debug')", str))
+}
+
+// A log level override set with DashCloudClient.SetLogLevel.  Returned from LogLevels().
+type LogLevelRule struct {
+	Filter string   `json:"filter"`
+	Level  LogLevel `json:"level"`
+}
+
+type logFilter struct {
+	rule    LogLevelRule
+	appName string
+	pathRe  *regexp.Regexp // nil matches all paths
+	seq     int
+}
+
+// more specific filters win, app+path > path > app > default
+func (f *logFilter) specificity() int {
+	rtn := 0
+	if f.pathRe != nil {
+		rtn += 2
+	}
+	if f.appName != "" {
+		rtn += 1
+	}
+	return rtn
+}
+
+// '*' matches any sequence of characters (including '/')
+func globToRegexp(glob string) *regexp.Regexp {
+	reStr := "^" + strings.ReplaceAll(regexp.QuoteMeta(glob), "\\*", ".*") + "$"
+	return regexp.MustCompile(reStr)
+}
+
+// filter format is a comma separated list of key=value pairs, "app=[appname]" and/or
+// "path=[glob]".  the empty filter matches everything.
+func parseLogFilter(filter string) (*logFilter, error) {
+	rtn := &logFilter{}
+	var parts []string
+	for _, part := range strings.Split(filter, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eqIdx := strings.Index(part, "=")
+		if eqIdx == -1 {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid log filter '%s', expected key=value", part))
+		}
+		key, val := strings.TrimSpace(part[:eqIdx]), strings.TrimSpace(part[eqIdx+1:])
+		switch key {
+		case "app":
+			if !dashutil.IsAppNameValid(val) {
+				return nil, dasherr.ValidateErr(fmt.Errorf("Invalid log filter app name '%s'", val))
+			}
+			rtn.appName = val
+			parts = append(parts, "app="+val)
+
+		case "path":
+			if !strings.HasPrefix(val, "/") {
+				return nil, dasherr.ValidateErr(fmt.Errorf("Invalid log filter path '%s', must start with '/'", val))
+			}
+			rtn.pathRe = globToRegexp(val)
+			parts = append(parts, "path="+val)
+
+		default:
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid log filter key '%s' (must be 'app' or 'path')", key))
+		}
+	}
+	sort.Strings(parts)
+	rtn.rule.Filter = strings.Join(parts, ",")
+	return rtn, nil
+}
+
+// if the filter has an app, path globs are matched against the app relative path
+func (f *logFilter) matches(appName string, fullPath string) bool {
+	if f.appName != "" && f.appName != appName {
+		return false
+	}
+	if f.pathRe == nil {
+		return true
+	}
+	matchPath := fullPath
+	if f.appName != "" {
+		matchPath = strings.TrimPrefix(fullPath, AppPathFromName(f.appName))
+		if matchPath == "" {
+			matchPath = "/"
+		}
+	}
+	return f.pathRe.MatchString(matchPath)
+}
+
+// Sets the log level for requests and paths matching filter, so verbose logging can be
+// turned on for a single app or path without flooding the logs for the rest.  filter is a
+// comma separated list of "app=[appname]" and "path=[glob]" ('*' matches any characters).
+// If an app is given the path is app relative.  The empty filter sets the default level
+// (overrides Config.Verbose).  When multiple filters match, the most specific one wins
+// (app+path, then path, then app).  Setting the same filter again replaces its level.
+// Usage: client.SetLogLevel("app=orders,path=/slow*", dash.LogLevelDebug)
+func (pc *DashCloudClient) SetLogLevel(filter string, level LogLevel) error {
+	if level != LogLevelInfo && level != LogLevelDebug {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid log level %v", level))
+	}
+	lf, err := parseLogFilter(filter)
+	if err != nil {
+		return err
+	}
+	lf.rule.Level = level
+	pc.logLock.Lock()
+	defer pc.logLock.Unlock()
+	pc.logFilterSeq++
+	lf.seq = pc.logFilterSeq
+	for idx, existing := range pc.logFilters {
+		if existing.rule.Filter == lf.rule.Filter {
+			pc.logFilters[idx] = lf
+			return nil
+		}
+	}
+	pc.logFilters = append(pc.logFilters, lf)
+	return nil
+}
+
+// Removes a log level override set with SetLogLevel.  Returns true if the filter was found.
+func (pc *DashCloudClient) ClearLogLevel(filter string) (bool, error) {
+	lf, err := parseLogFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	pc.logLock.Lock()
+	defer pc.logLock.Unlock()
+	for idx, existing := range pc.logFilters {
+		if existing.rule.Filter == lf.rule.Filter {
+			pc.logFilters = append(pc.logFilters[:idx], pc.logFilters[idx+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the log level overrides set with SetLogLevel.
+func (pc *DashCloudClient) LogLevels() []LogLevelRule {
+	pc.logLock.Lock()
+	defer pc.logLock.Unlock()
+	var rtn []LogLevelRule
+	for _, lf := range pc.logFilters {
+		rtn = append(rtn, lf.rule)
+	}
+	return rtn
+}
+
+// Returns the effective log level for a full Dashborg FS path ("" for client level messages).
+func (pc *DashCloudClient) LogLevelForPath(fullPath string) LogLevel {
+	rtn := LogLevelInfo
+	if pc.Config.Verbose {
+		rtn = LogLevelDebug
+	}
+	appName := dashutil.AppNameFromPath(fullPath)
+	pc.logLock.Lock()
+	defer pc.logLock.Unlock()
+	var best *logFilter
+	for _, lf := range pc.logFilters {
+		if fullPath == "" && (lf.appName != "" || lf.pathRe != nil) {
+			continue
+		}
+		if !lf.matches(appName, fullPath) {
+			continue
+		}
+		if best == nil || lf.specificity() > best.specificity() || (lf.specificity() == best.specificity() && lf.seq > best.seq) {
+			best = lf
+		}
+	}
+	if best != nil {
+		rtn = best.rule.Level
+	}
+	return rtn
+}
+
+// debug logging for a request or path (see SetLogLevel)
+func (pc *DashCloudClient) logPathV(fullPath string, fmtStr string, args ...interface{}) {
+	if pc.LogLevelForPath(fullPath) >= LogLevelDebug {
+		pc.log(fmtStr, args...)
+	}
+}
+
+// Registers handlers to view and change the client's log levels at runtime (on an admin
+// app): "loglevels" returns the LogLevelRules, "set-loglevel" takes a filter and a level
+// ("info", "debug", or "" to clear the filter).  If allowedRoles is set the request must
+// have one of those roles.
+// Usage: client.AddLogLevelHandlers(adminApp.Runtime(), "admin")
+func (pc *DashCloudClient) AddLogLevelHandlers(rt HandlerRegistry, allowedRoles ...string) {
+	rt.PureHandler("loglevels", func(req *AppRequest) ([]LogLevelRule, error) {
+		err := checkAllowedRoles(req, allowedRoles)
+		if err != nil {
+			return nil, err
+		}
+		return pc.LogLevels(), nil
+	})
+	rt.Handler("set-loglevel", func(req *AppRequest, filter string, levelStr string) ([]LogLevelRule, error) {
+		err := checkAllowedRoles(req, allowedRoles)
+		if err != nil {
+			return nil, err
+		}
+		if levelStr == "" {
+			_, err = pc.ClearLogLevel(filter)
+			if err != nil {
+				return nil, err
+			}
+			return pc.LogLevels(), nil
+		}
+		level, err := ParseLogLevel(levelStr)
+		if err != nil {
+			return nil, err
+		}
+		err = pc.SetLogLevel(filter, level)
+		if err != nil {
+			return nil, err
+		}
+		pc.log("Dashborg log level set filter:'%s' level:%v\n", filter, level)
+		return pc.LogLevels(), nil
+	})
+}
+
+// returns a RoleAuth error unless the request has one of the allowed roles (or no roles are given)
+func checkAllowedRoles(req Request, allowedRoles []string) error {
+	if len(allowedRoles) == 0 {
+		return nil
+	}
+	authData := req.AuthData()
+	for _, role := range allowedRoles {
+		if authData.HasRole(role) {
+			return nil
+		}
+	}
+	return dasherr.ErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("Request does not have the required role"))
+}
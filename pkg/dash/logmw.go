@@ -0,0 +1,147 @@
+package dash
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	LoggingMwName     = "logging"
+	LoggingMwPriority = 200 // runs before all other built-in middleware, so Duration includes them
+)
+
+// One dispatched request, passed to a RequestLogSink by LoggingMiddleware.
+type RequestLogEntry struct {
+	Ts            int64 // request start (ms)
+	AppName       string
+	Path          string // full request path (including the path fragment)
+	PathFrag      string // handler name
+	ReqId         string
+	RequestType   string
+	RequestMethod string
+	FeClientId    string
+	UserId        string // AuthAtom id (empty if not authenticated)
+	AuthType      string
+	IsBackendCall bool
+	BackendProc   string // ProcName of the calling process for backend calls
+	Duration      time.Duration
+	ReqSize       int // size of the request data and app state (json)
+	RespSize      int // size of the return value (json) and pending actions (actions sent with Flush are not counted)
+	ErrCode       string
+	Err           string
+}
+
+// Receives RequestLogEntries from LoggingMiddleware.  LogRequest is called synchronously after
+// each request completes, so sinks that do I/O should buffer or hand off to a goroutine.
+type RequestLogSink interface {
+	LogRequest(entry RequestLogEntry)
+}
+
+// Adapts a function to the RequestLogSink interface.
+type RequestLogSinkFunc func(entry RequestLogEntry)
+
+func (fn RequestLogSinkFunc) LogRequest(entry RequestLogEntry) {
+	fn(entry)
+}
+
+// Options for LoggingMiddleware.
+type LoggingOpts struct {
+	Sink          RequestLogSink // defaults to the client log (one line per request)
+	ErrorsOnly    bool           // only log requests that return an error
+	SlowThreshold time.Duration  // if set, only log requests that take at least this long (or return an error)
+	SkipHandlers  []string       // handler names (path fragments) that are never logged (e.g. "@typeinfo")
+}
+
+func (entry RequestLogEntry) String() string {
+	rtn := fmt.Sprintf("Dashborg request app=%s path=%s reqid=%s method=%s", entry.AppName, entry.Path, entry.ReqId, entry.RequestMethod)
+	if entry.UserId != "" {
+		rtn += fmt.Sprintf(" user=%s", entry.UserId)
+	}
+	if entry.IsBackendCall {
+		rtn += fmt.Sprintf(" backend=%s", dashutil.DefaultString(entry.BackendProc, "true"))
+	}
+	rtn += fmt.Sprintf(" time=%dms reqsize=%d respsize=%d", int64(entry.Duration/time.Millisecond), entry.ReqSize, entry.RespSize)
+	if entry.Err != "" {
+		rtn += fmt.Sprintf(" errcode=%s err=%q", dashutil.DefaultString(entry.ErrCode, string(dasherr.ErrCodeUnknown)), entry.Err)
+	}
+	return rtn
+}
+
+func requestRespSize(req *AppRequest, rtnVal interface{}) int {
+	size := 0
+	if rtnVal != nil {
+		if rtnJson, err := dashutil.MarshalJson(rtnVal); err == nil {
+			size += len(rtnJson)
+		}
+	}
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	for _, rra := range req.rrActions {
+		size += len(rra.JsonData) + len(rra.Html) + len(rra.BlobBytes)
+	}
+	return size
+}
+
+// Creates a middleware that records every dispatched request (path, handler, caller, latency,
+// payload sizes, and error code) to opts.Sink.
+// Usage: app.Runtime().AddRawMiddleware(dash.LoggingMwName, dash.LoggingMiddleware(dash.LoggingOpts{}), dash.LoggingMwPriority)
+func LoggingMiddleware(opts LoggingOpts) MiddlewareFuncType {
+	skip := make(map[string]bool)
+	for _, name := range opts.SkipHandlers {
+		skip[name] = true
+	}
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		_, _, pathFrag, _ := dashutil.ParseFullPath(req.info.Path, true)
+		if pathFrag == "" {
+			pathFrag = pathFragDefault
+		}
+		if skip[pathFrag] {
+			return nextFn(req)
+		}
+		startTime := time.Now()
+		rtnVal, rtnErr := nextFn(req)
+		duration := time.Since(startTime)
+		err := rtnErr
+		if err == nil {
+			err = req.GetError()
+		}
+		if err == nil && (opts.ErrorsOnly || (opts.SlowThreshold > 0 && duration < opts.SlowThreshold)) {
+			return rtnVal, rtnErr
+		}
+		info := req.info
+		entry := RequestLogEntry{
+			Ts:            dashutil.DashTime(startTime),
+			AppName:       info.AppName,
+			Path:          info.Path,
+			PathFrag:      pathFrag,
+			ReqId:         info.ReqId,
+			RequestType:   info.RequestType,
+			RequestMethod: info.RequestMethod,
+			FeClientId:    info.FeClientId,
+			IsBackendCall: info.IsBackendCall,
+			Duration:      duration,
+			ReqSize:       len(req.rawData.DataJson) + len(req.rawData.AppStateJson),
+			RespSize:      requestRespSize(req, rtnVal),
+		}
+		if req.authData != nil {
+			entry.UserId = req.authData.Id
+			entry.AuthType = req.authData.Type
+		}
+		if info.IsBackendCall {
+			entry.BackendProc, _ = backendCallerInfo(req)
+		}
+		if err != nil {
+			entry.ErrCode = string(dasherr.GetErrCode(err))
+			entry.Err = dasherr.GetMessage(err)
+		}
+		if opts.Sink != nil {
+			opts.Sink.LogRequest(entry)
+		} else if req.client != nil {
+			req.client.log("%s\n", entry.String())
+		}
+		return rtnVal, rtnErr
+	}
+}
@@ -0,0 +1,141 @@
+package logtail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultJournalctlPath = "journalctl"
+	journaldRestartWait   = 5 * time.Second
+)
+
+// Follows the systemd journal (by running "journalctl -f -o json").  Entries are added with
+// File set to "journald:<host>:<identifier>".
+type JournaldConfig struct {
+	Units          []string `json:"units"`          // only these units (journalctl -u)
+	MinSeverity    string   `json:"minseverity"`    // drop less severe entries (emerg, alert, crit, err, warning, notice, info, debug)
+	Match          string   `json:"match"`          // keep only entries whose message matches this regexp
+	JournalctlPath string   `json:"journalctlpath"` // defaults to DefaultJournalctlPath
+}
+
+func (cfg *JournaldConfig) args(fromStart bool, maxLines int) []string {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	if fromStart {
+		args = append(args, "--lines="+strconv.Itoa(maxLines))
+	} else {
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range cfg.Units {
+		args = append(args, "--unit="+unit)
+	}
+	if cfg.MinSeverity != "" {
+		sev, _ := parseSeverity(cfg.MinSeverity)
+		args = append(args, "--priority="+strconv.Itoa(sev))
+	}
+	return args
+}
+
+// MESSAGE is a string, or an array of bytes if it is not valid UTF-8
+func journalString(val interface{}) string {
+	switch tval := val.(type) {
+	case string:
+		return tval
+
+	case []interface{}:
+		barr := make([]byte, 0, len(tval))
+		for _, b := range tval {
+			if fb, ok := b.(float64); ok {
+				barr = append(barr, byte(fb))
+			}
+		}
+		return string(barr)
+	}
+	return ""
+}
+
+func parseJournalEntry(data []byte) (*logEntry, error) {
+	var fields map[string]interface{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	entry := &logEntry{Severity: 6, Msg: journalString(fields["MESSAGE"]), Host: journalString(fields["_HOSTNAME"])}
+	if sev, err := strconv.Atoi(journalString(fields["PRIORITY"])); err == nil && sev >= 0 && sev <= 7 {
+		entry.Severity = sev
+	}
+	entry.App = journalString(fields["SYSLOG_IDENTIFIER"])
+	if entry.App == "" {
+		entry.App = journalString(fields["_SYSTEMD_UNIT"])
+	}
+	if usecs, err := strconv.ParseInt(journalString(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		entry.Ts = usecs / 1000
+	}
+	return entry, nil
+}
+
+// runs journalctl until the tailer is stopped, restarting it if it exits
+func (t *Tailer) runJournald() {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	go func() {
+		<-t.doneCh
+		cancelFn()
+	}()
+	fromStart := t.cfg.FromStart
+	for {
+		err := t.runJournalctl(ctx, fromStart)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("logtail journalctl exited: %v, restarting in %v\n", err, journaldRestartWait)
+		fromStart = false
+		select {
+		case <-time.After(journaldRestartWait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *Tailer) runJournalctl(ctx context.Context, fromStart bool) error {
+	cfg := t.cfg.Journald
+	journalctlPath := cfg.JournalctlPath
+	if journalctlPath == "" {
+		journalctlPath = DefaultJournalctlPath
+	}
+	cmd := exec.CommandContext(ctx, journalctlPath, cfg.args(fromStart, t.cfg.MaxLines)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxSyslogMsgSize)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		t.addEntry("journald", t.journaldFilter, entry)
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return scanErr
+	}
+	err = cmd.Wait()
+	if err == nil {
+		err = fmt.Errorf("journalctl exited")
+	}
+	return err
+}
@@ -0,0 +1,591 @@
+// Tails local log files (with rotation handling), syslog, and journald, and serves the lines to a
+// Dashborg log viewer app.
+package logtail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultAppName      = "logs"
+	DefaultMaxLines     = 10000
+	DefaultPollInterval = time.Second
+	DefaultFetchLines   = 500
+	MaxFetchLines       = 5000
+	maxLineLen          = 4096
+	maxReadPerPoll      = 4 * 1024 * 1024
+	maxStreamLines      = 2 * DefaultFetchLines // lines a viewer's stream appends before the tail is resent
+)
+
+var defaultLevelRe = regexp.MustCompile("(?i)\\b(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|PANIC)\\b")
+
+// Config block for the log viewer.  Can be unmarshaled from JSON.
+type Config struct {
+	AppName      string        `json:"appname"`      // defaults to DefaultAppName
+	Files        []string      `json:"files"`        // file names or globs (Files, Syslog, or Journald is required)
+	MaxLines     int           `json:"maxlines"`     // lines kept in memory (defaults to DefaultMaxLines)
+	PollInterval time.Duration `json:"pollinterval"` // defaults to DefaultPollInterval
+	FromStart    bool          `json:"fromstart"`    // read existing content of files (otherwise starts at the end of each file)
+	LevelRegexp  string        `json:"levelregexp"`  // first submatch is the level, defaults to matching common level names
+	AllowedRoles []string      `json:"allowedroles"`
+
+	Syslog   *SyslogConfig   `json:"syslog"`
+	Journald *JournaldConfig `json:"journald"`
+}
+
+type Line struct {
+	Seq   int64  `json:"seq"`
+	Ts    int64  `json:"ts"`
+	File  string `json:"file"`
+	Level string `json:"level,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Server side filter passed from the frontend to the "lines" handler.
+type Filter struct {
+	Regexp string `json:"regexp"`
+	Level  string `json:"level"`
+	File   string `json:"file"`
+}
+
+// Returned from the "lines" handler.  Cursor should be passed back on the next call
+// so each viewer tracks its own position.
+type LinesResult struct {
+	Lines  []*Line `json:"lines"`
+	Cursor int64   `json:"cursor"`
+	Reset  bool    `json:"reset,omitempty"` // set if lines between the old cursor and the returned lines were dropped
+}
+
+type tailFile struct {
+	path    string
+	fd      *os.File // kept open so a rotated (renamed) file can be read to EOF, only used by the poll goroutine
+	finfo   os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+type Tailer struct {
+	lock           *sync.Mutex
+	cfg            Config
+	levelRe        *regexp.Regexp
+	lines          []*Line // ring buffer ordered by Seq
+	nextSeq        int64
+	files          map[string]*tailFile
+	sources        map[string]bool // syslog and journald File names
+	syslogFilter   *entryFilter
+	journaldFilter *entryFilter
+	closers        map[io.Closer]bool // syslog listeners and connections, closed on Stop
+	subscribers    map[int]chan bool  // "stream" handlers, signaled when lines are added
+	nextSubId      int
+	doneCh         chan struct{}
+	once           *sync.Once
+}
+
+func MakeTailer(cfg *Config) (*Tailer, error) {
+	if cfg == nil || (len(cfg.Files) == 0 && cfg.Syslog == nil && cfg.Journald == nil) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("logtail Config must specify Files, Syslog, or Journald"))
+	}
+	rtn := &Tailer{
+		lock:        &sync.Mutex{},
+		cfg:         *cfg,
+		files:       make(map[string]*tailFile),
+		sources:     make(map[string]bool),
+		closers:     make(map[io.Closer]bool),
+		subscribers: make(map[int]chan bool),
+		nextSeq:     1,
+		doneCh:      make(chan struct{}),
+		once:        &sync.Once{},
+	}
+	if rtn.cfg.AppName == "" {
+		rtn.cfg.AppName = DefaultAppName
+	}
+	if !dashutil.IsAppNameValid(rtn.cfg.AppName) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", rtn.cfg.AppName))
+	}
+	if rtn.cfg.MaxLines <= 0 {
+		rtn.cfg.MaxLines = DefaultMaxLines
+	}
+	if rtn.cfg.PollInterval <= 0 {
+		rtn.cfg.PollInterval = DefaultPollInterval
+	}
+	rtn.levelRe = defaultLevelRe
+	if rtn.cfg.LevelRegexp != "" {
+		re, err := regexp.Compile(rtn.cfg.LevelRegexp)
+		if err != nil {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid LevelRegexp: %w", err))
+		}
+		rtn.levelRe = re
+	}
+	for _, pattern := range rtn.cfg.Files {
+		_, err := filepath.Match(pattern, "")
+		if err != nil {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid file glob '%s': %w", pattern, err))
+		}
+	}
+	if syslogCfg := rtn.cfg.Syslog; syslogCfg != nil {
+		if syslogCfg.UDPAddr == "" && syslogCfg.TCPAddr == "" {
+			return nil, dasherr.ValidateErr(fmt.Errorf("logtail Syslog config must specify UDPAddr or TCPAddr"))
+		}
+		filter, err := makeEntryFilter(syslogCfg.MinSeverity, syslogCfg.Match, syslogCfg.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		rtn.syslogFilter = filter
+	}
+	if journaldCfg := rtn.cfg.Journald; journaldCfg != nil {
+		filter, err := makeEntryFilter(journaldCfg.MinSeverity, journaldCfg.Match, nil)
+		if err != nil {
+			return nil, err
+		}
+		rtn.journaldFilter = filter
+	}
+	return rtn, nil
+}
+
+// Creates the tailer, starts tailing, and writes and connects the log viewer app.
+// Tailing stops when the client shuts down (or Stop() is called).
+func Start(client *dash.DashCloudClient, cfg *Config) (*Tailer, error) {
+	t, err := MakeTailer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	app := client.AppClient().NewApp(t.cfg.AppName)
+	app.SetAppTitle("Logs")
+	if len(t.cfg.AllowedRoles) > 0 {
+		app.SetAllowedRoles(t.cfg.AllowedRoles...)
+	}
+	app.SetHtml(viewerHtml)
+	t.Register(app.Runtime())
+	err = t.Run()
+	if err != nil {
+		return nil, err
+	}
+	err = client.AppClient().WriteAndConnectApp(app)
+	if err != nil {
+		t.Stop()
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-client.DoneCh:
+			t.Stop()
+		case <-t.doneCh:
+		}
+	}()
+	return t, nil
+}
+
+// Registers the "lines", "stream", and "files" handlers.
+func (t *Tailer) Register(rt dash.HandlerRegistry) {
+	rt.PureHandler("lines", t.GetLines)
+	rt.Handler("stream", t.StreamLines)
+	rt.PureHandler("files", t.FileNames)
+}
+
+// Starts the polling goroutine, the syslog listeners, and journalctl.  Returns an error if a
+// syslog address cannot be bound.
+func (t *Tailer) Run() error {
+	if t.cfg.Syslog != nil {
+		err := t.startSyslog()
+		if err != nil {
+			t.Stop()
+			return err
+		}
+	}
+	if t.cfg.Journald != nil {
+		go t.runJournald()
+	}
+	t.poll(!t.cfg.FromStart)
+	go func() {
+		ticker := time.NewTicker(t.cfg.PollInterval)
+		defer ticker.Stop()
+		defer t.closeFiles()
+		for {
+			select {
+			case <-ticker.C:
+				t.poll(false)
+
+			case <-t.doneCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *Tailer) Stop() {
+	t.once.Do(func() {
+		close(t.doneCh)
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		for closer := range t.closers {
+			closer.Close()
+		}
+		t.closers = make(map[io.Closer]bool)
+	})
+}
+
+func (t *Tailer) addCloser(closer io.Closer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.doneCh:
+		closer.Close() // already stopped
+	default:
+		t.closers[closer] = true
+	}
+}
+
+func (t *Tailer) removeCloser(closer io.Closer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.closers, closer)
+}
+
+// called from the poll goroutine when it exits
+func (t *Tailer) closeFiles() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, tf := range t.files {
+		if tf.fd != nil {
+			tf.fd.Close()
+			tf.fd = nil
+		}
+	}
+}
+
+// Returns the names of the files currently being tailed (and the syslog/journald sources seen).
+func (t *Tailer) FileNames() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	rtn := make([]string, 0, len(t.files)+len(t.sources))
+	for path := range t.files {
+		rtn = append(rtn, path)
+	}
+	for source := range t.sources {
+		rtn = append(rtn, source)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func (t *Tailer) expandGlobs() []string {
+	var rtn []string
+	seen := make(map[string]bool)
+	for _, pattern := range t.cfg.Files {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				rtn = append(rtn, match)
+			}
+		}
+	}
+	return rtn
+}
+
+func (t *Tailer) poll(skipExisting bool) {
+	paths := t.expandGlobs()
+	for _, path := range paths {
+		t.lock.Lock()
+		tf := t.files[path]
+		t.lock.Unlock()
+		finfo, err := os.Stat(path)
+		if err != nil || finfo.IsDir() {
+			continue
+		}
+		if tf == nil {
+			tf = &tailFile{path: path, finfo: finfo}
+			if skipExisting {
+				tf.offset = finfo.Size()
+			}
+			t.lock.Lock()
+			t.files[path] = tf
+			t.lock.Unlock()
+		} else if !os.SameFile(tf.finfo, finfo) {
+			// rotated, finish reading the old file before switching to the new one
+			if tf.fd != nil {
+				t.readFile(tf, false)
+				tf.fd.Close()
+				tf.fd = nil
+			}
+			if len(tf.partial) > 0 {
+				t.addLine(tf.path, strings.TrimRight(string(tf.partial), "\r"))
+			}
+			tf.finfo = finfo
+			tf.offset = 0
+			tf.partial = nil
+		} else if finfo.Size() < tf.offset {
+			// truncated, start from the beginning
+			tf.offset = 0
+			tf.partial = nil
+		}
+		tf.finfo = finfo
+		if finfo.Size() > tf.offset {
+			t.readFile(tf, true)
+		}
+	}
+}
+
+// reads from tf.offset to EOF (at most maxReadPerPoll bytes if limitRead is set)
+func (t *Tailer) readFile(tf *tailFile, limitRead bool) {
+	if tf.fd == nil {
+		fd, err := os.Open(tf.path)
+		if err != nil {
+			return
+		}
+		finfo, err := fd.Stat()
+		if err != nil || !os.SameFile(tf.finfo, finfo) {
+			fd.Close() // rotated again since the Stat, picked up on the next poll
+			return
+		}
+		tf.fd = fd
+	}
+	_, err := tf.fd.Seek(tf.offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 64*1024)
+	var totalRead int
+	for !limitRead || totalRead < maxReadPerPoll {
+		n, err := tf.fd.Read(buf)
+		if n > 0 {
+			totalRead += n
+			tf.offset += int64(n)
+			t.processBytes(tf, buf[0:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func (t *Tailer) processBytes(tf *tailFile, data []byte) {
+	data = append(tf.partial, data...)
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			break
+		}
+		t.addLine(tf.path, strings.TrimRight(string(data[0:idx]), "\r"))
+		data = data[idx+1:]
+	}
+	if len(data) > maxLineLen {
+		t.addLine(tf.path, string(data))
+		data = nil
+	}
+	tf.partial = append([]byte(nil), data...)
+}
+
+func (t *Tailer) addLine(fileName string, text string) {
+	if len(text) > maxLineLen {
+		text = text[0:maxLineLen]
+	}
+	line := &Line{Ts: dashutil.Ts(), File: fileName, Text: text}
+	if match := t.levelRe.FindStringSubmatch(text); match != nil {
+		level := match[0]
+		if len(match) > 1 {
+			level = match[1]
+		}
+		line.Level = strings.ToUpper(level)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.appendLineNoLock(line)
+}
+
+// must hold lock
+func (t *Tailer) appendLineNoLock(line *Line) {
+	line.Seq = t.nextSeq
+	t.nextSeq++
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.cfg.MaxLines {
+		t.lines = t.lines[len(t.lines)-t.cfg.MaxLines:]
+	}
+	for _, ch := range t.subscribers {
+		select {
+		case ch <- true:
+		default: // already signaled
+		}
+	}
+}
+
+func (t *Tailer) subscribe() (int, chan bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.nextSubId++
+	ch := make(chan bool, 1)
+	t.subscribers[t.nextSubId] = ch
+	return t.nextSubId, ch
+}
+
+func (t *Tailer) unsubscribe(subId int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.subscribers, subId)
+}
+
+func (f *Filter) compile() (*regexp.Regexp, error) {
+	if f == nil || f.Regexp == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(f.Regexp)
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid filter regexp: %w", err))
+	}
+	return re, nil
+}
+
+func (f *Filter) match(line *Line, re *regexp.Regexp) bool {
+	if f == nil {
+		return true
+	}
+	if f.Level != "" && !strings.EqualFold(f.Level, line.Level) {
+		return false
+	}
+	if f.File != "" && f.File != line.File {
+		return false
+	}
+	if re != nil && !re.MatchString(line.Text) {
+		return false
+	}
+	return true
+}
+
+// Returns lines with Seq > cursor that match the filter (up to maxLines).  A cursor of 0
+// returns the most recent maxLines matching lines.
+func (t *Tailer) GetLines(cursor int64, filter *Filter, maxLines int) (*LinesResult, error) {
+	if maxLines <= 0 {
+		maxLines = DefaultFetchLines
+	}
+	if maxLines > MaxFetchLines {
+		maxLines = MaxFetchLines
+	}
+	re, err := filter.compile()
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	rtn := &LinesResult{Lines: make([]*Line, 0), Cursor: t.nextSeq - 1}
+	if len(t.lines) > 0 && cursor > 0 && cursor < t.lines[0].Seq-1 {
+		rtn.Reset = true
+	}
+	startIdx := sort.Search(len(t.lines), func(i int) bool {
+		return t.lines[i].Seq > cursor
+	})
+	if cursor == 0 {
+		// initial fetch, return the tail
+		for idx := len(t.lines) - 1; idx >= 0 && len(rtn.Lines) < maxLines; idx-- {
+			if filter.match(t.lines[idx], re) {
+				rtn.Lines = append(rtn.Lines, t.lines[idx])
+			}
+		}
+		for i, j := 0, len(rtn.Lines)-1; i < j; i, j = i+1, j-1 {
+			rtn.Lines[i], rtn.Lines[j] = rtn.Lines[j], rtn.Lines[i]
+		}
+		return rtn, nil
+	}
+	for idx := startIdx; idx < len(t.lines); idx++ {
+		if !filter.match(t.lines[idx], re) {
+			continue
+		}
+		if len(rtn.Lines) >= maxLines {
+			rtn.Cursor = t.lines[idx].Seq - 1
+			break
+		}
+		rtn.Lines = append(rtn.Lines, t.lines[idx])
+	}
+	return rtn, nil
+}
+
+// Stream handler, pushes new lines matching the filter to the viewer (appended to $.result.lines)
+// until the stream ends.  Each stream tracks its own position, starting after cursor.  The
+// tail is resent (replacing $.result) when lines were dropped or after maxStreamLines appends.
+func (t *Tailer) StreamLines(req dash.ActionRequest, cursor int64, filter *Filter) error {
+	subId, ch := t.subscribe()
+	defer t.unsubscribe(subId)
+	numSent := 0
+	for {
+		result, err := t.GetLines(cursor, filter, MaxFetchLines)
+		if err != nil {
+			return err
+		}
+		if result.Reset || numSent+len(result.Lines) > maxStreamLines {
+			result, err = t.GetLines(0, filter, DefaultFetchLines)
+			if err != nil {
+				return err
+			}
+			req.SetData("$.result", result)
+			numSent = 0
+		} else {
+			for _, line := range result.Lines {
+				req.AddDataOp("append", "$.result.lines", line)
+			}
+			req.SetData("$.result.cursor", result.Cursor)
+			numSent += len(result.Lines)
+		}
+		if result.Reset || len(result.Lines) > 0 {
+			err = req.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		cursor = result.Cursor
+		if result.Cursor < t.lastSeq() {
+			continue // more than MaxFetchLines lines were added
+		}
+		select {
+		case <-ch:
+
+		case <-req.Context().Done():
+			return nil
+
+		case <-t.doneCh:
+			return nil
+		}
+	}
+}
+
+func (t *Tailer) lastSeq() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.nextSeq - 1
+}
+
+const viewerHtml = `<app ui="dashborg">
+  <d-data query="/@app:files" output.bindpath="$.files"/>
+  <d-data query="/@app:lines(0, $.filter, 500)" output.bindpath="$.result"/>
+  <d-data query="/@app:stream($.result.cursor, $.filter)" stream/>
+  <h1>Logs</h1>
+  <div class="row">
+    <d-input placeholder="Regexp" value.bindpath="$.filter.regexp"/>
+    <d-select value.bindpath="$.filter.level">
+      <d-option value="">All Levels</d-option>
+      <d-option value="DEBUG">DEBUG</d-option>
+      <d-option value="INFO">INFO</d-option>
+      <d-option value="WARN">WARN</d-option>
+      <d-option value="ERROR">ERROR</d-option>
+    </d-select>
+    <d-button onclickhandler="$.result = /@app:lines(0, $.filter, 500)">Apply</d-button>
+  </div>
+  <d-table bind="$.result.lines">
+    <d-col label="File" bind="fn:basename(.file)"/>
+    <d-col label="Level" bind=".level"/>
+    <d-col label="Line"><pre style="margin: 0"><d-text bind=".text"/></pre></d-col>
+  </d-table>
+</app>
+`
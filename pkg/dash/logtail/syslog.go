@@ -0,0 +1,333 @@
+package logtail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const maxSyslogMsgSize = 64 * 1024
+
+// Receives syslog messages (RFC 3164 or RFC 5424).  Entries are added with File set to
+// "syslog:<host>:<app>".
+type SyslogConfig struct {
+	UDPAddr     string   `json:"udpaddr"`     // UDP listen address (e.g. ":5514")
+	TCPAddr     string   `json:"tcpaddr"`     // TCP listen address, newline or octet-counted framing
+	MinSeverity string   `json:"minseverity"` // drop less severe entries (emerg, alert, crit, err, warning, notice, info, debug)
+	Match       string   `json:"match"`       // keep only entries whose message matches this regexp
+	Hosts       []string `json:"hosts"`       // keep only entries from these hosts
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var severityAliases = map[string]int{"emergency": 0, "panic": 0, "critical": 2, "error": 3, "warn": 4, "informational": 6}
+
+// severity => Line.Level (matches the levels in the viewer's level filter)
+var severityLevels = []string{"ERROR", "ERROR", "ERROR", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+// syslog and journald entries
+type logEntry struct {
+	Severity int
+	Host     string
+	App      string
+	Msg      string
+	Ts       int64 // ms, 0 if not known
+}
+
+type entryFilter struct {
+	maxSeverity int
+	re          *regexp.Regexp
+	hosts       map[string]bool
+}
+
+// parses a severity name or number ("" is debug, so nothing is dropped)
+func parseSeverity(name string) (int, error) {
+	if name == "" {
+		return 7, nil
+	}
+	name = strings.ToLower(name)
+	for idx, sevName := range severityNames {
+		if name == sevName || name == strconv.Itoa(idx) {
+			return idx, nil
+		}
+	}
+	if sev, ok := severityAliases[name]; ok {
+		return sev, nil
+	}
+	return 0, fmt.Errorf("Invalid severity '%s' (must be one of %s)", name, strings.Join(severityNames, ", "))
+}
+
+func makeEntryFilter(minSeverity string, match string, hosts []string) (*entryFilter, error) {
+	maxSeverity, err := parseSeverity(minSeverity)
+	if err != nil {
+		return nil, dasherr.ValidateErr(err)
+	}
+	rtn := &entryFilter{maxSeverity: maxSeverity}
+	if match != "" {
+		rtn.re, err = regexp.Compile(match)
+		if err != nil {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Match regexp: %w", err))
+		}
+	}
+	if len(hosts) > 0 {
+		rtn.hosts = make(map[string]bool)
+		for _, host := range hosts {
+			rtn.hosts[host] = true
+		}
+	}
+	return rtn, nil
+}
+
+func (f *entryFilter) match(entry *logEntry) bool {
+	if entry.Severity > f.maxSeverity {
+		return false
+	}
+	if f.hosts != nil && !f.hosts[entry.Host] {
+		return false
+	}
+	if f.re != nil && !f.re.MatchString(entry.Msg) {
+		return false
+	}
+	return true
+}
+
+func (t *Tailer) addEntry(source string, filter *entryFilter, entry *logEntry) {
+	if !filter.match(entry) {
+		return
+	}
+	text := strings.TrimRight(entry.Msg, "\r\n")
+	if len(text) > maxLineLen {
+		text = text[0:maxLineLen]
+	}
+	ts := entry.Ts
+	if ts == 0 {
+		ts = dashutil.Ts()
+	}
+	fileName := source + ":" + entry.Host + ":" + entry.App
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sources[fileName] = true
+	t.appendLineNoLock(&Line{Ts: ts, File: fileName, Level: severityLevels[entry.Severity], Text: text})
+}
+
+// returns the value up to the next space and the rest
+func nextField(data string) (string, string) {
+	idx := strings.IndexByte(data, ' ')
+	if idx == -1 {
+		return data, ""
+	}
+	return data[0:idx], data[idx+1:]
+}
+
+func nilValue(val string) string {
+	if val == "-" {
+		return ""
+	}
+	return val
+}
+
+// parses an RFC 5424 or RFC 3164 message.  fields that cannot be parsed are left empty
+// (the message is the unparsed remainder), so any line is accepted.
+func parseSyslog(data string) *logEntry {
+	entry := &logEntry{Severity: 5} // default for messages without PRI (user.notice)
+	data = strings.TrimRight(data, "\r\n\x00")
+	if strings.HasPrefix(data, "<") {
+		if endIdx := strings.IndexByte(data, '>'); endIdx > 1 && endIdx <= 4 {
+			pri, err := strconv.Atoi(data[1:endIdx])
+			if err == nil && pri >= 0 && pri <= 191 {
+				entry.Severity = pri % 8
+				data = data[endIdx+1:]
+			}
+		}
+	}
+	if strings.HasPrefix(data, "1 ") {
+		parseSyslog5424(entry, data[2:])
+		return entry
+	}
+	parseSyslog3164(entry, data)
+	return entry
+}
+
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseSyslog5424(entry *logEntry, data string) {
+	var tsStr string
+	tsStr, data = nextField(data)
+	entry.Host, data = nextField(data)
+	entry.App, data = nextField(data)
+	_, data = nextField(data) // PROCID
+	_, data = nextField(data) // MSGID
+	entry.Host, entry.App = nilValue(entry.Host), nilValue(entry.App)
+	if ts, err := time.Parse(time.RFC3339Nano, tsStr); err == nil {
+		entry.Ts = ts.UnixNano() / int64(time.Millisecond)
+	}
+	if strings.HasPrefix(data, "-") {
+		data = data[1:]
+	} else {
+		// skip SD-ELEMENTs ("[id k="v"]..."), values may contain escaped '"', '\', and ']'
+		for strings.HasPrefix(data, "[") {
+			inQuote := false
+			idx := 1
+			for ; idx < len(data); idx++ {
+				ch := data[idx]
+				if ch == '\\' && inQuote {
+					idx++
+				} else if ch == '"' {
+					inQuote = !inQuote
+				} else if ch == ']' && !inQuote {
+					break
+				}
+			}
+			if idx >= len(data) {
+				data = ""
+				break
+			}
+			data = data[idx+1:]
+		}
+	}
+	data = strings.TrimPrefix(data, " ")
+	entry.Msg = strings.TrimPrefix(data, "\ufeff") // BOM
+}
+
+// Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+func parseSyslog3164(entry *logEntry, data string) {
+	if len(data) < 16 || data[15] != ' ' {
+		entry.Msg = data
+		return
+	}
+	ts, err := time.ParseInLocation(time.Stamp, data[0:15], time.Local)
+	if err != nil {
+		entry.Msg = data
+		return
+	}
+	now := time.Now()
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0) // December messages received in January
+	}
+	entry.Ts = ts.UnixNano() / int64(time.Millisecond)
+	entry.Host, data = nextField(data[16:])
+	tagEnd := strings.IndexAny(data, "[: ")
+	if tagEnd > 0 && tagEnd <= 48 && data[tagEnd] != ' ' {
+		entry.App = data[0:tagEnd]
+		if colonIdx := strings.Index(data, ": "); colonIdx != -1 {
+			data = data[colonIdx+2:]
+		} else {
+			data = data[tagEnd:]
+		}
+	}
+	entry.Msg = data
+}
+
+// host of a remote address, used when the message has no HOSTNAME
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (t *Tailer) startSyslog() error {
+	cfg := t.cfg.Syslog
+	if cfg.UDPAddr != "" {
+		conn, err := net.ListenPacket("udp", cfg.UDPAddr)
+		if err != nil {
+			return fmt.Errorf("Cannot listen for syslog on udp %s: %w", cfg.UDPAddr, err)
+		}
+		t.addCloser(conn)
+		go t.readSyslogUDP(conn)
+	}
+	if cfg.TCPAddr != "" {
+		ln, err := net.Listen("tcp", cfg.TCPAddr)
+		if err != nil {
+			return fmt.Errorf("Cannot listen for syslog on tcp %s: %w", cfg.TCPAddr, err)
+		}
+		t.addCloser(ln)
+		go t.acceptSyslogTCP(ln)
+	}
+	return nil
+}
+
+func (t *Tailer) addSyslogMessage(msg string, addr net.Addr) {
+	entry := parseSyslog(msg)
+	if entry.Host == "" {
+		entry.Host = remoteHost(addr)
+	}
+	t.addEntry("syslog", t.syslogFilter, entry)
+}
+
+func (t *Tailer) readSyslogUDP(conn net.PacketConn) {
+	buf := make([]byte, maxSyslogMsgSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		t.addSyslogMessage(string(buf[0:n]), addr)
+	}
+}
+
+func (t *Tailer) acceptSyslogTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.addCloser(conn)
+		go func() {
+			defer t.removeCloser(conn)
+			defer conn.Close()
+			t.readSyslogTCP(conn)
+		}()
+	}
+}
+
+// each message is either octet-counted ("<len> <msg>", RFC 6587) or newline terminated
+func (t *Tailer) readSyslogTCP(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, maxSyslogMsgSize)
+	for {
+		firstByte, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		var msg []byte
+		if firstByte[0] >= '1' && firstByte[0] <= '9' {
+			lenStr, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			msgLen, err := strconv.Atoi(strings.TrimSpace(lenStr))
+			if err != nil || msgLen > maxSyslogMsgSize {
+				return
+			}
+			msg = make([]byte, msgLen)
+			_, err = io.ReadFull(r, msg)
+			if err != nil {
+				return
+			}
+		} else {
+			msg, err = r.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				msg = append([]byte(nil), msg...)
+				for err == bufio.ErrBufferFull {
+					_, err = r.ReadSlice('\n') // discard the rest of an oversized line
+				}
+			}
+			if err != nil && len(msg) == 0 {
+				return
+			}
+		}
+		if len(bytes.TrimSpace(msg)) > 0 {
+			t.addSyslogMessage(string(msg), conn.RemoteAddr())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const manifestDefaultName = "/_manifest.json"
+
+// A path in a Manifest.  Sha256 is base64 encoded (same as FileInfo.Sha256).
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// Checksums for a deployed tree of static paths (see BuildManifest).  Written to DashFS with
+// WriteManifest and checked against the remote state with VerifyManifest.
+type Manifest struct {
+	Version   string          `json:"version"`        // deploy version label
+	Root      string          `json:"root,omitempty"` // if set, VerifyManifest reports extra paths under Root
+	CreatedTs int64           `json:"createdts"`
+	Entries   []ManifestEntry `json:"entries"`
+}
+
+// Stored in the manifest file's metadata so the deployed version can be checked with FileInfo.
+type ManifestSummary struct {
+	Version    string `json:"version"`
+	NumEntries int    `json:"numentries"`
+	CreatedTs  int64  `json:"createdts"`
+}
+
+// A path whose remote size or hash does not match the manifest.
+type ManifestDiff struct {
+	Path           string
+	ExpectedSize   int64
+	ExpectedSha256 string
+	ActualSize     int64
+	ActualSha256   string
+}
+
+// Result of VerifyManifest.
+type ManifestReport struct {
+	Version string
+	NumOk   int
+	Missing []string       // in the manifest, not found remotely
+	Changed []ManifestDiff // size or hash differs
+	Extra   []string       // found under Manifest.Root, not in the manifest
+}
+
+// Returns true if the remote state matches the manifest.
+func (r *ManifestReport) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Changed) == 0 && len(r.Extra) == 0
+}
+
+func (r *ManifestReport) String() string {
+	if r.Ok() {
+		return fmt.Sprintf("manifest version:%s ok (%d paths)", r.Version, r.NumOk)
+	}
+	return fmt.Sprintf("manifest version:%s drift detected, ok:%d missing:%d changed:%d extra:%d", r.Version, r.NumOk, len(r.Missing), len(r.Changed), len(r.Extra))
+}
+
+func manifestEntryForItem(item *UploadItem) (ManifestEntry, error) {
+	fileOpts := &FileOpts{}
+	if item.FileName != "" {
+		fd, err := os.Open(item.FileName)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		defer fd.Close()
+		err = UpdateFileOptsFromReadSeeker(fd, fileOpts)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+	} else {
+		err := UpdateFileOptsFromReadSeeker(bytes.NewReader(item.Data), fileOpts)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+	}
+	return ManifestEntry{Path: item.Path, Size: fileOpts.Size, Sha256: fileOpts.Sha256}, nil
+}
+
+// Builds a manifest (sizes and hashes computed locally) for a set of UploadItems, normally
+// the items passed to UploadBatch.  Entries are sorted by path.
+func BuildManifest(version string, root string, items []UploadItem) (*Manifest, error) {
+	if root != "" {
+		err := dashutil.Path(root).Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rtn := &Manifest{Version: version, Root: root, CreatedTs: dashutil.Ts()}
+	for idx := range items {
+		item := &items[idx]
+		err := item.Validate()
+		if err != nil {
+			return nil, err
+		}
+		entry, err := manifestEntryForItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("Error building manifest entry for path:%s: %w", item.Path, err)
+		}
+		rtn.Entries = append(rtn.Entries, entry)
+	}
+	sort.Slice(rtn.Entries, func(i int, j int) bool {
+		return rtn.Entries[i].Path < rtn.Entries[j].Path
+	})
+	return rtn, nil
+}
+
+// Writes the manifest as a hidden JSON file to manifestPath (defaults to "/_manifest.json"
+// under the manifest's Root).  The file's metadata holds a ManifestSummary.
+func (fs *DashFSClient) WriteManifest(manifestPath string, manifest *Manifest) error {
+	if manifest == nil {
+		return dasherr.ValidateErr(fmt.Errorf("WriteManifest manifest cannot be nil"))
+	}
+	if manifestPath == "" {
+		manifestPath = strings.TrimRight(manifest.Root, "/") + manifestDefaultName
+	}
+	fileOpts := &FileOpts{Hidden: true}
+	err := fileOpts.SetMetadata(ManifestSummary{Version: manifest.Version, NumEntries: len(manifest.Entries), CreatedTs: manifest.CreatedTs})
+	if err != nil {
+		return err
+	}
+	return fs.SetJsonPath(manifestPath, manifest, fileOpts)
+}
+
+// Returns the summary (version, number of entries) of a manifest written with WriteManifest,
+// or nil if manifestPath does not exist.
+func (fs *DashFSClient) ManifestSummary(manifestPath string) (*ManifestSummary, error) {
+	finfo, err := fs.FileInfo(manifestPath)
+	if err != nil || finfo == nil {
+		return nil, err
+	}
+	if finfo.MetadataJson == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Path '%s' is not a manifest (no metadata)", manifestPath))
+	}
+	var rtn ManifestSummary
+	err = finfo.BindMetadata(&rtn)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("ManifestSummary", err)
+	}
+	return &rtn, nil
+}
+
+// Checks the remote state against the manifest (sizes and hashes from FileInfo), reporting
+// missing and changed paths, and (if the manifest has a Root) extra paths under the root.
+// Usage: report, err := fs.VerifyManifest(manifest); if !report.Ok() { log.Printf("%s\n", report) }
+func (fs *DashFSClient) VerifyManifest(manifest *Manifest) (*ManifestReport, error) {
+	if manifest == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("VerifyManifest manifest cannot be nil"))
+	}
+	rtn := &ManifestReport{Version: manifest.Version}
+	remote := make(map[string]*FileInfo)
+	if manifest.Root != "" {
+		finfos, err := fs.DirInfo(manifest.Root, &DirOpts{ShowHidden: true, Recursive: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, finfo := range finfos {
+			if finfo.FileType == FileTypeDir {
+				continue
+			}
+			remote[strings.TrimPrefix(finfo.Path, fs.rootPath)] = finfo
+		}
+	}
+	manifestPaths := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		manifestPaths[entry.Path] = true
+		finfo, ok := remote[entry.Path]
+		if !ok && manifest.Root == "" {
+			var err error
+			finfo, err = fs.FileInfo(entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			ok = (finfo != nil)
+		}
+		if !ok || finfo.Removed {
+			rtn.Missing = append(rtn.Missing, entry.Path)
+			continue
+		}
+		if finfo.Size != entry.Size || finfo.Sha256 != entry.Sha256 {
+			rtn.Changed = append(rtn.Changed, ManifestDiff{
+				Path:           entry.Path,
+				ExpectedSize:   entry.Size,
+				ExpectedSha256: entry.Sha256,
+				ActualSize:     finfo.Size,
+				ActualSha256:   finfo.Sha256,
+			})
+			continue
+		}
+		rtn.NumOk++
+	}
+	manifestFile := strings.TrimRight(manifest.Root, "/") + manifestDefaultName
+	for path := range remote {
+		if !manifestPaths[path] && path != manifestFile {
+			rtn.Extra = append(rtn.Extra, path)
+		}
+	}
+	sort.Strings(rtn.Extra)
+	return rtn, nil
+}
@@ -0,0 +1,463 @@
+// Subscribes to MQTT topics and maps the messages to frontend data paths, pushed to Dashborg
+// frontends with a stream handler.  The MQTT connection is provided by the caller as a
+// Subscriber (e.g. an adapter for github.com/eclipse/paho.mqtt.golang):
+//
+//	type pahoSubscriber struct {
+//		client mqtt.Client // created with SetAutoReconnect(true) and SetCleanSession(false)
+//	}
+//
+//	func (s *pahoSubscriber) Subscribe(topic string, qos byte, handler func(msg *mqttbridge.Message)) error {
+//		token := s.client.Subscribe(topic, qos, func(_ mqtt.Client, msg mqtt.Message) {
+//			handler(&mqttbridge.Message{Topic: msg.Topic(), Payload: msg.Payload()})
+//		})
+//		token.Wait()
+//		return token.Error()
+//	}
+//
+//	func (s *pahoSubscriber) Unsubscribe(topics ...string) error {
+//		token := s.client.Unsubscribe(topics...)
+//		token.Wait()
+//		return token.Error()
+//	}
+//
+//	func (s *pahoSubscriber) IsConnected() bool {
+//		return s.client.IsConnected()
+//	}
+package mqttbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultMaxItems     = 100
+	subscriberQueueSize = 256
+	maxStreamBatch      = 100
+
+	OpSet    = "set"
+	OpAppend = "append"
+)
+
+// Maps an MQTT topic filter to a frontend data path.
+type Mapping struct {
+	Topic    string `json:"topic"`    // topic filter, may use the "+" and "#" wildcards
+	QoS      byte   `json:"qos"`      // subscription QoS (0, 1, or 2)
+	Path     string `json:"path"`     // frontend data path, may be a text/template (e.g. "$.sensors.{{index .Parts 1}}")
+	Template string `json:"template"` // optional text/template producing JSON from the message, default is the payload as JSON (or a string)
+	Op       string `json:"op"`       // OpSet (default) or OpAppend
+	MaxItems int    `json:"maxitems"` // items kept for OpAppend paths (defaults to DefaultMaxItems)
+}
+
+// Bridge config.  Can be unmarshaled from JSON (except Logger).
+type Config struct {
+	Mappings []Mapping   `json:"mappings"`
+	Logger   *log.Logger `json:"-"` // defaults to the standard logger
+}
+
+// A received MQTT message.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// The MQTT client connection (see the package example for a paho adapter).  The client is
+// responsible for connecting, reconnecting, and restoring subscriptions.  handler is called for
+// each received message, it applies the message to the bridge, so for QoS 1 and 2 the client
+// should acknowledge the message after handler returns.  If the Subscriber also implements
+// IsConnected() bool, it is used for Status.Connected.
+type Subscriber interface {
+	Subscribe(topic string, qos byte, handler func(msg *Message)) error
+	Unsubscribe(topics ...string) error
+}
+
+// The data passed to Mapping Path and Template templates.
+type TemplateData struct {
+	Topic   string
+	Parts   []string    // topic split on "/"
+	Payload string      // raw payload
+	Json    interface{} // payload decoded as JSON (nil if it is not valid JSON)
+	Ts      int64       // receive time (ms)
+}
+
+type mapping struct {
+	Mapping
+	pathTmpl *template.Template
+	tmpl     *template.Template
+}
+
+type update struct {
+	op   string
+	path string
+	val  interface{}
+}
+
+type Bridge struct {
+	lock        *sync.Mutex
+	cfg         Config
+	mappings    []*mapping
+	values      map[string]interface{}   // OpSet path => last value
+	lists       map[string][]interface{} // OpAppend path => last MaxItems values
+	subscribers map[int]chan update
+	nextSubId   int
+	mqttSub     Subscriber
+	subscribed  bool
+	lastErr     error
+	doneCh      chan struct{}
+	once        *sync.Once
+}
+
+// Bridge state, returned from the "status" handler.
+type Status struct {
+	Connected bool   `json:"connected"` // subscribed (and the Subscriber reports it is connected)
+	LastErr   string `json:"lasterr,omitempty"`
+}
+
+func validTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	parts := strings.Split(filter, "/")
+	for idx, part := range parts {
+		if part == "#" && idx != len(parts)-1 {
+			return false
+		}
+		if part != "+" && part != "#" && strings.ContainsAny(part, "+#") {
+			return false
+		}
+	}
+	return true
+}
+
+func topicMatches(filter string, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for idx, fpart := range filterParts {
+		if fpart == "#" {
+			return true
+		}
+		if idx >= len(topicParts) {
+			return false
+		}
+		if fpart != "+" && fpart != topicParts[idx] {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
+
+func MakeBridge(mqttSub Subscriber, cfg *Config) (*Bridge, error) {
+	if mqttSub == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("mqttbridge requires a Subscriber"))
+	}
+	if cfg == nil || len(cfg.Mappings) == 0 {
+		return nil, dasherr.ValidateErr(fmt.Errorf("mqttbridge Config must specify Mappings"))
+	}
+	rtn := &Bridge{
+		lock:        &sync.Mutex{},
+		cfg:         *cfg,
+		values:      make(map[string]interface{}),
+		lists:       make(map[string][]interface{}),
+		subscribers: make(map[int]chan update),
+		mqttSub:     mqttSub,
+		doneCh:      make(chan struct{}),
+		once:        &sync.Once{},
+	}
+	var err error
+	for idx := range rtn.cfg.Mappings {
+		m := &mapping{Mapping: rtn.cfg.Mappings[idx]}
+		if !validTopicFilter(m.Topic) {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid MQTT topic filter '%s'", m.Topic))
+		}
+		if m.QoS > 2 {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid QoS %d for topic '%s'", m.QoS, m.Topic))
+		}
+		if m.Path == "" {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Mapping for topic '%s' must specify Path", m.Topic))
+		}
+		if m.Op == "" {
+			m.Op = OpSet
+		}
+		if m.Op != OpSet && m.Op != OpAppend {
+			return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Op '%s' for topic '%s' (must be \"set\" or \"append\")", m.Op, m.Topic))
+		}
+		if m.MaxItems <= 0 {
+			m.MaxItems = DefaultMaxItems
+		}
+		if strings.Contains(m.Path, "{{") {
+			m.pathTmpl, err = template.New("path").Parse(m.Path)
+			if err != nil {
+				return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Path template for topic '%s': %w", m.Topic, err))
+			}
+		}
+		if m.Template != "" {
+			m.tmpl, err = template.New("value").Parse(m.Template)
+			if err != nil {
+				return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Template for topic '%s': %w", m.Topic, err))
+			}
+		}
+		rtn.mappings = append(rtn.mappings, m)
+	}
+	return rtn, nil
+}
+
+func (b *Bridge) logf(fmtStr string, args ...interface{}) {
+	if b.cfg.Logger != nil {
+		b.cfg.Logger.Printf(fmtStr, args...)
+	} else {
+		log.Printf(fmtStr, args...)
+	}
+}
+
+// Creates the bridge, registers its handlers on the runtime, and subscribes to the Mapping topics.
+// The bridge stops (unsubscribes) when the client shuts down (or Stop() is called).
+func Start(client *dash.DashCloudClient, rt dash.HandlerRegistry, mqttSub Subscriber, cfg *Config) (*Bridge, error) {
+	b, err := MakeBridge(mqttSub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.Register(rt)
+	err = b.Run()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-client.DoneCh:
+			b.Stop()
+		case <-b.doneCh:
+		}
+	}()
+	return b, nil
+}
+
+// Registers the "stream" handler (start it as a stream to receive updates, it sends the
+// current values first) and the pure "values" and "status" handlers.
+func (b *Bridge) Register(rt dash.HandlerRegistry) {
+	rt.Handler("stream", b.stream)
+	rt.PureHandler("values", b.Values)
+	rt.PureHandler("status", b.Status)
+}
+
+// Subscribes to the Mapping topics.  On error, the topics that were subscribed are unsubscribed.
+func (b *Bridge) Run() error {
+	var topics []string
+	for _, m := range b.mappings {
+		err := b.mqttSub.Subscribe(m.Topic, m.QoS, b.handleMessage)
+		if err != nil {
+			err = fmt.Errorf("mqttbridge cannot subscribe to '%s': %w", m.Topic, err)
+			if len(topics) > 0 {
+				b.mqttSub.Unsubscribe(topics...)
+			}
+			b.setSubscribed(false, err)
+			return err
+		}
+		topics = append(topics, m.Topic)
+	}
+	b.setSubscribed(true, nil)
+	return nil
+}
+
+func (b *Bridge) Stop() {
+	b.once.Do(func() {
+		close(b.doneCh)
+		var topics []string
+		for _, m := range b.mappings {
+			topics = append(topics, m.Topic)
+		}
+		err := b.mqttSub.Unsubscribe(topics...)
+		if err != nil {
+			b.logf("mqttbridge error unsubscribing: %v\n", err)
+		}
+		b.setSubscribed(false, nil)
+	})
+}
+
+func (b *Bridge) setSubscribed(subscribed bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscribed = subscribed
+	if err != nil {
+		b.lastErr = err
+	}
+}
+
+func (b *Bridge) handleMessage(msg *Message) {
+	data := &TemplateData{Topic: msg.Topic, Parts: strings.Split(msg.Topic, "/"), Payload: string(msg.Payload), Ts: dashutil.Ts()}
+	var jsonVal interface{}
+	if json.Unmarshal(msg.Payload, &jsonVal) == nil {
+		data.Json = jsonVal
+	}
+	for _, m := range b.mappings {
+		if !topicMatches(m.Topic, msg.Topic) {
+			continue
+		}
+		path, val, err := m.convert(data)
+		if err != nil {
+			b.logf("mqttbridge error converting message topic:%s err:%v\n", msg.Topic, err)
+			continue
+		}
+		b.publish(update{op: m.Op, path: path, val: val}, m.MaxItems)
+	}
+}
+
+// returns the data path and value for a message
+func (m *mapping) convert(data *TemplateData) (string, interface{}, error) {
+	path := m.Path
+	if m.pathTmpl != nil {
+		var buf bytes.Buffer
+		err := m.pathTmpl.Execute(&buf, data)
+		if err != nil {
+			return "", nil, err
+		}
+		path = buf.String()
+	}
+	if m.tmpl == nil {
+		if data.Json != nil {
+			return path, data.Json, nil
+		}
+		return path, data.Payload, nil
+	}
+	var buf bytes.Buffer
+	err := m.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", nil, err
+	}
+	var val interface{}
+	err = json.Unmarshal(buf.Bytes(), &val)
+	if err != nil {
+		return "", nil, fmt.Errorf("Template output is not valid JSON: %w", err)
+	}
+	return path, val, nil
+}
+
+func (b *Bridge) publish(u update, maxItems int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if u.op == OpAppend {
+		list := append(b.lists[u.path], u.val)
+		if len(list) > maxItems {
+			list = list[len(list)-maxItems:]
+		}
+		b.lists[u.path] = list
+	} else {
+		b.values[u.path] = u.val
+	}
+	for subId, ch := range b.subscribers {
+		select {
+		case ch <- u:
+		default:
+			// slow stream, it is closed and the frontend restarts it (receiving a full snapshot)
+			close(ch)
+			delete(b.subscribers, subId)
+		}
+	}
+}
+
+func (b *Bridge) subscribe() (int, chan update) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nextSubId++
+	ch := make(chan update, subscriberQueueSize)
+	b.subscribers[b.nextSubId] = ch
+	return b.nextSubId, ch
+}
+
+func (b *Bridge) unsubscribe(subId int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, subId)
+}
+
+// must hold lock
+func (b *Bridge) sortedPathsNoLock() ([]string, []string) {
+	var setPaths, appendPaths []string
+	for path := range b.values {
+		setPaths = append(setPaths, path)
+	}
+	for path := range b.lists {
+		appendPaths = append(appendPaths, path)
+	}
+	sort.Strings(setPaths)
+	sort.Strings(appendPaths)
+	return setPaths, appendPaths
+}
+
+// sends the current values, then forwards updates until the stream ends
+func (b *Bridge) stream(req dash.ActionRequest) error {
+	subId, ch := b.subscribe()
+	defer b.unsubscribe(subId)
+	b.lock.Lock()
+	setPaths, appendPaths := b.sortedPathsNoLock()
+	for _, path := range setPaths {
+		req.SetData(path, b.values[path])
+	}
+	for _, path := range appendPaths {
+		req.SetData(path, append([]interface{}{}, b.lists[path]...))
+	}
+	b.lock.Unlock()
+	err := req.Flush()
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case u, ok := <-ch:
+			if !ok {
+				return dasherr.ErrWithCode(dasherr.ErrCodeQueueFull, fmt.Errorf("mqttbridge stream fell behind"))
+			}
+			req.AddDataOp(u.op, u.path, u.val)
+			for numBatch := 1; numBatch < maxStreamBatch && len(ch) > 0; numBatch++ {
+				u = <-ch
+				req.AddDataOp(u.op, u.path, u.val)
+			}
+			err = req.Flush()
+			if err != nil {
+				return err
+			}
+
+		case <-req.Context().Done():
+			return nil
+
+		case <-b.doneCh:
+			return nil
+		}
+	}
+}
+
+// Returns the current values by data path (OpAppend paths return their kept items).
+func (b *Bridge) Values() map[string]interface{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	rtn := make(map[string]interface{})
+	for path, val := range b.values {
+		rtn[path] = val
+	}
+	for path, list := range b.lists {
+		rtn[path] = append([]interface{}{}, list...)
+	}
+	return rtn
+}
+
+func (b *Bridge) Status() Status {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	rtn := Status{Connected: b.subscribed}
+	if connSub, ok := b.mqttSub.(interface{ IsConnected() bool }); ok && rtn.Connected {
+		rtn.Connected = connSub.IsConnected()
+	}
+	if b.lastErr != nil {
+		rtn.LastErr = b.lastErr.Error()
+	}
+	return rtn
+}
@@ -0,0 +1,127 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	AddrFamilyIPv4       = "ipv4"        // only connect over IPv4
+	AddrFamilyIPv6       = "ipv6"        // only connect over IPv6
+	AddrFamilyPreferIPv4 = "prefer-ipv4" // try IPv4 addresses first
+	AddrFamilyPreferIPv6 = "prefer-ipv6" // try IPv6 addresses first
+)
+
+// Resolves a hostname to IP addresses (see Config.Resolver).
+type ResolverFn func(ctx context.Context, host string) ([]string, error)
+
+func (c *Config) validateNetOpts() error {
+	switch c.AddressFamily {
+	case "", AddrFamilyIPv4, AddrFamilyIPv6, AddrFamilyPreferIPv4, AddrFamilyPreferIPv6:
+	default:
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AddressFamily '%s'", c.AddressFamily))
+	}
+	for _, ipStr := range c.grpcHostIPs() {
+		if net.ParseIP(ipStr) == nil {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid GrpcHostIP '%s'", ipStr))
+		}
+	}
+	return nil
+}
+
+func (c *Config) grpcHostIPs() []string {
+	if c.GrpcHostIP == "" {
+		return nil
+	}
+	var rtn []string
+	for _, ipStr := range strings.Split(c.GrpcHostIP, ",") {
+		rtn = append(rtn, strings.TrimSpace(ipStr))
+	}
+	return rtn
+}
+
+// true if dials need the SDK's dialer (instead of the gRPC / net/http default)
+func (c *Config) hasNetOverrides() bool {
+	return c.AddressFamily != "" || c.GrpcHostIP != "" || c.Resolver != nil
+}
+
+func (c *Config) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if c.GrpcHostIP != "" && host == c.GrpcHost {
+		return c.grpcHostIPs(), nil
+	}
+	if c.Resolver != nil {
+		return c.Resolver(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// filters and orders ips by AddressFamily
+func filterAddrFamily(ips []string, family string) []string {
+	var rtn []string
+	for _, ipStr := range ips {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			continue
+		}
+		isV4 := ip.To4() != nil
+		if (family == AddrFamilyIPv4 && !isV4) || (family == AddrFamilyIPv6 && isV4) {
+			continue
+		}
+		rtn = append(rtn, ipStr)
+	}
+	if family == AddrFamilyPreferIPv4 || family == AddrFamilyPreferIPv6 {
+		preferV4 := family == AddrFamilyPreferIPv4
+		sort.SliceStable(rtn, func(i int, j int) bool {
+			iV4 := net.ParseIP(rtn[i]).To4() != nil
+			jV4 := net.ParseIP(rtn[j]).To4() != nil
+			return iV4 != jV4 && iV4 == preferV4
+		})
+	}
+	return rtn
+}
+
+// dials addr (host:port) using GrpcHostIP, Resolver, and AddressFamily.  addresses are tried in order.
+func (c *Config) dialDirect(ctx context.Context, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := c.lookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot resolve host '%s': %w", host, err)
+	}
+	ips = filterAddrFamily(ips, c.AddressFamily)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No addresses for host '%s' (AddressFamily '%s')", host, c.AddressFamily)
+	}
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// rewrites the GrpcHost to GrpcHostIP (the proxy does the DNS lookup for other hosts)
+func (c *Config) proxyTargetAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || c.GrpcHostIP == "" || host != c.GrpcHost {
+		return addr
+	}
+	return net.JoinHostPort(c.grpcHostIPs()[0], port)
+}
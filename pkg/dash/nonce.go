@@ -0,0 +1,163 @@
+package dash
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	NonceMwName       = "nonce"
+	NonceMwPriority   = 100 // runs before FeatureFlagMwPriority
+	DefaultNonceTTL   = 30 * time.Minute
+	nonceStateKey     = "nonce"
+	nonceStatePath    = "$state.dashborg.nonce"
+	maxMemNonceStates = 100000
+)
+
+// Stores issued nonces for NonceMiddleware.  The default (MakeMemNonceStore) is in-memory, so
+// shared links (LinkOpts) with multiple processes should use a shared store (or an Affinity
+// that routes a frontend client to the same process).
+type NonceStore interface {
+	Issue(feClientId string, nonce string, expTs int64) error
+	// Returns true if the nonce was issued to feClientId and not expired or already consumed.
+	Consume(feClientId string, nonce string) (bool, error)
+}
+
+// Options for NonceMiddleware.
+type NonceOpts struct {
+	Store         NonceStore    // defaults to MakeMemNonceStore()
+	TTL           time.Duration // how long an issued nonce is valid (defaults to DefaultNonceTTL)
+	ExemptHandler []string      // handlers that do not require a nonce (they still issue one), defaults to "@init"
+}
+
+type memNonceEntry struct {
+	feClientId string
+	expTs      int64
+}
+
+type memNonceStore struct {
+	lock   *sync.Mutex
+	nonces map[string]memNonceEntry
+}
+
+// An in-memory NonceStore.  Expired nonces are swept on Issue.
+func MakeMemNonceStore() NonceStore {
+	return &memNonceStore{lock: &sync.Mutex{}, nonces: make(map[string]memNonceEntry)}
+}
+
+func (s *memNonceStore) Issue(feClientId string, nonce string, expTs int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.nonces) >= maxMemNonceStates {
+		nowTs := dashutil.Ts()
+		for key, entry := range s.nonces {
+			if entry.expTs < nowTs {
+				delete(s.nonces, key)
+			}
+		}
+		if len(s.nonces) >= maxMemNonceStates {
+			return dasherr.ErrWithCode(dasherr.ErrCodeLimit, fmt.Errorf("Too many outstanding nonces"))
+		}
+	}
+	s.nonces[nonce] = memNonceEntry{feClientId: feClientId, expTs: expTs}
+	return nil
+}
+
+func (s *memNonceStore) Consume(feClientId string, nonce string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.nonces[nonce]
+	if !ok {
+		return false, nil
+	}
+	delete(s.nonces, nonce)
+	return entry.feClientId == feClientId && entry.expTs >= dashutil.Ts(), nil
+}
+
+func nonceFromState(req *AppRequest) string {
+	var state dashborgState
+	err := req.BindAppState(&state)
+	if err != nil {
+		return ""
+	}
+	nonce, _ := state.Dashborg[nonceStateKey].(string)
+	return nonce
+}
+
+// Creates a middleware that protects mutating (non-GET) handler calls against replay.  Each
+// response pushes a fresh single-use nonce to the frontend ($state.dashborg.nonce), and each
+// mutating call must send back an unused nonce issued to the same frontend client.  Calls with
+// a missing or reused nonce are rejected before the handler runs.  If the handler returns an
+// error the nonce is re-issued (actions are not sent on errors, so the frontend keeps it).
+// Install with AddRawMiddleware(NonceMwName, mw, NonceMwPriority) or app.SetNonceProtection.
+func NonceMiddleware(opts NonceOpts) MiddlewareFuncType {
+	if opts.Store == nil {
+		opts.Store = MakeMemNonceStore()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultNonceTTL
+	}
+	if opts.ExemptHandler == nil {
+		opts.ExemptHandler = []string{pathFragInit}
+	}
+	exempt := make(map[string]bool)
+	for _, name := range opts.ExemptHandler {
+		exempt[name] = true
+	}
+	ttlMs := int64(opts.TTL / time.Millisecond)
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (interface{}, error) {
+		if req.info.RequestMethod == RequestMethodGet || req.info.IsBackendCall {
+			return nextFn(req)
+		}
+		_, _, handlerName, err := dashutil.ParseFullPath(req.info.Path, true)
+		if err != nil {
+			// fail closed, the nonce cannot be checked
+			return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadPath, fmt.Errorf("Forbidden, cannot check request nonce for path '%s': %w", req.info.Path, err))
+		}
+		if handlerName == "" {
+			handlerName = pathFragDefault
+		}
+		feClientId := req.info.FeClientId
+		var nonce string
+		if !exempt[handlerName] {
+			nonce = nonceFromState(req)
+			ok := false
+			if nonce != "" {
+				ok, err = opts.Store.Consume(feClientId, nonce)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !ok {
+				return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid or expired request nonce (handler '%s'), reload the app", handlerName))
+			}
+		}
+		rtn, handlerErr := nextFn(req)
+		if handlerErr != nil || req.GetError() != nil {
+			if nonce != "" {
+				issueErr := opts.Store.Issue(feClientId, nonce, dashutil.Ts()+ttlMs)
+				if issueErr != nil && req.client != nil {
+					req.client.log("Dashborg error re-issuing request nonce (handler '%s'), reqinfo=%s: %v\n", handlerName, req.reqInfoStr(), issueErr)
+				}
+			}
+			return rtn, handlerErr
+		}
+		newNonce := uuid.New().String()
+		err = opts.Store.Issue(feClientId, newNonce, dashutil.Ts()+ttlMs)
+		if err != nil {
+			return nil, err
+		}
+		req.SetData(nonceStatePath, newNonce)
+		return rtn, nil
+	}
+}
+
+// Installs a NonceMiddleware on the app's runtime.
+func (app *App) SetNonceProtection(opts NonceOpts) {
+	app.appRuntime.AddRawMiddleware(NonceMwName, NonceMiddleware(opts), NonceMwPriority)
+}
@@ -0,0 +1,7 @@
+//go:build !dashlite
+// +build !dashlite
+
+package dash
+
+// built with the "dashlite" tag (see Config.LiteMode)
+const liteBuild = false
@@ -0,0 +1,151 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// App relative directory that offline snapshot files are written to.  Handler outputs are
+// written to [OfflineSnapshotDir]/[handler-name].json (accessible in the UI as /@app/_/offline/[handler-name].json).
+const OfflineSnapshotDir = "/_/offline"
+
+const offlineHandlerTimeout = 30 * time.Second
+const minOfflineSnapshotInterval = 10 * time.Second
+
+// Options for App.PublishOfflineSnapshot.  At least one of Handlers or SnapshotFn must be set.
+type OfflineSnapshotOpts struct {
+	// Pure handlers (that take no data arguments) whose outputs are captured to
+	// OfflineSnapshotDir/[handler].json.  Handlers are run with no auth data and no app state.
+	Handlers []string
+
+	// Returns a map of app relative paths (e.g. "/offline/summary.json") to data.  Each value
+	// is written to its path as JSON.  ctx expires after 30s.
+	SnapshotFn func(ctx context.Context) (map[string]interface{}, error)
+
+	// If set, the snapshot is re-published every Interval (minimum 10s) until ShutdownCh is
+	// closed or the client shuts down.  If not set, the snapshot is published once.
+	Interval   time.Duration
+	ShutdownCh chan struct{}
+}
+
+func (opts *OfflineSnapshotOpts) Validate() error {
+	if len(opts.Handlers) == 0 && opts.SnapshotFn == nil {
+		return dasherr.ValidateErr(fmt.Errorf("OfflineSnapshotOpts must set Handlers or SnapshotFn"))
+	}
+	for _, handlerName := range opts.Handlers {
+		if !dashutil.IsPathFragValid(handlerName) || handlerName[0] == '@' {
+			return dasherr.ValidateErr(fmt.Errorf("OfflineSnapshotOpts invalid handler name '%s'", handlerName))
+		}
+	}
+	if opts.Interval != 0 && opts.Interval < minOfflineSnapshotInterval {
+		return dasherr.ValidateErr(fmt.Errorf("OfflineSnapshotOpts Interval must be at least %v", minOfflineSnapshotInterval))
+	}
+	return nil
+}
+
+// Captures the current outputs of data handlers (and/or SnapshotFn) into static Dashborg FS
+// files so the frontend has data to show when the app runtime is disconnected.  Should be
+// used with SetOfflineAccess(true).  The app must already be written and connected.
+// The first snapshot is published synchronously (errors are returned), subsequent
+// refreshes (if Interval is set) log errors.
+func (app *App) PublishOfflineSnapshot(opts *OfflineSnapshotOpts) error {
+	if opts == nil {
+		return dasherr.ValidateErr(fmt.Errorf("PublishOfflineSnapshot requires OfflineSnapshotOpts"))
+	}
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+	if !app.client.IsConnected() {
+		return NotConnectedErr
+	}
+	err = app.publishOfflineSnapshot(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Interval == 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !app.client.IsConnected() {
+					continue
+				}
+				err := app.publishOfflineSnapshot(opts)
+				if err != nil {
+					app.client.log("Dashborg error publishing offline snapshot app:%s err:%v\n", app.appName, err)
+				}
+
+			case <-opts.ShutdownCh:
+				return
+
+			case <-app.client.DoneCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (app *App) publishOfflineSnapshot(opts *OfflineSnapshotOpts) error {
+	fs := app.AppFSClient()
+	roles := app.appConfig.AllowedRoles
+	for _, handlerName := range opts.Handlers {
+		rtnVal, err := app.runOfflineHandler(handlerName)
+		if err != nil {
+			return fmt.Errorf("Error running handler '%s' for offline snapshot: %w", handlerName, err)
+		}
+		if _, ok := rtnVal.(*BlobReturn); ok {
+			return dasherr.ValidateErr(fmt.Errorf("Handler '%s' returns a blob, cannot be used in an offline snapshot", handlerName))
+		}
+		err = fs.SetJsonPath(fmt.Sprintf("%s/%s.json", OfflineSnapshotDir, handlerName), rtnVal, &FileOpts{AllowedRoles: roles})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.SnapshotFn != nil {
+		ctx, cancelFn := context.WithTimeout(context.Background(), offlineHandlerTimeout)
+		defer cancelFn()
+		snapshot, err := opts.SnapshotFn(ctx)
+		if err != nil {
+			return fmt.Errorf("Error running SnapshotFn for offline snapshot: %w", err)
+		}
+		for path, data := range snapshot {
+			err = fs.SetJsonPath(path, data, &FileOpts{AllowedRoles: roles})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runs a pure handler locally (not through the Dashborg service) with no data, auth, or app state.
+func (app *App) runOfflineHandler(handlerName string) (interface{}, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), offlineHandlerTimeout)
+	defer cancelFn()
+	reqMsg := &dashproto.RequestMessage{
+		Ts:            dashutil.Ts(),
+		ReqId:         uuid.New().String(),
+		RequestType:   requestTypePath,
+		RequestMethod: RequestMethodGet,
+		Path:          app.getRuntimePath() + ":" + handlerName,
+	}
+	preq := makeAppRequest(ctx, reqMsg, app.client)
+	if preq.err != nil {
+		return nil, preq.err
+	}
+	// actions are never sent for offline requests
+	preq.isDone = true
+	return app.appRuntime.RunHandler(preq)
+}
@@ -0,0 +1,347 @@
+package dash
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	AuthTypeOIDC         = "oidc"
+	OIDCTokenParam       = "idtoken" // request data key (challenge submit) holding the ID/bearer token
+	DefaultOIDCCacheTTL  = time.Hour
+	DefaultOIDCRoleClaim = "roles"
+	oidcFetchTimeout     = 10 * time.Second
+	oidcMinRefetch       = time.Minute // unknown "kid"s refetch the JWKS at most this often
+)
+
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// Identity provider settings for OIDCAuth.
+type OIDCProviderConfig struct {
+	Issuer       string            // required, must match the token's "iss" claim
+	ClientId     string            // required, must be in the token's "aud" claim
+	JWKSURL      string            // defaults to the "jwks_uri" from Issuer + "/.well-known/openid-configuration"
+	RoleClaim    string            // claim holding the user's groups/roles (string or list), defaults to DefaultOIDCRoleClaim
+	RoleMap      map[string]string // RoleClaim value => Dashborg role, unmapped values are ignored (if nil, values are used as roles)
+	DefaultRoles []string          // roles given to every valid token (e.g. RoleUser)
+	UserIdClaim  string            // defaults to "sub"
+	CacheTTL     time.Duration     // how long the JWKS is cached, defaults to DefaultOIDCCacheTTL
+	HttpClient   *http.Client      // defaults to http.DefaultClient
+}
+
+type jwksCache struct {
+	lock      *sync.Mutex
+	keys      map[string]interface{} // kid => *rsa.PublicKey or *ecdsa.PublicKey
+	fetchTime time.Time
+}
+
+var oidcCacheLock = &sync.Mutex{}
+var oidcCaches = make(map[string]*jwksCache) // issuer|jwksurl => cache
+
+type jwkKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (cfg *OIDCProviderConfig) Validate() error {
+	if cfg.Issuer == "" || cfg.ClientId == "" {
+		return dasherr.ValidateErr(fmt.Errorf("OIDCProviderConfig must specify Issuer and ClientId"))
+	}
+	if cfg.JWKSURL == "" && !strings.HasPrefix(cfg.Issuer, "https://") {
+		return dasherr.ValidateErr(fmt.Errorf("OIDCProviderConfig Issuer must be an https URL (or set JWKSURL)"))
+	}
+	return nil
+}
+
+func (cfg *OIDCProviderConfig) httpClient() *http.Client {
+	if cfg.HttpClient != nil {
+		return cfg.HttpClient
+	}
+	return http.DefaultClient
+}
+
+func (cfg *OIDCProviderConfig) getJson(url string, obj interface{}) error {
+	client := *cfg.httpClient()
+	client.Timeout = oidcFetchTimeout
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(obj)
+	if err != nil {
+		return dasherr.JsonUnmarshalErr("OIDC "+url, err)
+	}
+	return nil
+}
+
+func (cfg *OIDCProviderConfig) jwksUrl() (string, error) {
+	if cfg.JWKSURL != "" {
+		return cfg.JWKSURL, nil
+	}
+	var discovery struct {
+		JwksUri string `json:"jwks_uri"`
+	}
+	err := cfg.getJson(strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return "", err
+	}
+	if discovery.JwksUri == "" {
+		return "", fmt.Errorf("OIDC discovery for '%s' has no jwks_uri", cfg.Issuer)
+	}
+	return discovery.JwksUri, nil
+}
+
+func decodeB64Int(str string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(str, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// converts an RSA or EC JWK to a public key
+func (k jwkKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeB64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeB64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+// must hold cache lock
+func (cfg *OIDCProviderConfig) fetchJwksNoLock(cache *jwksCache) error {
+	url, err := cfg.jwksUrl()
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []jwkKey `json:"keys"`
+	}
+	err = cfg.getJson(url, &jwks)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pubKey, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pubKey
+	}
+	cache.keys = keys
+	cache.fetchTime = time.Now()
+	return nil
+}
+
+// returns the signing key for kid, (re)fetching the JWKS when the cache is stale or the kid is unknown (key rotation)
+func (cfg *OIDCProviderConfig) signingKey(kid string) (interface{}, error) {
+	cacheKey := cfg.Issuer + "|" + cfg.JWKSURL
+	oidcCacheLock.Lock()
+	cache := oidcCaches[cacheKey]
+	if cache == nil {
+		cache = &jwksCache{lock: &sync.Mutex{}}
+		oidcCaches[cacheKey] = cache
+	}
+	oidcCacheLock.Unlock()
+	cacheTTL := cfg.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = DefaultOIDCCacheTTL
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	key, ok := cache.keys[kid]
+	sinceFetch := time.Since(cache.fetchTime)
+	if cache.keys == nil || sinceFetch > cacheTTL || (!ok && sinceFetch > oidcMinRefetch) {
+		err := cfg.fetchJwksNoLock(cache)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot fetch OIDC JWKS for '%s': %w", cfg.Issuer, err)
+		}
+		key, ok = cache.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("Unknown OIDC signing key kid:%s", kid)
+	}
+	return key, nil
+}
+
+// string or list claim values
+func claimStrings(val interface{}) []string {
+	switch v := val.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var rtn []string
+		for _, elem := range v {
+			if str, ok := elem.(string); ok {
+				rtn = append(rtn, str)
+			}
+		}
+		return rtn
+	}
+	return nil
+}
+
+// maps the token's claims to Dashborg roles and a user id
+func (cfg *OIDCProviderConfig) mapClaims(claims jwt.MapClaims) ([]string, string) {
+	roleClaim := cfg.RoleClaim
+	if roleClaim == "" {
+		roleClaim = DefaultOIDCRoleClaim
+	}
+	seen := make(map[string]bool)
+	var roleList []string
+	addRole := func(role string) {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roleList = append(roleList, role)
+		}
+	}
+	for _, role := range cfg.DefaultRoles {
+		addRole(role)
+	}
+	for _, val := range claimStrings(claims[roleClaim]) {
+		if cfg.RoleMap == nil {
+			addRole(val)
+		} else {
+			addRole(cfg.RoleMap[val])
+		}
+	}
+	userIdClaim := cfg.UserIdClaim
+	if userIdClaim == "" {
+		userIdClaim = "sub"
+	}
+	userId, _ := claims[userIdClaim].(string)
+	return roleList, userId
+}
+
+// Validates an OIDC ID (or JWT bearer) token: signature against the provider's JWKS, "iss",
+// "aud", and expiration.  Returns the token's claims.
+func (cfg *OIDCProviderConfig) ValidateToken(tokenStr string) (jwt.MapClaims, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	parser := &jwt.Parser{ValidMethods: oidcSigningMethods}
+	token, err := parser.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return cfg.signingKey(kid)
+	})
+	if err != nil {
+		return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid OIDC token: %w", err))
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid OIDC token"))
+	}
+	if !claims.VerifyIssuer(cfg.Issuer, true) {
+		return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid OIDC token, issuer does not match '%s'", cfg.Issuer))
+	}
+	if !claims.VerifyAudience(cfg.ClientId, true) {
+		return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid OIDC token, audience does not include '%s'", cfg.ClientId))
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, dasherr.NoRetryErrWithCode(dasherr.ErrCodeBadAuth, fmt.Errorf("Invalid OIDC token, no expiration"))
+	}
+	return claims, nil
+}
+
+// checks that an existing oidc AuthAtom was issued by this provider (issuer and client id)
+func (cfg *OIDCProviderConfig) isAuthAtomFromProvider(aa *AuthAtom) bool {
+	iss, _ := aa.Data["iss"].(string)
+	aud, _ := aa.Data["aud"].(string)
+	return iss == cfg.Issuer && aud == cfg.ClientId
+}
+
+// Authenticates the request with an OIDC ID token (or JWT access token) submitted as the
+// "idtoken" request data field (e.g. from a challenge, "Bearer " prefix optional).  The token
+// is validated with cfg.ValidateToken (the provider's JWKS is cached) and its claims are
+// mapped to Dashborg roles (RoleClaim, RoleMap, DefaultRoles).  On success the request's
+// AuthAtom is set (type "oidc", expiring with the token) and sent to the frontend.  An existing
+// oidc AuthAtom is only accepted if it was issued for the same Issuer and ClientId.
+// Returns false (and no error) if no token was submitted, and a BADAUTH error for an invalid
+// token or a token that maps to no roles.
+// Usage: ok, err := req.OIDCAuth(&dash.OIDCProviderConfig{Issuer: "https://login.example.com", ClientId: "dashborg", RoleMap: map[string]string{"ops": "admin"}})
+func (req *AppRequest) OIDCAuth(cfg *OIDCProviderConfig) (bool, error) {
+	if req.authData != nil && req.authData.Type == AuthTypeOIDC && cfg.isAuthAtomFromProvider(req.authData) {
+		return true, nil
+	}
+	tokenStr := strings.TrimSpace(req.DataPath(OIDCTokenParam).StringOr(""))
+	tokenStr = strings.TrimSpace(strings.TrimPrefix(tokenStr, "Bearer "))
+	if tokenStr == "" {
+		return false, nil
+	}
+	claims, err := cfg.ValidateToken(tokenStr)
+	if err != nil {
+		return false, err
+	}
+	roleList, userId := cfg.mapClaims(claims)
+	if len(roleList) == 0 {
+		return false, dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf("OIDC token for '%s' does not map to any roles", userId))
+	}
+	aa := &AuthAtom{Type: AuthTypeOIDC, Id: userId, RoleList: roleList, Data: map[string]interface{}{"iss": cfg.Issuer, "aud": cfg.ClientId}}
+	if exp, ok := claims["exp"].(float64); ok {
+		maxTs := (time.Now().UnixNano() + int64(MaxAuthExp)) / int64(time.Millisecond)
+		aa.Ts = int64(exp) * 1000
+		if aa.Ts > maxTs {
+			aa.Ts = maxTs
+		}
+	}
+	req.setAuthData(aa)
+	req.authData = aa
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package dash
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	RecoverMwName     = "recover"
+	RecoverMwPriority = 150 // runs inside LoggingMiddleware, so recovered panics are logged as errors
+	maxPanicStackSize = 8 * 1024
+)
+
+// Passed to RecoverOpts.OnPanic.
+type PanicInfo struct {
+	AppName string
+	Path    string
+	ReqId   string
+	Value   interface{} // the value passed to panic()
+	Stack   []byte
+}
+
+// Options for RecoverMiddleware.
+type RecoverOpts struct {
+	IncludeStack bool // attach the stack trace to the error sent to the frontend (defaults to the client's Verbose setting)
+	OnPanic      func(info PanicInfo)
+}
+
+// converts a recovered panic to an ErrCodePanic error, the stack is only included in the message if includeStack is set
+func panicToErr(panicVal interface{}, stack []byte, includeStack bool) error {
+	if !includeStack {
+		return dasherr.ErrWithCode(dasherr.ErrCodePanic, fmt.Errorf("PANIC in handler %v", panicVal))
+	}
+	if len(stack) > maxPanicStackSize {
+		stack = stack[0:maxPanicStackSize]
+	}
+	return dasherr.ErrWithCode(dasherr.ErrCodePanic, fmt.Errorf("PANIC in handler %v\n%s", panicVal, stack))
+}
+
+func (pc *DashCloudClient) isVerbose() bool {
+	return pc != nil && pc.Config != nil && pc.Config.Verbose
+}
+
+// default panic handling for dispatched requests, logs the panic (with stack) and sets the request error
+func handleRequestPanic(preq *AppRequest, panicVal interface{}) {
+	stack := debug.Stack()
+	log.Printf("Dashborg PANIC in Handler %4s %s | %v\n%s", preq.info.RequestMethod, dashutil.SimplifyPath(preq.info.Path, nil), panicVal, stack)
+	preq.SetError(panicToErr(panicVal, stack, preq.client.isVerbose()))
+}
+
+// Creates a middleware that recovers panics in the handler (and lower priority middleware) and
+// returns them as ErrCodePanic errors.  Requests are always protected by a default recovery at
+// dispatch, this middleware lets outer middleware (e.g. LoggingMiddleware) see the error, and
+// adds the OnPanic hook (e.g. to report to an error tracker).
+// Usage: app.Runtime().AddRawMiddleware(dash.RecoverMwName, dash.RecoverMiddleware(dash.RecoverOpts{}), dash.RecoverMwPriority)
+func RecoverMiddleware(opts RecoverOpts) MiddlewareFuncType {
+	return func(req *AppRequest, nextFn MiddlewareNextFuncType) (rtnVal interface{}, rtnErr error) {
+		defer func() {
+			panicVal := recover()
+			if panicVal == nil {
+				return
+			}
+			stack := debug.Stack()
+			includeStack := opts.IncludeStack || req.client.isVerbose()
+			if req.client != nil {
+				req.client.log("Dashborg PANIC in Handler %4s %s | %v\n%s", req.info.RequestMethod, dashutil.SimplifyPath(req.info.Path, nil), panicVal, stack)
+			}
+			if opts.OnPanic != nil {
+				opts.OnPanic(PanicInfo{AppName: req.info.AppName, Path: req.info.Path, ReqId: req.info.ReqId, Value: panicVal, Stack: stack})
+			}
+			rtnVal = nil
+			rtnErr = panicToErr(panicVal, stack, includeStack)
+		}()
+		return nextFn(req)
+	}
+}
@@ -0,0 +1,199 @@
+package dash
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Results of Preflight.  Errors would cause ConnectClient or writing/connecting an app to
+// fail, Warnings are informational (e.g. a keypair that will be created by AutoKeygen).
+type PreflightReport struct {
+	Errors   []error
+	Warnings []string
+}
+
+// Returns nil if there are no errors, otherwise all of the errors (as a dashutil.MultiErr
+// if there is more than one).
+func (r *PreflightReport) Err() error {
+	return dashutil.ConvertErrArray(r.Errors)
+}
+
+func (r *PreflightReport) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Dashborg preflight: %d error(s), %d warning(s)\n", len(r.Errors), len(r.Warnings))
+	for _, err := range r.Errors {
+		fmt.Fprintf(&buf, "  ERROR %v\n", err)
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(&buf, "  WARN  %s\n", warning)
+	}
+	return buf.String()
+}
+
+func (r *PreflightReport) addErr(err error) {
+	if err != nil {
+		r.Errors = append(r.Errors, err)
+	}
+}
+
+func (r *PreflightReport) addWarning(fmtStr string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(fmtStr, args...))
+}
+
+// Checks a Config (and optionally apps) without making any network calls, collecting every
+// problem instead of failing on the first one: keypair files exist and are readable, the
+// certificate CN matches AccId, zone/proc/app names are valid, HTML files exist, and
+// options do not conflict.  config is not modified (defaults are applied to a copy).
+// ConnectClient runs the config checks automatically.
+func Preflight(config *Config, apps ...*App) *PreflightReport {
+	report := &PreflightReport{}
+	if config == nil {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Config is nil")))
+		return report
+	}
+	cfg := *config
+	if cfg.JWTOpts != nil {
+		err := cfg.JWTOpts.Validate()
+		if err != nil {
+			report.addErr(fmt.Errorf("Invalid JWTOpts: %w", err))
+			cfg.JWTOpts = DefaultJWTOpts // setDefaults panics on invalid JWTOpts
+		}
+	}
+	cfg.setDefaults()
+	procNameFromExec := config.ProcName == "" && os.Getenv("DASHBORG_PROCNAME") == ""
+	preflightConfig(&cfg, procNameFromExec, report)
+	for _, app := range apps {
+		preflightApp(app, report)
+	}
+	return report
+}
+
+// an invalid ProcName set from the executable name is only a warning
+func preflightConfig(cfg *Config, procNameFromExec bool, report *PreflightReport) {
+	if cfg.AccId != "" && !dashutil.IsUUIDValid(cfg.AccId) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid AccId '%s' (must be a UUID)", cfg.AccId)))
+	}
+	if !dashutil.IsZoneNameValid(cfg.ZoneName) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid ZoneName '%s'", cfg.ZoneName)))
+	}
+	if !dashutil.IsProcNameValid(cfg.ProcName) && procNameFromExec {
+		report.addWarning("Invalid ProcName '%s' (set from the executable name, set Config.ProcName)", cfg.ProcName)
+	} else if !dashutil.IsProcNameValid(cfg.ProcName) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid ProcName '%s'", cfg.ProcName)))
+	}
+	if cfg.ProcIKey != "" && !dashutil.IsProcIKeyValid(cfg.ProcIKey) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid ProcIKey '%s'", cfg.ProcIKey)))
+	}
+	if cfg.GrpcPort < 0 || cfg.GrpcPort > 65535 {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid GrpcPort %d", cfg.GrpcPort)))
+	}
+	if cfg.CompressMinSize < -1 {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid CompressMinSize %d (set to -1 to disable)", cfg.CompressMinSize)))
+	}
+	if !isAppVersionPolicyValid(cfg.AppVersionPolicy) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid AppVersionPolicy '%s' (must be '%s', '%s', or '%s')", cfg.AppVersionPolicy, AppVersionRefuse, AppVersionPreserve, AppVersionDrop)))
+	}
+	if !dashutil.IsJsonTimeFormatValid(cfg.JsonTimeFormat) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid JsonTimeFormat '%s' (must be '', '%s', '%s', '%s', or '%s')", cfg.JsonTimeFormat, dashutil.JsonTimeRFC3339, dashutil.JsonTimeRFC3339Ms, dashutil.JsonTimeEpochMs, dashutil.JsonTimeEpochSec)))
+	}
+	if !dashutil.IsJsonDurationFormatValid(cfg.JsonDurationFormat) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid JsonDurationFormat '%s' (must be '', '%s', '%s', or '%s')", cfg.JsonDurationFormat, dashutil.JsonDurationSeconds, dashutil.JsonDurationMs, dashutil.JsonDurationString)))
+	}
+	if !isErrorSnapshotModeValid(cfg.ErrorSnapshots) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid ErrorSnapshots '%s' (must be '', '%s', or '%s')", cfg.ErrorSnapshots, ErrorSnapshotDashFS, ErrorSnapshotLocal)))
+	}
+	if cfg.KeyFileName == cfg.CertFileName {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("KeyFileName and CertFileName cannot be the same file '%s'", cfg.KeyFileName)))
+		return
+	}
+	preflightKeys(cfg, report)
+}
+
+func fileReadable(fileName string) (bool, error) {
+	fd, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	fd.Close()
+	return true, nil
+}
+
+func preflightKeys(cfg *Config, report *PreflightReport) {
+	keyExists, keyErr := fileReadable(cfg.KeyFileName)
+	certExists, certErr := fileReadable(cfg.CertFileName)
+	if !keyExists && !certExists && cfg.AutoKeygen {
+		report.addWarning("Key file:%s and certificate file:%s do not exist, a new self-signed keypair will be created (AutoKeygen)", cfg.KeyFileName, cfg.CertFileName)
+		return
+	}
+	if cfg.AutoKeygen && keyExists != certExists {
+		report.addErr(fmt.Errorf("AutoKeygen cannot create a keypair, only one of key:%s cert:%s exists", cfg.KeyFileName, cfg.CertFileName))
+	}
+	if !keyExists {
+		report.addErr(fmt.Errorf("Dashborg key file does not exist file:%s", cfg.KeyFileName))
+	} else if keyErr != nil {
+		report.addErr(fmt.Errorf("Cannot read Dashborg key file:%s err:%w", cfg.KeyFileName, keyErr))
+	}
+	if !certExists {
+		report.addErr(fmt.Errorf("Dashborg certificate file does not exist file:%s", cfg.CertFileName))
+	} else if certErr != nil {
+		report.addErr(fmt.Errorf("Cannot read Dashborg certificate file:%s err:%w", cfg.CertFileName, certErr))
+	}
+	if !keyExists || !certExists || keyErr != nil || certErr != nil {
+		return
+	}
+	certInfo, err := readCertInfo(cfg.CertFileName)
+	if err != nil {
+		report.addErr(err)
+		return
+	}
+	if cfg.AccId != "" && certInfo.AccId != cfg.AccId {
+		report.addErr(fmt.Errorf("Dashborg AccId read from certificate:%s does not match AccId in config:%s", certInfo.AccId, cfg.AccId))
+	}
+	_, err = cfg.loadPrivateKey()
+	if err != nil {
+		report.addErr(err)
+	}
+}
+
+func preflightApp(app *App, report *PreflightReport) {
+	if app == nil {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("App is nil")))
+		return
+	}
+	if !dashutil.IsAppNameValid(app.appName) {
+		report.addErr(dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", app.appName)))
+	}
+	if err := app.Err(); err != nil {
+		if merr, ok := err.(dashutil.MultiErr); ok {
+			for _, appErr := range merr.Errs {
+				report.addErr(fmt.Errorf("App '%s': %w", app.appName, appErr))
+			}
+		} else {
+			report.addErr(fmt.Errorf("App '%s': %w", app.appName, err))
+		}
+	}
+	if err := app.validateHtmlOpts(); err != nil {
+		report.addErr(fmt.Errorf("App '%s': %w", app.appName, err))
+	}
+	if app.htmlFileName != "" {
+		exists, err := fileReadable(app.htmlFileName)
+		if !exists {
+			report.addErr(fmt.Errorf("App '%s': HTML file does not exist file:%s", app.appName, app.htmlFileName))
+		} else if err != nil {
+			report.addErr(fmt.Errorf("App '%s': Cannot read HTML file:%s err:%w", app.appName, app.htmlFileName, err))
+		}
+	}
+	if app.htmlStr != "" || app.htmlFileName != "" {
+		issues, _ := app.LintHtml(&HtmlLintOpts{HandlersOnly: true})
+		for _, issue := range issues {
+			report.addWarning("App '%s' HTML %s", app.appName, issue.String())
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package dash
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	MetricGrpcCalls         = "dashborg_grpc_calls_total"
+	MetricRequests          = "dashborg_requests_total"
+	MetricHandlerDuration   = "dashborg_handler_duration_seconds"
+	MetricReconnects        = "dashborg_reconnect_attempts_total"
+	MetricBlobUploadBytes   = "dashborg_blob_upload_bytes_total"
+	MetricStreamClients     = "dashborg_stream_clients"
+	MetricEndpointFailovers = "dashborg_endpoint_failovers_total"
+	MetricNetworkBytes      = "dashborg_network_bytes_total"
+	prometheusTextMediaType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+const (
+	MetricKindCounter   = "counter"
+	MetricKindGauge     = "gauge"
+	MetricKindHistogram = "histogram"
+)
+
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// A snapshot of one labeled value.  For histograms, Buckets holds cumulative counts for each
+// of the family's Buckets upper bounds, Value is unused.
+type MetricSample struct {
+	Labels  map[string]string
+	Value   float64
+	Count   uint64
+	Sum     float64
+	Buckets []uint64
+}
+
+// A snapshot of a metric family, returned by MetricsRegistry.Gather.  Can be converted to
+// prometheus.Metric values (e.g. prometheus.MustNewConstMetric) in a custom Collector.
+type MetricFamily struct {
+	Name       string
+	Help       string
+	Kind       string // MetricKindCounter, MetricKindGauge, or MetricKindHistogram
+	LabelNames []string
+	Buckets    []float64 // histogram upper bounds
+	Samples    []MetricSample
+}
+
+type metricValue struct {
+	labelVals []string
+	val       float64
+	count     uint64
+	sum       float64
+	buckets   []uint64 // non-cumulative counts
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+	values     map[string]*metricValue // joined label values => value
+}
+
+// The SDK's internal metrics (gRPC calls, request dispatches, handler latencies, reconnect
+// attempts, blob upload bytes, stream clients, endpoint failovers, and network bytes).
+// Enabled with Config.EnableMetrics.
+// Serve with Handler() (Prometheus text format) or export with Gather().
+type MetricsRegistry struct {
+	lock     *sync.Mutex
+	families map[string]*metricFamily
+}
+
+func makeMetricsRegistry() *MetricsRegistry {
+	r := &MetricsRegistry{lock: &sync.Mutex{}, families: make(map[string]*metricFamily)}
+	r.addFamily(MetricGrpcCalls, "gRPC calls to the Dashborg service by method and result code.", MetricKindCounter, []string{"method", "code"})
+	r.addFamily(MetricRequests, "Requests received on the request stream by request type.", MetricKindCounter, []string{"type"})
+	r.addFamily(MetricHandlerDuration, "Handler latency for dispatched requests by link path and outcome.", MetricKindHistogram, []string{"path", "outcome"})
+	r.addFamily(MetricReconnects, "Attempts to reconnect the client to the Dashborg service.", MetricKindCounter, []string{"result"})
+	r.addFamily(MetricBlobUploadBytes, "Bytes uploaded to the Dashborg blob service.", MetricKindCounter, nil)
+	r.addFamily(MetricStreamClients, "Number of frontend clients connected to a stream (last reported by the service).", MetricKindGauge, []string{"path"})
+	r.addFamily(MetricEndpointFailovers, "Switches between the primary and secondary gRPC endpoints.", MetricKindCounter, []string{"endpoint"})
+	r.addFamily(MetricNetworkBytes, "Payload bytes sent and received by direction (upload/download) and category (blob, stream, response, file).", MetricKindCounter, []string{"direction", "category"})
+	return r
+}
+
+func (r *MetricsRegistry) addFamily(name string, help string, kind string, labelNames []string) {
+	family := &metricFamily{name: name, help: help, kind: kind, labelNames: labelNames, values: make(map[string]*metricValue)}
+	if kind == MetricKindHistogram {
+		family.buckets = defaultDurationBuckets
+	}
+	r.families[name] = family
+}
+
+// must hold lock
+func (r *MetricsRegistry) getValue(name string, labelVals []string) *metricValue {
+	family := r.families[name]
+	if family == nil || len(labelVals) != len(family.labelNames) {
+		panic(fmt.Sprintf("invalid metric %s labels %v", name, labelVals))
+	}
+	key := strings.Join(labelVals, "\x00")
+	mv := family.values[key]
+	if mv == nil {
+		mv = &metricValue{labelVals: labelVals}
+		if family.kind == MetricKindHistogram {
+			mv.buckets = make([]uint64, len(family.buckets))
+		}
+		family.values[key] = mv
+	}
+	return mv
+}
+
+// all record methods are no-ops on a nil registry (metrics disabled)
+func (r *MetricsRegistry) add(name string, delta float64, labelVals ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.getValue(name, labelVals).val += delta
+}
+
+func (r *MetricsRegistry) set(name string, val float64, labelVals ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.getValue(name, labelVals).val = val
+}
+
+func (r *MetricsRegistry) observe(name string, val float64, labelVals ...string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	mv := r.getValue(name, labelVals)
+	mv.count++
+	mv.sum += val
+	for idx, upperBound := range r.families[name].buckets {
+		if val <= upperBound {
+			mv.buckets[idx]++
+			break
+		}
+	}
+}
+
+func (r *MetricsRegistry) observeDuration(name string, startTime time.Time, labelVals ...string) {
+	r.observe(name, time.Since(startTime).Seconds(), labelVals...)
+}
+
+// Returns a snapshot of all metric families (sorted by name, samples sorted by labels).
+func (r *MetricsRegistry) Gather() []MetricFamily {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var rtn []MetricFamily
+	for _, family := range r.families {
+		mf := MetricFamily{Name: family.name, Help: family.help, Kind: family.kind, LabelNames: family.labelNames, Buckets: family.buckets}
+		var keys []string
+		for key := range family.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			mv := family.values[key]
+			sample := MetricSample{Labels: make(map[string]string), Value: mv.val, Count: mv.count, Sum: mv.sum}
+			for idx, labelName := range family.labelNames {
+				sample.Labels[labelName] = mv.labelVals[idx]
+			}
+			if family.kind == MetricKindHistogram {
+				var cumulative uint64
+				for _, bucketCount := range mv.buckets {
+					cumulative += bucketCount
+					sample.Buckets = append(sample.Buckets, cumulative)
+				}
+			}
+			mf.Samples = append(mf.Samples, sample)
+		}
+		rtn = append(rtn, mf)
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn
+}
+
+var promLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func promLabelStr(labelNames []string, labels map[string]string, extraName string, extraVal string) string {
+	var parts []string
+	for _, labelName := range labelNames {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", labelName, promLabelEscaper.Replace(labels[labelName])))
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", extraName, extraVal))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func promFloatStr(val float64) string {
+	return fmt.Sprintf("%g", val)
+}
+
+// Writes the metrics in the Prometheus text exposition format.
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	var buf strings.Builder
+	for _, mf := range r.Gather() {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", mf.Name, mf.Help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", mf.Name, mf.Kind)
+		for _, sample := range mf.Samples {
+			if mf.Kind != MetricKindHistogram {
+				fmt.Fprintf(&buf, "%s%s %s\n", mf.Name, promLabelStr(mf.LabelNames, sample.Labels, "", ""), promFloatStr(sample.Value))
+				continue
+			}
+			for idx, upperBound := range mf.Buckets {
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", mf.Name, promLabelStr(mf.LabelNames, sample.Labels, "le", promFloatStr(upperBound)), sample.Buckets[idx])
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", mf.Name, promLabelStr(mf.LabelNames, sample.Labels, "le", "+Inf"), sample.Count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", mf.Name, promLabelStr(mf.LabelNames, sample.Labels, "", ""), promFloatStr(sample.Sum))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", mf.Name, promLabelStr(mf.LabelNames, sample.Labels, "", ""), sample.Count)
+		}
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// Returns an http.Handler that serves the metrics in the Prometheus text format (mount it
+// on your metrics endpoint).  Returns 404 if metrics are not enabled.
+// Usage: http.Handle("/metrics", client.Metrics().Handler())
+func (r *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r == nil {
+			http.Error(w, "Dashborg metrics not enabled (Config.EnableMetrics)", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", prometheusTextMediaType)
+		r.WritePrometheus(w)
+	})
+}
+
+// Returns the client's metrics registry, nil if Config.EnableMetrics is not set.  The
+// MetricsRegistry methods can be called on a nil registry.
+func (pc *DashCloudClient) Metrics() *MetricsRegistry {
+	return pc.metrics
+}
+
+// "OK" for nil errors, otherwise the error code ("UNKNOWN" if not set)
+func metricsErrCode(err error) string {
+	if err == nil {
+		return "OK"
+	}
+	code := dasherr.GetErrCode(err)
+	if code == dasherr.ErrCodeNone {
+		return string(dasherr.ErrCodeUnknown)
+	}
+	return string(code)
+}
+
+func metricsResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// strips the path argument from rpc names like "ConnectLink(/path)"
+func grpcMethodName(fnName string) string {
+	if idx := strings.Index(fnName, "("); idx != -1 {
+		return fnName[0:idx]
+	}
+	return fnName
+}
+
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,80 @@
+package dash
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Options for encoding proto.Message values (see SetProtoJsonOpts).
+type ProtoJsonOpts struct {
+	UseProtoNames   bool // use the proto field names (e.g. "user_id") instead of lowerCamelCase JSON names ("userId")
+	UseEnumNumbers  bool // encode enums as numbers instead of their names
+	EmitUnpopulated bool // include fields with zero values (and empty lists/maps)
+}
+
+var protoJsonLock = &sync.Mutex{}
+var protoJsonOpts ProtoJsonOpts
+var protoJsonEnabled bool
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// Enables protojson encoding: once called, proto.Message values in handler return values,
+// SetData, and SetJsonPath (including ones nested in structs, maps, and slices) are encoded
+// with protojson (using opts) instead of encoding/json.  Not enabled by default, so output
+// matches encoding/json unless this is called.  Call at startup, before any requests are
+// processed.  (Handler arguments and BindData targets that are proto.Message types are
+// always decoded with protojson.)
+// Usage: dash.SetProtoJsonOpts(dash.ProtoJsonOpts{UseProtoNames: true})
+func SetProtoJsonOpts(opts ProtoJsonOpts) {
+	protoJsonLock.Lock()
+	defer protoJsonLock.Unlock()
+	protoJsonOpts = opts
+	if !protoJsonEnabled {
+		protoJsonEnabled = true
+		dashutil.RegisterJsonType(protoMessageType, marshalProtoJson, nil)
+	}
+}
+
+func getProtoJsonOpts() ProtoJsonOpts {
+	protoJsonLock.Lock()
+	defer protoJsonLock.Unlock()
+	return protoJsonOpts
+}
+
+func marshalProtoJson(val interface{}) (interface{}, error) {
+	msg, ok := val.(proto.Message)
+	if !ok {
+		return val, nil
+	}
+	opts := getProtoJsonOpts()
+	mopts := protojson.MarshalOptions{
+		UseProtoNames:   opts.UseProtoNames,
+		UseEnumNumbers:  opts.UseEnumNumbers,
+		EmitUnpopulated: opts.EmitUnpopulated,
+	}
+	barr, err := mopts.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(barr), nil
+}
+
+func isProtoMessageType(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Ptr && typ.Implements(protoMessageType)
+}
+
+// unknown fields are discarded so frontends can send extra data (same as encoding/json)
+func unmarshalProtoJson(jsonData string, msg proto.Message) error {
+	uopts := protojson.UnmarshalOptions{DiscardUnknown: true}
+	err := uopts.Unmarshal([]byte(jsonData), msg)
+	if err != nil {
+		return dasherr.JsonUnmarshalErr("ProtoMessage", err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package dash
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// Dials a TCP connection to addr (host:port), see Config.DialContext.
+type DialContextFn func(ctx context.Context, addr string) (net.Conn, error)
+
+const proxyHandshakeTimeout = 30 * time.Second
+
+func parseProxyUrl(proxyUrl string) (*url.URL, error) {
+	urlVal, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid ProxyURL: %w", err))
+	}
+	switch urlVal.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid ProxyURL scheme '%s' (must be http, https, socks5, or socks5h)", urlVal.Scheme))
+	}
+	if urlVal.Hostname() == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid ProxyURL '%s', no host", proxyUrl))
+	}
+	return urlVal, nil
+}
+
+func proxyHostPort(proxyUrl *url.URL) string {
+	if proxyUrl.Port() != "" {
+		return proxyUrl.Host
+	}
+	port := "1080"
+	if proxyUrl.Scheme == "http" {
+		port = "80"
+	} else if proxyUrl.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(proxyUrl.Hostname(), port)
+}
+
+// returns the dialer for the gRPC connection (nil to use gRPC's default, which honors HTTPS_PROXY)
+func (c *Config) grpcDialer() (DialContextFn, error) {
+	if c.DialContext != nil {
+		return c.DialContext, nil
+	}
+	if c.ProxyURL != "" {
+		proxyUrl, err := parseProxyUrl(c.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialProxy(ctx, proxyUrl, c.proxyTargetAddr(addr))
+		}, nil
+	}
+	if c.hasNetOverrides() {
+		return c.dialDirect, nil
+	}
+	return nil, nil
+}
+
+// http transport for calls to the ConsoleHost (and the https transport) that uses the
+// configured DialContext, ProxyURL, RootCAFile, and address overrides.  Defaults to
+// http.DefaultTransport (proxy environment variables).
+func (c *Config) httpTransport() (http.RoundTripper, error) {
+	tlsConfig, err := c.httpsTlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.DialContext == nil && c.ProxyURL == "" && tlsConfig == nil && !c.hasNetOverrides() {
+		return http.DefaultTransport, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	switch {
+	case c.DialContext != nil:
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return c.DialContext(ctx, addr)
+		}
+
+	case c.ProxyURL != "":
+		proxyUrl, err := parseProxyUrl(c.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+
+	case c.hasNetOverrides():
+		transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return c.dialDirect(ctx, addr)
+		}
+	}
+	return transport, nil
+}
+
+// dials addr (host:port) through an http(s) CONNECT or SOCKS5 proxy
+func dialProxy(ctx context.Context, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyHostPort(proxyUrl))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to proxy %s: %w", proxyUrl.Host, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(proxyHandshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+	if proxyUrl.Scheme == "socks5" || proxyUrl.Scheme == "socks5h" {
+		err = socks5Connect(conn, proxyUrl, addr)
+	} else {
+		conn, err = httpConnect(conn, proxyUrl, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s: %w", proxyUrl.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// keeps bytes the proxy sent after the CONNECT response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func httpConnect(conn net.Conn, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	if proxyUrl.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyUrl.Hostname()})
+		err := tlsConn.Handshake()
+		if err != nil {
+			return conn, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{Method: "CONNECT", URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		authStr := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+authStr)
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return conn, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s returned %s", addr, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5UserPassAuth = 2
+	socks5CmdConnect   = 1
+	socks5AtypIPv4     = 1
+	socks5AtypDomain   = 3
+	socks5AtypIPv6     = 4
+)
+
+// RFC 1928 CONNECT (no auth or RFC 1929 username/password).  hostnames are always resolved
+// by the proxy (socks5 and socks5h behave the same).
+func socks5Connect(conn net.Conn, proxyUrl *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port in address '%s'", addr)
+	}
+	methods := []byte{socks5NoAuth}
+	if proxyUrl.User != nil {
+		methods = []byte{socks5NoAuth, socks5UserPassAuth}
+	}
+	_, err = conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("invalid SOCKS version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5NoAuth:
+
+	case socks5UserPassAuth:
+		if proxyUrl.User == nil {
+			return fmt.Errorf("SOCKS proxy requires a username/password")
+		}
+		username := proxyUrl.User.Username()
+		password, _ := proxyUrl.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("SOCKS username/password too long")
+		}
+		authMsg := []byte{1, byte(len(username))}
+		authMsg = append(authMsg, username...)
+		authMsg = append(authMsg, byte(len(password)))
+		authMsg = append(authMsg, password...)
+		_, err = conn.Write(authMsg)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return fmt.Errorf("SOCKS authentication failed")
+		}
+
+	default:
+		return fmt.Errorf("SOCKS proxy has no acceptable authentication method")
+	}
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, socks5AtypIPv4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, socks5AtypIPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("SOCKS hostname too long")
+		}
+		req = append(append(req, socks5AtypDomain, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("SOCKS CONNECT %s failed (reply code %d)", addr, reply[1])
+	}
+	var addrLen int
+	switch reply[3] {
+	case socks5AtypIPv4:
+		addrLen = net.IPv4len
+	case socks5AtypIPv6:
+		addrLen = net.IPv6len
+	case socks5AtypDomain:
+		_, err = io.ReadFull(conn, buf[0:1])
+		if err != nil {
+			return err
+		}
+		addrLen = int(buf[0])
+	default:
+		return fmt.Errorf("invalid SOCKS reply address type %d", reply[3])
+	}
+	// discard the bound address and port
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
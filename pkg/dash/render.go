@@ -0,0 +1,251 @@
+package dash
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const DefaultRenderBindPath = "$.render"
+const defaultRenderMaxDepth = 6
+
+var simpleBindKeyRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Options for Render().  All fields are optional.
+type RenderOpts struct {
+	BindPath string // frontend data path to bind to (defaults to DefaultRenderBindPath)
+	Title    string // optional title (rendered as an <h2>)
+	MaxDepth int    // nested values deeper than MaxDepth are shown using <d-dataview> (defaults to 6)
+	NoApp    bool   // set to true to omit the outer <app> tag (to embed the HTML in a larger template)
+}
+
+// Result of Render().  Data should be set to BindPath (e.g. req.SetData(r.BindPath, r.Data))
+// or published to a static path, and Html used as (or embedded in) the app's HTML.
+type RenderResult struct {
+	Html     string
+	BindPath string
+	Data     interface{}
+}
+
+type renderCtx struct {
+	buf      *bytes.Buffer
+	maxDepth int
+}
+
+// Render reflects over an arbitrary Go value (struct, map, slice, or primitive) and produces
+// Dashborg HTML to display it.  Slices render as tables, structs and maps as key/value panels,
+// and nested values as collapsible sections.  Field names follow encoding/json tag rules.
+func Render(obj interface{}, opts *RenderOpts) (*RenderResult, error) {
+	if opts == nil {
+		opts = &RenderOpts{}
+	}
+	bindPath := opts.BindPath
+	if bindPath == "" {
+		bindPath = DefaultRenderBindPath
+	}
+	if !strings.HasPrefix(bindPath, "$") {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Render BindPath must start with '$'"))
+	}
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultRenderMaxDepth
+	}
+	rctx := &renderCtx{buf: &bytes.Buffer{}, maxDepth: maxDepth}
+	if !opts.NoApp {
+		rctx.buf.WriteString("<app ui=\"dashborg\">\n")
+	}
+	if opts.Title != "" {
+		fmt.Fprintf(rctx.buf, "<h2>%s</h2>\n", html.EscapeString(opts.Title))
+	}
+	rctx.renderValue(reflect.ValueOf(obj), bindPath, 0)
+	if !opts.NoApp {
+		rctx.buf.WriteString("</app>\n")
+	}
+	return &RenderResult{Html: rctx.buf.String(), BindPath: bindPath, Data: obj}, nil
+}
+
+func renderChildPath(parentPath string, key string) string {
+	if simpleBindKeyRe.MatchString(key) {
+		return parentPath + "." + key
+	}
+	return parentPath + "[" + dashutil.QuoteString(key) + "]"
+}
+
+func derefValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// returns the struct fields using encoding/json naming (skips "-" fields, flattens embedded structs)
+func renderStructFields(t reflect.Type) []dashutil.JsonStructField {
+	return dashutil.JsonStructFields(t)
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// types with their own JSON encoding (e.g. time.Time) render as a single value
+func isRenderMarshaler(t reflect.Type) bool {
+	t = derefType(t)
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	ptrType := reflect.PtrTo(t)
+	return t.Implements(jsonMarshalerType) || ptrType.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) || ptrType.Implements(textMarshalerType)
+}
+
+func isRenderScalar(t reflect.Type) bool {
+	if isRenderMarshaler(t) {
+		return true
+	}
+	switch derefType(t).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+		return false
+	}
+	return true
+}
+
+func (rctx *renderCtx) renderValue(v reflect.Value, path string, depth int) {
+	v = derefValue(v)
+	if !v.IsValid() {
+		fmt.Fprintf(rctx.buf, "<d-text bind=\"%s\"/>\n", html.EscapeString(path))
+		return
+	}
+	if depth >= rctx.maxDepth {
+		fmt.Fprintf(rctx.buf, "<d-dataview bind=\"%s\"/>\n", html.EscapeString(path))
+		return
+	}
+	if isRenderMarshaler(v.Type()) {
+		fmt.Fprintf(rctx.buf, "<d-text bind=\"%s\"/>\n", html.EscapeString(path))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		rctx.buf.WriteString("<table class=\"ui celled compact definition table\">\n")
+		for _, field := range renderStructFields(v.Type()) {
+			fieldV, ok := dashutil.JsonFieldByIndex(v, field.Index)
+			if !ok {
+				continue
+			}
+			rctx.renderKV(field.Name, fieldV, renderChildPath(path, field.Name), depth)
+		}
+		rctx.buf.WriteString("</table>\n")
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			fmt.Fprintf(rctx.buf, "<d-dataview bind=\"%s\"/>\n", html.EscapeString(path))
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i int, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		rctx.buf.WriteString("<table class=\"ui celled compact definition table\">\n")
+		for _, key := range keys {
+			rctx.renderKV(key.String(), v.MapIndex(key), renderChildPath(path, key.String()), depth)
+		}
+		rctx.buf.WriteString("</table>\n")
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte marshals as a base64 string
+			fmt.Fprintf(rctx.buf, "<d-text bind=\"%s\"/>\n", html.EscapeString(path))
+			return
+		}
+		rctx.renderTable(v, path)
+
+	default:
+		fmt.Fprintf(rctx.buf, "<d-text bind=\"%s\"/>\n", html.EscapeString(path))
+	}
+}
+
+func (rctx *renderCtx) renderKV(name string, v reflect.Value, path string, depth int) {
+	fmt.Fprintf(rctx.buf, "<tr><td>%s</td><td>\n", html.EscapeString(name))
+	dv := derefValue(v)
+	if !dv.IsValid() || isRenderScalar(dv.Type()) {
+		rctx.renderValue(v, path, depth+1)
+	} else {
+		rctx.buf.WriteString("<details>\n")
+		fmt.Fprintf(rctx.buf, "<summary>%s</summary>\n", html.EscapeString(renderSummary(dv)))
+		rctx.renderValue(v, path, depth+1)
+		rctx.buf.WriteString("</details>\n")
+	}
+	rctx.buf.WriteString("</td></tr>\n")
+}
+
+func renderSummary(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("%v [%d]", v.Type(), v.Len())
+	}
+	return v.Type().String()
+}
+
+// slices render as a <d-table>.  columns come from the struct fields (or map keys) of the elements.
+func (rctx *renderCtx) renderTable(v reflect.Value, path string) {
+	elemType := derefType(v.Type().Elem())
+	fmt.Fprintf(rctx.buf, "<d-table bind=\"%s\">\n", html.EscapeString(path))
+	rctx.buf.WriteString("<d-col label=\"#\" bind=\"@index+1\"/>\n")
+	var colNames []string
+	scalarCols := make(map[string]bool)
+	if isRenderMarshaler(elemType) {
+		// rendered as a single value column
+	} else if elemType.Kind() == reflect.Struct {
+		for _, field := range renderStructFields(elemType) {
+			colNames = append(colNames, field.Name)
+			scalarCols[field.Name] = isRenderScalar(elemType.FieldByIndex(field.Index).Type)
+		}
+	} else if elemType.Kind() == reflect.Map && elemType.Key().Kind() == reflect.String {
+		keySet := make(map[string]bool)
+		for i := 0; i < v.Len(); i++ {
+			elem := derefValue(v.Index(i))
+			if !elem.IsValid() {
+				continue
+			}
+			for _, key := range elem.MapKeys() {
+				keySet[key.String()] = true
+			}
+		}
+		for key := range keySet {
+			colNames = append(colNames, key)
+		}
+		sort.Strings(colNames)
+	}
+	if len(colNames) == 0 {
+		if isRenderScalar(elemType) {
+			rctx.buf.WriteString("<d-col label=\"Value\" bind=\".\"/>\n")
+		} else {
+			rctx.buf.WriteString("<d-col label=\"Value\"><d-dataview bind=\".\"/></d-col>\n")
+		}
+	}
+	for _, colName := range colNames {
+		colPath := renderChildPath("", colName)
+		if scalarCols[colName] {
+			fmt.Fprintf(rctx.buf, "<d-col label=\"%s\" bind=\"%s\"/>\n", html.EscapeString(colName), html.EscapeString(colPath))
+			continue
+		}
+		fmt.Fprintf(rctx.buf, "<d-col label=\"%s\"><d-dataview bind=\"%s\"/></d-col>\n", html.EscapeString(colName), html.EscapeString(colPath))
+	}
+	rctx.buf.WriteString("</d-table>\n")
+}
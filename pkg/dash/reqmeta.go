@@ -0,0 +1,78 @@
+package dash
+
+import (
+	"context"
+)
+
+type requestMetaKey struct{}
+
+// Environment info attached to every handler's context.  Lets shared libraries (logging,
+// metrics, tenancy) find the process and app a request belongs to without globals.
+// Returned from RequestMeta(ctx).  ProcTags is shared, do not modify it.
+type RequestMetaInfo struct {
+	AccId      string
+	ZoneName   string
+	ProcName   string
+	ProcRunId  string
+	InstanceId string
+	ProcTags   map[string]string
+
+	AppName  string
+	AppTitle string // set if the app is connected in this process
+	Path     string // request path (including the handler path fragment)
+	ReqId    string
+}
+
+// Returns the RequestMetaInfo attached to a handler's context (req.Context(), or any context
+// derived from it).  Returns nil for contexts that did not come from a Dashborg request.
+// Usage: if meta := dash.RequestMeta(ctx); meta != nil { log.Printf("zone=%s app=%s", meta.ZoneName, meta.AppName) }
+func RequestMeta(ctx context.Context) *RequestMetaInfo {
+	if ctx == nil {
+		return nil
+	}
+	meta, _ := ctx.Value(requestMetaKey{}).(*RequestMetaInfo)
+	return meta
+}
+
+func (pc *DashCloudClient) getConnectedAppByName(appName string) *App {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	for _, app := range pc.connectedApps {
+		if app.appName == appName {
+			return app
+		}
+	}
+	return nil
+}
+
+func makeRequestMeta(req *AppRequest) *RequestMetaInfo {
+	meta := &RequestMetaInfo{
+		AppName: req.info.AppName,
+		Path:    req.info.Path,
+		ReqId:   req.info.ReqId,
+	}
+	pc := req.client
+	if pc == nil || pc.Config == nil {
+		return meta
+	}
+	meta.AccId = pc.Config.AccId
+	meta.ZoneName = pc.Config.ZoneName
+	meta.ProcName = pc.Config.ProcName
+	meta.ProcRunId = pc.ProcRunId
+	meta.InstanceId = pc.Config.InstanceId
+	meta.ProcTags = pc.Config.ProcTags
+	if app := pc.getConnectedAppByName(meta.AppName); app != nil {
+		meta.AppTitle = app.appConfig.AppTitle
+	}
+	return meta
+}
+
+// carries the request meta from an existing request context over to a new context
+// (for contexts that are not derived from the request context, e.g. dispatch timeouts)
+func withRequestMetaFrom(ctx context.Context, fromCtx context.Context) context.Context {
+	meta := RequestMeta(fromCtx)
+	if meta == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, requestMetaKey{}, meta)
+}
@@ -0,0 +1,131 @@
+package dash
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Logger scoped to a single request.  Every line is tagged with the app, path, request id,
+// and user (the AuthAtom id).  Returned from AppRequest.Logger().
+type RequestLogger struct {
+	req    *AppRequest
+	prefix string
+}
+
+// Request scoped counters.  Returned from AppRequest.Metrics().  When the request completes
+// the counters are added to the app's totals (reported in AppMetrics.Counters, see
+// DashCloudClient.MetricsSnapshot).
+type RequestMetrics struct {
+	lock     *sync.Mutex
+	counters map[string]int64
+}
+
+// Returns a logger tagged with the request's app, path, request id, and user.
+// Usage: req.Logger().Printf("refund issued order:%s\n", orderId)
+func (req *AppRequest) Logger() *RequestLogger {
+	info := req.info
+	tags := []string{
+		fmt.Sprintf("app=%s", info.AppName),
+		fmt.Sprintf("path=%s", info.Path),
+		fmt.Sprintf("reqid=%s", info.ReqId),
+	}
+	if req.authData != nil && req.authData.Id != "" {
+		tags = append(tags, fmt.Sprintf("user=%s", req.authData.Id))
+	}
+	return &RequestLogger{req: req, prefix: "[" + strings.Join(tags, " ") + "] "}
+}
+
+// Logs a message (always logged).
+func (l *RequestLogger) Printf(fmtStr string, args ...interface{}) {
+	client := l.req.client
+	if client == nil {
+		log.Printf(l.prefix+fmtStr, args...)
+		return
+	}
+	client.log(l.prefix+fmtStr, args...)
+}
+
+// Logs a debug message, only logged if the log level for the request's path is debug (see
+// DashCloudClient.SetLogLevel).
+func (l *RequestLogger) Debugf(fmtStr string, args ...interface{}) {
+	client := l.req.client
+	if client == nil {
+		return
+	}
+	client.logPathV(l.req.info.Path, l.prefix+fmtStr, args...)
+}
+
+// Returns the request scoped counters (created on first use).
+// Usage: req.Metrics().Inc("cache-miss")
+func (req *AppRequest) Metrics() *RequestMetrics {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	if req.metrics == nil {
+		req.metrics = &RequestMetrics{lock: &sync.Mutex{}, counters: make(map[string]int64)}
+	}
+	return req.metrics
+}
+
+// Increments a counter by 1.
+func (m *RequestMetrics) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Adds delta to a counter.
+func (m *RequestMetrics) Add(name string, delta int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[name] += delta
+}
+
+// Returns a copy of the counters.
+func (m *RequestMetrics) Counters() map[string]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rtn := make(map[string]int64)
+	for name, val := range m.counters {
+		rtn[name] = val
+	}
+	return rtn
+}
+
+// adds a completed request's counters to the app totals
+func (pc *DashCloudClient) recordRequestMetrics(req *AppRequest) {
+	req.lock.Lock()
+	metrics := req.metrics
+	req.lock.Unlock()
+	if metrics == nil {
+		return
+	}
+	counters := metrics.Counters()
+	if len(counters) == 0 {
+		return
+	}
+	appName := req.info.AppName
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	appCounters := pc.appCounters[appName]
+	if appCounters == nil {
+		appCounters = make(map[string]int64)
+		pc.appCounters[appName] = appCounters
+	}
+	for name, val := range counters {
+		appCounters[name] += val
+	}
+}
+
+// Returns the totals of the request counters (see AppRequest.Metrics) for an app.
+func (pc *DashCloudClient) AppCounters(appName string) map[string]int64 {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if len(pc.appCounters[appName]) == 0 {
+		return nil
+	}
+	rtn := make(map[string]int64)
+	for name, val := range pc.appCounters[appName] {
+		rtn[name] = val
+	}
+	return rtn
+}
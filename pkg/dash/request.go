@@ -13,10 +13,12 @@ import (
 	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
 	"github.com/sawka/dashborg-go-sdk/pkg/dashproto"
 	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+	"google.golang.org/protobuf/proto"
 )
 
 const htmlPagePath = "$state.dashborg.htmlpage"
 const pageNameKey = "apppage"
+const stateVersionKey = "stateversion"
 
 type RequestInfo struct {
 	StartTime     time.Time
@@ -26,6 +28,8 @@ type RequestInfo struct {
 	Path          string // request path
 	AppName       string // app name
 	FeClientId    string // unique id for client
+	AffinityKey   string // for shared links, the key used to route requests to this process (see LinkOpts.Affinity)
+	IsBackendCall bool   // true if the request was made by another backend process (not a frontend)
 }
 
 type RawRequestData struct {
@@ -44,6 +48,20 @@ type Request interface {
 	RawData() RawRequestData
 	BindData(obj interface{}) error
 	BindAppState(obj interface{}) error
+	DataPath(path string) DataValue
+}
+
+// Request plus the methods that send data and actions back to the frontend.  *AppRequest is
+// the only implementation.  Helper packages and middleware (see AddMiddleware) accept it so
+// they do not depend on the concrete type.  Pure and link runtime handlers only get a Request.
+type ActionRequest interface {
+	Request
+	AddDataOp(op string, path string, data interface{}) error
+	SetData(path string, data interface{}) error
+	InvalidateData(pathRegexp string) error
+	SetBlob(path string, mimeType string, reader io.Reader) error
+	Flush() error
+	IsDone() bool
 }
 
 type dashborgState struct {
@@ -58,17 +76,25 @@ type dashborgState struct {
 // parts that cause side effects in the UI).  The limited API for those requests
 // is encapsulated in the Request interface.
 type AppRequest struct {
-	lock      *sync.Mutex     // synchronizes RRActions
-	ctx       context.Context // gRPC context / streaming context
-	info      RequestInfo
-	rawData   RawRequestData
-	client    *DashCloudClient
-	appState  interface{}           // json-unmarshaled app state for this request
-	authData  *AuthAtom             // authentication tokens associated with this request
-	err       error                 // set if an error occured (when set, RRActions are not sent)
-	rrActions []*dashproto.RRAction // output, these are the actions that will be returned
-	isDone    bool                  // set after Done() is called and response has been sent to server
-	infoMsgs  []string              // debugging information
+	lock       *sync.Mutex     // synchronizes RRActions
+	ctx        context.Context // gRPC context / streaming context
+	info       RequestInfo
+	rawData    RawRequestData
+	client     *DashCloudClient
+	appState   interface{}           // json-unmarshaled app state for this request
+	authData   *AuthAtom             // authentication tokens associated with this request
+	err        error                 // set if an error occured (when set, RRActions are not sent)
+	rrActions  []*dashproto.RRAction // output, these are the actions that will be returned
+	isDone     bool                  // set after Done() is called and response has been sent to server
+	infoMsgs   []string              // debugging information
+	metrics    *RequestMetrics       // request scoped counters (see Metrics)
+	lastFlush  time.Time             // last stream Flush sent (see Config.StreamFlushInterval), protected by lock
+	flushTimer *time.Timer           // trailing Flush for held stream actions, protected by lock
+	flushDone  bool                  // set when the final response is sent (no more trailing Flushes), protected by lock
+
+	dataDecoded bool        // DataPath, request data decoded (lazily) into dataVal
+	dataVal     interface{} // protected by lock
+	dataErr     error
 }
 
 func (req *AppRequest) canSetHtml() bool {
@@ -92,12 +118,16 @@ func (req *AppRequest) AuthData() *AuthAtom {
 }
 
 // Binds a Go struct to the data passed in this request.  Used for special cases or when
-// the func reflection binding is not sufficient.  Used just like json.Unmarshal().
+// the func reflection binding is not sufficient.  Used just like json.Unmarshal().  proto.Message
+// objects are decoded with protojson.
 func (req *AppRequest) BindData(obj interface{}) error {
 	if req.rawData.DataJson == "" {
 		return nil
 	}
-	err := json.Unmarshal([]byte(req.rawData.DataJson), obj)
+	if msg, ok := obj.(proto.Message); ok {
+		return unmarshalProtoJson(req.rawData.DataJson, msg)
+	}
+	err := dashutil.UnmarshalJson(req.rawData.DataJson, obj, req.jsonOpts())
 	return err
 }
 
@@ -107,10 +137,25 @@ func (req *AppRequest) BindAppState(obj interface{}) error {
 	if req.rawData.AppStateJson == "" {
 		return nil
 	}
-	err := json.Unmarshal([]byte(req.rawData.AppStateJson), obj)
+	err := dashutil.UnmarshalJson(req.rawData.AppStateJson, obj, req.jsonOpts())
 	return err
 }
 
+func (req *AppRequest) jsonOpts() dashutil.JsonOpts {
+	if req.client == nil || req.client.Config == nil {
+		return dashutil.JsonOpts{}
+	}
+	return req.client.appJsonOpts(req.info.AppName)
+}
+
+// returns the JSON encoding/decoding options for a request (defaults for non *AppRequest implementations)
+func requestJsonOpts(req Request) dashutil.JsonOpts {
+	if appReq, ok := req.(*AppRequest); ok {
+		return appReq.jsonOpts()
+	}
+	return dashutil.JsonOpts{}
+}
+
 func (req *AppRequest) appendRR(rrAction *dashproto.RRAction) {
 	req.lock.Lock()
 	defer req.lock.Unlock()
@@ -129,17 +174,7 @@ func (req *AppRequest) clearActions() []*dashproto.RRAction {
 // easier than creating a separate handler that returns BlobData -- e.g. getting
 // a data-table and a graph image.
 func (req *AppRequest) SetBlob(path string, mimeType string, reader io.Reader) error {
-	if req.isDone {
-		return fmt.Errorf("Cannot call SetBlob(), path=%s, Request is already done", path)
-	}
-	actions, err := blobToRRA(mimeType, reader)
-	if err != nil {
-		return err
-	}
-	for _, rrAction := range actions {
-		req.appendRR(rrAction)
-	}
-	return nil
+	return req.SetBlobWithOpts(path, mimeType, reader, nil)
 }
 
 // Calls SetBlobData with the the contents of fileName.  Do not confuse path with fileName.
@@ -164,7 +199,7 @@ func (req *AppRequest) AddDataOp(op string, path string, data interface{}) error
 	if req.isDone {
 		return fmt.Errorf("Cannot call SetData(), reqinfo=%s data-path=%s, Request is already done", req.reqInfoStr())
 	}
-	jsonData, err := dashutil.MarshalJson(data)
+	jsonData, err := dashutil.MarshalJsonOpts(data, req.jsonOpts())
 	if err != nil {
 		return fmt.Errorf("Error marshaling json for SetData, path:%s, err:%v\n", path, err)
 	}
@@ -283,6 +318,7 @@ func makeAppRequest(ctx context.Context, reqMsg *dashproto.RequestMessage, clien
 			RequestMethod: reqMsg.RequestMethod,
 			Path:          reqMsg.Path,
 			FeClientId:    reqMsg.FeClientId,
+			IsBackendCall: reqMsg.IsBackendCall,
 		},
 		rawData: RawRequestData{
 			DataJson:     reqMsg.JsonData,
@@ -294,6 +330,9 @@ func makeAppRequest(ctx context.Context, reqMsg *dashproto.RequestMessage, clien
 		client: client,
 	}
 	preq.info.AppName = dashutil.AppNameFromPath(reqMsg.Path)
+	if preq.ctx != nil {
+		preq.ctx = context.WithValue(preq.ctx, requestMetaKey{}, makeRequestMeta(preq))
+	}
 	if !dashutil.IsRequestTypeValid(reqMsg.RequestType) {
 		preq.err = fmt.Errorf("Invalid RequestMessage.RequestType [%s]", reqMsg.RequestType)
 		return preq
@@ -309,7 +348,7 @@ func makeAppRequest(ctx context.Context, reqMsg *dashproto.RequestMessage, clien
 	}
 	if reqMsg.AppStateData != "" {
 		var pstate interface{}
-		err := json.Unmarshal([]byte(reqMsg.AppStateData), &pstate)
+		err := dashutil.UnmarshalJson(reqMsg.AppStateData, &pstate, preq.jsonOpts())
 		if err != nil {
 			preq.err = fmt.Errorf("Cannot unmarshal AppStateData: %v", err)
 			return preq
@@ -323,6 +362,39 @@ func (req *AppRequest) getRRA() []*dashproto.RRAction {
 	return req.rrActions
 }
 
+// Sends any pending actions (SetData, AddDataOp, etc.) to the frontend without completing
+// the request.  Allows long running handlers to send incremental output.  The handler's
+// return value (and any actions added after the last Flush) are sent when the handler returns.
+// For streams, Flushes within Config.StreamFlushInterval of the last one are batched
+// (the actions are sent when the interval expires, or when the handler returns if that is sooner).
+func (req *AppRequest) Flush() error {
+	if req.isDone {
+		return fmt.Errorf("Cannot call Flush(), reqinfo=%s, Request is already done", req.reqInfoStr())
+	}
+	if req.client == nil {
+		// requests without a client (shadow requests) have nowhere to send flushed actions
+		return nil
+	}
+	if req.holdStreamFlush() {
+		return nil
+	}
+	actions := req.clearActions()
+	if len(actions) == 0 {
+		return nil
+	}
+	m := &dashproto.SendResponseMessage{
+		Ts:           dashutil.Ts(),
+		ReqId:        req.info.ReqId,
+		RequestType:  req.info.RequestType,
+		Path:         req.info.Path,
+		FeClientId:   req.info.FeClientId,
+		ResponseDone: false,
+		Actions:      actions,
+	}
+	_, err := req.client.sendResponseProtoRpc(m, req)
+	return err
+}
+
 // Returns the error (if any) that has been set on this request.
 func (req *AppRequest) GetError() error {
 	return req.err
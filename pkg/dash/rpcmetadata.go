@@ -0,0 +1,99 @@
+package dash
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const maxExtraMetadataValueLen = 4096
+
+var metadataKeyRe = regexp.MustCompile(`^[a-z0-9_.-]{1,100}$`)
+
+// keys must be lowercase, cannot use the reserved "grpc-" and "dashborg-" prefixes, and values
+// must be printable ASCII (unless the key ends with "-bin")
+func validateExtraMetadata(key string, value string) error {
+	if !metadataKeyRe.MatchString(key) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid metadata key '%s' (lowercase letters, digits, '_', '.', and '-')", key))
+	}
+	if strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, "dashborg-") {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid metadata key '%s' (reserved prefix)", key))
+	}
+	if len(value) > maxExtraMetadataValueLen {
+		return dasherr.ValidateErr(fmt.Errorf("Metadata value for '%s' too long (max %d)", key, maxExtraMetadataValueLen))
+	}
+	if strings.HasSuffix(key, "-bin") {
+		return nil
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] > 0x7e {
+			return dasherr.ValidateErr(fmt.Errorf("Invalid metadata value for '%s' (must be printable ASCII, use a \"-bin\" key for binary values)", key))
+		}
+	}
+	return nil
+}
+
+func validateExtraMetadataMap(md map[string]string) error {
+	for key, value := range md {
+		err := validateExtraMetadata(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// must hold pc.Lock.  stores the metadata as sorted key/value pairs for ctxWithMd
+func (pc *DashCloudClient) storeExtraMetadataNoLock(md map[string]string) {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kv := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		kv = append(kv, key, md[key])
+	}
+	pc.extraMd = md
+	pc.extraMdKv.Store(kv)
+}
+
+// Sets (or with an empty value, removes) a metadata key/value sent with every outgoing RPC,
+// in addition to Config.ExtraMetadata.  Takes effect for calls started after it returns
+// (the request stream picks it up when it reconnects).
+// Usage: err := client.SetExtraMetadata("x-team", "payments")
+func (pc *DashCloudClient) SetExtraMetadata(key string, value string) error {
+	if value != "" {
+		err := validateExtraMetadata(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	newMd := make(map[string]string)
+	for k, v := range pc.extraMd {
+		newMd[k] = v
+	}
+	if value == "" {
+		delete(newMd, key)
+	} else {
+		newMd[key] = value
+	}
+	pc.storeExtraMetadataNoLock(newMd)
+	return nil
+}
+
+// Returns a copy of the extra metadata sent with every outgoing RPC.
+func (pc *DashCloudClient) ExtraMetadata() map[string]string {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	rtn := make(map[string]string)
+	for k, v := range pc.extraMd {
+		rtn[k] = v
+	}
+	return rtn
+}
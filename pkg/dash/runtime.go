@@ -1,10 +1,12 @@
 package dash
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
 	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
@@ -17,6 +19,7 @@ const (
 	pathFragTypeInfo = "@typeinfo"
 	pathFragDyn      = "@dyn"
 	pathFragPageInit = "@pageinit"
+	pathFragPing     = "@ping"
 )
 
 type handlerType struct {
@@ -47,6 +50,7 @@ type runtimeHandlerInfo struct {
 	ContextParam   bool              `json:"contextparam,omitempty"`
 	ReqParam       bool              `json:"reqparam,omitempty"`
 	AppStateParam  bool              `json:"appstateparam,omitempty"`
+	StateAtoms     []string          `json:"stateatoms,omitempty"`
 	RtnType        *runtimeTypeInfo  `json:"rtntype"`
 	ParamsType     []runtimeTypeInfo `json:"paramstype"`
 }
@@ -61,6 +65,31 @@ type HandlerOpts struct {
 	Display        string
 	FormDisplay    string
 	ResultsDisplay string
+
+	// If set, only these top-level app state keys (e.g. "filters" for $.filters) are
+	// sent with requests to this handler.  If not set, the full app state is sent.
+	StateAtoms []string
+
+	// Dispatch priority when requests are queued (see DispatchLimits).  Defaults to
+	// PriorityInteractive (PriorityData for pure handlers).
+	Priority int
+
+	// Heavy handlers (e.g. slow report generators) run in a separate bounded pool with a
+	// longer timeout (see SetHeavyDispatchLimits), so they cannot exhaust concurrency for
+	// quick interactive handlers.
+	Heavy bool
+}
+
+// Creates a HandlerOpts that limits the app state sent to the handler to the given atoms.
+// Usage: app.Runtime().Handler("search", searchFn, dash.StateAtoms("filters", "page"))
+func StateAtoms(atoms ...string) *HandlerOpts {
+	return &HandlerOpts{StateAtoms: atoms}
+}
+
+// Creates a HandlerOpts that sets the handler's dispatch priority.
+// Usage: app.Runtime().PureHandler("refresh", refreshFn, dash.HandlerPriority(dash.PriorityStream))
+func HandlerPriority(priority int) *HandlerOpts {
+	return &HandlerOpts{Priority: priority}
 }
 
 type LinkRuntimeImpl struct {
@@ -68,6 +97,7 @@ type LinkRuntimeImpl struct {
 	middlewares []middlewareType
 	handlers    map[string]handlerType
 	errs        []error
+	backendACLs *backendACLSet
 }
 
 type handlerFuncType = func(req *AppRequest) (interface{}, error)
@@ -79,8 +109,21 @@ type AppRuntimeImpl struct {
 	pageHandlers map[string]handlerFuncType
 	middlewares  []middlewareType
 	errs         []error
+
+	stateVersion    int
+	stateMigrations map[int]StateMigrationFn
+	limiter         *dispatchLimiter
+	heavyLimiter    *dispatchLimiter
+	backendACLs     *backendACLSet
+	shadow          *shadowType
+	canary          *canaryType
+	htmlDiff        *htmlDiffState // nil unless SetHtmlDiff(true)
 }
 
+// Converts app state persisted at version fromVersion to the shape expected by fromVersion+1.
+// The returned value must marshal to a JSON object.
+type StateMigrationFn func(oldState map[string]interface{}) interface{}
+
 type runtimeImplIf interface {
 	addError(err error)
 	setHandler(path string, handler handlerType)
@@ -91,6 +134,13 @@ type HasErr interface {
 	Err() error
 }
 
+// Implemented by both *AppRuntimeImpl and *LinkRuntimeImpl.  Allows reusable packages
+// to register their handlers on either runtime type.
+type HandlerRegistry interface {
+	Handler(name string, handlerFn interface{}, opts ...*HandlerOpts)
+	PureHandler(name string, handlerFn interface{}, opts ...*HandlerOpts)
+}
+
 // Creates an app runtime.  Normally you should
 // use the App class to manage applications which creates
 // an AppRuntime automatically.  This is for special low-level use cases.
@@ -99,6 +149,11 @@ func MakeAppRuntime() *AppRuntimeImpl {
 		lock:         &sync.Mutex{},
 		handlers:     make(map[string]handlerType),
 		pageHandlers: make(map[string]handlerFuncType),
+
+		stateMigrations: make(map[int]StateMigrationFn),
+		limiter:         makeDispatchLimiter(DispatchLimits{}),
+		heavyLimiter:    makeDispatchLimiter(DefaultHeavyDispatchLimits),
+		backendACLs:     makeBackendACLSet(),
 	}
 	rtn.SetInitHandler(func() {}, &HandlerOpts{Hidden: true})
 	rtn.Handler(pathFragPageInit, rtn.pageInitHandler, &HandlerOpts{Hidden: true})
@@ -152,7 +207,15 @@ func (apprt *AppRuntimeImpl) SetPageHandler(pageName string, handlerFn interface
 
 // Runs an application handler given an AppRequest.  This method is not normally used by end users,
 // it is used by the Dashborg runtime to dispatch requests to this runtime.
-func (apprt *AppRuntimeImpl) RunHandler(req *AppRequest) (interface{}, error) {
+func (apprt *AppRuntimeImpl) RunHandler(req *AppRequest) (rtnVal interface{}, rtnErr error) {
+	if canary := apprt.getCanary(); canary != nil {
+		if canary.isCanaryRequest(req) {
+			rtn, err := canary.rt.RunHandler(req)
+			canary.recordResult(true, err)
+			return rtn, err
+		}
+		defer func() { canary.recordResult(false, rtnErr) }()
+	}
 	_, _, pathFrag, err := dashutil.ParseFullPath(req.info.Path, true)
 	if err != nil {
 		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid Path: %w", err))
@@ -163,6 +226,8 @@ func (apprt *AppRuntimeImpl) RunHandler(req *AppRequest) (interface{}, error) {
 	apprt.lock.Lock()
 	hval, ok := apprt.handlers[pathFrag]
 	mws := apprt.middlewares
+	shadow := apprt.shadow
+	htmlDiff := apprt.htmlDiff
 	apprt.lock.Unlock()
 	if !ok {
 		return nil, dasherr.ErrWithCode(dasherr.ErrCodeNoHandler, fmt.Errorf("No handler found for %s", dashutil.SimplifyPath(req.RequestInfo().Path, nil)))
@@ -170,10 +235,43 @@ func (apprt *AppRuntimeImpl) RunHandler(req *AppRequest) (interface{}, error) {
 	if req.info.RequestMethod == RequestMethodGet && !hval.Opts.PureHandler {
 		return nil, dasherr.ValidateErr(fmt.Errorf("GET/data request to non-pure handler '%s'", pathFrag))
 	}
+	err = apprt.backendACLs.check(req, pathFrag)
+	if err != nil {
+		return nil, err
+	}
+	if shadow != nil && shadow.shouldMirror(req, pathFrag, hval) {
+		shadowReq, shadowCancelFn := shadow.makeShadowRequest(req)
+		startTime := time.Now()
+		defer func() {
+			go shadow.runShadow(shadowReq, shadowCancelFn, pathFrag, time.Since(startTime), rtnErr)
+		}()
+	}
+	limiter := apprt.limiter
+	if hval.Opts.Heavy {
+		limiter = apprt.heavyLimiter
+	}
+	err = limiter.acquire(req.Context(), requestPriority(req, hval))
+	if err != nil {
+		return nil, err
+	}
+	defer limiter.release()
+	if timeout := limiter.getTimeout(); timeout > 0 {
+		ctx, cancelFn := withDispatchTimeout(req.ctx, timeout)
+		defer cancelFn()
+		req.ctx = ctx
+	}
+	err = apprt.migrateRequestState(req, !hval.Opts.PureHandler && req.info.RequestMethod != RequestMethodGet)
+	if err != nil {
+		return nil, err
+	}
+	defer watchHandlerDeadline(req, pathFrag)()
 	rtn, err := mwHelper(req, hval, mws, 0)
 	if err != nil {
 		return nil, err
 	}
+	if htmlDiff != nil && pathFrag == pathFragHtml {
+		return htmlDiff.diffResponse(req, rtn)
+	}
 	return rtn, nil
 }
 
@@ -226,6 +324,146 @@ func removeMiddleware(mws []middlewareType, name string) []middlewareType {
 	return newmws
 }
 
+// Sets the current version of the app state.  Frontends persist the version with the app
+// state, requests with state from an older version are run through the migration
+// functions registered with MigrateState before being passed to handlers.
+func (apprt *AppRuntimeImpl) SetStateVersion(version int) {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	if version < 0 {
+		apprt.errs = append(apprt.errs, fmt.Errorf("Invalid StateVersion %d", version))
+		return
+	}
+	apprt.stateVersion = version
+}
+
+// Returns the current app state version.  This is the larger of the version set with
+// SetStateVersion and the highest registered migration's fromVersion+1.
+func (apprt *AppRuntimeImpl) StateVersion() int {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	return apprt.stateVersionNoLock()
+}
+
+func (apprt *AppRuntimeImpl) stateVersionNoLock() int {
+	rtn := apprt.stateVersion
+	for fromVersion := range apprt.stateMigrations {
+		if fromVersion+1 > rtn {
+			rtn = fromVersion + 1
+		}
+	}
+	return rtn
+}
+
+// Registers a function to migrate app state from fromVersion to fromVersion+1.  When a
+// frontend with stale persisted state sends a request, migrations are chained
+// (e.g. 1->2->3) until the state reaches the current StateVersion.  The migrated state is
+// sent back to the frontend (for non-pure, non-GET handlers) so subsequent requests are current.
+// State without a version (e.g. created by the current HTML) is assumed to be current, it is
+// stamped with the current StateVersion instead of being migrated.
+func (apprt *AppRuntimeImpl) MigrateState(fromVersion int, migrateFn StateMigrationFn) {
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	if fromVersion < 0 || migrateFn == nil {
+		apprt.errs = append(apprt.errs, fmt.Errorf("Invalid MigrateState(%d), fromVersion must be >= 0 and migrateFn must not be nil", fromVersion))
+		return
+	}
+	apprt.stateMigrations[fromVersion] = migrateFn
+}
+
+// returns false if the state has no version
+func getStateVersion(state map[string]interface{}) (int, bool) {
+	dbState, ok := state["dashborg"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	// json.Number when Config.JsonUseNumber is set
+	switch ver := dbState[stateVersionKey].(type) {
+	case float64:
+		return int(ver), true
+
+	case json.Number:
+		iver, err := ver.Int64()
+		if err != nil {
+			return 0, false
+		}
+		return int(iver), true
+	}
+	return 0, false
+}
+
+// Runs the registered state migrations on the request's app state (if it is out of date).
+// Updates the request's raw AppStateJson so BindAppState and handler state args
+// see the migrated state.  The migrated state is only sent to the frontend if sendState is set.
+func (apprt *AppRuntimeImpl) migrateRequestState(req *AppRequest, sendState bool) error {
+	apprt.lock.Lock()
+	curVersion := apprt.stateVersionNoLock()
+	migrations := make(map[int]StateMigrationFn)
+	for ver, fn := range apprt.stateMigrations {
+		migrations[ver] = fn
+	}
+	apprt.lock.Unlock()
+	if len(migrations) == 0 || req.appState == nil {
+		return nil
+	}
+	state, ok := req.appState.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	stateVersion, hasVersion := getStateVersion(state)
+	if !hasVersion {
+		stateVersion = curVersion
+	}
+	if hasVersion && stateVersion >= curVersion {
+		return nil
+	}
+	for ver := stateVersion; ver < curVersion; ver++ {
+		migrateFn := migrations[ver]
+		if migrateFn == nil {
+			continue
+		}
+		newState, err := normalizeMigratedState(migrateFn(state))
+		if err != nil {
+			return fmt.Errorf("Error migrating app state from version %d: %w", ver, err)
+		}
+		state = newState
+	}
+	dbState, ok := state["dashborg"].(map[string]interface{})
+	if !ok {
+		dbState = make(map[string]interface{})
+		state["dashborg"] = dbState
+	}
+	dbState[stateVersionKey] = curVersion
+	jsonStr, err := dashutil.MarshalJson(state)
+	if err != nil {
+		return dasherr.JsonMarshalErr("MigratedAppState", err)
+	}
+	req.appState = state
+	req.rawData.AppStateJson = jsonStr
+	if sendState {
+		req.SetData("$state", state)
+	}
+	return nil
+}
+
+// round-trips the migrated state through JSON so later migrations (and handlers)
+// see the same plain map/slice/float64 values they would get from the frontend.
+func normalizeMigratedState(newState interface{}) (map[string]interface{}, error) {
+	jsonStr, err := dashutil.MarshalJson(newState)
+	if err != nil {
+		return nil, err
+	}
+	var rtn map[string]interface{}
+	err = json.Unmarshal([]byte(jsonStr), &rtn)
+	if err != nil {
+		return nil, err
+	}
+	if rtn == nil {
+		rtn = make(map[string]interface{})
+	}
+	return rtn, nil
+}
+
 // Adds a middleware function to this runtime.
 func (apprt *AppRuntimeImpl) AddRawMiddleware(name string, mwFunc MiddlewareFuncType, priority float64) {
 	apprt.RemoveMiddleware(name)
@@ -235,6 +473,12 @@ func (apprt *AppRuntimeImpl) AddRawMiddleware(name string, mwFunc MiddlewareFunc
 	apprt.middlewares = addMiddlewares(apprt.middlewares, newmw)
 }
 
+// Adds a middleware function written against the ActionRequest interface (so it can be
+// shared with helper packages).
+func (apprt *AppRuntimeImpl) AddMiddleware(name string, mwFunc RequestMiddlewareFuncType, priority float64) {
+	apprt.AddRawMiddleware(name, adaptRequestMiddleware(mwFunc), priority)
+}
+
 // Removes a middleware function from this runtime
 func (apprt *AppRuntimeImpl) RemoveMiddleware(name string) {
 	apprt.lock.Lock()
@@ -275,10 +519,12 @@ func (apprt *AppRuntimeImpl) SetHtmlHandler(handlerFn interface{}, opts ...*Hand
 // Creates a LinkRuntime structure.
 func MakeRuntime() *LinkRuntimeImpl {
 	rtn := &LinkRuntimeImpl{
-		lock:     &sync.Mutex{},
-		handlers: make(map[string]handlerType),
+		lock:        &sync.Mutex{},
+		handlers:    make(map[string]handlerType),
+		backendACLs: makeBackendACLSet(),
 	}
 	rtn.PureHandler(pathFragTypeInfo, rtn.getHandlerInfo)
+	rtn.PureHandler(pathFragPing, pingHandler, &HandlerOpts{Hidden: true})
 	return rtn
 }
 
@@ -340,6 +586,11 @@ func (linkrt *LinkRuntimeImpl) RunHandler(req *AppRequest) (interface{}, error)
 	if req.info.RequestMethod == RequestMethodGet && !hval.Opts.PureHandler {
 		return nil, dasherr.ValidateErr(fmt.Errorf("GET/Data request to non-pure handler"))
 	}
+	err = linkrt.backendACLs.check(req, pathFrag)
+	if err != nil {
+		return nil, err
+	}
+	defer watchHandlerDeadline(req, pathFrag)()
 	rtn, err := mwHelper(req, hval, mws, 0)
 	if err != nil {
 		return nil, err
@@ -389,6 +640,12 @@ func (linkrt *LinkRuntimeImpl) AddRawMiddleware(name string, mwFunc MiddlewareFu
 	linkrt.middlewares = addMiddlewares(linkrt.middlewares, newmw)
 }
 
+// Adds a middleware function written against the ActionRequest interface (so it can be
+// shared with helper packages).
+func (linkrt *LinkRuntimeImpl) AddMiddleware(name string, mwFunc RequestMiddlewareFuncType, priority float64) {
+	linkrt.AddRawMiddleware(name, adaptRequestMiddleware(mwFunc), priority)
+}
+
 // Removes a middleware function from this runtime
 func (linkrt *LinkRuntimeImpl) RemoveMiddleware(name string) {
 	linkrt.lock.Lock()
@@ -0,0 +1,149 @@
+package dash
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const MinSelfMetricsInterval = time.Second
+
+// Per-app metrics in a ClientMetrics snapshot.
+type AppMetrics struct {
+	AppName       string           `json:"appname"`
+	Connected     bool             `json:"connected"`
+	ConnectTs     int64            `json:"connectts"`
+	NumConnects   int              `json:"numconnects"`
+	OnConnectErr  string           `json:"onconnecterr,omitempty"`
+	Dispatch      DispatchStats    `json:"dispatch"`
+	HeavyDispatch DispatchStats    `json:"heavydispatch"`
+	Counters      map[string]int64 `json:"counters,omitempty"` // request counter totals (see AppRequest.Metrics)
+}
+
+// Snapshot of the SDK's internal metrics.  Returned from DashCloudClient.MetricsSnapshot().
+type ClientMetrics struct {
+	Ts          int64        `json:"ts"`
+	ProcRunId   string       `json:"procrunid"`
+	InstanceId  string       `json:"instanceid"`
+	ConnId      string       `json:"connid"`
+	Connected   bool         `json:"connected"`
+	UptimeSec   float64      `json:"uptimesec"`
+	NumRequests int64        `json:"numrequests"` // requests received (all streams) since the client started
+	Links       []LinkStats  `json:"links"`
+	Apps        []AppMetrics `json:"apps"`
+}
+
+// Returns a snapshot of the client's connection, link, and app dispatch metrics.
+func (pc *DashCloudClient) MetricsSnapshot() *ClientMetrics {
+	rtn := &ClientMetrics{
+		Ts:         dashutil.Ts(),
+		ProcRunId:  pc.ProcRunId,
+		InstanceId: pc.Config.InstanceId,
+		Connected:  pc.IsConnected(),
+		UptimeSec:  time.Since(pc.StartTime).Seconds(),
+		Links:      pc.LinkStats(),
+	}
+	rtn.ConnId, _ = pc.ConnId.Load().(string)
+	pc.Lock.Lock()
+	rtn.NumRequests = pc.numRequests
+	var apps []*App
+	for _, app := range pc.connectedApps {
+		apps = append(apps, app)
+	}
+	pc.Lock.Unlock()
+	for _, app := range apps {
+		status := app.Status()
+		appMetrics := AppMetrics{
+			AppName:       status.AppName,
+			Connected:     status.Connected,
+			ConnectTs:     status.ConnectTs,
+			NumConnects:   status.NumConnects,
+			Dispatch:      status.Dispatch,
+			HeavyDispatch: status.HeavyDispatch,
+			Counters:      pc.AppCounters(status.AppName),
+		}
+		if status.OnConnectErr != nil {
+			appMetrics.OnConnectErr = status.OnConnectErr.Error()
+		}
+		rtn.Apps = append(rtn.Apps, appMetrics)
+	}
+	sort.Slice(rtn.Links, func(i int, j int) bool {
+		return rtn.Links[i].Path < rtn.Links[j].Path
+	})
+	sort.Slice(rtn.Apps, func(i int, j int) bool {
+		return rtn.Apps[i].AppName < rtn.Apps[j].AppName
+	})
+	return rtn
+}
+
+// Publishes a MetricsSnapshot to the app relative path (e.g. "/sdk-metrics.json") every
+// interval (in a new goroutine), so the client can be monitored from within Dashborg.
+// Calling again for the same app and path replaces the publisher, pass an interval of 0 to
+// stop publishing.  Publishing stops when the client shuts down.  Snapshots are only
+// published while the client is connected.
+// Usage: client.PublishSelfMetrics("myapp", "/sdk-metrics.json", 30*time.Second)
+func (pc *DashCloudClient) PublishSelfMetrics(appName string, path string, interval time.Duration) error {
+	if !dashutil.IsAppNameValid(appName) {
+		return dasherr.ValidateErr(fmt.Errorf("Invalid AppName '%s'", appName))
+	}
+	err := dashutil.Path(path).Validate()
+	if err != nil {
+		return err
+	}
+	if interval != 0 && interval < MinSelfMetricsInterval {
+		return dasherr.ValidateErr(fmt.Errorf("PublishSelfMetrics interval must be at least %v", MinSelfMetricsInterval))
+	}
+	fullPath := AppPathFromName(appName) + path
+	stopCh := make(chan struct{})
+	pc.Lock.Lock()
+	if oldStopCh, ok := pc.selfMetricsPubs[fullPath]; ok {
+		close(oldStopCh)
+		delete(pc.selfMetricsPubs, fullPath)
+	}
+	if interval != 0 {
+		pc.selfMetricsPubs[fullPath] = stopCh
+	}
+	pc.Lock.Unlock()
+	if interval == 0 {
+		return nil
+	}
+	fs := pc.GlobalFSClient()
+	fileOpts := &FileOpts{Hidden: true}
+	if pc.IsConnected() {
+		err = fs.SetJsonPath(fullPath, pc.MetricsSnapshot(), fileOpts)
+		if err != nil {
+			pc.Lock.Lock()
+			if pc.selfMetricsPubs[fullPath] == stopCh {
+				delete(pc.selfMetricsPubs, fullPath)
+			}
+			pc.Lock.Unlock()
+			return err
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !pc.IsConnected() {
+					continue
+				}
+				err := fs.SetJsonPath(fullPath, pc.MetricsSnapshot(), fileOpts)
+				if err != nil {
+					pc.logPathV(fullPath, "Dashborg error publishing self metrics path:%s err:%v\n", fullPath, err)
+				}
+
+			case <-stopCh:
+				return
+
+			case <-pc.DoneCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,191 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const defaultShadowTimeout = 30 * time.Second
+
+// Options for mirroring live requests to a shadow runtime (see AppRuntimeImpl.SetShadowRuntime).
+type ShadowOpts struct {
+	// Percentage (0-100) of requests that are mirrored to the shadow runtime.
+	Percent float64
+
+	// If set, only requests to these handlers are mirrored.
+	Handlers []string
+
+	// By default only pure (data) handlers are mirrored, since running a non-pure handler twice
+	// can cause duplicate side effects.  Set to also mirror non-pure handlers.  Stream requests
+	// are never mirrored.
+	IncludeNonPure bool
+
+	// Timeout for shadow requests (defaults to 30s).  Shadow requests do not share the
+	// primary request's context.
+	Timeout time.Duration
+
+	// If set, called (in the shadow request's goroutine) with the result of every mirrored request.
+	OnResult func(result ShadowResult)
+}
+
+// Result of a mirrored request.  The shadow runtime's return value is always discarded.
+type ShadowResult struct {
+	HandlerName     string
+	ReqId           string
+	PrimaryDuration time.Duration
+	ShadowDuration  time.Duration
+	PrimaryErr      error
+	ShadowErr       error
+}
+
+// Aggregate stats for mirrored requests.  Returned from AppRuntimeImpl.ShadowStats().
+type ShadowStats struct {
+	NumMirrored       int64         `json:"nummirrored"`
+	NumShadowErrors   int64         `json:"numshadowerrors"`
+	NumShadowOnlyErrs int64         `json:"numshadowonlyerrs"` // shadow returned an error, primary did not
+	PrimaryDuration   time.Duration `json:"primaryduration"`   // total time spent in mirrored primary requests
+	ShadowDuration    time.Duration `json:"shadowduration"`    // total time spent in shadow requests
+}
+
+type shadowType struct {
+	lock  *sync.Mutex
+	rt    *AppRuntimeImpl
+	opts  ShadowOpts
+	stats ShadowStats
+}
+
+func (opts *ShadowOpts) Validate() error {
+	if opts.Percent < 0 || opts.Percent > 100 {
+		return dasherr.ValidateErr(fmt.Errorf("ShadowOpts Percent must be between 0 and 100"))
+	}
+	if opts.Timeout < 0 {
+		return dasherr.ValidateErr(fmt.Errorf("ShadowOpts Timeout cannot be negative"))
+	}
+	return nil
+}
+
+func (s *shadowType) shouldMirror(req *AppRequest, handlerName string, hval handlerType) bool {
+	if req.isStream() || (!hval.Opts.PureHandler && !s.opts.IncludeNonPure) {
+		return false
+	}
+	if len(s.opts.Handlers) > 0 {
+		found := false
+		for _, name := range s.opts.Handlers {
+			if name == handlerName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return rand.Float64()*100 < s.opts.Percent
+}
+
+// copies the request for the shadow runtime.  client is nil, so
+// Flush, streamed blobs, and StreamAckTracker sends from the shadow handler are never sent.
+func (s *shadowType) makeShadowRequest(req *AppRequest) (*AppRequest, context.CancelFunc) {
+	timeout := s.opts.Timeout
+	if timeout == 0 {
+		timeout = defaultShadowTimeout
+	}
+	ctx, cancelFn := context.WithTimeout(withRequestMetaFrom(context.Background(), req.ctx), timeout)
+	shadowReq := &AppRequest{
+		lock:     &sync.Mutex{},
+		ctx:      ctx,
+		info:     req.info,
+		rawData:  req.rawData,
+		client:   nil,
+		appState: req.appState,
+		authData: req.authData,
+	}
+	return shadowReq, cancelFn
+}
+
+func (s *shadowType) runShadow(shadowReq *AppRequest, cancelFn context.CancelFunc, handlerName string, primaryDur time.Duration, primaryErr error) {
+	defer cancelFn()
+	startTime := time.Now()
+	var shadowErr error
+	func() {
+		defer func() {
+			if panicErr := recover(); panicErr != nil {
+				shadowErr = fmt.Errorf("PANIC in shadow handler %v", panicErr)
+			}
+		}()
+		_, shadowErr = s.rt.RunHandler(shadowReq)
+	}()
+	result := ShadowResult{
+		HandlerName:     handlerName,
+		ReqId:           shadowReq.info.ReqId,
+		PrimaryDuration: primaryDur,
+		ShadowDuration:  time.Since(startTime),
+		PrimaryErr:      primaryErr,
+		ShadowErr:       shadowErr,
+	}
+	s.lock.Lock()
+	s.stats.NumMirrored++
+	if shadowErr != nil {
+		s.stats.NumShadowErrors++
+		if primaryErr == nil {
+			s.stats.NumShadowOnlyErrs++
+		}
+	}
+	s.stats.PrimaryDuration += result.PrimaryDuration
+	s.stats.ShadowDuration += result.ShadowDuration
+	onResult := s.opts.OnResult
+	s.lock.Unlock()
+	if onResult != nil {
+		onResult(result)
+	}
+}
+
+// Mirrors a percentage of live requests to shadowRt (e.g. a rewrite of the app's handlers).
+// The shadow runtime's responses are discarded, its errors and latency are recorded in
+// ShadowStats (and passed to ShadowOpts.OnResult).  Pass a nil shadowRt to stop mirroring.
+func (apprt *AppRuntimeImpl) SetShadowRuntime(shadowRt *AppRuntimeImpl, opts *ShadowOpts) {
+	if shadowRt == nil {
+		apprt.lock.Lock()
+		apprt.shadow = nil
+		apprt.lock.Unlock()
+		return
+	}
+	if shadowRt == apprt {
+		apprt.addError(dasherr.ValidateErr(fmt.Errorf("Cannot set a runtime as its own shadow")))
+		return
+	}
+	if opts == nil {
+		opts = &ShadowOpts{}
+	}
+	err := opts.Validate()
+	if err != nil {
+		apprt.addError(err)
+		return
+	}
+	apprt.lock.Lock()
+	defer apprt.lock.Unlock()
+	apprt.shadow = &shadowType{lock: &sync.Mutex{}, rt: shadowRt, opts: *opts}
+}
+
+// Returns stats for requests mirrored to the shadow runtime (zero value if no shadow runtime is set).
+func (apprt *AppRuntimeImpl) ShadowStats() ShadowStats {
+	apprt.lock.Lock()
+	shadow := apprt.shadow
+	apprt.lock.Unlock()
+	if shadow == nil {
+		return ShadowStats{}
+	}
+	shadow.lock.Lock()
+	defer shadow.lock.Unlock()
+	return shadow.stats
+}
+
+// Mirrors a percentage of the app's requests to shadowRt (see AppRuntimeImpl.SetShadowRuntime).
+func (app *App) SetShadowRuntime(shadowRt *AppRuntimeImpl, opts *ShadowOpts) {
+	app.appRuntime.SetShadowRuntime(shadowRt, opts)
+}
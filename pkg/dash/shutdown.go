@@ -0,0 +1,68 @@
+package dash
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+// Gracefully shuts down the client.  Stops accepting new requests (the request stream is
+// closed, so the server stops routing requests to this process), waits for in-flight
+// handlers to finish and send their responses, then closes the gRPC connection.  If ctx
+// is done before the handlers finish, the connection is closed anyway and a TIMEOUT error
+// is returned.  WaitForShutdown() returns after Shutdown completes.
+// Usage: ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second); defer cancel(); err := client.Shutdown(ctx)
+func (pc *DashCloudClient) Shutdown(ctx context.Context) error {
+	if !pc.hasConn() {
+		return dasherr.ErrWithCode(dasherr.ErrCodeNotConnected, fmt.Errorf("Cannot shutdown, gRPC connection is not initialized"))
+	}
+	pc.Lock.Lock()
+	alreadyShutdown := pc.shuttingDown
+	pc.shuttingDown = true
+	streamCancelFn := pc.streamCancelFn
+	pc.Lock.Unlock()
+	if alreadyShutdown {
+		return dasherr.ValidateErr(fmt.Errorf("Shutdown already called"))
+	}
+	pc.setExitError(fmt.Errorf("Shutdown called"))
+	pc.logV("DashborgCloudClient shutting down, waiting for in-flight requests\n")
+	if streamCancelFn != nil {
+		streamCancelFn()
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		pc.inflightWg.Wait()
+		close(doneCh)
+	}()
+	var rtnErr error
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		rtnErr = dasherr.ErrWithCode(dasherr.ErrCodeTimeout, fmt.Errorf("Shutdown timed out waiting for in-flight requests: %w", ctx.Err()))
+		pc.log("DashborgCloudClient %v\n", rtnErr)
+	}
+	err := pc.closeConn()
+	if err != nil {
+		pc.logV("DashborgCloudClient ERROR closing gRPC connection: %v\n", err)
+	}
+	close(pc.shutdownDoneCh)
+	return rtnErr
+}
+
+func (pc *DashCloudClient) isShuttingDown() bool {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	return pc.shuttingDown
+}
+
+// registers an in-flight request, returns false if the client is shutting down
+func (pc *DashCloudClient) startInflight() bool {
+	pc.Lock.Lock()
+	defer pc.Lock.Unlock()
+	if pc.shuttingDown {
+		return false
+	}
+	pc.inflightWg.Add(1)
+	return true
+}
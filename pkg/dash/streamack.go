@@ -0,0 +1,251 @@
+package dash
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	DefaultStreamAckTimeout = 30 * time.Second
+	DefaultStreamMaxPending = 1000
+	DefaultStreamDedupTTL   = 10 * time.Minute
+	DefaultStreamMaxRedeliv = 10
+)
+
+// Options for MakeStreamAckTracker.
+type StreamAckOpts struct {
+	AckTimeout time.Duration // unacked messages are redelivered after this (defaults to DefaultStreamAckTimeout)
+	MaxPending int           // maximum unacked messages (defaults to DefaultStreamMaxPending)
+	DedupTTL   time.Duration // how long processed message ids (and unacked messages) are kept (defaults to DefaultStreamDedupTTL)
+
+	// unacked messages are dropped after this many redeliveries (defaults to DefaultStreamMaxRedeliv)
+	MaxRedeliveries int
+}
+
+// A message sent with StreamAckTracker.Send.  Appended to the frontend data path, the frontend
+// must ack MsgId (see StreamAckTracker.Ack) once it has applied the message.
+type StreamMessage struct {
+	MsgId       string      `json:"msgid"`
+	Seq         int64       `json:"seq"`
+	Data        interface{} `json:"data"`
+	Redelivered bool        `json:"redelivered,omitempty"`
+}
+
+type pendingStreamMsg struct {
+	msg           StreamMessage
+	path          string
+	firstTs       time.Time
+	sentTs        time.Time
+	numRedelivery int
+}
+
+type processedMsg struct {
+	rtn interface{}
+	err error
+	ts  time.Time
+}
+
+// Tracks delivery of stream messages (backend => frontend) and processing of streamed commands
+// (frontend => backend) so that stream-driven actions are not lost or duplicated across reconnects.
+//
+// Outgoing: Send assigns each message an id, the frontend acks ids with an ack handler (see
+// Ack), and unacked messages are redelivered (RedeliverPending) after AckTimeout or when a
+// new stream request starts.  Incoming: Process runs a command at most once per message id,
+// returning the saved result for duplicates.
+//
+// A tracker serves a single consumer (e.g. one frontend client's stream), an Ack from any
+// frontend clears the message id.  Unacked messages are dropped once they are older than
+// DedupTTL or have been redelivered MaxRedeliveries times, so a consumer that goes away
+// does not fill MaxPending forever.
+type StreamAckTracker struct {
+	lock       *sync.Mutex
+	opts       StreamAckOpts
+	nextSeq    int64
+	pending    map[string]*pendingStreamMsg
+	processed  map[string]*processedMsg
+	inProgress map[string]chan bool
+}
+
+func MakeStreamAckTracker(opts *StreamAckOpts) *StreamAckTracker {
+	rtn := &StreamAckTracker{
+		lock:       &sync.Mutex{},
+		pending:    make(map[string]*pendingStreamMsg),
+		processed:  make(map[string]*processedMsg),
+		inProgress: make(map[string]chan bool),
+	}
+	if opts != nil {
+		rtn.opts = *opts
+	}
+	if rtn.opts.AckTimeout <= 0 {
+		rtn.opts.AckTimeout = DefaultStreamAckTimeout
+	}
+	if rtn.opts.MaxPending <= 0 {
+		rtn.opts.MaxPending = DefaultStreamMaxPending
+	}
+	if rtn.opts.DedupTTL <= 0 {
+		rtn.opts.DedupTTL = DefaultStreamDedupTTL
+	}
+	if rtn.opts.MaxRedeliveries <= 0 {
+		rtn.opts.MaxRedeliveries = DefaultStreamMaxRedeliv
+	}
+	return rtn
+}
+
+// Appends a new StreamMessage (wrapping data) to the frontend data path and flushes the
+// request.  Returns the message id.  The message is redelivered until it is acked (or expires).
+func (t *StreamAckTracker) Send(req ActionRequest, path string, data interface{}) (string, error) {
+	t.lock.Lock()
+	t.expirePendingNoLock()
+	if len(t.pending) >= t.opts.MaxPending {
+		t.lock.Unlock()
+		return "", dasherr.ErrWithCode(dasherr.ErrCodeQueueFull, fmt.Errorf("Too many unacked stream messages (max %d)", t.opts.MaxPending))
+	}
+	t.nextSeq++
+	now := time.Now()
+	pmsg := &pendingStreamMsg{
+		msg:     StreamMessage{MsgId: uuid.New().String(), Seq: t.nextSeq, Data: data},
+		path:    path,
+		firstTs: now,
+		sentTs:  now,
+	}
+	t.pending[pmsg.msg.MsgId] = pmsg
+	t.lock.Unlock()
+	err := req.AddDataOp("append", path, pmsg.msg)
+	if err != nil {
+		// never sent, so it is not pending (it would be redelivered forever)
+		t.lock.Lock()
+		delete(t.pending, pmsg.msg.MsgId)
+		t.lock.Unlock()
+		return "", err
+	}
+	err = req.Flush()
+	if err != nil {
+		// stays pending, will be redelivered
+		return pmsg.msg.MsgId, err
+	}
+	return pmsg.msg.MsgId, nil
+}
+
+// Acks delivered messages.  Normally called from a handler the frontend calls with the
+// message ids it has applied.
+// Usage: app.Runtime().Handler("stream-ack", func(msgIds []string) error { tracker.Ack(msgIds...); return nil })
+func (t *StreamAckTracker) Ack(msgIds ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, msgId := range msgIds {
+		delete(t.pending, msgId)
+	}
+}
+
+// Returns the number of unacked messages.
+func (t *StreamAckTracker) NumPending() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.expirePendingNoLock()
+	return len(t.pending)
+}
+
+// Resends unacked messages (in order) on req.  If all is false, only messages that have not
+// been acked within AckTimeout are resent.  Call with all=true at the start of a new stream
+// request (after a reconnect), and periodically with all=false from long running streams.
+// Returns the number of messages resent.
+func (t *StreamAckTracker) RedeliverPending(req ActionRequest, all bool) (int, error) {
+	t.lock.Lock()
+	t.expirePendingNoLock()
+	var toSend []pendingStreamMsg
+	now := time.Now()
+	for _, pmsg := range t.pending {
+		if all || now.Sub(pmsg.sentTs) >= t.opts.AckTimeout {
+			pmsg.sentTs = now
+			pmsg.numRedelivery++
+			pmsg.msg.Redelivered = true
+			toSend = append(toSend, *pmsg)
+		}
+	}
+	t.lock.Unlock()
+	if len(toSend) == 0 {
+		return 0, nil
+	}
+	sort.Slice(toSend, func(i int, j int) bool {
+		return toSend[i].msg.Seq < toSend[j].msg.Seq
+	})
+	for _, pmsg := range toSend {
+		err := req.AddDataOp("append", pmsg.path, pmsg.msg)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err := req.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return len(toSend), nil
+}
+
+// Runs fn at most once for msgId.  Duplicate calls (e.g. a command resent by the frontend
+// after a reconnect) wait for the first call to finish and return its result.  Results are
+// remembered for DedupTTL.  If fn returns a retryable error (see dasherr.CanRetry) the result
+// is not saved, so a redelivered command runs again.
+func (t *StreamAckTracker) Process(msgId string, fn func() (interface{}, error)) (interface{}, error) {
+	if msgId == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("StreamAckTracker.Process requires a msgId"))
+	}
+	for {
+		t.lock.Lock()
+		t.expireProcessedNoLock()
+		if result, ok := t.processed[msgId]; ok {
+			t.lock.Unlock()
+			return result.rtn, result.err
+		}
+		if waitCh, ok := t.inProgress[msgId]; ok {
+			t.lock.Unlock()
+			<-waitCh
+			continue
+		}
+		doneCh := make(chan bool)
+		t.inProgress[msgId] = doneCh
+		t.lock.Unlock()
+		rtn, err := t.runProcessFn(fn)
+		t.lock.Lock()
+		delete(t.inProgress, msgId)
+		if err == nil || !dasherr.CanRetry(err) {
+			t.processed[msgId] = &processedMsg{rtn: rtn, err: err, ts: time.Now()}
+		}
+		t.lock.Unlock()
+		close(doneCh)
+		return rtn, err
+	}
+}
+
+func (t *StreamAckTracker) runProcessFn(fn func() (interface{}, error)) (rtn interface{}, rtnErr error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			rtnErr = dasherr.ErrWithCode(dasherr.ErrCodePanic, fmt.Errorf("PANIC in StreamAckTracker.Process %v", panicErr))
+		}
+	}()
+	return fn()
+}
+
+// drops unacked messages that are older than DedupTTL or have used up MaxRedeliveries
+func (t *StreamAckTracker) expirePendingNoLock() {
+	now := time.Now()
+	for msgId, pmsg := range t.pending {
+		if now.Sub(pmsg.firstTs) > t.opts.DedupTTL || pmsg.numRedelivery >= t.opts.MaxRedeliveries {
+			delete(t.pending, msgId)
+		}
+	}
+}
+
+func (t *StreamAckTracker) expireProcessedNoLock() {
+	now := time.Now()
+	for msgId, result := range t.processed {
+		if now.Sub(result.ts) > t.opts.DedupTTL {
+			delete(t.processed, msgId)
+		}
+	}
+}
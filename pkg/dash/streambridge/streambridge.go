@@ -0,0 +1,550 @@
+// Reads messages from a Kafka or NATS consumer and pushes them to a Dashborg stream handler as
+// a live feed (the last MaxItems messages at a frontend data path), with offset tracking and
+// backpressure.
+//
+// Message systems plug in by implementing Consumer around their client library.  For Kafka with
+// github.com/segmentio/kafka-go, Fetch calls reader.FetchMessage and Commit calls
+// reader.CommitMessages with the Raw messages.  For NATS, use a JetStream pull consumer (core
+// NATS has no offsets to commit) with github.com/nats-io/nats.go:
+//
+//	type jetStreamConsumer struct {
+//		sub *nats.Subscription // js.PullSubscribe("orders.>", "dashborg-feed", nats.AckAll())
+//	}
+//
+//	func (c *jetStreamConsumer) Fetch(ctx context.Context) (*streambridge.Message, error) {
+//		for {
+//			msgs, err := c.sub.Fetch(1, nats.Context(ctx))
+//			if ctx.Err() != nil {
+//				return nil, ctx.Err()
+//			}
+//			if err == nats.ErrBadSubscription {
+//				return nil, streambridge.ErrConsumerClosed
+//			}
+//			if err == nats.ErrTimeout || (err == nil && len(msgs) == 0) {
+//				continue
+//			}
+//			if err != nil {
+//				return nil, err
+//			}
+//			meta, err := msgs[0].Metadata()
+//			if err != nil {
+//				return nil, err
+//			}
+//			// Subject is the stream name, so offsets are tracked per stream (not per NATS subject)
+//			return &streambridge.Message{Subject: meta.Stream, Offset: int64(meta.Sequence.Stream), Key: []byte(msgs[0].Subject), Value: msgs[0].Data, Time: meta.Timestamp, Raw: msgs[0]}, nil
+//		}
+//	}
+//
+//	// with AckAll, acking the last processed message acks every message before it
+//	func (c *jetStreamConsumer) Commit(ctx context.Context, msgs []*streambridge.Message) error {
+//		for _, msg := range msgs {
+//			err := msg.Raw.(*nats.Msg).AckSync(nats.Context(ctx))
+//			if err != nil {
+//				return err
+//			}
+//		}
+//		return nil
+//	}
+//
+//	func (c *jetStreamConsumer) Close() error {
+//		return c.sub.Unsubscribe()
+//	}
+package streambridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+const (
+	DefaultName           = "feed"
+	DefaultPath           = "$.feed"
+	DefaultMaxItems       = 100
+	DefaultBufferSize     = 1000
+	DefaultCommitInterval = 5 * time.Second
+	fetchRetryMinWait     = time.Second
+	fetchRetryMaxWait     = 60 * time.Second
+	commitTimeout         = 10 * time.Second
+	subscriberQueueSize   = 256
+	maxStreamBatch        = 100
+)
+
+// Returned from Fetch after Close, stops the bridge's fetch loop.
+var ErrConsumerClosed = errors.New("Consumer is closed")
+
+// A message read from a Consumer.
+type Message struct {
+	Subject   string // NATS subject or Kafka topic
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Time      time.Time
+	Raw       interface{} // consumer specific message (passed back to Commit)
+}
+
+// Source of messages for a Bridge.  Fetch is called from a single goroutine and should block
+// until a message is available (returning ctx.Err() when ctx is canceled).  Commit is called
+// periodically with the last processed message of each subject/partition.
+type Consumer interface {
+	Fetch(ctx context.Context) (*Message, error)
+	Commit(ctx context.Context, msgs []*Message) error
+	Close() error
+}
+
+// A feed item, as sent to the frontend.
+type Item struct {
+	Subject   string      `json:"subject"`
+	Partition int32       `json:"partition,omitempty"`
+	Offset    int64       `json:"offset"`
+	Key       string      `json:"key,omitempty"`
+	Ts        int64       `json:"ts"`
+	Value     interface{} `json:"value"`
+}
+
+// Bridge config.  Can be unmarshaled from JSON (except Convert and Logger).
+type Config struct {
+	Name           string        `json:"name"`           // stream handler name, defaults to DefaultName ("<name>-items" and "<name>-status" are also registered)
+	Path           string        `json:"path"`           // frontend data path for the feed, defaults to DefaultPath
+	MaxItems       int           `json:"maxitems"`       // items kept (and sent to new streams), defaults to DefaultMaxItems
+	BufferSize     int           `json:"buffersize"`     // messages buffered between the consumer and the streams, defaults to DefaultBufferSize
+	DropWhenFull   bool          `json:"dropwhenfull"`   // drop messages when the buffer is full (default is to stop fetching until there is room)
+	CommitInterval time.Duration `json:"commitinterval"` // defaults to DefaultCommitInterval
+
+	// Converts a message to its Item value, returning nil skips the message.  The default is
+	// the message value decoded as JSON (or a string if it is not valid JSON).
+	Convert func(msg *Message) (interface{}, error) `json:"-"`
+	Logger  *log.Logger                             `json:"-"` // defaults to the standard logger
+}
+
+type Bridge struct {
+	lock         *sync.Mutex
+	cfg          Config
+	consumer     Consumer
+	bufCh        chan *Message
+	items        []Item
+	subscribers  map[int]chan Item
+	nextSubId    int
+	pending      map[string]*Message // subject/partition => last processed message, not yet committed
+	offsets      map[string]int64    // subject/partition => last processed offset
+	committed    map[string]int64    // subject/partition => last committed offset
+	numDelivered int64
+	numDropped   int64
+	running      bool
+	lastErr      error
+	ctx          context.Context
+	cancelFn     context.CancelFunc
+	doneCh       chan struct{}
+	loopWg       *sync.WaitGroup
+	once         *sync.Once
+}
+
+// Bridge state, returned from the "<name>-status" handler.
+type Status struct {
+	Running   bool             `json:"running"`
+	Delivered int64            `json:"delivered"`
+	Dropped   int64            `json:"dropped"`
+	Buffered  int              `json:"buffered"`
+	Offsets   map[string]int64 `json:"offsets"`
+	Committed map[string]int64 `json:"committed"`
+	LastErr   string           `json:"lasterr,omitempty"`
+}
+
+func partitionKey(msg *Message) string {
+	return fmt.Sprintf("%s/%d", msg.Subject, msg.Partition)
+}
+
+func MakeBridge(consumer Consumer, cfg *Config) (*Bridge, error) {
+	if consumer == nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("streambridge requires a Consumer"))
+	}
+	rtn := &Bridge{
+		lock:        &sync.Mutex{},
+		consumer:    consumer,
+		subscribers: make(map[int]chan Item),
+		pending:     make(map[string]*Message),
+		offsets:     make(map[string]int64),
+		committed:   make(map[string]int64),
+		doneCh:      make(chan struct{}),
+		loopWg:      &sync.WaitGroup{},
+		once:        &sync.Once{},
+	}
+	if cfg != nil {
+		rtn.cfg = *cfg
+	}
+	if rtn.cfg.Name == "" {
+		rtn.cfg.Name = DefaultName
+	}
+	if rtn.cfg.Path == "" {
+		rtn.cfg.Path = DefaultPath
+	}
+	if rtn.cfg.MaxItems <= 0 {
+		rtn.cfg.MaxItems = DefaultMaxItems
+	}
+	if rtn.cfg.BufferSize <= 0 {
+		rtn.cfg.BufferSize = DefaultBufferSize
+	}
+	if rtn.cfg.CommitInterval <= 0 {
+		rtn.cfg.CommitInterval = DefaultCommitInterval
+	}
+	if rtn.cfg.Convert == nil {
+		rtn.cfg.Convert = defaultConvert
+	}
+	rtn.bufCh = make(chan *Message, rtn.cfg.BufferSize)
+	rtn.ctx, rtn.cancelFn = context.WithCancel(context.Background())
+	return rtn, nil
+}
+
+func defaultConvert(msg *Message) (interface{}, error) {
+	var val interface{}
+	if json.Unmarshal(msg.Value, &val) == nil {
+		return val, nil
+	}
+	return string(msg.Value), nil
+}
+
+func (b *Bridge) logf(fmtStr string, args ...interface{}) {
+	if b.cfg.Logger != nil {
+		b.cfg.Logger.Printf(fmtStr, args...)
+	} else {
+		log.Printf(fmtStr, args...)
+	}
+}
+
+// Creates the bridge, starts it, and registers its handlers on the runtime.
+// The bridge stops (committing offsets and closing the consumer) when the client shuts down
+// (or Stop() is called).
+// Usage: streambridge.Start(client, app.Runtime(), consumer, &streambridge.Config{Name: "orders"})
+func Start(client *dash.DashCloudClient, rt dash.HandlerRegistry, consumer Consumer, cfg *Config) (*Bridge, error) {
+	b, err := MakeBridge(consumer, cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.Register(rt)
+	b.Run()
+	go func() {
+		select {
+		case <-client.DoneCh:
+			b.Stop()
+		case <-b.doneCh:
+		}
+	}()
+	return b, nil
+}
+
+// Registers the "<name>" stream handler (start it as a stream to receive the feed, it sends
+// the kept items first) and the pure "<name>-items" and "<name>-status" handlers.
+func (b *Bridge) Register(rt dash.HandlerRegistry) {
+	rt.Handler(b.cfg.Name, b.stream)
+	rt.PureHandler(b.cfg.Name+"-items", b.Items)
+	rt.PureHandler(b.cfg.Name+"-status", b.Status)
+}
+
+// Starts the fetch, process, and commit goroutines.
+func (b *Bridge) Run() {
+	b.lock.Lock()
+	b.running = true
+	b.lock.Unlock()
+	b.loopWg.Add(2)
+	go b.fetchLoop()
+	go b.processLoop()
+	go b.commitLoop()
+}
+
+// Stops fetching, commits the processed offsets, and closes the consumer.
+func (b *Bridge) Stop() {
+	b.once.Do(func() {
+		close(b.doneCh)
+		b.cancelFn()
+		b.loopWg.Wait()
+		b.commit()
+		err := b.consumer.Close()
+		if err != nil {
+			b.logf("streambridge %s error closing consumer: %v\n", b.cfg.Name, err)
+		}
+		b.lock.Lock()
+		b.running = false
+		b.lock.Unlock()
+	})
+}
+
+func (b *Bridge) isStopped() bool {
+	select {
+	case <-b.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Bridge) setErr(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.lastErr = err
+}
+
+func (b *Bridge) fetchLoop() {
+	defer b.loopWg.Done()
+	wait := fetchRetryMinWait
+	for {
+		msg, err := b.consumer.Fetch(b.ctx)
+		if b.isStopped() {
+			return
+		}
+		if err == ErrConsumerClosed {
+			b.setErr(err)
+			return
+		}
+		if err != nil {
+			b.setErr(err)
+			b.logf("streambridge %s fetch error: %v, retrying in %v\n", b.cfg.Name, err, wait)
+			select {
+			case <-time.After(wait):
+			case <-b.doneCh:
+				return
+			}
+			wait *= 2
+			if wait > fetchRetryMaxWait {
+				wait = fetchRetryMaxWait
+			}
+			continue
+		}
+		wait = fetchRetryMinWait
+		if msg == nil {
+			continue
+		}
+		if b.cfg.DropWhenFull {
+			select {
+			case b.bufCh <- msg:
+			default:
+				b.lock.Lock()
+				b.numDropped++
+				b.lock.Unlock()
+			}
+			continue
+		}
+		select {
+		case b.bufCh <- msg:
+		case <-b.doneCh:
+			return
+		}
+	}
+}
+
+// must hold lock
+func (b *Bridge) markProcessedNoLock(msg *Message) {
+	key := partitionKey(msg)
+	b.pending[key] = msg
+	b.offsets[key] = msg.Offset
+}
+
+func (b *Bridge) processLoop() {
+	defer b.loopWg.Done()
+	for {
+		select {
+		case msg := <-b.bufCh:
+			b.processMessage(msg)
+
+		case <-b.doneCh:
+			return
+		}
+	}
+}
+
+func (b *Bridge) processMessage(msg *Message) {
+	val, err := b.cfg.Convert(msg)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.markProcessedNoLock(msg)
+	if err != nil {
+		b.logf("streambridge %s error converting message subject:%s offset:%d err:%v\n", b.cfg.Name, msg.Subject, msg.Offset, err)
+		return
+	}
+	if val == nil {
+		return
+	}
+	msgTime := msg.Time
+	if msgTime.IsZero() {
+		msgTime = time.Now()
+	}
+	item := Item{
+		Subject:   msg.Subject,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Ts:        msgTime.UnixNano() / int64(time.Millisecond),
+		Value:     val,
+	}
+	b.items = append(b.items, item)
+	if len(b.items) > b.cfg.MaxItems {
+		b.items = b.items[len(b.items)-b.cfg.MaxItems:]
+	}
+	b.numDelivered++
+	for subId, ch := range b.subscribers {
+		select {
+		case ch <- item:
+		default:
+			// slow stream, it is closed and the frontend restarts it (receiving the kept items)
+			close(ch)
+			delete(b.subscribers, subId)
+		}
+	}
+}
+
+func (b *Bridge) commitLoop() {
+	ticker := time.NewTicker(b.cfg.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.commit()
+
+		case <-b.doneCh:
+			return
+		}
+	}
+}
+
+// commits the last processed message of each subject/partition
+func (b *Bridge) commit() {
+	b.lock.Lock()
+	if len(b.pending) == 0 {
+		b.lock.Unlock()
+		return
+	}
+	var keys []string
+	var msgs []*Message
+	for key, msg := range b.pending {
+		keys = append(keys, key)
+		msgs = append(msgs, msg)
+	}
+	b.pending = make(map[string]*Message)
+	b.lock.Unlock()
+	ctx, cancelFn := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancelFn()
+	err := b.consumer.Commit(ctx, msgs)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err != nil {
+		b.lastErr = err
+		b.logf("streambridge %s commit error: %v\n", b.cfg.Name, err)
+		for idx, key := range keys {
+			if _, ok := b.pending[key]; !ok {
+				b.pending[key] = msgs[idx] // retry on the next commit (unless superseded)
+			}
+		}
+		return
+	}
+	for idx, key := range keys {
+		b.committed[key] = msgs[idx].Offset
+	}
+}
+
+func (b *Bridge) subscribe() (int, chan Item) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nextSubId++
+	ch := make(chan Item, subscriberQueueSize)
+	b.subscribers[b.nextSubId] = ch
+	return b.nextSubId, ch
+}
+
+func (b *Bridge) unsubscribe(subId int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, subId)
+}
+
+// returns the kept items, discarding the queued items (they are already in the kept items)
+func (b *Bridge) snapshot(ch chan Item) []Item {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for len(ch) > 0 {
+		<-ch
+	}
+	return append([]Item{}, b.items...)
+}
+
+// sends the kept items, then appends new items until the stream ends.  the full list is
+// resent once the frontend holds 2*MaxItems items (appends are not trimmed by the frontend).
+func (b *Bridge) stream(req dash.ActionRequest) error {
+	subId, ch := b.subscribe()
+	defer b.unsubscribe(subId)
+	items := b.snapshot(ch)
+	req.SetData(b.cfg.Path, items)
+	err := req.Flush()
+	if err != nil {
+		return err
+	}
+	numSent := len(items)
+	for {
+		select {
+		case item, ok := <-ch:
+			if !ok {
+				return dasherr.ErrWithCode(dasherr.ErrCodeQueueFull, fmt.Errorf("streambridge stream fell behind"))
+			}
+			batch := []Item{item}
+			for len(batch) < maxStreamBatch && len(ch) > 0 {
+				batch = append(batch, <-ch)
+			}
+			if numSent+len(batch) > 2*b.cfg.MaxItems {
+				items = b.snapshot(ch)
+				req.SetData(b.cfg.Path, items)
+				numSent = len(items)
+			} else {
+				for _, item := range batch {
+					req.AddDataOp("append", b.cfg.Path, item)
+				}
+				numSent += len(batch)
+			}
+			err = req.Flush()
+			if err != nil {
+				return err
+			}
+
+		case <-req.Context().Done():
+			return nil
+
+		case <-b.doneCh:
+			return nil
+		}
+	}
+}
+
+// Returns the kept items (oldest first).
+func (b *Bridge) Items() []Item {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]Item{}, b.items...)
+}
+
+func (b *Bridge) Status() Status {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	rtn := Status{
+		Running:   b.running,
+		Delivered: b.numDelivered,
+		Dropped:   b.numDropped,
+		Buffered:  len(b.bufCh),
+		Offsets:   make(map[string]int64),
+		Committed: make(map[string]int64),
+	}
+	for key, offset := range b.offsets {
+		rtn.Offsets[key] = offset
+	}
+	for key, offset := range b.committed {
+		rtn.Committed[key] = offset
+	}
+	if b.lastErr != nil {
+		rtn.LastErr = b.lastErr.Error()
+	}
+	return rtn
+}
@@ -0,0 +1,193 @@
+// Tenant isolation helpers for multi-tenant Dashborg apps.  Derives a tenant id from the
+// request's auth claims, scopes Dashborg FS paths by tenant, and provides middleware that
+// rejects cross-tenant requests.
+//
+// Frontend data paths (req.SetData) are not prefixed, they are already scoped to the
+// frontend client that made the request.  Use TenantPath or FSClient for data that is
+// stored in the Dashborg FS.
+package tenancy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dash"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	DefaultClaimName      = "tenant"
+	DefaultStateKey       = "tenant"
+	DefaultRootDir        = "/tenants"
+	MiddlewareName        = "tenancy"
+	MiddlewarePriority    = 110 // runs after dash.AuthzMwPriority, before dash.NonceMwPriority (rejected requests do not consume a nonce)
+	tenantIdMax           = 64
+	tenantErrNoTenant     = "No tenant for request"
+	tenantErrCrossTenant  = "Cross-tenant access denied"
+	tenantErrInvalidClaim = "Invalid tenant claim"
+)
+
+var tenantIdRe = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+type Config struct {
+	// Key in AuthAtom.Data (the JWT / auth claims) that holds the tenant id.  Defaults to DefaultClaimName.
+	ClaimName string
+
+	// Top-level app state key (and url param) that the frontend uses to select a tenant.  If a request
+	// has this key set to a different tenant than the auth claim, the request is rejected.
+	// Defaults to DefaultStateKey.
+	StateKey string
+
+	// Roles that may access any tenant (their requests are not rejected for cross-tenant access).
+	// The super role is always allowed.
+	CrossTenantRoles []string
+
+	// App relative directory that tenant FS paths are created under (defaults to DefaultRootDir).
+	RootDir string
+}
+
+type Tenancy struct {
+	cfg Config
+}
+
+type middlewareRegistry interface {
+	AddRawMiddleware(name string, mwFunc dash.MiddlewareFuncType, priority float64)
+}
+
+func MakeTenancy(cfg *Config) *Tenancy {
+	rtn := &Tenancy{}
+	if cfg != nil {
+		rtn.cfg = *cfg
+	}
+	if rtn.cfg.ClaimName == "" {
+		rtn.cfg.ClaimName = DefaultClaimName
+	}
+	if rtn.cfg.StateKey == "" {
+		rtn.cfg.StateKey = DefaultStateKey
+	}
+	if rtn.cfg.RootDir == "" {
+		rtn.cfg.RootDir = DefaultRootDir
+	}
+	return rtn
+}
+
+func IsTenantIdValid(tenantId string) bool {
+	return len(tenantId) <= tenantIdMax && tenantIdRe.MatchString(tenantId)
+}
+
+// Returns the tenant id from the request's auth claims.  Returns an error if the
+// request has no (or an invalid) tenant claim.
+func (t *Tenancy) TenantId(req dash.Request) (string, error) {
+	aa := req.AuthData()
+	if aa == nil || aa.Data == nil {
+		return "", dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf(tenantErrNoTenant))
+	}
+	tenantId, ok := aa.Data[t.cfg.ClaimName].(string)
+	if !ok || tenantId == "" {
+		return "", dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf(tenantErrNoTenant))
+	}
+	if !IsTenantIdValid(tenantId) {
+		return "", dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf(tenantErrInvalidClaim))
+	}
+	return tenantId, nil
+}
+
+func (t *Tenancy) isCrossTenantAllowed(aa *dash.AuthAtom) bool {
+	if aa.IsSuper() {
+		return true
+	}
+	for _, role := range t.cfg.CrossTenantRoles {
+		if aa.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the tenant the frontend requested (app state key or url param), "" if not set
+func (t *Tenancy) requestedTenant(req dash.Request) (string, error) {
+	stateJson := req.RawData().AppStateJson
+	if stateJson == "" {
+		return "", nil
+	}
+	var state map[string]interface{}
+	err := json.Unmarshal([]byte(stateJson), &state)
+	if err != nil {
+		return "", dasherr.JsonUnmarshalErr("AppState", err)
+	}
+	if tenantId, ok := state[t.cfg.StateKey].(string); ok && tenantId != "" {
+		return tenantId, nil
+	}
+	if urlParams, ok := state["urlparams"].(map[string]interface{}); ok {
+		if tenantId, ok := urlParams[t.cfg.StateKey].(string); ok {
+			return tenantId, nil
+		}
+	}
+	return "", nil
+}
+
+// Checks that the request has a tenant and does not try to access a different tenant.
+func (t *Tenancy) CheckRequest(req dash.Request) error {
+	aa := req.AuthData()
+	if aa.IsSuper() {
+		return nil
+	}
+	tenantId, err := t.TenantId(req)
+	if err != nil {
+		return err
+	}
+	requested, err := t.requestedTenant(req)
+	if err != nil {
+		return err
+	}
+	if requested != "" && requested != tenantId && !t.isCrossTenantAllowed(aa) {
+		return dasherr.NoRetryErrWithCode(dasherr.ErrCodeRoleAuth, fmt.Errorf(tenantErrCrossTenant))
+	}
+	return nil
+}
+
+// Middleware that rejects requests without a tenant or with cross-tenant access.
+func (t *Tenancy) Middleware(req *dash.AppRequest, nextFn dash.MiddlewareNextFuncType) (interface{}, error) {
+	err := t.CheckRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return nextFn(req)
+}
+
+// Installs the tenancy middleware on an app runtime or link runtime.
+func (t *Tenancy) Install(rt middlewareRegistry) {
+	rt.AddRawMiddleware(MiddlewareName, t.Middleware, MiddlewarePriority)
+}
+
+// Returns the app relative path for a tenant's file (e.g. "/data.json" => "/tenants/[tenant]/data.json").
+func (t *Tenancy) TenantPath(tenantId string, path string) (string, error) {
+	if !IsTenantIdValid(tenantId) {
+		return "", dasherr.ValidateErr(fmt.Errorf("Invalid tenant id"))
+	}
+	err := dashutil.Path(path).Validate()
+	if err != nil {
+		return "", err
+	}
+	return string(dashutil.Path(t.cfg.RootDir).Join(tenantId, path)), nil
+}
+
+// Returns a DashFSClient rooted at the tenant's directory under the app's path.  All paths
+// written or read with the returned client are scoped to the tenant.
+func (t *Tenancy) FSClient(client *dash.DashCloudClient, app *dash.App, tenantId string) (*dash.DashFSClient, error) {
+	if !IsTenantIdValid(tenantId) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid tenant id"))
+	}
+	return client.FSClientAtRoot(app.AppPath() + t.cfg.RootDir + "/" + tenantId)
+}
+
+// Returns a DashFSClient scoped to the tenant of the given request.
+func (t *Tenancy) RequestFSClient(client *dash.DashCloudClient, app *dash.App, req dash.Request) (*dash.DashFSClient, error) {
+	tenantId, err := t.TenantId(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.FSClient(client, app, tenantId)
+}
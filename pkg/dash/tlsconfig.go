@@ -0,0 +1,72 @@
+package dash
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+)
+
+func defaultGrpcTlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS13,
+		CurvePreferences:         []tls.CurveID{tls.CurveP384},
+		PreferServerCipherSuites: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		},
+	}
+}
+
+func loadRootCAs(fileName string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read RootCAFile '%s': %w", fileName, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("RootCAFile '%s' contains no PEM certificates", fileName))
+	}
+	return pool, nil
+}
+
+// applies RootCAFile and warns if server verification is disabled
+func (c *Config) finishTlsConfig(tlsConfig *tls.Config) (*tls.Config, error) {
+	if c.RootCAFile != "" {
+		pool, err := loadRootCAs(c.RootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if tlsConfig.InsecureSkipVerify {
+		c.log("Dashborg WARNING TLSConfig.InsecureSkipVerify is set, the server's certificate is not verified\n")
+	}
+	return tlsConfig, nil
+}
+
+// TLS config for the gRPC connection, Config.TLSConfig (or the default) with the client certificate
+func (c *Config) grpcTlsConfig(clientCert tls.Certificate) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	} else {
+		tlsConfig = defaultGrpcTlsConfig()
+	}
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetClientCertificate == nil {
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return c.finishTlsConfig(tlsConfig)
+}
+
+// TLS config for https calls (ConsoleHost and the https transport), nil to use the default.
+// only RootCAFile applies (TLSConfig is for the gRPC connection)
+func (c *Config) httpsTlsConfig() (*tls.Config, error) {
+	if c.RootCAFile == "" {
+		return nil, nil
+	}
+	return c.finishTlsConfig(&tls.Config{})
+}
@@ -0,0 +1,138 @@
+package dash
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+	"google.golang.org/grpc"
+)
+
+const (
+	TraceAttrReqId       = "dashborg.reqid"
+	TraceAttrFeClientId  = "dashborg.feclientid"
+	TraceAttrRequestType = "dashborg.requesttype"
+	TraceAttrPath        = "dashborg.path"
+	TraceAttrAppName     = "dashborg.appname"
+	TraceAttrErrCode     = "dashborg.errcode"
+	TraceAttrRpcSystem   = "rpc.system"
+	TraceAttrRpcMethod   = "rpc.method"
+)
+
+// A key/value attribute set on a span.  Value is a string, bool, int, int64, or float64.
+type SpanAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// A span started by a Tracer.  Maps directly to an OpenTelemetry trace.Span
+// (SetAttributes, RecordError + SetStatus(codes.Error), End).
+type Span interface {
+	SetAttrs(attrs ...SpanAttr)
+	SetError(err error)
+	End()
+}
+
+// Set as Config.Tracer to trace handler dispatches and gRPC calls.  Start should return a
+// context carrying the new span (so spans started by handlers from req.Context() are children).
+// The SDK does not depend on OpenTelemetry, wrap an otel trace.Tracer with a small adapter.
+// Usage: config.Tracer = myOtelAdapter{otel.Tracer("dashborg")}
+type Tracer interface {
+	Start(ctx context.Context, spanName string, attrs ...SpanAttr) (context.Context, Span)
+}
+
+func (pc *DashCloudClient) tracer() Tracer {
+	if pc == nil || pc.Config == nil {
+		return nil
+	}
+	return pc.Config.Tracer
+}
+
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetError(err)
+		if code := dasherr.GetErrCode(err); code != "" {
+			span.SetAttrs(SpanAttr{Key: TraceAttrErrCode, Value: string(code)})
+		}
+	}
+	span.End()
+}
+
+// starts the span for a handler dispatch, replaces the request's context with the span's
+// context.  returns nil if no Tracer is configured.
+func (pc *DashCloudClient) startRequestSpan(preq *AppRequest) Span {
+	tracer := pc.tracer()
+	if tracer == nil || preq.ctx == nil {
+		return nil
+	}
+	spanName := "dashborg.request " + dashutil.SimplifyPath(preq.info.Path, nil)
+	ctx, span := tracer.Start(preq.ctx, spanName,
+		SpanAttr{Key: TraceAttrReqId, Value: preq.info.ReqId},
+		SpanAttr{Key: TraceAttrFeClientId, Value: preq.info.FeClientId},
+		SpanAttr{Key: TraceAttrRequestType, Value: preq.info.RequestType},
+		SpanAttr{Key: TraceAttrPath, Value: preq.info.Path},
+		SpanAttr{Key: TraceAttrAppName, Value: preq.info.AppName},
+	)
+	if span == nil {
+		return nil
+	}
+	if ctx != nil {
+		preq.ctx = ctx
+	}
+	return span
+}
+
+// "/dashborg.rpc1.DashborgService/SetPath" => "SetPath"
+func grpcShortMethod(fullMethod string) string {
+	if idx := strings.LastIndex(fullMethod, "/"); idx != -1 {
+		return fullMethod[idx+1:]
+	}
+	return fullMethod
+}
+
+func (pc *DashCloudClient) startRpcSpan(ctx context.Context, fullMethod string) (context.Context, Span) {
+	ctx, span := pc.tracer().Start(ctx, "dashborg.rpc "+grpcShortMethod(fullMethod),
+		SpanAttr{Key: TraceAttrRpcSystem, Value: "grpc"},
+		SpanAttr{Key: TraceAttrRpcMethod, Value: fullMethod},
+	)
+	return ctx, span
+}
+
+func (pc *DashCloudClient) traceUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	spanCtx, span := pc.startRpcSpan(ctx, method)
+	if span == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	if spanCtx != nil {
+		ctx = spanCtx
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	endSpan(span, err)
+	return err
+}
+
+// the span covers stream setup only (the request stream is long lived)
+func (pc *DashCloudClient) traceStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	spanCtx, span := pc.startRpcSpan(ctx, method)
+	if span == nil {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	if spanCtx != nil {
+		ctx = spanCtx
+	}
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	endSpan(span, err)
+	return stream, err
+}
+
+// gRPC dial options that trace RPCs, nil if no Tracer is configured
+func (pc *DashCloudClient) traceDialOpts() []grpc.DialOption {
+	if pc.tracer() == nil {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(pc.traceUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(pc.traceStreamInterceptor),
+	}
+}
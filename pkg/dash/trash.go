@@ -0,0 +1,267 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// Global Dashborg FS directory that trashed files are moved to.  Each trashed file is stored
+// as [TrashDir]/[trashid]/entry.json (a TrashEntry) and [TrashDir]/[trashid]/content.
+const TrashDir = "/_/trash"
+
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+const (
+	trashEntryName   = "entry.json"
+	trashContentName = "content"
+)
+
+// Options for DashFSClient.TrashPath.
+type TrashOpts struct {
+	Retention time.Duration // how long the file can be restored (defaults to DefaultTrashRetention)
+}
+
+// A trashed file.  Returned from TrashPath and ListTrash.
+type TrashEntry struct {
+	TrashId    string   `json:"trashid"`
+	OrigPath   string   `json:"origpath"` // full Dashborg FS path the file was removed from
+	TrashedTs  int64    `json:"trashedts"`
+	ExpTs      int64    `json:"expts"`
+	HasContent bool     `json:"hascontent"`
+	FileOpts   FileOpts `json:"fileopts"`
+}
+
+func (entry *TrashEntry) IsExpired() bool {
+	return dashutil.Ts() > entry.ExpTs
+}
+
+func trashEntryPath(trashId string) string {
+	return TrashDir + "/" + trashId + "/" + trashEntryName
+}
+
+func trashContentPath(trashId string) string {
+	return TrashDir + "/" + trashId + "/" + trashContentName
+}
+
+func trashFileOpts(mimeType string) *FileOpts {
+	return &FileOpts{MimeType: mimeType, Hidden: true, AllowedRoles: []string{RoleSuper}}
+}
+
+// Moves path to the trash instead of removing it (see RemovePath).  The file can be restored
+// with RestorePath until its retention window expires.  Static files and apps can be trashed,
+// directories and runtime links cannot.
+func (fs *DashFSClient) TrashPath(path string, opts *TrashOpts) (*TrashEntry, error) {
+	fullPath, err := dashutil.FullPathFromRoot(fs.rootPath, path)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &TrashOpts{}
+	}
+	if opts.Retention < 0 {
+		return nil, dasherr.ValidateErr(fmt.Errorf("TrashOpts Retention cannot be negative"))
+	}
+	retention := opts.Retention
+	if retention == 0 {
+		retention = DefaultTrashRetention
+	}
+	if dashutil.Path(fullPath).IsUnder(TrashDir) {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Cannot trash a path in %s", TrashDir))
+	}
+	finfos, content, err := fs.client.fileInfo(fullPath, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(finfos) == 0 {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("Path %s not found", fullPath))
+	}
+	finfo := finfos[0]
+	if finfo.FileType == FileTypeDir || finfo.IsLinkType() {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Cannot trash %s, file-type:%s", fullPath, finfo.FileType))
+	}
+	nowTs := dashutil.Ts()
+	entry := &TrashEntry{
+		TrashId:   uuid.New().String(),
+		OrigPath:  fullPath,
+		TrashedTs: nowTs,
+		ExpTs:     nowTs + int64(retention/time.Millisecond),
+		FileOpts: FileOpts{
+			FileType:      finfo.FileType,
+			Sha256:        finfo.Sha256,
+			Size:          finfo.Size,
+			MimeType:      finfo.MimeType,
+			AllowedRoles:  finfo.AllowedRoles,
+			EditRoles:     finfo.EditRoles,
+			Display:       finfo.Display,
+			MetadataJson:  finfo.MetadataJson,
+			Description:   finfo.Description,
+			Hidden:        finfo.Hidden,
+			AppConfigJson: finfo.AppConfigJson,
+			CacheControl:  finfo.CacheControl,
+			ETag:          finfo.ETag,
+		},
+	}
+	if finfo.FileType == FileTypeStatic {
+		entry.HasContent = true
+		contentOpts := trashFileOpts(finfo.MimeType)
+		r := bytes.NewReader(content)
+		err = UpdateFileOptsFromReadSeeker(r, contentOpts)
+		if err != nil {
+			return nil, err
+		}
+		err = fs.client.setRawPath(trashContentPath(entry.TrashId), r, contentOpts, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = fs.client.GlobalFSClient().SetJsonPath(trashEntryPath(entry.TrashId), entry, trashFileOpts(MimeTypeJson))
+	if err != nil {
+		return nil, err
+	}
+	err = fs.client.removePath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (fs *DashFSClient) readTrashEntry(trashId string) (*TrashEntry, error) {
+	if _, err := uuid.Parse(trashId); err != nil {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid TrashId '%s'", trashId))
+	}
+	_, content, err := fs.client.fileInfo(trashEntryPath(trashId), nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("TrashId %s not found", trashId))
+	}
+	var entry TrashEntry
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return nil, dasherr.JsonUnmarshalErr("TrashEntry", err)
+	}
+	return &entry, nil
+}
+
+// Restores a trashed file to its original path.  Fails if the retention window has expired,
+// or if a file already exists at the original path.
+func (fs *DashFSClient) RestorePath(trashId string) error {
+	entry, err := fs.readTrashEntry(trashId)
+	if err != nil {
+		return err
+	}
+	if !dashutil.Path(entry.OrigPath).IsUnder(dashutil.Path(fs.rootPath)) {
+		return dasherr.ValidateErr(fmt.Errorf("TrashId %s was not trashed from this client's root", trashId))
+	}
+	if entry.IsExpired() {
+		return dasherr.ErrWithCode(dasherr.ErrCodePathNotFound, fmt.Errorf("TrashId %s has expired", trashId))
+	}
+	existing, _, err := fs.client.fileInfo(entry.OrigPath, nil, false)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return dasherr.ValidateErr(fmt.Errorf("Cannot restore %s, path already exists", entry.OrigPath))
+	}
+	fileOpts := entry.FileOpts
+	if entry.HasContent {
+		_, content, err := fs.client.fileInfo(trashContentPath(trashId), nil, true)
+		if err != nil {
+			return err
+		}
+		r := bytes.NewReader(content)
+		err = UpdateFileOptsFromReadSeeker(r, &fileOpts)
+		if err != nil {
+			return err
+		}
+		err = fs.client.setRawPath(entry.OrigPath, r, &fileOpts, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = fs.client.setRawPath(entry.OrigPath, nil, &fileOpts, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return fs.removeTrashEntry(entry)
+}
+
+func (fs *DashFSClient) removeTrashEntry(entry *TrashEntry) error {
+	if entry.HasContent {
+		err := fs.client.removePath(trashContentPath(entry.TrashId))
+		if err != nil {
+			return err
+		}
+	}
+	return fs.client.removePath(trashEntryPath(entry.TrashId))
+}
+
+func (fs *DashFSClient) listTrashEntries() ([]*TrashEntry, error) {
+	dirInfos, _, err := fs.client.fileInfo(TrashDir, &DirOpts{ShowHidden: true}, false)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*TrashEntry
+	for _, dirInfo := range dirInfos {
+		if dirInfo.FileType != FileTypeDir {
+			continue
+		}
+		entry, err := fs.readTrashEntry(dirInfo.FileName)
+		if err != nil {
+			if dasherr.GetErrCode(err) == dasherr.ErrCodePathNotFound || dasherr.GetErrCode(err) == dasherr.ErrCodeValidation {
+				continue
+			}
+			return nil, err
+		}
+		if !dashutil.Path(entry.OrigPath).IsUnder(dashutil.Path(fs.rootPath)) {
+			continue
+		}
+		rtn = append(rtn, entry)
+	}
+	return rtn, nil
+}
+
+// Lists the (unexpired) trashed files that were removed from this client's root.
+func (fs *DashFSClient) ListTrash() ([]*TrashEntry, error) {
+	entries, err := fs.listTrashEntries()
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*TrashEntry
+	for _, entry := range entries {
+		if entry.IsExpired() {
+			continue
+		}
+		rtn = append(rtn, entry)
+	}
+	return rtn, nil
+}
+
+// Permanently removes expired trash entries (under this client's root).  Returns the number
+// of entries removed.
+func (fs *DashFSClient) PurgeTrash() (int, error) {
+	entries, err := fs.listTrashEntries()
+	if err != nil {
+		return 0, err
+	}
+	numPurged := 0
+	for _, entry := range entries {
+		if !entry.IsExpired() {
+			continue
+		}
+		err = fs.removeTrashEntry(entry)
+		if err != nil {
+			return numPurged, err
+		}
+		numPurged++
+	}
+	return numPurged, nil
+}
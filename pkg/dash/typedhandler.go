@@ -0,0 +1,102 @@
+//go:build go1.18
+// +build go1.18
+
+package dash
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// A typed app handler.  data is decoded from the request data (the first element if the
+// request data is an array), and validated if it implements DataValidator.
+type HandlerFunc[Req any, Resp any] func(req *AppRequest, data Req) (Resp, error)
+
+// A typed pure handler (can also be registered on a LinkRuntime).
+type PureHandlerFunc[Req any, Resp any] func(req Request, data Req) (Resp, error)
+
+// handler params are sent as an array, typed handlers take a single argument
+func decodeTypedData(req *AppRequest, dataPtr interface{}) error {
+	dataJson := strings.TrimSpace(req.rawData.DataJson)
+	if strings.HasPrefix(dataJson, "[") {
+		var params []json.RawMessage
+		err := json.Unmarshal([]byte(dataJson), &params)
+		if err != nil {
+			return dasherr.JsonUnmarshalErr("HandlerData", err)
+		}
+		dataJson = ""
+		if len(params) > 0 {
+			dataJson = string(params[0])
+		}
+	}
+	if dataJson != "" && dataJson != "null" {
+		err := dashutil.UnmarshalJson(dataJson, dataPtr, req.jsonOpts())
+		if err != nil {
+			return dasherr.JsonUnmarshalErr("HandlerData", err)
+		}
+	}
+	// missing data is validated as the zero value (same as reflection handlers)
+	return validateHandlerData(reflect.ValueOf(dataPtr).Elem(), 0)
+}
+
+func setTypedHandler(rti runtimeImplIf, name string, hfn handlerFuncType, typeFn interface{}, opts HandlerOpts) error {
+	if !dashutil.IsPathFragValid(name) {
+		return fmt.Errorf("Invalid handler name")
+	}
+	hinfo, err := makeHandlerInfo(rti, name, typeFn, opts)
+	if err != nil {
+		return err
+	}
+	rti.setHandler(name, handlerType{HandlerFn: hfn, Opts: opts, HandlerInfo: hinfo})
+	return nil
+}
+
+// Registers a typed handler on an app runtime.  Types are only inspected (with reflection)
+// at registration, requests call fn directly.
+// Usage: dash.TypedHandler(app.Runtime(), "add-order", func(req *dash.AppRequest, order Order) (*OrderResult, error) { ... })
+func TypedHandler[Req any, Resp any](apprt *AppRuntimeImpl, name string, fn HandlerFunc[Req, Resp], opts ...*HandlerOpts) {
+	singleOpt := getSingleOpt(opts)
+	hfn := func(req *AppRequest) (interface{}, error) {
+		var data Req
+		err := decodeTypedData(req, &data)
+		if err != nil {
+			return nil, err
+		}
+		return fn(req, data)
+	}
+	err := setTypedHandler(apprt, name, hfn, (func(*AppRequest, Req) (Resp, error))(fn), singleOpt)
+	if err != nil {
+		apprt.addError(fmt.Errorf("Error adding handler '%s': %w", name, err))
+	}
+}
+
+// Registers a typed pure handler on an app runtime or a link runtime.  Other HandlerRegistry
+// implementations are passed fn as a regular (reflection) pure handler.
+// Usage: dash.TypedPureHandler(linkrt, "get-order", func(req dash.Request, orderId string) (*Order, error) { ... })
+func TypedPureHandler[Req any, Resp any](rt HandlerRegistry, name string, fn PureHandlerFunc[Req, Resp], opts ...*HandlerOpts) {
+	singleOpt := getSingleOpt(opts)
+	singleOpt.PureHandler = true
+	hfn := func(req *AppRequest) (interface{}, error) {
+		var data Req
+		err := decodeTypedData(req, &data)
+		if err != nil {
+			return nil, err
+		}
+		return fn(req, data)
+	}
+	rti, ok := rt.(runtimeImplIf)
+	if !ok {
+		// other registries get a reflection handler (errors are reported by the registry)
+		rt.PureHandler(name, (func(Request, Req) (Resp, error))(fn), &singleOpt)
+		return
+	}
+	err := setTypedHandler(rti, name, hfn, (func(Request, Req) (Resp, error))(fn), singleOpt)
+	if err != nil {
+		rti.addError(fmt.Errorf("Error adding handler '%s': %w", name, err))
+	}
+}
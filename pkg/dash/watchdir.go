@@ -0,0 +1,311 @@
+//go:build !dashlite
+// +build !dashlite
+
+package dash
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const mimeTypeOctetStream = "application/octet-stream"
+
+type dirWatcher struct {
+	fs       *DashFSClient
+	path     string
+	localDir string
+	opts     WatchDirOpts
+	watcher  *fsnotify.Watcher
+}
+
+func matchesAnyGlob(patterns []string, relPath string) bool {
+	baseName := filepath.Base(relPath)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, relPath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, baseName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// relPath is slash separated (relative to localDir)
+func (w *dirWatcher) included(relPath string) bool {
+	if matchesAnyGlob(w.opts.Exclude, relPath) {
+		return false
+	}
+	return len(w.opts.Include) == 0 || matchesAnyGlob(w.opts.Include, relPath)
+}
+
+func (w *dirWatcher) dashPath(relPath string) string {
+	return strings.TrimRight(w.path, "/") + "/" + relPath
+}
+
+func (w *dirWatcher) uploadFile(relPath string) error {
+	fileOpts := &FileOpts{}
+	if w.opts.FileOpts != nil {
+		*fileOpts = *w.opts.FileOpts
+	}
+	if fileOpts.MimeType == "" {
+		// strip parameters (e.g. "; charset=utf-8"), not allowed in FileOpts.MimeType
+		extMimeType := strings.TrimSpace(strings.Split(mime.TypeByExtension(filepath.Ext(relPath)), ";")[0])
+		fileOpts.MimeType = dashutil.DefaultString(extMimeType, mimeTypeOctetStream)
+	}
+	return w.fs.SetPathFromFile(w.dashPath(relPath), filepath.Join(w.localDir, filepath.FromSlash(relPath)), fileOpts)
+}
+
+// walks the local directory, adding watches for every directory.  returns the relative paths of included files.
+func (w *dirWatcher) walkLocal(root string) (map[string]string, error) {
+	rtn := make(map[string]string) // relPath => local fileName
+	err := filepath.Walk(root, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return w.watcher.Add(fileName)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(w.localDir, fileName)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if w.included(relPath) {
+			rtn[relPath] = fileName
+		}
+		return nil
+	})
+	return rtn, err
+}
+
+// remote relative paths (files only) under the watched prefix (or under relDir, "" for all)
+func (w *dirWatcher) remoteFiles(relDir string) (map[string]*FileInfo, error) {
+	dirPath := w.path
+	if relDir != "" {
+		dirPath = w.dashPath(relDir)
+	}
+	finfos, err := w.fs.DirInfo(dirPath, &DirOpts{ShowHidden: true, Recursive: true})
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimRight(w.fs.rootPath+w.path, "/") + "/"
+	rtn := make(map[string]*FileInfo)
+	for _, finfo := range finfos {
+		if finfo.FileType != FileTypeStatic || !strings.HasPrefix(finfo.Path, prefix) {
+			continue
+		}
+		rtn[strings.TrimPrefix(finfo.Path, prefix)] = finfo
+	}
+	return rtn, nil
+}
+
+func (w *dirWatcher) logSync(action string, relPath string, err error) {
+	if err != nil {
+		w.fs.client.log("DashFS WatchDir %s error path=%s file=%s err=%v\n", action, dashutil.SimplifyPath(w.dashPath(relPath), nil), relPath, err)
+		return
+	}
+	w.fs.client.log("DashFS WatchDir %s path=%s\n", action, dashutil.SimplifyPath(w.dashPath(relPath), nil))
+}
+
+// initial sync, uploads changed files (by sha256) and removes remote files that do not exist locally
+func (w *dirWatcher) initialSync() error {
+	localFiles, err := w.walkLocal(w.localDir)
+	if err != nil {
+		return err
+	}
+	remote, err := w.remoteFiles("")
+	if err != nil {
+		return err
+	}
+	var relPaths []string
+	for relPath := range localFiles {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		fileOpts := &FileOpts{}
+		fd, err := os.Open(localFiles[relPath])
+		if err == nil {
+			err = UpdateFileOptsFromReadSeeker(fd, fileOpts)
+			fd.Close()
+		}
+		if err == nil && remote[relPath] != nil && remote[relPath].Sha256 == fileOpts.Sha256 {
+			continue
+		}
+		err = w.uploadFile(relPath)
+		if err != nil {
+			return fmt.Errorf("WatchDir initial sync file=%s: %w", relPath, err)
+		}
+		w.logSync("upload", relPath, nil)
+	}
+	if w.opts.NoDelete {
+		return nil
+	}
+	for relPath := range remote {
+		if _, ok := localFiles[relPath]; ok || !w.included(relPath) {
+			continue
+		}
+		err = w.fs.RemovePath(w.dashPath(relPath))
+		w.logSync("remove", relPath, err)
+	}
+	return nil
+}
+
+// syncs a changed relative path (file created/written, renamed, removed, or a new directory)
+func (w *dirWatcher) syncPath(relPath string) {
+	fileName := filepath.Join(w.localDir, filepath.FromSlash(relPath))
+	info, err := os.Stat(fileName)
+	if err == nil && info.IsDir() {
+		// new (or renamed) directory, watch it and upload its contents
+		localFiles, err := w.walkLocal(fileName)
+		if err != nil {
+			w.logSync("watch", relPath, err)
+			return
+		}
+		for fileRelPath := range localFiles {
+			w.logSync("upload", fileRelPath, w.uploadFile(fileRelPath))
+		}
+		return
+	}
+	if err == nil {
+		if !info.Mode().IsRegular() || !w.included(relPath) {
+			return
+		}
+		w.logSync("upload", relPath, w.uploadFile(relPath))
+		return
+	}
+	if !os.IsNotExist(err) || w.opts.NoDelete {
+		return
+	}
+	// removed (or renamed away), may have been a file or a directory
+	remoteInfo, err := w.fs.FileInfo(w.dashPath(relPath))
+	if err != nil || remoteInfo == nil {
+		return
+	}
+	if remoteInfo.FileType == FileTypeDir {
+		// only the included files are removed (excluded files were not uploaded from this directory)
+		remote, err := w.remoteFiles(relPath)
+		if err != nil {
+			w.logSync("remove-dir", relPath, err)
+			return
+		}
+		var fileRelPaths []string
+		for fileRelPath := range remote {
+			if w.included(fileRelPath) {
+				fileRelPaths = append(fileRelPaths, fileRelPath)
+			}
+		}
+		sort.Strings(fileRelPaths)
+		for _, fileRelPath := range fileRelPaths {
+			w.logSync("remove", fileRelPath, w.fs.RemovePath(w.dashPath(fileRelPath)))
+		}
+		return
+	}
+	if !w.included(relPath) {
+		return
+	}
+	err = w.fs.RemovePath(w.dashPath(relPath))
+	w.logSync("remove", relPath, err)
+}
+
+func (w *dirWatcher) run() {
+	defer w.watcher.Close()
+	dirty := make(map[string]bool)
+	var timer *time.Timer
+	for {
+		var timerCh <-chan time.Time
+		if timer != nil {
+			timerCh = timer.C
+		}
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			relPath, err := filepath.Rel(w.localDir, event.Name)
+			if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+				continue
+			}
+			dirty[filepath.ToSlash(relPath)] = true
+			if timer == nil {
+				timer = time.NewTimer(w.opts.ThrottleTime)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			// e.g. event queue overflow, keep watching (later events are still delivered)
+			w.fs.client.log("DashFS WatchDir Error path=%s dir=%s err=%v\n", dashutil.SimplifyPath(w.path, nil), w.localDir, err)
+
+		case <-timerCh:
+			timer = nil
+			var relPaths []string
+			for relPath := range dirty {
+				relPaths = append(relPaths, relPath)
+			}
+			dirty = make(map[string]bool)
+			sort.Strings(relPaths)
+			for _, relPath := range relPaths {
+				w.syncPath(relPath)
+			}
+
+		case <-w.opts.ShutdownCh:
+			return
+		}
+	}
+}
+
+// Recursively mirrors localDir into the Dashborg FS directory path.  First does a full sync
+// (uploads new and changed files, removes remote files that no longer exist locally unless
+// NoDelete is set).  If that fails an error is returned and the directory is *not* watched.
+// Then watches the directory (and new sub-directories) with fsnotify, batching creates,
+// writes, renames, and deletes every ThrottleTime.  opts may be nil.
+// Usage: err := fs.WatchDir("/static", "./dist", &dash.WatchDirOpts{Exclude: []string{"*.map", ".*"}})
+func (fs *DashFSClient) WatchDir(path string, localDir string, opts *WatchDirOpts) error {
+	err := dashutil.Path(path).Validate()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return dasherr.ValidateErr(fmt.Errorf("WatchDir localDir '%s' is not a directory", localDir))
+	}
+	w := &dirWatcher{fs: fs, path: path, localDir: filepath.Clean(localDir)}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.ThrottleTime <= 0 {
+		w.opts.ThrottleTime = time.Second
+	}
+	w.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = w.initialSync()
+	if err != nil {
+		w.watcher.Close()
+		return err
+	}
+	go w.run()
+	return nil
+}
@@ -0,0 +1,88 @@
+//go:build !dashlite
+// +build !dashlite
+
+package dash
+
+import (
+	"log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+// First calls SetPathFromFile.  If that that fails, an error is returned and the file will *not* be watched
+// (watching only starts if this function returns nil).  The given file will be watched using fsnotify.
+// Every time fsnotify detects a file modification, the file will be be re-uploaded using SetPathFromFile.
+// watchOpts may be nil, which will use default settings (Throttle time of 1 second, no shutdown channel).
+// This is function is recommended for use in development environments.
+func (fs *DashFSClient) WatchFile(path string, fileName string, fileOpts *FileOpts, watchOpts *WatchOpts) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if fileOpts == nil {
+		fileOpts = &FileOpts{}
+	}
+	if watchOpts == nil {
+		watchOpts = &WatchOpts{ThrottleTime: time.Second}
+	}
+	err = fs.SetPathFromFile(path, fileName, fileOpts)
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(fileName)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var needsRun bool
+		lastRun := time.Now()
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			var timerCh <-chan time.Time
+			if timer != nil {
+				timerCh = timer.C
+			}
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Write || event.Op == fsnotify.Create {
+					dur := time.Since(lastRun)
+					if dur < watchOpts.ThrottleTime {
+						needsRun = true
+						if timer == nil {
+							timer = time.NewTimer(watchOpts.ThrottleTime - dur)
+						}
+					} else {
+						needsRun = false
+						fs.runWatchedSetPath(path, fileName, fileOpts)
+						lastRun = time.Now()
+					}
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("DashFS Watch Error path=%s file=%s err=%v\n", dashutil.SimplifyPath(path, nil), fileName, err)
+				return
+
+			case <-timerCh:
+				if needsRun {
+					timer = nil
+					needsRun = false
+					fs.runWatchedSetPath(path, fileName, fileOpts)
+					lastRun = time.Now()
+				}
+
+			case <-watchOpts.ShutdownCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
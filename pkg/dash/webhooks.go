@@ -0,0 +1,209 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
+	"github.com/sawka/dashborg-go-sdk/pkg/dashutil"
+)
+
+const (
+	WebhookSignatureHeader = "X-Dashborg-Signature" // "sha256=" + hex HMAC-SHA256 of the body (if WebhookOpts.Secret is set)
+	WebhookEventHeader     = "X-Dashborg-Event"
+
+	defaultWebhookTimeout = 10 * time.Second
+	webhookQueueSize      = 64
+	webhookMaxAttempts    = 3
+)
+
+// Options for AddWebhook.  All fields are optional.
+type WebhookOpts struct {
+	Events  []string          // event types to send (AppEvent* and ConnEvent* constants), defaults to all
+	Headers map[string]string // extra request headers (e.g. Authorization)
+	Secret  string            // signs each body with HMAC-SHA256 (see WebhookSignatureHeader)
+	Timeout time.Duration     // per attempt, defaults to 10s
+}
+
+// JSON body POSTed by a webhook.  App fields are set for app events, ConnId and Error for
+// connection events.
+type WebhookPayload struct {
+	Event     string           `json:"event"`
+	Ts        int64            `json:"ts"`
+	AccId     string           `json:"accid"`
+	ZoneName  string           `json:"zonename"`
+	ProcName  string           `json:"procname"`
+	ProcRunId string           `json:"procrunid"`
+	AppName   string           `json:"appname,omitempty"`
+	AppLink   string           `json:"applink,omitempty"`
+	Connected bool             `json:"connected,omitempty"`
+	BuildInfo *BuildInfoOption `json:"buildinfo,omitempty"`
+	ConnId    string           `json:"connid,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+type webhook struct {
+	pc         *DashCloudClient
+	url        string
+	opts       WebhookOpts
+	events     map[string]bool
+	httpClient *http.Client
+	lock       *sync.Mutex
+	queue      chan WebhookPayload
+	closed     bool
+}
+
+// Registers an outbound webhook.  A JSON WebhookPayload is POSTed to webhookUrl when this
+// client writes or removes an app, and when its connection state changes (see OnConnEvent).
+// Payloads are sent in order on a background goroutine, failed posts (network errors and 5xx
+// responses) are retried up to 3 times, and errors are logged.  Returns a function that
+// removes the webhook.
+// Usage: remove, err := client.AddWebhook("https://hooks.example.com/deploys", &dash.WebhookOpts{Events: []string{dash.AppEventWrite}, Secret: secret})
+func (pc *DashCloudClient) AddWebhook(webhookUrl string, opts *WebhookOpts) (func(), error) {
+	if opts == nil {
+		opts = &WebhookOpts{}
+	}
+	urlVal, err := url.Parse(webhookUrl)
+	if err != nil || (urlVal.Scheme != "http" && urlVal.Scheme != "https") || urlVal.Host == "" {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid webhook url '%s' (must be an http or https url)", webhookUrl))
+	}
+	if opts.Timeout < 0 {
+		return nil, dasherr.ValidateErr(fmt.Errorf("Invalid WebhookOpts Timeout (negative)"))
+	}
+	wh := &webhook{
+		pc:     pc,
+		url:    webhookUrl,
+		opts:   *opts,
+		lock:   &sync.Mutex{},
+		queue:  make(chan WebhookPayload, webhookQueueSize),
+		events: make(map[string]bool),
+	}
+	if wh.opts.Timeout == 0 {
+		wh.opts.Timeout = defaultWebhookTimeout
+	}
+	wh.httpClient = &http.Client{Timeout: wh.opts.Timeout}
+	for _, event := range opts.Events {
+		wh.events[event] = true
+	}
+	go wh.run()
+	removeAppFn := pc.OnAppEvent(func(e AppEvent) {
+		wh.enqueue(WebhookPayload{
+			Event:     e.Type,
+			Ts:        dashutil.DashTime(e.Ts),
+			AppName:   e.AppName,
+			AppLink:   e.AppLink,
+			Connected: e.Connected,
+			BuildInfo: e.BuildInfo,
+		})
+	})
+	removeConnFn := pc.OnConnEvent(func(e ConnEvent) {
+		payload := WebhookPayload{Event: e.Type, Ts: dashutil.DashTime(e.Ts), ConnId: e.ConnId}
+		if e.Err != nil {
+			payload.Error = e.Err.Error()
+		}
+		wh.enqueue(payload)
+	})
+	return func() {
+		removeAppFn()
+		removeConnFn()
+		wh.close()
+	}, nil
+}
+
+func (wh *webhook) enqueue(payload WebhookPayload) {
+	if len(wh.events) > 0 && !wh.events[payload.Event] {
+		return
+	}
+	cfg := wh.pc.Config
+	payload.AccId = cfg.AccId
+	payload.ZoneName = cfg.ZoneName
+	payload.ProcName = cfg.ProcName
+	payload.ProcRunId = wh.pc.ProcRunId
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	if wh.closed {
+		return
+	}
+	select {
+	case wh.queue <- payload:
+	default:
+		wh.pc.log("DashborgCloudClient webhook %s queue full, dropping %s event\n", wh.url, payload.Event)
+	}
+}
+
+func (wh *webhook) close() {
+	wh.lock.Lock()
+	defer wh.lock.Unlock()
+	if wh.closed {
+		return
+	}
+	wh.closed = true
+	close(wh.queue)
+}
+
+func (wh *webhook) run() {
+	for payload := range wh.queue {
+		var err error
+		for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+			var retry bool
+			retry, err = wh.post(payload)
+			if err == nil || !retry {
+				break
+			}
+			if attempt < webhookMaxAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			wh.pc.log("DashborgCloudClient webhook %s error sending %s event: %v\n", wh.url, payload.Event, err)
+		}
+	}
+}
+
+// returns (shouldRetry, error)
+func (wh *webhook) post(payload WebhookPayload) (bool, error) {
+	body, err := dashutil.MarshalJson(payload)
+	if err != nil {
+		return false, dasherr.JsonMarshalErr("WebhookPayload", err)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), wh.opts.Timeout)
+	defer cancelFn()
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dashborg-go-sdk/"+ClientVersion)
+	req.Header.Set(WebhookEventHeader, payload.Event)
+	for key, val := range wh.opts.Headers {
+		req.Header.Set(key, val)
+	}
+	if wh.opts.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(wh.opts.Secret))
+		mac.Write([]byte(body))
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
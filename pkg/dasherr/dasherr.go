@@ -38,6 +38,8 @@ const (
 	ErrCodeNoApp        ErrCode = "NOAPP"
 	ErrCodeProtocol     ErrCode = "PROTOCOL"
 	ErrCodeInitErr      ErrCode = "INITERR"
+	ErrCodeVersion      ErrCode = "VERSION"
+	ErrCodeConflict     ErrCode = "CONFLICT"
 )
 
 type DashErr struct {
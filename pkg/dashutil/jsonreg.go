@@ -0,0 +1,514 @@
+package dashutil
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Converts a value of a registered type to a JSON-marshalable replacement (e.g. a formatted
+// string for a time or money type).
+type JsonMarshalFn func(val interface{}) (interface{}, error)
+
+// Converts raw JSON to a value of a registered type.
+type JsonUnmarshalFn func(data []byte) (interface{}, error)
+
+type jsonTypeFns struct {
+	marshalFn   JsonMarshalFn
+	unmarshalFn JsonUnmarshalFn
+}
+
+var jsonRegLock = &sync.Mutex{}
+var jsonReg = make(map[reflect.Type]jsonTypeFns)
+var jsonContainsCache = make(map[reflect.Type]bool)
+var jsonMarshalFnCache = make(map[reflect.Type]JsonMarshalFn)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+const maxJsonConvertDepth = 100
+
+// Registers custom JSON marshal/unmarshal functions for a type.  Used by all SDK serialization
+// (MarshalJson, UnmarshalJson, and the dash package's SetData, SetJsonPath, handler return
+// values, and handler argument binding).  marshalFn is applied to values of the type anywhere
+// in the marshaled data (struct fields, maps, slices).  unmarshalFn is applied when the
+// unmarshal target is the type itself (e.g. a handler argument), nested values should
+// implement json.Unmarshaler.  Either function may be nil.  If typ is an interface type,
+// marshalFn is applied to all values implementing it (exact type registrations take
+// precedence) and unmarshalFn is not used.  Register types at startup, before any requests
+// are processed.
+func RegisterJsonType(typ reflect.Type, marshalFn JsonMarshalFn, unmarshalFn JsonUnmarshalFn) {
+	jsonRegLock.Lock()
+	defer jsonRegLock.Unlock()
+	if marshalFn == nil && unmarshalFn == nil {
+		delete(jsonReg, typ)
+	} else {
+		jsonReg[typ] = jsonTypeFns{marshalFn: marshalFn, unmarshalFn: unmarshalFn}
+	}
+	jsonContainsCache = make(map[reflect.Type]bool)
+	jsonMarshalFnCache = make(map[reflect.Type]JsonMarshalFn)
+}
+
+func getJsonTypeFns(typ reflect.Type) (jsonTypeFns, bool) {
+	jsonRegLock.Lock()
+	defer jsonRegLock.Unlock()
+	fns, ok := jsonReg[typ]
+	return fns, ok
+}
+
+func getJsonMarshalFn(typ reflect.Type) JsonMarshalFn {
+	jsonRegLock.Lock()
+	defer jsonRegLock.Unlock()
+	return getJsonMarshalFnNoLock(typ)
+}
+
+func getJsonMarshalFnNoLock(typ reflect.Type) JsonMarshalFn {
+	if fn, ok := jsonMarshalFnCache[typ]; ok {
+		return fn
+	}
+	var rtn JsonMarshalFn
+	if fns, ok := jsonReg[typ]; ok && fns.marshalFn != nil {
+		rtn = fns.marshalFn
+	} else if typ.Kind() != reflect.Interface {
+		for regType, fns := range jsonReg {
+			if regType.Kind() == reflect.Interface && fns.marshalFn != nil && typ.Implements(regType) {
+				rtn = fns.marshalFn
+				break
+			}
+		}
+	}
+	jsonMarshalFnCache[typ] = rtn
+	return rtn
+}
+
+// returns true if values of typ can contain a value with a registered marshalFn
+func containsRegisteredType(typ reflect.Type) bool {
+	jsonRegLock.Lock()
+	defer jsonRegLock.Unlock()
+	if len(jsonReg) == 0 {
+		return false
+	}
+	rtn, _ := containsRegisteredTypeNoLock(typ, make(map[reflect.Type]int))
+	return rtn
+}
+
+const noCycleDepth = 1 << 30
+
+// visiting maps the types being checked to their depth.  also returns the depth of the
+// shallowest visiting type that was reached (a cycle), noCycleDepth if none.  false results
+// that depend on a type still being checked (at a shallower depth) are not cached, since
+// that type may yet turn out to contain a registered type.
+func containsRegisteredTypeNoLock(typ reflect.Type, visiting map[reflect.Type]int) (bool, int) {
+	if rtn, ok := jsonContainsCache[typ]; ok {
+		return rtn, noCycleDepth
+	}
+	if depth, ok := visiting[typ]; ok {
+		return false, depth
+	}
+	depth := len(visiting)
+	visiting[typ] = depth
+	rtn := false
+	cycleDepth := noCycleDepth
+	checkElem := func(elemType reflect.Type) bool {
+		elemRtn, elemCycleDepth := containsRegisteredTypeNoLock(elemType, visiting)
+		if elemCycleDepth < cycleDepth {
+			cycleDepth = elemCycleDepth
+		}
+		return elemRtn
+	}
+	if getJsonMarshalFnNoLock(typ) != nil {
+		rtn = true
+	} else if isCustomJsonType(typ) {
+		rtn = false
+	} else {
+		switch typ.Kind() {
+		case reflect.Interface:
+			rtn = true // dynamic, must be checked at runtime
+
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			rtn = checkElem(typ.Elem())
+
+		case reflect.Struct:
+			for i := 0; i < typ.NumField(); i++ {
+				if checkElem(typ.Field(i).Type) {
+					rtn = true
+					break
+				}
+			}
+		}
+	}
+	delete(visiting, typ)
+	if rtn || cycleDepth >= depth {
+		jsonContainsCache[typ] = rtn
+		cycleDepth = noCycleDepth
+	}
+	return rtn, cycleDepth
+}
+
+// types that encoding/json marshals with their own methods
+func isCustomJsonType(typ reflect.Type) bool {
+	return typ.Implements(jsonMarshalerType) || typ.Implements(textMarshalerType)
+}
+
+// Replaces values of registered types (see RegisterJsonType) with their marshalFn replacements,
+// and applies the JsonOpts marshaling options.  Returns val unchanged when no types are
+// registered and no marshaling options are set.
+func ConvertForJson(val interface{}, opts JsonOpts) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(val)
+	if !opts.hasMarshalOpts() && !containsRegisteredType(v.Type()) {
+		return val, nil
+	}
+	return convertValueForJson(v, opts, 0)
+}
+
+func convertValueForJson(v reflect.Value, opts JsonOpts, depth int) (interface{}, error) {
+	if depth > maxJsonConvertDepth {
+		return nil, fmt.Errorf("Cannot marshal JSON, value is nested too deeply (possible cycle)")
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	typ := v.Type()
+	if marshalFn := getJsonMarshalFn(typ); marshalFn != nil {
+		return marshalFn(v.Interface())
+	}
+	if rtn, ok := convertTemporalForJson(v, opts); ok {
+		return rtn, nil
+	}
+	if opts.hasMarshalOpts() {
+		if isCustomJsonType(typ) {
+			return v.Interface(), nil
+		}
+	} else if !containsRegisteredType(typ) {
+		return v.Interface(), nil
+	}
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return convertValueForJson(v.Elem(), opts, depth+1)
+
+	case reflect.Slice, reflect.Array:
+		if typ.Kind() == reflect.Slice && v.IsNil() {
+			if opts.NilSlicesAsEmpty {
+				return []interface{}{}, nil
+			}
+			return nil, nil
+		}
+		if typ.Elem().Kind() == reflect.Uint8 && !containsRegisteredType(typ.Elem()) {
+			return v.Interface(), nil // []byte is encoded as a base64 string
+		}
+		rtn := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := convertValueForJson(v.Index(i), opts, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			rtn[i] = elem
+		}
+		return rtn, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			if opts.NilMapsAsEmpty {
+				return map[string]interface{}{}, nil
+			}
+			return nil, nil
+		}
+		rtn := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			keyStr, err := jsonMapKey(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			elem, err := convertValueForJson(iter.Value(), opts, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			rtn[keyStr] = elem
+		}
+		return rtn, nil
+
+	case reflect.Struct:
+		rtn := make(map[string]interface{})
+		err := convertStructForJson(v, rtn, opts, depth)
+		if err != nil {
+			return nil, err
+		}
+		return rtn, nil
+	}
+	return v.Interface(), nil
+}
+
+func jsonMapKey(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if key.Type().Implements(textMarshalerType) {
+		keyBytes, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(keyBytes), nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprintf("%d", key.Interface()), nil
+	}
+	return "", fmt.Errorf("Cannot marshal JSON map key type %v", key.Type())
+}
+
+// a struct field as encoded by encoding/json (promoted fields have multi-element indexes)
+type jsonField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	asString  bool
+	typ       reflect.Type // embedded struct type (only while collecting fields)
+}
+
+var jsonFieldsCache = make(map[reflect.Type][]jsonField) // guarded by jsonRegLock
+
+func jsonStructFields(typ reflect.Type) []jsonField {
+	jsonRegLock.Lock()
+	fields, ok := jsonFieldsCache[typ]
+	jsonRegLock.Unlock()
+	if ok {
+		return fields
+	}
+	fields = computeJsonStructFields(typ)
+	jsonRegLock.Lock()
+	jsonFieldsCache[typ] = fields
+	jsonRegLock.Unlock()
+	return fields
+}
+
+// follows encoding/json field rules: exported fields, json tag names, "-", and promoted
+// fields from embedded structs without a tag name.  when several fields have the same name
+// the shallowest one wins, then the tagged one, otherwise (ambiguous) all are dropped.
+func computeJsonStructFields(typ reflect.Type) []jsonField {
+	var fields []jsonField
+	var current []jsonField
+	next := []jsonField{{typ: typ}}
+	count := make(map[reflect.Type]int)
+	nextCount := make(map[reflect.Type]int)
+	visited := make(map[reflect.Type]bool)
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					embedType := sf.Type
+					if embedType.Kind() == reflect.Ptr {
+						embedType = embedType.Elem()
+					}
+					if sf.PkgPath != "" && embedType.Kind() != reflect.Struct {
+						continue // unexported non-struct embedded field
+					}
+				} else if sf.PkgPath != "" {
+					continue // unexported
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				tagName, tagOpts := tag, ""
+				if commaIdx := strings.Index(tag, ","); commaIdx != -1 {
+					tagName, tagOpts = tag[:commaIdx], tag[commaIdx+1:]
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if tagName != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					name := tagName
+					if name == "" {
+						name = sf.Name
+					}
+					field := jsonField{
+						name:      name,
+						index:     index,
+						tagged:    tagName != "",
+						omitEmpty: strings.Contains(","+tagOpts+",", ",omitempty,"),
+						asString:  strings.Contains(","+tagOpts+",", ",string,"),
+					}
+					fields = append(fields, field)
+					if count[f.typ] > 1 {
+						// struct embedded more than once at this depth, the duplicate makes the field ambiguous
+						fields = append(fields, field)
+					}
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, jsonField{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+	sort.Slice(fields, func(i int, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		if fields[i].tagged != fields[j].tagged {
+			return fields[i].tagged
+		}
+		return jsonIndexLess(fields[i].index, fields[j].index)
+	})
+	rtn := make([]jsonField, 0, len(fields))
+	for i := 0; i < len(fields); {
+		numSame := 1
+		for i+numSame < len(fields) && fields[i+numSame].name == fields[i].name {
+			numSame++
+		}
+		// sorted by depth then tagged, so the first field dominates unless the second ties it
+		if numSame == 1 || len(fields[i].index) != len(fields[i+1].index) || fields[i].tagged != fields[i+1].tagged {
+			rtn = append(rtn, fields[i])
+		}
+		i += numSame
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return jsonIndexLess(rtn[i].index, rtn[j].index)
+	})
+	return rtn
+}
+
+// A struct field as encoded by encoding/json (see JsonStructFields).
+type JsonStructField struct {
+	Name  string
+	Index []int // for reflect.Type.FieldByIndex, promoted fields have multi-element indexes
+}
+
+// Returns the fields that encoding/json encodes for a struct type (in encoding order),
+// including fields promoted from embedded structs, using its naming and conflict rules.
+func JsonStructFields(typ reflect.Type) []JsonStructField {
+	fields := jsonStructFields(typ)
+	rtn := make([]JsonStructField, len(fields))
+	for idx, field := range fields {
+		rtn[idx] = JsonStructField{Name: field.name, Index: field.index}
+	}
+	return rtn
+}
+
+// Returns the field of struct value v with the given index (see JsonStructFields).  Returns
+// false if the field is promoted through a nil embedded pointer (encoding/json omits it).
+func JsonFieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	return jsonFieldByIndex(v, index)
+}
+
+func jsonIndexLess(idx1 []int, idx2 []int) bool {
+	for i := 0; i < len(idx1) && i < len(idx2); i++ {
+		if idx1[i] != idx2[i] {
+			return idx1[i] < idx2[i]
+		}
+	}
+	return len(idx1) < len(idx2)
+}
+
+// returns false if the field is promoted through a nil embedded pointer
+func jsonFieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, fieldIdx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIdx)
+	}
+	return v, true
+}
+
+func convertStructForJson(v reflect.Value, rtn map[string]interface{}, opts JsonOpts, depth int) error {
+	for _, field := range jsonStructFields(v.Type()) {
+		fieldV, ok := jsonFieldByIndex(v, field.index)
+		if !ok {
+			continue
+		}
+		if (opts.OmitEmpty || field.omitEmpty) && isEmptyJsonValue(fieldV) {
+			continue
+		}
+		if field.asString {
+			// let encoding/json handle the ",string" option for scalar fields
+			strVal, err := json.Marshal(fieldV.Interface())
+			if err == nil && fieldV.Kind() != reflect.String {
+				rtn[field.name] = string(strVal)
+				continue
+			}
+		}
+		fieldVal, err := convertValueForJson(fieldV, opts, depth+1)
+		if err != nil {
+			return err
+		}
+		rtn[field.name] = fieldVal
+	}
+	return nil
+}
+
+func isEmptyJsonValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// if val is a pointer to a registered type with an unmarshalFn, unmarshals data with it.
+// returns true if the registered function was used.
+func unmarshalRegisteredType(data string, val interface{}) (bool, error) {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return false, nil
+	}
+	fns, ok := getJsonTypeFns(v.Type().Elem())
+	if !ok || fns.unmarshalFn == nil || v.Type().Elem().Kind() == reflect.Interface {
+		return false, nil
+	}
+	rtn, err := fns.unmarshalFn([]byte(data))
+	if err != nil {
+		return true, err
+	}
+	rtnV := reflect.ValueOf(rtn)
+	if !rtnV.IsValid() {
+		v.Elem().Set(reflect.Zero(v.Type().Elem()))
+		return true, nil
+	}
+	if !rtnV.Type().AssignableTo(v.Type().Elem()) {
+		return true, fmt.Errorf("Registered JSON unmarshal function for %v returned %v", v.Type().Elem(), rtnV.Type())
+	}
+	v.Elem().Set(rtnV)
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package dashutil
+
+import (
+	"reflect"
+	"time"
+)
+
+// JsonOpts.TimeFormat values (how time.Time values are encoded).  The default ("") uses
+// encoding/json (RFC3339 with nanoseconds).  Zero times are encoded as null for all other formats.
+const (
+	JsonTimeRFC3339   = "rfc3339"   // "2006-01-02T15:04:05Z07:00"
+	JsonTimeRFC3339Ms = "rfc3339ms" // "2006-01-02T15:04:05.000Z07:00"
+	JsonTimeEpochMs   = "epochms"   // milliseconds since the epoch (number)
+	JsonTimeEpochSec  = "epochsec"  // seconds since the epoch (number)
+)
+
+// JsonOpts.DurationFormat values (how time.Duration values are encoded).  The default ("")
+// uses encoding/json (integer nanoseconds).
+const (
+	JsonDurationSeconds = "seconds" // seconds (number, may be fractional)
+	JsonDurationMs      = "ms"      // milliseconds (integer number)
+	JsonDurationString  = "string"  // Go duration string, e.g. "1h2m3.5s"
+)
+
+const rfc3339MsLayout = "2006-01-02T15:04:05.000Z07:00"
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func IsJsonTimeFormatValid(format string) bool {
+	switch format {
+	case "", JsonTimeRFC3339, JsonTimeRFC3339Ms, JsonTimeEpochMs, JsonTimeEpochSec:
+		return true
+	}
+	return false
+}
+
+func IsJsonDurationFormatValid(format string) bool {
+	switch format {
+	case "", JsonDurationSeconds, JsonDurationMs, JsonDurationString:
+		return true
+	}
+	return false
+}
+
+// converts time.Time and time.Duration values using opts.TimeFormat / opts.DurationFormat.
+// returns false if v is not a temporal value (or its format is the default).
+func convertTemporalForJson(v reflect.Value, opts JsonOpts) (interface{}, bool) {
+	if v.Kind() == reflect.Ptr && (v.Type().Elem() == timeType || v.Type().Elem() == durationType) {
+		if v.IsNil() {
+			return nil, opts.TimeFormat != "" || opts.DurationFormat != ""
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type() == timeType && opts.TimeFormat != "":
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return nil, true
+		}
+		switch opts.TimeFormat {
+		case JsonTimeRFC3339:
+			return t.Format(time.RFC3339), true
+		case JsonTimeRFC3339Ms:
+			return t.Format(rfc3339MsLayout), true
+		case JsonTimeEpochMs:
+			return DashTime(t), true
+		case JsonTimeEpochSec:
+			return t.Unix(), true
+		}
+
+	case v.Type() == durationType && opts.DurationFormat != "":
+		d := time.Duration(v.Int())
+		switch opts.DurationFormat {
+		case JsonDurationSeconds:
+			return d.Seconds(), true
+		case JsonDurationMs:
+			return d.Milliseconds(), true
+		case JsonDurationString:
+			return d.String(), true
+		}
+	}
+	return nil, false
+}
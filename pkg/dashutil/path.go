@@ -2,8 +2,11 @@ package dashutil
 
 import (
 	"fmt"
+	gopath "path"
 	"regexp"
 	"strings"
+
+	"github.com/sawka/dashborg-go-sdk/pkg/dasherr"
 )
 
 // keep in sync with dash consts
@@ -215,3 +218,76 @@ func GetPathDepth(fullPath string) int {
 	}
 	return pathDepth
 }
+
+// A Dashborg FS path (e.g. "/_/apps/myapp/data.json").  May have a namespace ("/@app/data.json")
+// but not a path-fragment.  Path validation errors are always dasherr validation errors.
+type Path string
+
+func (p Path) String() string {
+	return string(p)
+}
+
+// Returns a validation error if the path is empty, does not begin with '/', is too long,
+// contains invalid characters, or has an empty part ("//").
+func (p Path) Validate() error {
+	err := ValidateFullPath(string(p), false)
+	if err != nil {
+		return dasherr.ValidateErr(err)
+	}
+	return nil
+}
+
+// Like Validate, but allows a path-fragment (e.g. "/_/apps/myapp/_/runtime:handler").
+func (p Path) ValidateWithFrag() error {
+	err := ValidateFullPath(string(p), true)
+	if err != nil {
+		return dasherr.ValidateErr(err)
+	}
+	return nil
+}
+
+// Returns the shortest equivalent path (like path.Clean).  Removes empty parts, "." and ".."
+// elements, and any trailing '/'.  A path that does not begin with '/' is made absolute.
+func (p Path) Clean() Path {
+	return Path(gopath.Clean("/" + string(p)))
+}
+
+// Joins elements onto the path (separated by '/') and cleans the result.
+func (p Path) Join(elems ...string) Path {
+	parts := append([]string{"/", string(p)}, elems...)
+	return Path(gopath.Join(parts...))
+}
+
+// Returns the parent directory of the path ("/" for the root and top-level paths).
+func (p Path) Dir() Path {
+	return Path(gopath.Dir(string(p.Clean())))
+}
+
+// Returns the last element of the path.
+func (p Path) Base() string {
+	return gopath.Base(string(p.Clean()))
+}
+
+// Returns true if the path is equal to dir or is under dir.
+func (p Path) IsUnder(dir Path) bool {
+	cleanP := p.Clean()
+	cleanDir := dir.Clean()
+	if cleanDir == "/" || cleanP == cleanDir {
+		return true
+	}
+	return strings.HasPrefix(string(cleanP), string(cleanDir)+"/")
+}
+
+// Validates a client relative path (a path passed to DashFSClient methods) and returns
+// the full path under rootPath.
+func FullPathFromRoot(rootPath string, relPath string) (string, error) {
+	if relPath == "" || relPath[0] != '/' {
+		return "", dasherr.ValidateErr(fmt.Errorf("Path must begin with '/'"))
+	}
+	fullPath := rootPath + relPath
+	err := Path(fullPath).Validate()
+	if err != nil {
+		return "", err
+	}
+	return fullPath, nil
+}
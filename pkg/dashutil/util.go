@@ -46,10 +46,19 @@ func GoTime(ts int64) time.Time {
 
 // Marshal json helper
 func MarshalJson(val interface{}) (string, error) {
+	return MarshalJsonOpts(val, JsonOpts{})
+}
+
+// Marshal json helper that applies the JsonOpts marshaling options.
+func MarshalJsonOpts(val interface{}, opts JsonOpts) (string, error) {
+	val, err := ConvertForJson(val, opts)
+	if err != nil {
+		return "", err
+	}
 	var jsonBuf bytes.Buffer
 	enc := json.NewEncoder(&jsonBuf)
 	enc.SetEscapeHTML(false)
-	err := enc.Encode(val)
+	err = enc.Encode(val)
 	if err != nil {
 		return "", err
 	}
@@ -58,11 +67,15 @@ func MarshalJson(val interface{}) (string, error) {
 
 // Marshal json helper (adding indentation)
 func MarshalJsonIndent(val interface{}) (string, error) {
+	val, err := ConvertForJson(val, JsonOpts{})
+	if err != nil {
+		return "", err
+	}
 	var jsonBuf bytes.Buffer
 	enc := json.NewEncoder(&jsonBuf)
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "  ")
-	err := enc.Encode(val)
+	err = enc.Encode(val)
 	if err != nil {
 		return "", err
 	}
@@ -72,16 +85,58 @@ func MarshalJsonIndent(val interface{}) (string, error) {
 // Marshal json helper, no error returned (no panic either) useful
 // when a structure should never have a json encoding error.
 func MarshalJsonNoError(val interface{}) string {
+	val, err := ConvertForJson(val, JsonOpts{})
+	if err != nil {
+		return "\"error marshaling json\""
+	}
 	var jsonBuf bytes.Buffer
 	enc := json.NewEncoder(&jsonBuf)
 	enc.SetEscapeHTML(false)
-	err := enc.Encode(val)
+	err = enc.Encode(val)
 	if err != nil {
 		return "\"error marshaling json\""
 	}
 	return jsonBuf.String()
 }
 
+// Options for encoding (see MarshalJsonOpts) and decoding (see UnmarshalJson) JSON data.
+type JsonOpts struct {
+	// Decode numbers into interface{} values as json.Number instead of float64.  Preserves
+	// large int64 ids and decimals that cannot be represented exactly as a float64.
+	UseNumber bool
+
+	NilSlicesAsEmpty bool // encode nil slices as [] instead of null
+	NilMapsAsEmpty   bool // encode nil maps as {} instead of null
+	OmitEmpty        bool // omit zero-valued struct fields (as if every field was tagged ",omitempty")
+
+	TimeFormat     string // encoding for time.Time values (JsonTimeRFC3339, JsonTimeEpochMs, etc.), "" for the encoding/json default
+	DurationFormat string // encoding for time.Duration values (JsonDurationSeconds, JsonDurationString, etc.), "" for integer nanoseconds
+}
+
+func (opts JsonOpts) hasMarshalOpts() bool {
+	return opts.NilSlicesAsEmpty || opts.NilMapsAsEmpty || opts.OmitEmpty || opts.TimeFormat != "" || opts.DurationFormat != ""
+}
+
+// Unmarshal json helper (like json.Unmarshal) that applies JsonOpts.  If val is a pointer
+// to a type registered with RegisterJsonType, its unmarshal function is used.
+func UnmarshalJson(data string, val interface{}, opts JsonOpts) error {
+	if ok, err := unmarshalRegisteredType(data, val); ok {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(data))
+	if opts.UseNumber {
+		dec.UseNumber()
+	}
+	err := dec.Decode(val)
+	if err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON, extra data after top-level value")
+	}
+	return nil
+}
+
 // Creates a Dashborg compatible double quoted string for pure ASCII printable strings (+ tab, newline, linefeed).
 // Not a general purpose string quoter, but will work for most simple keys.
 func QuoteString(str string) string {
@@ -1,7 +1,9 @@
 package dashutil
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -39,6 +41,8 @@ const (
 	UserIdMax        = 100
 	AppConfigJsonMax = 2000
 	MetadataJsonMax  = 1000
+	CacheControlMax  = 100
+	ETagMax          = 100
 )
 
 var (
@@ -64,6 +68,8 @@ var (
 	simpleIdRe       = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_-]*")
 	clientVersionRe  = regexp.MustCompile("^([a-z][a-z0-9_]*)-(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,4})$")
 	zoneAccessRe     = regexp.MustCompile("^[a-zA-Z0-9_.*-]+$")
+	cacheControlRe   = regexp.MustCompile("^[a-z-]+(=[0-9]+)?(, *[a-z-]+(=[0-9]+)?)*$")
+	etagRe           = regexp.MustCompile("^(W/)?\"[a-zA-Z0-9_+/=.-]*\"$")
 
 	// https://www.w3.org/TR/2016/REC-html51-20161101/sec-forms.html#email-state-typeemali
 	emailRe = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
@@ -240,6 +246,30 @@ func IsRoleValid(s string) bool {
 	return roleRe.MatchString(s)
 }
 
+// Compares two client versions (e.g. "go-0.7.4").  Returns -1, 0, or 1.  Returns an error if
+// either version is invalid or the versions are from different clients (e.g. "go" and "py").
+func CompareClientVersions(v1 string, v2 string) (int, error) {
+	m1 := clientVersionRe.FindStringSubmatch(v1)
+	m2 := clientVersionRe.FindStringSubmatch(v2)
+	if m1 == nil || m2 == nil {
+		return 0, fmt.Errorf("Invalid client version '%s' or '%s'", v1, v2)
+	}
+	if m1[1] != m2[1] {
+		return 0, fmt.Errorf("Cannot compare client versions from different clients '%s' and '%s'", v1, v2)
+	}
+	for i := 2; i <= 4; i++ {
+		n1, _ := strconv.Atoi(m1[i])
+		n2, _ := strconv.Atoi(m2[i])
+		if n1 < n2 {
+			return -1, nil
+		}
+		if n1 > n2 {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
 func IsClientVersionValid(s string) bool {
 	if len(s) == 0 || len(s) > ClientVersionMax {
 		return false
@@ -292,6 +322,22 @@ func IsDescriptionValid(s string) bool {
 	return len(s) < DescriptionMax
 }
 
+// Cache-Control directives, e.g. "public, max-age=3600" (quoted directive values are not supported)
+func IsCacheControlValid(s string) bool {
+	if len(s) == 0 || len(s) > CacheControlMax {
+		return false
+	}
+	return cacheControlRe.MatchString(s)
+}
+
+// Quoted (strong or weak) HTTP entity tag, e.g. "\"abc123\"" or "W/\"abc123\""
+func IsETagValid(s string) bool {
+	if len(s) == 0 || len(s) > ETagMax {
+		return false
+	}
+	return etagRe.MatchString(s)
+}
+
 func IsRequestMethodValid(s string) bool {
 	return ValidRequestMethod[s]
 }
@@ -2,7 +2,9 @@
 package keygen
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
@@ -12,11 +14,28 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 const p384Params = "BgUrgQQAIg=="
 
+const (
+	KeyTypeP384    = "p384" // ECDSA secp384r1 (default)
+	KeyTypeP256    = "p256" // ECDSA secp256r1
+	KeyTypeEd25519 = "ed25519"
+)
+
+// Options for CreateKeyPairWithOpts.  All fields are optional.
+type KeyPairOpts struct {
+	KeyType   string        // KeyTypeP384 (default), KeyTypeP256, or KeyTypeEd25519
+	NotBefore time.Time     // defaults to now (minus 5 minutes for clock skew)
+	ValidFor  time.Duration // certificate lifetime starting at NotBefore, defaults to 10 years
+	Overwrite bool          // replace existing key/cert files (otherwise an error is returned)
+}
+
+const defaultCertValidFor = 10 * 365 * 24 * time.Hour
+
 // Creates a keypair with CN=[accId], private key at keyFileName, and
 // public key certificate at certFileName.
 func CreateKeyPair(keyFileName string, certFileName string, accId string) error {
@@ -110,3 +129,123 @@ func CreateCertificate(certFileName string, privateKey *ecdsa.PrivateKey, accId
 	}
 	return nil
 }
+
+// Creates a keypair with CN=[accId] using the given key type and validity period.  The files
+// are written to temp files and renamed, so existing files (with Overwrite) are replaced
+// atomically.  opts may be nil.
+func CreateKeyPairWithOpts(keyFileName string, certFileName string, accId string, opts *KeyPairOpts) error {
+	if opts == nil {
+		opts = &KeyPairOpts{}
+	}
+	if keyFileName == "" || certFileName == "" {
+		return fmt.Errorf("Empty/Invalid Key or Cert filenames")
+	}
+	if opts.ValidFor < 0 {
+		return fmt.Errorf("Invalid ValidFor (negative)")
+	}
+	if !opts.Overwrite {
+		for _, fileName := range []string{keyFileName, certFileName} {
+			if _, err := os.Stat(fileName); err == nil {
+				return fmt.Errorf("Cannot create keypair, file:%s already exists", fileName)
+			}
+		}
+	}
+	var privateKey crypto.Signer
+	var err error
+	switch opts.KeyType {
+	case "", KeyTypeP384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeP256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("Invalid KeyType '%s' (must be p384, p256, or ed25519)", opts.KeyType)
+	}
+	if err != nil {
+		return fmt.Errorf("Error generating %s key err:%w", opts.KeyType, err)
+	}
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now().Add(-5 * time.Minute)
+	}
+	validFor := opts.ValidFor
+	if validFor == 0 {
+		validFor = defaultCertValidFor
+	}
+	pkBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("Error MarshalPKCS8PrivateKey err:%w", err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkBytes})
+	certBytes, err := makeCertBytes(privateKey, accId, notBefore, notBefore.Add(validFor))
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	keyTmp, err := writeTempFile(keyFileName, keyPem, 0600)
+	if err != nil {
+		return err
+	}
+	certTmp, err := writeTempFile(certFileName, certPem, 0644)
+	if err != nil {
+		os.Remove(keyTmp)
+		return err
+	}
+	err = os.Rename(keyTmp, keyFileName)
+	if err != nil {
+		os.Remove(keyTmp)
+		os.Remove(certTmp)
+		return fmt.Errorf("Error writing file:%s err:%w", keyFileName, err)
+	}
+	err = os.Rename(certTmp, certFileName)
+	if err != nil {
+		os.Remove(certTmp)
+		return fmt.Errorf("Error writing file:%s err:%w", certFileName, err)
+	}
+	return nil
+}
+
+func makeCertBytes(privateKey crypto.Signer, accId string, notBefore time.Time, notAfter time.Time) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1000000000000))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate serial number err:%w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: accId,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error running x509.CreateCertificate err:%w", err)
+	}
+	return certBytes, nil
+}
+
+func writeTempFile(fileName string, content []byte, perm os.FileMode) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("Error opening temp file for:%s err:%w", fileName, err)
+	}
+	tmpName := tmpFile.Name()
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("Error writing temp file for:%s err:%w", fileName, err)
+	}
+	return tmpName, nil
+}